@@ -0,0 +1,28 @@
+package main
+
+import (
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// FeatureABAC turns on the role checks below. Organizations whose users do
+// not yet carry role attributes can be left out with an org override until
+// their CA has reissued certificates.
+const FeatureABAC = "abac"
+
+// requireABACRole returns an error unless the caller carries role, while
+// attribute-based access control is enabled for the caller's organization.
+// action describes the refused operation in the error.
+func (s *SupplyChainContract) requireABACRole(ctx contractapi.TransactionContextInterface, role, action string) error {
+	enabled, err := s.featureEnabled(ctx, FeatureABAC)
+	if err != nil || !enabled {
+		return err
+	}
+	ok, err := s.hasRole(ctx, role)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return newError(ErrForbidden, "only callers with the %s role can %s", role, action)
+	}
+	return nil
+}
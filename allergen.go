@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const allergenObjectType = "allergen"
+
+// CategoryFood is the product category whose lots must declare their
+// ingredients and allergens
+const CategoryFood = "Food"
+
+// Allergen is an entry in the admin-managed allergen vocabulary
+type Allergen struct {
+	Code      string `json:"code"`
+	Name      string `json:"name"`
+	Active    bool   `json:"active"`
+	UpdatedAt string `json:"updated_at"`
+}
+
+// AddAllergen adds or reactivates an allergen in the managed vocabulary
+func (s *SupplyChainContract) AddAllergen(ctx contractapi.TransactionContextInterface, code, name string) error {
+	if err := s.requireRole(ctx, RoleAdmin); err != nil {
+		return err
+	}
+	if code == "" {
+		return newError(ErrInvalidArgument, "allergen code must not be empty")
+	}
+
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	allergen := Allergen{Code: code, Name: name, Active: true, UpdatedAt: curTime}
+	key, err := s.makeKey(ctx, allergenObjectType, code)
+	if err != nil {
+		return err
+	}
+	return s.putState(ctx, key, &allergen)
+}
+
+// RetireAllergen stops an allergen from being accepted in new declarations.
+// Lots that already declare it are unaffected.
+func (s *SupplyChainContract) RetireAllergen(ctx contractapi.TransactionContextInterface, code string) error {
+	if err := s.requireRole(ctx, RoleAdmin); err != nil {
+		return err
+	}
+
+	key, err := s.makeKey(ctx, allergenObjectType, code)
+	if err != nil {
+		return err
+	}
+	var allergen Allergen
+	exists, err := s.getState(ctx, key, &allergen)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return newError(ErrNotFound, "allergen %s does not exist", code)
+	}
+
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	allergen.Active = false
+	allergen.UpdatedAt = curTime
+	return s.putState(ctx, key, &allergen)
+}
+
+// GetAllergens returns the managed allergen vocabulary, including retired entries
+func (s *SupplyChainContract) GetAllergens(ctx contractapi.TransactionContextInterface) ([]*Allergen, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(allergenObjectType, []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var allergens []*Allergen
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var allergen Allergen
+		if err := json.Unmarshal(queryResponse.Value, &allergen); err != nil {
+			return nil, err
+		}
+		allergens = append(allergens, &allergen)
+	}
+
+	return allergens, nil
+}
+
+// DeclareLotIngredients records the ingredients and allergens of a lot.
+// Every allergen must be active in the managed vocabulary; a lot without
+// allergens passes an empty list. A new declaration replaces the previous one.
+// Only the lot's manufacturer or an admin can declare its ingredients.
+func (s *SupplyChainContract) DeclareLotIngredients(ctx contractapi.TransactionContextInterface, lotID string, ingredients, allergens []string) error {
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	lot, err := s.QueryLot(ctx, lotID)
+	if err != nil {
+		return err
+	}
+	if err := s.requireLotManufacturer(ctx, lot, "declare its ingredients"); err != nil {
+		return err
+	}
+	if len(ingredients) == 0 {
+		return newError(ErrInvalidArgument, "an ingredient declaration needs at least one ingredient")
+	}
+	for _, code := range allergens {
+		key, err := s.makeKey(ctx, allergenObjectType, code)
+		if err != nil {
+			return err
+		}
+		var allergen Allergen
+		exists, err := s.getState(ctx, key, &allergen)
+		if err != nil {
+			return err
+		}
+		if !exists || !allergen.Active {
+			return newError(ErrInvalidArgument, "allergen %s is not in the managed allergen vocabulary", code)
+		}
+	}
+
+	lot.Ingredients = ingredients
+	lot.Allergens = mergeIDs(allergens, nil)
+	lot.UpdatedAt = curTime
+	return s.putLot(ctx, lot)
+}
+
+// GetProductAllergens returns the union of the allergens declared for a
+// product's lot, carried through the transformation that produced it and
+// declared for any of its components
+func (s *SupplyChainContract) GetProductAllergens(ctx contractapi.TransactionContextInterface, productID string) ([]string, error) {
+	product, err := s.QueryProduct(ctx, productID)
+	if err != nil {
+		return nil, err
+	}
+	return s.productAllergens(ctx, product)
+}
+
+// productAllergens computes the sorted allergen union of a product.
+// Components form a tree, so the recursion always terminates.
+func (s *SupplyChainContract) productAllergens(ctx contractapi.TransactionContextInterface, product *Product) ([]string, error) {
+	allergens := []string{}
+	if product.LotID != "" {
+		lot, err := s.QueryLot(ctx, product.LotID)
+		if err != nil {
+			return nil, err
+		}
+		allergens = mergeIDs(allergens, lot.Allergens)
+	}
+
+	source, err := s.GetProductSources(ctx, product.ID)
+	if err != nil {
+		return nil, err
+	}
+	if source != nil {
+		allergens = mergeIDs(allergens, source.Allergens)
+	}
+
+	components, err := s.getRelated(ctx, relationIndexName, product.ID, RelationComponent)
+	if err != nil {
+		return nil, err
+	}
+	for _, componentID := range components {
+		component, err := s.QueryProduct(ctx, componentID)
+		if err != nil {
+			return nil, err
+		}
+		componentAllergens, err := s.productAllergens(ctx, component)
+		if err != nil {
+			return nil, err
+		}
+		allergens = mergeIDs(allergens, componentAllergens)
+	}
+
+	return allergens, nil
+}
+
+// isFoodCategory reports whether a product category falls under the
+// ingredient declaration rules
+func isFoodCategory(category string) bool {
+	return strings.EqualFold(category, CategoryFood)
+}
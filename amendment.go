@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const amendmentObjectType = "amendment"
+
+// defaultAmendmentWindowHours applies when no window has been configured
+const defaultAmendmentWindowHours = 24
+
+// FieldChange captures the before and after value of a single field
+type FieldChange struct {
+	Field    string `json:"field"`
+	OldValue string `json:"old_value"`
+	NewValue string `json:"new_value"`
+}
+
+// Amendment records a data correction made to a product shortly after its
+// creation, kept separate from regular updates so corrections can be reviewed
+type Amendment struct {
+	ProductID     string        `json:"product_id"`
+	TxID          string        `json:"tx_id"`
+	AmendedBy     string        `json:"amended_by"`
+	Justification string        `json:"justification"`
+	Changes       []FieldChange `json:"changes"`
+	AmendedAt     string        `json:"amended_at"`
+}
+
+// AmendProduct corrects data-entry mistakes on a product. It is only allowed
+// for the identity that created the product and only within the configured
+// window after creation, and never while the product is archived or frozen.
+// patchJSON is an object holding any of the name, description and category
+// fields, validated as on creation; status and ownership have their own
+// transactions and cannot be amended.
+func (s *SupplyChainContract) AmendProduct(ctx contractapi.TransactionContextInterface, id, patchJSON, justification string) error {
+	txTime, err := s.getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+	curTime := txTime.Format(time.RFC3339)
+
+	if justification == "" {
+		return newError(ErrInvalidArgument, "a justification is required to amend a product")
+	}
+
+	var patch map[string]string
+	if err := json.Unmarshal([]byte(patchJSON), &patch); err != nil {
+		return newError(ErrInvalidArgument, "failed to parse patch: %v", err)
+	}
+	if len(patch) == 0 {
+		return newError(ErrInvalidArgument, "patch does not change any fields")
+	}
+
+	product, err := s.QueryProduct(ctx, id)
+	if err != nil {
+		return err
+	}
+	if product.Archived {
+		return newError(ErrConflict, "product %s is archived and must be restored first", id)
+	}
+	if err := checkNotFrozen(product); err != nil {
+		return err
+	}
+
+	clientID, err := s.getClientID(ctx)
+	if err != nil {
+		return err
+	}
+	if product.CreatedBy == "" || product.CreatedBy != clientID {
+		return newError(ErrForbidden, "only the creator of product %s may amend it", id)
+	}
+
+	config, err := s.GetContractConfig(ctx)
+	if err != nil {
+		return err
+	}
+	windowHours := config.AmendmentWindowHours
+	if windowHours == 0 {
+		windowHours = defaultAmendmentWindowHours
+	}
+	createdAt, err := time.Parse(time.RFC3339, product.CreatedAt)
+	if err != nil {
+		return wrapError(err, "product %s has an invalid creation time", id)
+	}
+	if txTime.After(createdAt.Add(time.Duration(windowHours) * time.Hour)) {
+		return newError(ErrConflict, "the %d hour amendment window for product %s has closed", windowHours, id)
+	}
+
+	before := *product
+	fields := map[string]inputField{
+		"name":        field("name", &product.Name, productNameRule),
+		"description": field("description", &product.Description, descriptionRule),
+		"category":    field("category", &product.Category, categoryRule),
+	}
+	names := make([]string, 0, len(patch))
+	for name := range patch {
+		if _, ok := fields[name]; !ok {
+			return newError(ErrInvalidArgument, "field %s cannot be amended", name)
+		}
+		names = append(names, name)
+	}
+	// Map iteration order is random; sort so every endorser writes the same record
+	sort.Strings(names)
+
+	amended := make([]inputField, 0, len(names))
+	for _, name := range names {
+		value := patch[name]
+		amended = append(amended, field(name, &value, fields[name].rule))
+	}
+	if err := validateFields(amended...); err != nil {
+		return err
+	}
+
+	var changes []FieldChange
+	for _, change := range amended {
+		target := fields[change.name].value
+		if *target == *change.value {
+			continue
+		}
+		changes = append(changes, FieldChange{Field: change.name, OldValue: *target, NewValue: *change.value})
+		*target = *change.value
+	}
+	if len(changes) == 0 {
+		return newError(ErrInvalidArgument, "patch does not change any fields")
+	}
+	if product.Category != before.Category {
+		if err := s.checkProductID(ctx, product.ID, product.Category); err != nil {
+			return err
+		}
+	}
+	if err := s.checkUpdatedCategoryRules(ctx, &before, product); err != nil {
+		return err
+	}
+	if err := s.refingerprint(ctx, &before, product); err != nil {
+		return err
+	}
+
+	product.UpdatedAt = curTime
+	if err := s.putIndexedProduct(ctx, before, product); err != nil {
+		return err
+	}
+
+	amendment := Amendment{
+		ProductID:     id,
+		TxID:          ctx.GetStub().GetTxID(),
+		AmendedBy:     clientID,
+		Justification: justification,
+		Changes:       changes,
+		AmendedAt:     curTime,
+	}
+	key, err := s.makeKey(ctx, amendmentObjectType, id, amendment.TxID)
+	if err != nil {
+		return err
+	}
+	return s.putState(ctx, key, &amendment)
+}
+
+// GetProductAmendments returns every amendment made to a product
+func (s *SupplyChainContract) GetProductAmendments(ctx contractapi.TransactionContextInterface, id string) ([]*Amendment, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(amendmentObjectType, []string{id})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var amendments []*Amendment
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var amendment Amendment
+		if err := json.Unmarshal(queryResponse.Value, &amendment); err != nil {
+			return nil, err
+		}
+		amendments = append(amendments, &amendment)
+	}
+
+	// Keys are ordered by transaction ID, not time
+	sort.Slice(amendments, func(i, j int) bool { return amendments[i].AmendedAt < amendments[j].AmendedAt })
+
+	return amendments, nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Batch events replace the lifecycle event of each product in the batch,
+// since a transaction keeps only its last event
+const (
+	EventProductsCreated     = "ProductsCreated"
+	EventProductsTransferred = "ProductsTransferred"
+)
+
+// ProductBatchEvent is the payload of EventProductsCreated and
+// EventProductsTransferred. NewOwner is set for transfers.
+type ProductBatchEvent struct {
+	ProductIDs []string `json:"product_ids"`
+	NewOwner   string   `json:"new_owner"`
+	Actor      string   `json:"actor"`
+	MSPID      string   `json:"msp_id"`
+	TxID       string   `json:"tx_id"`
+	Timestamp  string   `json:"timestamp"`
+}
+
+// CreateProducts creates a manufacturing run of products in one
+// transaction. productsJSON is a JSON array of CreateProductRequest, at
+// most as many as the caller's organization may process in one call. Every
+// entry is checked as CreateProductFromRequest would; if any entry is
+// invalid, repeats another entry or already exists, no product is created.
+func (s *SupplyChainContract) CreateProducts(ctx contractapi.TransactionContextInterface, productsJSON string) (*TxResult, error) {
+	var entries []CreateProductRequest
+	if err := parseRequest(productsJSON, &entries); err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, newError(ErrInvalidArgument, "no products to create")
+	}
+	if err := s.checkBatchSize(ctx, len(entries)); err != nil {
+		return nil, err
+	}
+
+	// The ledger only sees this transaction's writes once it commits, so
+	// duplicates within the batch are caught here
+	seenIDs := make(map[string]bool, len(entries))
+	seenSerials := make(map[string]string)
+	for i := range entries {
+		entry := &entries[i]
+		if err := entry.validate(); err != nil {
+			return nil, wrapError(err, "product %d of the batch", i+1)
+		}
+		if seenIDs[entry.ID] {
+			return nil, newError(ErrInvalidArgument, "product %s is listed more than once", entry.ID)
+		}
+		seenIDs[entry.ID] = true
+
+		if entry.SerialNumber != "" {
+			fingerprint := strings.Join(productFingerprint(&Product{Manufacturer: entry.Manufacturer, Name: entry.Name, SerialNumber: entry.SerialNumber}), "|")
+			if other, ok := seenSerials[fingerprint]; ok {
+				return nil, newError(WarningDuplicateSuspected, "products %s and %s match on manufacturer, name and serial number", other, entry.ID)
+			}
+			seenSerials[fingerprint] = entry.ID
+		}
+	}
+
+	productIDs := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if err := s.createProduct(ctx, entry.ID, entry.Name, entry.Owner, entry.Description, entry.Category, entry.Manufacturer, entry.SerialNumber, entry.AllowDuplicate); err != nil {
+			return nil, wrapError(err, "product %s", entry.ID)
+		}
+		productIDs = append(productIDs, entry.ID)
+	}
+
+	if err := s.emitBatchEvent(ctx, EventProductsCreated, productIDs, ""); err != nil {
+		return nil, err
+	}
+	return txResult(ctx), nil
+}
+
+// TransferOwnershipBatch transfers every product in productIDs to
+// newOwner in one transaction, for example a shipment's worth of goods
+// changing hands. Each transfer is checked as TransferOwnership would; if
+// one fails, none of the products changes hands. Ownership reversals need a
+// reason and so cannot be batched. One ProductsTransferred event lists the
+// products.
+func (s *SupplyChainContract) TransferOwnershipBatch(ctx contractapi.TransactionContextInterface, productIDs []string, newOwner string) (*TxResult, error) {
+	if len(productIDs) == 0 {
+		return nil, newError(ErrInvalidArgument, "no products to transfer")
+	}
+	if err := s.checkBatchSize(ctx, len(productIDs)); err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool, len(productIDs))
+	for i := range productIDs {
+		productID := &productIDs[i]
+		if err := validateFields(field("product id", productID, productRefRule)); err != nil {
+			return nil, err
+		}
+		if seen[*productID] {
+			return nil, newError(ErrInvalidArgument, "product %s is listed more than once", *productID)
+		}
+		seen[*productID] = true
+	}
+
+	for _, productID := range productIDs {
+		if err := s.transferOwnership(ctx, productID, newOwner, transferOptions{}); err != nil {
+			return nil, wrapError(err, "product %s", productID)
+		}
+	}
+	if err := s.emitBatchEvent(ctx, EventProductsTransferred, productIDs, newOwner); err != nil {
+		return nil, err
+	}
+	return txResult(ctx), nil
+}
+
+// checkBatchSize refuses a batch larger than the caller's organization may
+// process in one call
+func (s *SupplyChainContract) checkBatchSize(ctx contractapi.TransactionContextInterface, size int) error {
+	limits, err := s.callerQueryLimits(ctx)
+	if err != nil {
+		return err
+	}
+	if size > limits.MaxResults {
+		return newError(ErrInvalidArgument, "a batch holds at most %d products, got %d", limits.MaxResults, size)
+	}
+	return nil
+}
+
+// emitBatchEvent sets the event of a batch transaction over productIDs
+func (s *SupplyChainContract) emitBatchEvent(ctx contractapi.TransactionContextInterface, name string, productIDs []string, newOwner string) error {
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	clientID, err := s.getClientID(ctx)
+	if err != nil {
+		return err
+	}
+	mspID, err := s.getClientMSPID(ctx)
+	if err != nil {
+		return err
+	}
+	eventJSON, err := json.Marshal(ProductBatchEvent{
+		ProductIDs: productIDs,
+		NewOwner:   newOwner,
+		Actor:      clientID,
+		MSPID:      mspID,
+		TxID:       ctx.GetStub().GetTxID(),
+		Timestamp:  curTime,
+	})
+	if err != nil {
+		return err
+	}
+	if err := ctx.GetStub().SetEvent(name, eventJSON); err != nil {
+		return wrapError(err, "failed to set event")
+	}
+	return nil
+}
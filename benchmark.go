@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	benchmarkObjectType = "benchmark"
+
+	// minBenchmarkSample is the fewest observations a figure is published
+	// from, so no single member's records can be read back out of it
+	minBenchmarkSample = 5
+)
+
+// LaneBenchmark is the median transit time of received shipments on one
+// origin -> destination lane
+type LaneBenchmark struct {
+	Origin             string  `json:"origin"`
+	Destination        string  `json:"destination"`
+	Shipments          int     `json:"shipments"`
+	MedianTransitHours float64 `json:"median_transit_hours"`
+}
+
+// Benchmark is a channel-wide snapshot of supply chain KPIs. It carries no
+// organization, carrier or facility identities, and figures based on fewer
+// than minBenchmarkSample observations are left out.
+type Benchmark struct {
+	ID                   string          `json:"id"`
+	Lanes                []LaneBenchmark `json:"lanes"`
+	DockVisits           int             `json:"dock_visits"`
+	AverageDwellMinutes  float64         `json:"average_dwell_minutes"`
+	Shipments            int             `json:"shipments"`
+	ExcursionRatePercent float64         `json:"excursion_rate_percent"`
+	ComputedAt           string          `json:"computed_at"`
+}
+
+// ComputeBenchmarks aggregates every shipment, dock visit and excursion on
+// the channel into an anonymized benchmark snapshot and stores it
+func (s *SupplyChainContract) ComputeBenchmarks(ctx contractapi.TransactionContextInterface) (*Benchmark, error) {
+	if err := s.requireRole(ctx, RoleAdmin); err != nil {
+		return nil, err
+	}
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	benchmark := Benchmark{ID: curTime, Lanes: []LaneBenchmark{}, ComputedAt: curTime}
+
+	// Transit time per lane and the share of shipments with an excursion
+	excursionShipments, err := s.getIndexedPrefixes(ctx, excursionObjectType)
+	if err != nil {
+		return nil, err
+	}
+	transits := make(map[[2]string][]float64)
+	var excursions int
+	err = s.forEachState(ctx, shipmentObjectType, func(value []byte) error {
+		var shipment Shipment
+		if err := json.Unmarshal(value, &shipment); err != nil {
+			return err
+		}
+		benchmark.Shipments++
+		if excursionShipments[shipment.ID] {
+			excursions++
+		}
+		if shipment.Status != ShipmentReceived || shipment.ReceivedAt == "" {
+			return nil
+		}
+		minutes, err := minutesBetween(shipment.CreatedAt, shipment.ReceivedAt)
+		if err != nil {
+			return err
+		}
+		lane := [2]string{shipment.Origin, shipment.Destination}
+		transits[lane] = append(transits[lane], float64(minutes)/60)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if benchmark.Shipments >= minBenchmarkSample {
+		benchmark.ExcursionRatePercent = roundTo2(float64(excursions) / float64(benchmark.Shipments) * 100)
+	}
+	for lane, hours := range transits {
+		if len(hours) < minBenchmarkSample {
+			continue
+		}
+		benchmark.Lanes = append(benchmark.Lanes, LaneBenchmark{
+			Origin:             lane[0],
+			Destination:        lane[1],
+			Shipments:          len(hours),
+			MedianTransitHours: roundTo2(median(hours)),
+		})
+	}
+	sort.Slice(benchmark.Lanes, func(i, j int) bool {
+		if benchmark.Lanes[i].Origin != benchmark.Lanes[j].Origin {
+			return benchmark.Lanes[i].Origin < benchmark.Lanes[j].Origin
+		}
+		return benchmark.Lanes[i].Destination < benchmark.Lanes[j].Destination
+	})
+
+	// Dwell time from arrival to release at the dock
+	var dwellMinutes int
+	err = s.forEachState(ctx, dockSlotObjectType, func(value []byte) error {
+		var slot DockSlot
+		if err := json.Unmarshal(value, &slot); err != nil {
+			return err
+		}
+		if slot.ArrivedAt == "" || slot.ReleasedAt == "" {
+			return nil
+		}
+		minutes, err := minutesBetween(slot.ArrivedAt, slot.ReleasedAt)
+		if err != nil {
+			return err
+		}
+		benchmark.DockVisits++
+		dwellMinutes += minutes
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if benchmark.DockVisits >= minBenchmarkSample {
+		benchmark.AverageDwellMinutes = roundTo2(float64(dwellMinutes) / float64(benchmark.DockVisits))
+	}
+
+	key, err := s.makeKey(ctx, benchmarkObjectType, benchmark.ID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.putState(ctx, key, &benchmark); err != nil {
+		return nil, err
+	}
+	return &benchmark, nil
+}
+
+// GetLatestBenchmark returns the most recent benchmark snapshot
+func (s *SupplyChainContract) GetLatestBenchmark(ctx contractapi.TransactionContextInterface) (*Benchmark, error) {
+	var latest *Benchmark
+	// Snapshots are keyed by computation time, so the last one is the latest
+	err := s.forEachState(ctx, benchmarkObjectType, func(value []byte) error {
+		var benchmark Benchmark
+		if err := json.Unmarshal(value, &benchmark); err != nil {
+			return err
+		}
+		latest = &benchmark
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if latest == nil {
+		return nil, newError(ErrNotFound, "no benchmark has been computed yet")
+	}
+	return latest, nil
+}
+
+// forEachState calls fn with the value of every record of an object type
+func (s *SupplyChainContract) forEachState(ctx contractapi.TransactionContextInterface, objectType string, fn func(value []byte) error) error {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(objectType, []string{})
+	if err != nil {
+		return err
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return err
+		}
+		if err := fn(queryResponse.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getIndexedPrefixes returns the set of first attributes of every key of an
+// object type, e.g. the shipment IDs that have excursions
+func (s *SupplyChainContract) getIndexedPrefixes(ctx contractapi.TransactionContextInterface, objectType string) (map[string]bool, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(objectType, []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	prefixes := make(map[string]bool)
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, keyParts, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, err
+		}
+		if len(keyParts) > 0 {
+			prefixes[keyParts[0]] = true
+		}
+	}
+	return prefixes, nil
+}
+
+// median returns the median of a non-empty slice, sorting it in place
+func median(values []float64) float64 {
+	sort.Float64s(values)
+	mid := len(values) / 2
+	if len(values)%2 == 0 {
+		return (values[mid-1] + values[mid]) / 2
+	}
+	return values[mid]
+}
+
+// roundTo2 rounds a value to two decimals
+func roundTo2(value float64) float64 {
+	return math.Round(value*100) / 100
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// BulkStatusResult lists the products moved by AdvanceStatusByFilter. More
+// is set when further matching products were left for another call.
+type BulkStatusResult struct {
+	FromStatus string   `json:"from_status"`
+	ToStatus   string   `json:"to_status"`
+	Updated    []string `json:"updated"`
+	More       bool     `json:"more"`
+}
+
+// AdvanceStatusByFilter moves up to maxItems products matching filterJSON
+// (a ProductFilter) from fromStatus to toStatus; maxItems is capped by the
+// caller's organization limits. Updated products no longer match, so
+// calling again with the same arguments continues where the last call
+// stopped. Downgrades are refused since they need a reason per product.
+// Only admins can move products their organization does not own.
+func (s *SupplyChainContract) AdvanceStatusByFilter(ctx contractapi.TransactionContextInterface, filterJSON, fromStatus, toStatus string, maxItems int) (*BulkStatusResult, error) {
+	if fromStatus == "" || toStatus == "" || fromStatus == toStatus {
+		return nil, newError(ErrInvalidArgument, "from and to status must be set and differ")
+	}
+	if err := s.checkProductStatusChange(ctx, fromStatus, toStatus, nil); err != nil {
+		return nil, err
+	}
+	if err := s.checkResultCap(ctx, maxItems); err != nil {
+		return nil, err
+	}
+	filter, err := parseProductFilter(filterJSON)
+	if err != nil {
+		return nil, err
+	}
+	if filter.Status != "" && filter.Status != fromStatus {
+		return nil, newError(ErrInvalidArgument, "filter status %s conflicts with from status %s", filter.Status, fromStatus)
+	}
+	filter.Status = fromStatus
+
+	isAdmin, err := s.hasRole(ctx, RoleAdmin)
+	if err != nil {
+		return nil, err
+	}
+	mspID, err := s.getClientMSPID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case filter.Owner == FilterOwnerSelf || (filter.Owner == "" && !isAdmin):
+		filter.Owner = mspID
+	case filter.Owner != mspID && !isAdmin:
+		return nil, newError(ErrForbidden, "only an admin can advance products owned by %s", filter.Owner)
+	}
+
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// advance updates a product if it matches and reports whether to keep
+	// going. Frozen products are left where they are.
+	result := &BulkStatusResult{FromStatus: fromStatus, ToStatus: toStatus, Updated: []string{}}
+	advance := func(product *Product) (bool, error) {
+		if !filter.matches(product) || product.Frozen {
+			return true, nil
+		}
+		if len(result.Updated) == maxItems {
+			result.More = true
+			return false, nil
+		}
+		before := *product
+		if err := s.setProductStatus(ctx, product, toStatus, nil); err != nil {
+			return false, err
+		}
+		product.UpdatedAt = curTime
+		if err := s.putIndexedProduct(ctx, before, product); err != nil {
+			return false, err
+		}
+		result.Updated = append(result.Updated, product.ID)
+		return true, nil
+	}
+
+	// A shipment lists its products, which saves scanning every product
+	if filter.ShipmentID != "" {
+		shipment, err := s.QueryShipment(ctx, filter.ShipmentID)
+		if err != nil {
+			return nil, err
+		}
+		for _, productID := range shipment.ProductIDs {
+			product, err := s.QueryProduct(ctx, productID)
+			if err != nil {
+				return nil, err
+			}
+			keepGoing, err := advance(product)
+			if err != nil {
+				return nil, err
+			}
+			if !keepGoing {
+				break
+			}
+		}
+		return result, nil
+	}
+
+	resultsIterator, err := ctx.GetStub().GetStateByRange("", "")
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var product Product
+		if err := decodeProduct(queryResponse.Value, &product); err != nil {
+			return nil, err
+		}
+		keepGoing, err := advance(&product)
+		if err != nil {
+			return nil, err
+		}
+		if !keepGoing {
+			break
+		}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// CategoryRule constrains one product field, named by its JSON name, for
+// the products of a category. Pattern is a regular expression the whole
+// value must match; Range bounds the value as a number. Constraints other
+// than Required only apply to a field that is set.
+type CategoryRule struct {
+	Field    string        `json:"field"`
+	Required bool          `json:"required"`
+	Pattern  string        `json:"pattern"`
+	Range    *NumericRange `json:"range,omitempty" metadata:",optional"`
+}
+
+// NumericRange is an inclusive range of numbers
+type NumericRange struct {
+	Min float64 `json:"min"`
+	Max float64 `json:"max"`
+}
+
+// CategoryRuleSet is the rules the products of a category must satisfy
+type CategoryRuleSet struct {
+	Category string         `json:"category"`
+	Rules    []CategoryRule `json:"rules"`
+}
+
+// SetCategoryRules sets the validation rules of a category's products.
+// rulesJSON is a JSON array of CategoryRule; an empty array removes the
+// category's rules. Rules are checked when products are created, when a
+// product moves into the category and on the fields a change touches, so
+// existing products are not locked out by a new rule.
+func (s *SupplyChainContract) SetCategoryRules(ctx contractapi.TransactionContextInterface, category, rulesJSON string) error {
+	if err := s.requireRole(ctx, RoleAdmin); err != nil {
+		return err
+	}
+	if category == "" {
+		return newError(ErrInvalidArgument, "category must not be empty")
+	}
+	var rules []CategoryRule
+	if err := parseRequest(rulesJSON, &rules); err != nil {
+		return err
+	}
+	fields := productFieldNames()
+	for i, rule := range rules {
+		if err := rule.validate(fields); err != nil {
+			return wrapError(err, "rule %d", i+1)
+		}
+	}
+
+	config, err := s.GetContractConfig(ctx)
+	if err != nil {
+		return err
+	}
+	sets := []CategoryRuleSet{}
+	for _, set := range config.CategoryRules {
+		if set.Category != category {
+			sets = append(sets, set)
+		}
+	}
+	if len(rules) > 0 {
+		sets = append(sets, CategoryRuleSet{Category: category, Rules: rules})
+	}
+	sort.Slice(sets, func(i, j int) bool {
+		return sets[i].Category < sets[j].Category
+	})
+
+	config.CategoryRules = sets
+	return s.putContractConfig(ctx, config)
+}
+
+// GetCategoryRules returns the validation rules of every category that has
+// them
+func (s *SupplyChainContract) GetCategoryRules(ctx contractapi.TransactionContextInterface) ([]CategoryRuleSet, error) {
+	config, err := s.GetContractConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return config.CategoryRules, nil
+}
+
+// validate checks that the rule names a product field and can be evaluated
+func (r *CategoryRule) validate(fields map[string]bool) error {
+	if !fields[r.Field] {
+		return newError(ErrInvalidArgument, "unknown product field %q", r.Field)
+	}
+	if r.Pattern != "" {
+		if _, err := regexp.Compile(r.Pattern); err != nil {
+			return newError(ErrInvalidArgument, "invalid pattern for %s: %v", r.Field, err)
+		}
+	}
+	if r.Range != nil && r.Range.Min > r.Range.Max {
+		return newError(ErrInvalidArgument, "range of %s has its min above its max", r.Field)
+	}
+	if !r.Required && r.Pattern == "" && r.Range == nil {
+		return newError(ErrInvalidArgument, "rule for %s constrains nothing", r.Field)
+	}
+	return nil
+}
+
+// check returns what is wrong with value, or an empty string if it
+// satisfies the rule. value is the field as decoded from the product's JSON.
+func (r *CategoryRule) check(value interface{}) string {
+	var text string
+	switch v := value.(type) {
+	case nil:
+	case string:
+		text = v
+	case bool:
+		if v {
+			text = "true"
+		}
+	case float64:
+		text = strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return "cannot be checked"
+	}
+
+	if text == "" {
+		if r.Required {
+			return "is required"
+		}
+		return ""
+	}
+	if r.Pattern != "" && !regexp.MustCompile("^(?:"+r.Pattern+")$").MatchString(text) {
+		return fmt.Sprintf("must match %s", r.Pattern)
+	}
+	if r.Range != nil {
+		number, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return "must be a number"
+		}
+		if number < r.Range.Min || number > r.Range.Max {
+			return fmt.Sprintf("must be between %v and %v", r.Range.Min, r.Range.Max)
+		}
+	}
+	return ""
+}
+
+// checkCategoryRules evaluates the rules of the product's category. Only
+// rules on the named fields are evaluated; no fields means all of them.
+func (s *SupplyChainContract) checkCategoryRules(ctx contractapi.TransactionContextInterface, product *Product, fields ...string) error {
+	if product.Category == "" {
+		return nil
+	}
+	config, err := s.GetContractConfig(ctx)
+	if err != nil {
+		return err
+	}
+	var rules []CategoryRule
+	for _, set := range config.CategoryRules {
+		if set.Category == product.Category {
+			rules = set.Rules
+		}
+	}
+	if len(rules) == 0 {
+		return nil
+	}
+
+	values, err := productFields(product)
+	if err != nil {
+		return err
+	}
+	var problems []string
+	for _, rule := range rules {
+		if len(fields) > 0 && !containsString(fields, rule.Field) {
+			continue
+		}
+		if problem := rule.check(values[rule.Field]); problem != "" {
+			problems = append(problems, rule.Field+" "+problem)
+		}
+	}
+	if len(problems) > 0 {
+		return newError(ErrInvalidArgument, "%s product %s: %s", product.Category, product.ID, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// checkUpdatedCategoryRules evaluates the category rules on the fields a
+// change to a product touches, or all of them when the product moves into a
+// new category
+func (s *SupplyChainContract) checkUpdatedCategoryRules(ctx contractapi.TransactionContextInterface, before, after *Product) error {
+	if after.Category != before.Category {
+		return s.checkCategoryRules(ctx, after)
+	}
+	beforeValues, err := productFields(before)
+	if err != nil {
+		return err
+	}
+	afterValues, err := productFields(after)
+	if err != nil {
+		return err
+	}
+	var changed []string
+	for name, value := range afterValues {
+		if value != beforeValues[name] {
+			changed = append(changed, name)
+		}
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+	return s.checkCategoryRules(ctx, after, changed...)
+}
+
+// productFields returns the fields of a product keyed by their JSON names
+func productFields(product *Product) (map[string]interface{}, error) {
+	productJSON, err := json.Marshal(product)
+	if err != nil {
+		return nil, err
+	}
+	var values map[string]interface{}
+	if err := json.Unmarshal(productJSON, &values); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// productFieldNames returns the JSON names of the product fields
+func productFieldNames() map[string]bool {
+	values, _ := productFields(&Product{})
+	names := make(map[string]bool, len(values))
+	for name := range values {
+		names[name] = true
+	}
+	return names
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const certifierObjectType = "certifier"
+
+// Subjects of certifier-issued credentials
+const (
+	CredentialSubjectProduct = "product"
+	CredentialSubjectLot     = "lot"
+)
+
+// Certifier is a certification body allowed to issue credentials of the
+// listed types, identified by its registered DID
+type Certifier struct {
+	DID             string   `json:"did"`
+	Name            string   `json:"name"`
+	CredentialTypes []string `json:"credential_types"`
+	Active          bool     `json:"active"`
+	RegisteredBy    string   `json:"registered_by"`
+	CreatedAt       string   `json:"created_at"`
+	UpdatedAt       string   `json:"updated_at"`
+}
+
+// CredentialPresentation is the result of PresentCredential. Anchored is
+// set if the presented credential is the one recorded on the ledger.
+type CredentialPresentation struct {
+	ID          string   `json:"id"`
+	Issuer      string   `json:"issuer"`
+	Subject     string   `json:"subject"`
+	SubjectType string   `json:"subject_type"`
+	Types       []string `json:"types"`
+	Anchored    bool     `json:"anchored"`
+	Valid       bool     `json:"valid"`
+	Reason      string   `json:"reason"`
+}
+
+// RegisterCertifier registers a certification body by its DID and the
+// credential types (besides VerifiableCredential) it may issue
+func (s *SupplyChainContract) RegisterCertifier(ctx contractapi.TransactionContextInterface, did, name string, credentialTypes []string) error {
+	if err := s.requireRole(ctx, RoleAdmin); err != nil {
+		return err
+	}
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	if len(credentialTypes) == 0 {
+		return newError(ErrInvalidArgument, "a certifier must be allowed at least one credential type")
+	}
+	if err := s.requireActiveDID(ctx, did); err != nil {
+		return err
+	}
+
+	existing, err := s.findCertifier(ctx, did)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return newError(ErrAlreadyExists, "certifier %s is already registered", did)
+	}
+	clientID, err := s.getClientID(ctx)
+	if err != nil {
+		return err
+	}
+
+	certifier := Certifier{
+		DID:             did,
+		Name:            name,
+		CredentialTypes: mergeIDs(credentialTypes, nil),
+		Active:          true,
+		RegisteredBy:    clientID,
+		CreatedAt:       curTime,
+		UpdatedAt:       curTime,
+	}
+	return s.putCertifier(ctx, &certifier)
+}
+
+// SuspendCertifier stops a certifier from issuing credentials. The
+// credentials it issued no longer pass PresentCredential.
+func (s *SupplyChainContract) SuspendCertifier(ctx contractapi.TransactionContextInterface, did string) error {
+	if err := s.requireRole(ctx, RoleAdmin); err != nil {
+		return err
+	}
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	certifier, err := s.GetCertifier(ctx, did)
+	if err != nil {
+		return err
+	}
+	if !certifier.Active {
+		return newError(ErrConflict, "certifier %s is already suspended", did)
+	}
+
+	certifier.Active = false
+	certifier.UpdatedAt = curTime
+	return s.putCertifier(ctx, certifier)
+}
+
+// GetCertifier returns a registered certifier
+func (s *SupplyChainContract) GetCertifier(ctx contractapi.TransactionContextInterface, did string) (*Certifier, error) {
+	certifier, err := s.findCertifier(ctx, did)
+	if err != nil {
+		return nil, err
+	}
+	if certifier == nil {
+		return nil, newError(ErrNotFound, "%s is not a registered certifier", did)
+	}
+	return certifier, nil
+}
+
+// IssueCredential anchors a credential a registered certifier issues about
+// a product or lot, named by its ID in credentialSubject.id. The caller
+// must be the organization controlling the certifier's DID, and every
+// credential type must be one the certifier is registered for. The proof is
+// checked as in SubmitCredential.
+func (s *SupplyChainContract) IssueCredential(ctx contractapi.TransactionContextInterface, credentialJSON, verificationMethod, proofValue string) (*Credential, error) {
+	credential, err := s.newCredential(ctx, credentialJSON, verificationMethod, proofValue)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkCertifier(ctx, credential); err != nil {
+		return nil, err
+	}
+	issuer, err := s.ResolveDID(ctx, credential.Issuer)
+	if err != nil {
+		return nil, err
+	}
+	mspID, err := s.getClientMSPID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if mspID != issuer.Controller {
+		return nil, newError(ErrForbidden, "only the controller %s of %s can issue its credentials", issuer.Controller, credential.Issuer)
+	}
+
+	productExists, err := s.ProductExists(ctx, credential.Subject)
+	if err != nil {
+		return nil, err
+	}
+	lotExists, err := s.lotExists(ctx, credential.Subject)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case productExists:
+		credential.SubjectType = CredentialSubjectProduct
+	case lotExists:
+		credential.SubjectType = CredentialSubjectLot
+	default:
+		return nil, newError(ErrInvalidArgument, "credential subject %s is neither a product nor a lot", credential.Subject)
+	}
+
+	if err := s.putCredential(ctx, credential); err != nil {
+		return nil, err
+	}
+	return credential, nil
+}
+
+// RevokeCredential revokes a credential. Only the organization controlling
+// the issuer's DID can revoke it.
+func (s *SupplyChainContract) RevokeCredential(ctx contractapi.TransactionContextInterface, id string) error {
+	credential, err := s.GetCredential(ctx, id)
+	if err != nil {
+		return err
+	}
+	if credential.Revoked {
+		return newError(ErrConflict, "credential %s is already revoked", id)
+	}
+	issuer, err := s.ResolveDID(ctx, credential.Issuer)
+	if err != nil {
+		return err
+	}
+	mspID, err := s.getClientMSPID(ctx)
+	if err != nil {
+		return err
+	}
+	if mspID != issuer.Controller {
+		return newError(ErrForbidden, "only the controller %s of %s can revoke its credentials", issuer.Controller, credential.Issuer)
+	}
+
+	credential.Revoked = true
+	key, err := s.makeKey(ctx, credentialObjectType, id)
+	if err != nil {
+		return err
+	}
+	return s.putState(ctx, key, credential)
+}
+
+// PresentCredential verifies a credential a downstream party was handed,
+// as the credential JSON and proof value the issuer signed. It is valid if
+// it is the credential anchored on the ledger, its proof still verifies
+// against the issuer's DID, it has not expired or been revoked, and, for a
+// certification, its certifier is still active.
+func (s *SupplyChainContract) PresentCredential(ctx contractapi.TransactionContextInterface, credentialJSON, proofValue string) (*CredentialPresentation, error) {
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+	presented, err := parseVerifiableCredential(credentialJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &CredentialPresentation{ID: presented.ID, Issuer: presented.Issuer, Types: presented.Type}
+	if result.Types == nil {
+		result.Types = []string{}
+	}
+	anchored, err := s.GetCredential(ctx, presented.ID)
+	if err != nil {
+		result.Reason = errorMessage(err)
+		return result, nil
+	}
+	result.Subject = anchored.Subject
+	result.SubjectType = anchored.SubjectType
+	if anchored.Document != credentialJSON || anchored.ProofValue != proofValue {
+		result.Reason = fmt.Sprintf("the presented credential differs from credential %s on the ledger", presented.ID)
+		return result, nil
+	}
+	result.Anchored = true
+
+	if err := s.verifyCredential(ctx, anchored, curTime); err != nil {
+		result.Reason = errorMessage(err)
+		return result, nil
+	}
+	if anchored.SubjectType != "" {
+		if err := s.checkCertifier(ctx, anchored); err != nil {
+			result.Reason = errorMessage(err)
+			return result, nil
+		}
+	}
+	result.Valid = true
+	return result, nil
+}
+
+// GetProductCertifications returns the certifier-issued credentials about a
+// product and its lot
+func (s *SupplyChainContract) GetProductCertifications(ctx contractapi.TransactionContextInterface, productID string) ([]*Credential, error) {
+	product, err := s.QueryProduct(ctx, productID)
+	if err != nil {
+		return nil, err
+	}
+	subjects := []string{productID}
+	if product.LotID != "" {
+		subjects = append(subjects, product.LotID)
+	}
+
+	certifications := []*Credential{}
+	for _, subject := range subjects {
+		credentials, err := s.GetCredentialsBySubject(ctx, subject)
+		if err != nil {
+			return nil, err
+		}
+		for _, credential := range credentials {
+			if credential.SubjectType != "" {
+				certifications = append(certifications, credential)
+			}
+		}
+	}
+	return certifications, nil
+}
+
+// checkCertifier returns an error unless a credential's issuer is an active
+// certifier registered for all of its types
+func (s *SupplyChainContract) checkCertifier(ctx contractapi.TransactionContextInterface, credential *Credential) error {
+	certifier, err := s.GetCertifier(ctx, credential.Issuer)
+	if err != nil {
+		return err
+	}
+	if !certifier.Active {
+		return newError(ErrForbidden, "certifier %s is suspended", credential.Issuer)
+	}
+	allowed := map[string]bool{verifiableCredentialBaseType: true}
+	for _, t := range certifier.CredentialTypes {
+		allowed[t] = true
+	}
+	for _, t := range credential.Types {
+		if !allowed[t] {
+			return newError(ErrForbidden, "certifier %s is not registered to issue %s credentials", credential.Issuer, t)
+		}
+	}
+	return nil
+}
+
+// findCertifier returns a certifier, or nil if the DID is not registered as one
+func (s *SupplyChainContract) findCertifier(ctx contractapi.TransactionContextInterface, did string) (*Certifier, error) {
+	key, err := s.makeKey(ctx, certifierObjectType, did)
+	if err != nil {
+		return nil, err
+	}
+	var certifier Certifier
+	exists, err := s.getState(ctx, key, &certifier)
+	if err != nil || !exists {
+		return nil, err
+	}
+	return &certifier, nil
+}
+
+// putCertifier writes a certifier
+func (s *SupplyChainContract) putCertifier(ctx contractapi.TransactionContextInterface, certifier *Certifier) error {
+	key, err := s.makeKey(ctx, certifierObjectType, certifier.DID)
+	if err != nil {
+		return err
+	}
+	return s.putState(ctx, key, certifier)
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const changeEventObjectType = "changeevent"
+
+// Change actions
+const (
+	ChangeCreate = "create"
+	ChangeUpdate = "update"
+	ChangeDelete = "delete"
+)
+
+// Entities recorded in the change log
+const ChangeEntityProduct = "product"
+
+// ChangeEvent is an append-only record of one mutation, written when event
+// sourcing is enabled. Diff values are JSON; the old value is empty for a
+// created entity and the new one for a deleted entity. The diff is against
+// the state before the transaction, so a transaction that writes an entity
+// twice leaves a single event holding the combined change.
+type ChangeEvent struct {
+	Entity    string        `json:"entity"`
+	EntityID  string        `json:"entity_id"`
+	Action    string        `json:"action"`
+	Diff      []FieldChange `json:"diff"`
+	Actor     string        `json:"actor"`
+	MSPID     string        `json:"msp_id"`
+	TxID      string        `json:"tx_id"`
+	Timestamp string        `json:"timestamp"`
+}
+
+// ChangeEventPage is one page of GetChangeEvents results. Bookmark is empty
+// once the last page has been read.
+type ChangeEventPage struct {
+	Events   []*ChangeEvent `json:"events"`
+	Bookmark string         `json:"bookmark"`
+}
+
+// SetEventSourcing turns the change log on or off. While it is on, every
+// product mutation also appends a ChangeEvent.
+func (s *SupplyChainContract) SetEventSourcing(ctx contractapi.TransactionContextInterface, enabled bool) error {
+	if err := s.requireRole(ctx, RoleAdmin); err != nil {
+		return err
+	}
+
+	config, err := s.GetContractConfig(ctx)
+	if err != nil {
+		return err
+	}
+
+	config.EventSourcing = enabled
+	return s.putContractConfig(ctx, config)
+}
+
+// GetChangeEvents returns one page of the change log in time order. A
+// consumer rebuilding a read model replays every page and keeps the last
+// bookmark to pick up later events.
+func (s *SupplyChainContract) GetChangeEvents(ctx contractapi.TransactionContextInterface, pageSize int, bookmark string) (*ChangeEventPage, error) {
+	if err := s.checkPageSize(ctx, pageSize); err != nil {
+		return nil, err
+	}
+
+	resultsIterator, metadata, err := ctx.GetStub().GetStateByPartialCompositeKeyWithPagination(changeEventObjectType, []string{}, int32(pageSize), bookmark)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	page := &ChangeEventPage{Events: []*ChangeEvent{}, Bookmark: metadata.GetBookmark()}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var event ChangeEvent
+		if err := json.Unmarshal(queryResponse.Value, &event); err != nil {
+			return nil, err
+		}
+		page.Events = append(page.Events, &event)
+	}
+
+	return page, nil
+}
+
+// recordChange appends a ChangeEvent for a write of key, whose new value is
+// newJSON (nil for a delete), if event sourcing is enabled. Events are keyed
+// by time first so a scan of the log returns them in order.
+func (s *SupplyChainContract) recordChange(ctx contractapi.TransactionContextInterface, entity, id, key string, newJSON []byte) error {
+	config, err := s.GetContractConfig(ctx)
+	if err != nil {
+		return err
+	}
+	if !config.EventSourcing {
+		return nil
+	}
+
+	oldJSON, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return wrapError(err, "failed to read from world state")
+	}
+	if entity == ChangeEntityProduct && oldJSON != nil {
+		if oldJSON, err = productStateJSON(oldJSON); err != nil {
+			return err
+		}
+	}
+	action := ChangeUpdate
+	switch {
+	case oldJSON == nil:
+		action = ChangeCreate
+	case newJSON == nil:
+		action = ChangeDelete
+	}
+	diff, err := diffFields(oldJSON, newJSON)
+	if err != nil {
+		return err
+	}
+
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	clientID, err := s.getClientID(ctx)
+	if err != nil {
+		return err
+	}
+	mspID, err := s.getClientMSPID(ctx)
+	if err != nil {
+		return err
+	}
+	txID := ctx.GetStub().GetTxID()
+
+	event := ChangeEvent{
+		Entity:    entity,
+		EntityID:  id,
+		Action:    action,
+		Diff:      diff,
+		Actor:     clientID,
+		MSPID:     mspID,
+		TxID:      txID,
+		Timestamp: curTime,
+	}
+	eventKey, err := s.makeKey(ctx, changeEventObjectType, curTime, txID, entity, id)
+	if err != nil {
+		return err
+	}
+	return s.putState(ctx, eventKey, &event)
+}
+
+// diffFields compares the top-level fields of two JSON objects, either of
+// which may be nil, and returns the changed ones ordered by name
+func diffFields(oldJSON, newJSON []byte) ([]FieldChange, error) {
+	oldFields := make(map[string]json.RawMessage)
+	newFields := make(map[string]json.RawMessage)
+	if oldJSON != nil {
+		if err := json.Unmarshal(oldJSON, &oldFields); err != nil {
+			return nil, err
+		}
+	}
+	if newJSON != nil {
+		if err := json.Unmarshal(newJSON, &newFields); err != nil {
+			return nil, err
+		}
+	}
+
+	names := make(map[string]bool)
+	for name := range oldFields {
+		names[name] = true
+	}
+	for name := range newFields {
+		names[name] = true
+	}
+
+	diff := []FieldChange{}
+	for name := range names {
+		oldValue, newValue := string(oldFields[name]), string(newFields[name])
+		if oldValue != newValue {
+			diff = append(diff, FieldChange{Field: name, OldValue: oldValue, NewValue: newValue})
+		}
+	}
+	sort.Slice(diff, func(i, j int) bool {
+		return diff[i].Field < diff[j].Field
+	})
+	return diff, nil
+}
@@ -0,0 +1,380 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	chargebackObjectType        = "chargeback"
+	chargebackEvidenceIndexName = "chargeback~evidence"
+	chargebackSupplierIndexName = "chargeback~supplier"
+)
+
+// Chargeback types, each backed by a different kind of ledger evidence
+const (
+	ChargebackShortage = "Shortage"
+	ChargebackDamage   = "Damage"
+	ChargebackLate     = "Late"
+)
+
+// Chargeback statuses
+const (
+	ChargebackOpen      = "Open"
+	ChargebackAccepted  = "Accepted"
+	ChargebackContested = "Contested"
+	ChargebackWithdrawn = "Withdrawn"
+)
+
+// Chargeback is a deduction a retailer takes against a supplier for a
+// shipment. Evidence names the ledger record the claim rests on: the ID of
+// a Missing discrepancy for a shortage, of any other declared discrepancy
+// (e.g. Damaged) for damage, or the sequence of a leg delivered after its
+// SLA for a late delivery.
+type Chargeback struct {
+	ID            string  `json:"id"`
+	Type          string  `json:"type"`
+	Retailer      string  `json:"retailer"`
+	Supplier      string  `json:"supplier"`
+	ShipmentID    string  `json:"shipment_id"`
+	Evidence      string  `json:"evidence"`
+	Amount        float64 `json:"amount"`
+	Note          string  `json:"note"`
+	Status        string  `json:"status"`
+	ContestReason string  `json:"contest_reason"`
+	AgeDays       int     `json:"age_days"`
+	CreatedAt     string  `json:"created_at"`
+	UpdatedAt     string  `json:"updated_at"`
+}
+
+// ChargebackAging buckets the amounts outstanding against a supplier, open
+// or contested, by days since the chargeback was taken
+type ChargebackAging struct {
+	Supplier   string  `json:"supplier"`
+	AsOf       string  `json:"as_of"`
+	Days0To30  float64 `json:"days_0_to_30"`
+	Days31To60 float64 `json:"days_31_to_60"`
+	Days61To90 float64 `json:"days_61_to_90"`
+	Over90     float64 `json:"over_90"`
+	Contested  float64 `json:"contested"`
+	Total      float64 `json:"total"`
+}
+
+// OpenChargeback records a deduction the calling retailer takes against a
+// supplier (MSP ID) over a shipment. For a consigned shipment only the
+// consignee may charge back. Each piece of evidence backs one chargeback.
+func (s *SupplyChainContract) OpenChargeback(ctx contractapi.TransactionContextInterface, id, chargebackType, shipmentID, evidence, supplier string, amount float64, note string) (*Chargeback, error) {
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+	mspID, err := s.getClientMSPID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if id == "" || supplier == "" {
+		return nil, newError(ErrInvalidArgument, "chargeback ID and supplier must not be empty")
+	}
+	if supplier == mspID {
+		return nil, newError(ErrInvalidArgument, "an organization cannot charge back itself")
+	}
+	if amount <= 0 || math.IsInf(amount, 0) || math.IsNaN(amount) {
+		return nil, newError(ErrInvalidArgument, "chargeback amount must be positive")
+	}
+	key, err := s.makeKey(ctx, chargebackObjectType, id)
+	if err != nil {
+		return nil, err
+	}
+	var existing Chargeback
+	exists, err := s.getState(ctx, key, &existing)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return nil, newError(ErrAlreadyExists, "chargeback with ID %s already exists", id)
+	}
+
+	shipment, err := s.QueryShipment(ctx, shipmentID)
+	if err != nil {
+		return nil, err
+	}
+	if shipment.ConsigneeMSP != "" && shipment.ConsigneeMSP != mspID {
+		return nil, newError(ErrForbidden, "only the consignee %s may charge back shipment %s", shipment.ConsigneeMSP, shipmentID)
+	}
+	if err := s.checkChargebackEvidence(ctx, chargebackType, shipmentID, evidence); err != nil {
+		return nil, err
+	}
+	claimed, err := s.getIndexedIDs(ctx, chargebackEvidenceIndexName, shipmentID, evidence)
+	if err != nil {
+		return nil, err
+	}
+	if len(claimed) > 0 {
+		return nil, newError(ErrConflict, "evidence %s of shipment %s already backs chargeback %s", evidence, shipmentID, claimed[0])
+	}
+
+	chargeback := Chargeback{
+		ID:         id,
+		Type:       chargebackType,
+		Retailer:   mspID,
+		Supplier:   supplier,
+		ShipmentID: shipmentID,
+		Evidence:   evidence,
+		Amount:     amount,
+		Note:       note,
+		Status:     ChargebackOpen,
+		CreatedAt:  curTime,
+		UpdatedAt:  curTime,
+	}
+	if err := s.putState(ctx, key, &chargeback); err != nil {
+		return nil, err
+	}
+	if err := s.putIndexKey(ctx, chargebackEvidenceIndexName, shipmentID, evidence, id); err != nil {
+		return nil, err
+	}
+	if err := s.putIndexKey(ctx, chargebackSupplierIndexName, supplier, id); err != nil {
+		return nil, err
+	}
+	return &chargeback, nil
+}
+
+// QueryChargeback retrieves a single chargeback from the ledger by ID
+func (s *SupplyChainContract) QueryChargeback(ctx contractapi.TransactionContextInterface, id string) (*Chargeback, error) {
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+	chargeback, err := s.queryChargeback(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := setChargebackAge(chargeback, curTime); err != nil {
+		return nil, err
+	}
+	return chargeback, nil
+}
+
+// AcceptChargeback lets the supplier accept a chargeback, open or
+// previously contested, settling the deduction
+func (s *SupplyChainContract) AcceptChargeback(ctx contractapi.TransactionContextInterface, id string) error {
+	return s.decideChargeback(ctx, id, ChargebackAccepted, "")
+}
+
+// ContestChargeback lets the supplier dispute an open chargeback, giving
+// the reason the evidence does not support it
+func (s *SupplyChainContract) ContestChargeback(ctx contractapi.TransactionContextInterface, id, reason string) error {
+	if reason == "" {
+		return newError(ErrInvalidArgument, "contest reason must not be empty")
+	}
+	return s.decideChargeback(ctx, id, ChargebackContested, reason)
+}
+
+// WithdrawChargeback lets the retailer drop a chargeback the supplier has
+// not accepted, e.g. after a contest it agrees with
+func (s *SupplyChainContract) WithdrawChargeback(ctx contractapi.TransactionContextInterface, id string) error {
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	chargeback, err := s.queryChargeback(ctx, id)
+	if err != nil {
+		return err
+	}
+	mspID, err := s.getClientMSPID(ctx)
+	if err != nil {
+		return err
+	}
+	if mspID != chargeback.Retailer {
+		return newError(ErrForbidden, "only the retailer %s may withdraw chargeback %s", chargeback.Retailer, id)
+	}
+	if chargeback.Status != ChargebackOpen && chargeback.Status != ChargebackContested {
+		return newError(ErrConflict, "chargeback %s is already %s", id, chargeback.Status)
+	}
+
+	chargeback.Status = ChargebackWithdrawn
+	chargeback.UpdatedAt = curTime
+	return s.putChargeback(ctx, chargeback)
+}
+
+// GetSupplierChargebacks returns every chargeback taken against a supplier,
+// oldest first
+func (s *SupplyChainContract) GetSupplierChargebacks(ctx contractapi.TransactionContextInterface, supplier string) ([]*Chargeback, error) {
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+	chargebackIDs, err := s.getIndexedIDs(ctx, chargebackSupplierIndexName, supplier)
+	if err != nil {
+		return nil, err
+	}
+
+	chargebacks := []*Chargeback{}
+	for _, chargebackID := range chargebackIDs {
+		chargeback, err := s.queryChargeback(ctx, chargebackID)
+		if err != nil {
+			return nil, err
+		}
+		if err := setChargebackAge(chargeback, curTime); err != nil {
+			return nil, err
+		}
+		chargebacks = append(chargebacks, chargeback)
+	}
+
+	sort.SliceStable(chargebacks, func(i, j int) bool {
+		return chargebacks[i].CreatedAt < chargebacks[j].CreatedAt
+	})
+	return chargebacks, nil
+}
+
+// GetChargebackAging buckets the chargebacks outstanding against a supplier
+// by age. Contested amounts are counted in their bucket and also totalled
+// separately.
+func (s *SupplyChainContract) GetChargebackAging(ctx contractapi.TransactionContextInterface, supplier string) (*ChargebackAging, error) {
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+	chargebacks, err := s.GetSupplierChargebacks(ctx, supplier)
+	if err != nil {
+		return nil, err
+	}
+
+	aging := &ChargebackAging{Supplier: supplier, AsOf: curTime}
+	for _, chargeback := range chargebacks {
+		if chargeback.Status != ChargebackOpen && chargeback.Status != ChargebackContested {
+			continue
+		}
+		switch {
+		case chargeback.AgeDays <= 30:
+			aging.Days0To30 += chargeback.Amount
+		case chargeback.AgeDays <= 60:
+			aging.Days31To60 += chargeback.Amount
+		case chargeback.AgeDays <= 90:
+			aging.Days61To90 += chargeback.Amount
+		default:
+			aging.Over90 += chargeback.Amount
+		}
+		if chargeback.Status == ChargebackContested {
+			aging.Contested += chargeback.Amount
+		}
+		aging.Total += chargeback.Amount
+	}
+	return aging, nil
+}
+
+// decideChargeback records the supplier's answer to a chargeback
+func (s *SupplyChainContract) decideChargeback(ctx contractapi.TransactionContextInterface, id, status, reason string) error {
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	chargeback, err := s.queryChargeback(ctx, id)
+	if err != nil {
+		return err
+	}
+	mspID, err := s.getClientMSPID(ctx)
+	if err != nil {
+		return err
+	}
+	if mspID != chargeback.Supplier {
+		return newError(ErrForbidden, "only the supplier %s may answer chargeback %s", chargeback.Supplier, id)
+	}
+	// A contested chargeback may still be accepted, but not contested again
+	if chargeback.Status != ChargebackOpen && (chargeback.Status != ChargebackContested || status != ChargebackAccepted) {
+		return newError(ErrConflict, "chargeback %s is %s", id, chargeback.Status)
+	}
+
+	chargeback.Status = status
+	if reason != "" {
+		chargeback.ContestReason = reason
+	}
+	chargeback.UpdatedAt = curTime
+	return s.putChargeback(ctx, chargeback)
+}
+
+// checkChargebackEvidence checks that the evidence is on the ledger and
+// supports the type of chargeback
+func (s *SupplyChainContract) checkChargebackEvidence(ctx contractapi.TransactionContextInterface, chargebackType, shipmentID, evidence string) error {
+	switch chargebackType {
+	case ChargebackShortage, ChargebackDamage:
+		key, err := s.makeKey(ctx, discrepancyObjectType, shipmentID, evidence)
+		if err != nil {
+			return err
+		}
+		var discrepancy Discrepancy
+		exists, err := s.getState(ctx, key, &discrepancy)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return newError(ErrNotFound, "discrepancy with ID %s does not exist on shipment %s", evidence, shipmentID)
+		}
+		shortage := discrepancy.Type == DiscrepancyMissing
+		if discrepancy.Type == DiscrepancyExtra || shortage != (chargebackType == ChargebackShortage) {
+			return newError(ErrInvalidArgument, "a %s discrepancy does not support a %s chargeback", discrepancy.Type, chargebackType)
+		}
+	case ChargebackLate:
+		sequence, err := strconv.Atoi(evidence)
+		if err != nil {
+			return newError(ErrInvalidArgument, "the evidence of a late chargeback must be a leg sequence, got %q", evidence)
+		}
+		leg, err := s.QueryShipmentLeg(ctx, shipmentID, sequence)
+		if err != nil {
+			return err
+		}
+		if leg.Status != LegDelivered || leg.SLAMet {
+			return newError(ErrInvalidArgument, "leg %d of shipment %s was not delivered late", sequence, shipmentID)
+		}
+	default:
+		return newError(ErrInvalidArgument, "chargeback type must be %s, %s or %s", ChargebackShortage, ChargebackDamage, ChargebackLate)
+	}
+	return nil
+}
+
+// queryChargeback reads a chargeback as stored, without its age
+func (s *SupplyChainContract) queryChargeback(ctx contractapi.TransactionContextInterface, id string) (*Chargeback, error) {
+	key, err := s.makeKey(ctx, chargebackObjectType, id)
+	if err != nil {
+		return nil, err
+	}
+
+	var chargeback Chargeback
+	exists, err := s.getState(ctx, key, &chargeback)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, newError(ErrNotFound, "chargeback with ID %s does not exist", id)
+	}
+
+	return &chargeback, nil
+}
+
+// putChargeback writes a chargeback; its age is derived on read, not stored
+func (s *SupplyChainContract) putChargeback(ctx contractapi.TransactionContextInterface, chargeback *Chargeback) error {
+	key, err := s.makeKey(ctx, chargebackObjectType, chargeback.ID)
+	if err != nil {
+		return err
+	}
+	stored := *chargeback
+	stored.AgeDays = 0
+	return s.putState(ctx, key, &stored)
+}
+
+// setChargebackAge derives the whole days since a chargeback was taken
+func setChargebackAge(chargeback *Chargeback, curTime string) error {
+	now, err := time.Parse(time.RFC3339, curTime)
+	if err != nil {
+		return err
+	}
+	createdAt, err := time.Parse(time.RFC3339, chargeback.CreatedAt)
+	if err != nil {
+		return wrapError(err, "chargeback %s has an invalid creation time", chargeback.ID)
+	}
+	chargeback.AgeDays = int(now.Sub(createdAt).Hours() / 24)
+	return nil
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	stateCheckpointObjectType  = "statecheckpoint"
+	checkpointLeavesObjectType = "checkpointleaves"
+
+	// maxCheckpointProducts bounds the products one checkpoint covers
+	maxCheckpointProducts = 5000
+)
+
+// StateCheckpoint is a Merkle root over the product states in scope at the
+// time it was computed. Leaves are ordered by product ID. A leaf is
+// SHA-256(0x00 || product ID || 0x00 || state hash) where the state hash is
+// the SHA-256 of the product's JSON; a parent is SHA-256(0x01 || left ||
+// right) and a node without a sibling is carried up unchanged.
+//
+// The root is meant to be posted to a public blockchain by an off-chain
+// job, which then records where it went with RecordCheckpointAnchor.
+type StateCheckpoint struct {
+	ID        string `json:"id"`
+	Scope     string `json:"scope"`
+	Root      string `json:"root"`
+	LeafCount int    `json:"leaf_count"`
+	CreatedBy string `json:"created_by"`
+	CreatedAt string `json:"created_at"`
+	// Where the root was anchored outside the channel, once it has been
+	AnchorNetwork   string `json:"anchor_network"`
+	AnchorReference string `json:"anchor_reference"`
+	AnchoredAt      string `json:"anchored_at"`
+}
+
+// checkpointLeafSet is the ordered leaf data of a checkpoint, kept so
+// inclusion proofs can be built after the products have changed
+type checkpointLeafSet struct {
+	ProductIDs  []string `json:"product_ids"`
+	StateHashes []string `json:"state_hashes"`
+}
+
+// ProofStep is one sibling hash on the path from a leaf to the root. Left
+// is set when the sibling is the left-hand child.
+type ProofStep struct {
+	Hash string `json:"hash"`
+	Left bool   `json:"left"`
+}
+
+// InclusionProof shows that a product state is a leaf of a checkpoint
+type InclusionProof struct {
+	CheckpointID string      `json:"checkpoint_id"`
+	ProductID    string      `json:"product_id"`
+	StateHash    string      `json:"state_hash"`
+	Path         []ProofStep `json:"path"`
+}
+
+// InclusionResult is the outcome of VerifyInclusion. CurrentState reports
+// whether the product's present state still has the proven state hash.
+type InclusionResult struct {
+	CheckpointID string `json:"checkpoint_id"`
+	ProductID    string `json:"product_id"`
+	Root         string `json:"root"`
+	Included     bool   `json:"included"`
+	CurrentState bool   `json:"current_state"`
+}
+
+// ComputeStateCheckpoint computes and stores the Merkle root over every
+// product, or only the products of one manufacturer when scope is set
+func (s *SupplyChainContract) ComputeStateCheckpoint(ctx contractapi.TransactionContextInterface, scope string) (*StateCheckpoint, error) {
+	if err := s.requireRole(ctx, RoleAdmin); err != nil {
+		return nil, err
+	}
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+	clientID, err := s.getClientID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	leafSet, err := s.checkpointLeaves(ctx, scope)
+	if err != nil {
+		return nil, err
+	}
+	if len(leafSet.ProductIDs) == 0 {
+		return nil, newError(ErrNotFound, "no products in scope %q", scope)
+	}
+	leaves, err := leafSet.hashes()
+	if err != nil {
+		return nil, err
+	}
+
+	checkpoint := StateCheckpoint{
+		ID:        ctx.GetStub().GetTxID(),
+		Scope:     scope,
+		Root:      hex.EncodeToString(merkleRoot(leaves)),
+		LeafCount: len(leaves),
+		CreatedBy: clientID,
+		CreatedAt: curTime,
+	}
+	leavesKey, err := s.makeKey(ctx, checkpointLeavesObjectType, checkpoint.ID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.putCompressedState(ctx, leavesKey, leafSet); err != nil {
+		return nil, err
+	}
+	return &checkpoint, s.putCheckpoint(ctx, &checkpoint)
+}
+
+// QueryStateCheckpoint retrieves a single checkpoint from the ledger by ID
+func (s *SupplyChainContract) QueryStateCheckpoint(ctx contractapi.TransactionContextInterface, id string) (*StateCheckpoint, error) {
+	key, err := s.makeKey(ctx, stateCheckpointObjectType, id)
+	if err != nil {
+		return nil, err
+	}
+
+	var checkpoint StateCheckpoint
+	exists, err := s.getState(ctx, key, &checkpoint)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, newError(ErrNotFound, "checkpoint with ID %s does not exist", id)
+	}
+
+	return &checkpoint, nil
+}
+
+// RecordCheckpointAnchor records that a checkpoint's root was published on
+// an external network, reference being the transaction or block there.
+// A checkpoint is anchored once.
+func (s *SupplyChainContract) RecordCheckpointAnchor(ctx contractapi.TransactionContextInterface, checkpointID, network, reference string) (*StateCheckpoint, error) {
+	if err := s.requireRole(ctx, RoleAdmin); err != nil {
+		return nil, err
+	}
+	if network == "" || reference == "" {
+		return nil, newError(ErrInvalidArgument, "anchor network and reference must not be empty")
+	}
+	checkpoint, err := s.QueryStateCheckpoint(ctx, checkpointID)
+	if err != nil {
+		return nil, err
+	}
+	if checkpoint.AnchoredAt != "" {
+		return nil, newError(ErrConflict, "checkpoint %s was already anchored on %s at %s", checkpointID, checkpoint.AnchorNetwork, checkpoint.AnchoredAt)
+	}
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	checkpoint.AnchorNetwork = network
+	checkpoint.AnchorReference = reference
+	checkpoint.AnchoredAt = curTime
+	return checkpoint, s.putCheckpoint(ctx, checkpoint)
+}
+
+// GetInclusionProof returns the Merkle path from a product's leaf to the
+// root of a checkpoint, which VerifyInclusion or an offline verifier can
+// check against the published root
+func (s *SupplyChainContract) GetInclusionProof(ctx contractapi.TransactionContextInterface, checkpointID, productID string) (*InclusionProof, error) {
+	if _, err := s.QueryStateCheckpoint(ctx, checkpointID); err != nil {
+		return nil, err
+	}
+	leavesKey, err := s.makeKey(ctx, checkpointLeavesObjectType, checkpointID)
+	if err != nil {
+		return nil, err
+	}
+	var leafSet checkpointLeafSet
+	exists, err := s.getState(ctx, leavesKey, &leafSet)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, newError(ErrNotFound, "checkpoint %s has no stored leaves", checkpointID)
+	}
+
+	index := sort.SearchStrings(leafSet.ProductIDs, productID)
+	if index == len(leafSet.ProductIDs) || leafSet.ProductIDs[index] != productID {
+		return nil, newError(ErrNotFound, "product %s is not covered by checkpoint %s", productID, checkpointID)
+	}
+	level, err := leafSet.hashes()
+	if err != nil {
+		return nil, err
+	}
+
+	proof := &InclusionProof{
+		CheckpointID: checkpointID,
+		ProductID:    productID,
+		StateHash:    leafSet.StateHashes[index],
+		Path:         []ProofStep{},
+	}
+	for len(level) > 1 {
+		sibling := index ^ 1
+		if sibling < len(level) {
+			proof.Path = append(proof.Path, ProofStep{Hash: hex.EncodeToString(level[sibling]), Left: sibling < index})
+		}
+		level = merkleLevel(level)
+		index /= 2
+	}
+	return proof, nil
+}
+
+// VerifyInclusion checks an inclusion proof, given as JSON, against the
+// root of the checkpoint it names
+func (s *SupplyChainContract) VerifyInclusion(ctx contractapi.TransactionContextInterface, productID, proof string) (*InclusionResult, error) {
+	var inclusion InclusionProof
+	if err := json.Unmarshal([]byte(proof), &inclusion); err != nil {
+		return nil, newError(ErrInvalidArgument, "failed to parse proof: %v", err)
+	}
+	if inclusion.ProductID != "" && inclusion.ProductID != productID {
+		return nil, newError(ErrInvalidArgument, "proof is for product %s, not %s", inclusion.ProductID, productID)
+	}
+	checkpoint, err := s.QueryStateCheckpoint(ctx, inclusion.CheckpointID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &InclusionResult{CheckpointID: checkpoint.ID, ProductID: productID, Root: checkpoint.Root}
+	stateHash, err := hex.DecodeString(inclusion.StateHash)
+	if err != nil || len(stateHash) != sha256.Size {
+		return nil, newError(ErrInvalidArgument, "state hash must be a hex-encoded SHA-256 digest")
+	}
+	hash := merkleLeaf(productID, stateHash)
+	for _, step := range inclusion.Path {
+		sibling, err := hex.DecodeString(step.Hash)
+		if err != nil || len(sibling) != sha256.Size {
+			return nil, newError(ErrInvalidArgument, "proof hashes must be hex-encoded SHA-256 digests")
+		}
+		if step.Left {
+			hash = merkleParent(sibling, hash)
+		} else {
+			hash = merkleParent(hash, sibling)
+		}
+	}
+	result.Included = hex.EncodeToString(hash) == checkpoint.Root
+
+	productJSON, err := ctx.GetStub().GetState(productID)
+	if err != nil {
+		return nil, wrapError(err, "failed to read from world state")
+	}
+	if productJSON != nil {
+		current := sha256.Sum256(productJSON)
+		result.CurrentState = bytes.Equal(current[:], stateHash)
+	}
+
+	return result, nil
+}
+
+// checkpointLeaves collects the IDs and state hashes of the products in
+// scope, in key order
+func (s *SupplyChainContract) checkpointLeaves(ctx contractapi.TransactionContextInterface, scope string) (*checkpointLeafSet, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByRange("", "")
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	leafSet := &checkpointLeafSet{ProductIDs: []string{}, StateHashes: []string{}}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		if scope != "" {
+			var product Product
+			if err := decodeProduct(queryResponse.Value, &product); err != nil {
+				return nil, err
+			}
+			if product.Manufacturer != scope {
+				continue
+			}
+		}
+		if len(leafSet.ProductIDs) == maxCheckpointProducts {
+			return nil, newError(ErrInvalidArgument, "more than %d products in scope; checkpoint a narrower scope", maxCheckpointProducts)
+		}
+
+		stateHash := sha256.Sum256(queryResponse.Value)
+		leafSet.ProductIDs = append(leafSet.ProductIDs, queryResponse.Key)
+		leafSet.StateHashes = append(leafSet.StateHashes, hex.EncodeToString(stateHash[:]))
+	}
+	return leafSet, nil
+}
+
+// hashes returns the leaf hashes of the set, in order
+func (l *checkpointLeafSet) hashes() ([][]byte, error) {
+	leaves := make([][]byte, 0, len(l.ProductIDs))
+	for i, productID := range l.ProductIDs {
+		stateHash, err := hex.DecodeString(l.StateHashes[i])
+		if err != nil {
+			return nil, err
+		}
+		leaves = append(leaves, merkleLeaf(productID, stateHash))
+	}
+	return leaves, nil
+}
+
+// putCheckpoint writes a checkpoint under its ID
+func (s *SupplyChainContract) putCheckpoint(ctx contractapi.TransactionContextInterface, checkpoint *StateCheckpoint) error {
+	key, err := s.makeKey(ctx, stateCheckpointObjectType, checkpoint.ID)
+	if err != nil {
+		return err
+	}
+	return s.putState(ctx, key, checkpoint)
+}
+
+// merkleLeaf is the leaf hash of a product state
+func merkleLeaf(productID string, stateHash []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{0})
+	h.Write([]byte(productID))
+	h.Write([]byte{0})
+	h.Write(stateHash)
+	return h.Sum(nil)
+}
+
+// merkleParent is the hash of an interior node
+func merkleParent(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{1})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// merkleRoot folds a non-empty level of hashes up to the root
+func merkleRoot(level [][]byte) []byte {
+	for len(level) > 1 {
+		level = merkleLevel(level)
+	}
+	return level[0]
+}
+
+// merkleLevel hashes a level of the tree pairwise into the level above
+func merkleLevel(level [][]byte) [][]byte {
+	next := make([][]byte, 0, (len(level)+1)/2)
+	for i := 0; i < len(level); i += 2 {
+		if i+1 == len(level) {
+			next = append(next, level[i])
+			continue
+		}
+		next = append(next, merkleParent(level[i], level[i+1]))
+	}
+	return next
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const archivedIndexName = "archived~product"
+
+// ArchiveProduct takes a product out of circulation without deleting it.
+// Listings and saved queries leave it out, but QueryProduct, its history
+// and GetArchivedProducts still return it for audits. Only the owner or an
+// admin can archive a product.
+func (s *SupplyChainContract) ArchiveProduct(ctx contractapi.TransactionContextInterface, id string) error {
+	return s.setArchived(ctx, id, true)
+}
+
+// RestoreProduct puts an archived product back into circulation
+func (s *SupplyChainContract) RestoreProduct(ctx contractapi.TransactionContextInterface, id string) error {
+	return s.setArchived(ctx, id, false)
+}
+
+// GetArchivedProducts returns every archived product, ordered by ID
+func (s *SupplyChainContract) GetArchivedProducts(ctx contractapi.TransactionContextInterface) ([]*Product, error) {
+	ids, err := s.getIndexedIDs(ctx, archivedIndexName)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(ids)
+
+	products := []*Product{}
+	for _, id := range ids {
+		product, err := s.QueryProduct(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		products = append(products, product)
+	}
+	return products, nil
+}
+
+// setArchived is the shared implementation of ArchiveProduct and RestoreProduct
+func (s *SupplyChainContract) setArchived(ctx contractapi.TransactionContextInterface, id string, archived bool) error {
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	product, err := s.QueryProduct(ctx, id)
+	if err != nil {
+		return err
+	}
+	if product.Archived == archived {
+		if archived {
+			return newError(ErrConflict, "product %s is already archived", id)
+		}
+		return newError(ErrConflict, "product %s is not archived", id)
+	}
+
+	if err := checkNotFrozen(product); err != nil {
+		return err
+	}
+	action := "restore"
+	if archived {
+		action = "archive"
+	}
+	if err := s.requireProductOwner(ctx, product, action); err != nil {
+		return err
+	}
+
+	if archived {
+		err = s.putIndexKey(ctx, archivedIndexName, id)
+	} else {
+		err = s.delIndexKey(ctx, archivedIndexName, id)
+	}
+	if err != nil {
+		return err
+	}
+
+	product.Archived = archived
+	product.UpdatedAt = curTime
+	return s.putProduct(ctx, product)
+}
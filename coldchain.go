@@ -0,0 +1,138 @@
+package main
+
+import (
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// TemperatureRange is the inclusive range, in degrees Celsius, a cold-chain
+// shipment must be kept in
+type TemperatureRange struct {
+	Min float64 `json:"min"`
+	Max float64 `json:"max"`
+}
+
+// TemperatureAttestation is the reading taken when the goods of a leg
+// change hands. InRange records whether it was within the shipment's range
+// at that moment, which tells whose custody an excursion started in.
+type TemperatureAttestation struct {
+	DeviceID    string  `json:"device_id"`
+	Temperature float64 `json:"temperature"`
+	InRange     bool    `json:"in_range"`
+	AttestedBy  string  `json:"attested_by"`
+	AttestedAt  string  `json:"attested_at"`
+}
+
+// handoffReading is the reading given with a leg pickup or dropoff
+type handoffReading struct {
+	deviceID    string
+	temperature float64
+}
+
+// SetShipmentTemperatureRange makes a shipment cold chain: from then on the
+// pickup and dropoff of each of its legs must be confirmed with a reading
+// (ConfirmLegPickupWithReading and ConfirmLegDropoffWithReading). Only the
+// shipper or an admin can set the range, while the shipment is Created and
+// before the journey starts.
+func (s *SupplyChainContract) SetShipmentTemperatureRange(ctx contractapi.TransactionContextInterface, shipmentID string, minTemperature, maxTemperature float64) error {
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	if minTemperature > maxTemperature {
+		return newError(ErrInvalidArgument, "minimum temperature %g is above the maximum %g", minTemperature, maxTemperature)
+	}
+	for _, temperature := range []float64{minTemperature, maxTemperature} {
+		if err := checkTemperature(temperature); err != nil {
+			return err
+		}
+	}
+	shipment, err := s.QueryShipment(ctx, shipmentID)
+	if err != nil {
+		return err
+	}
+	if err := s.requireShipper(ctx, shipment, "set the temperature range of"); err != nil {
+		return err
+	}
+	if shipment.Status != ShipmentCreated {
+		return newError(ErrConflict, "shipment with ID %s is %s, not %s", shipmentID, shipment.Status, ShipmentCreated)
+	}
+	legs, err := s.GetShipmentLegs(ctx, shipmentID)
+	if err != nil {
+		return err
+	}
+	if len(legs) > 0 && legs[0].Status != LegPending {
+		return newError(ErrConflict, "the journey of shipment %s has already started", shipmentID)
+	}
+
+	shipment.TemperatureRange = &TemperatureRange{Min: minTemperature, Max: maxTemperature}
+	shipment.UpdatedAt = curTime
+	return s.putShipment(ctx, shipment)
+}
+
+// ConfirmLegPickupWithReading is ConfirmLegPickup with the temperature the
+// carrier's device reads as it takes over the goods
+func (s *SupplyChainContract) ConfirmLegPickupWithReading(ctx contractapi.TransactionContextInterface, shipmentID string, sequence int, deviceID string, temperature float64) error {
+	return s.confirmLegPickup(ctx, shipmentID, sequence, &handoffReading{deviceID: deviceID, temperature: temperature})
+}
+
+// ConfirmLegDropoffWithReading is ConfirmLegDropoff with the temperature the
+// carrier's device reads as it hands the goods over
+func (s *SupplyChainContract) ConfirmLegDropoffWithReading(ctx contractapi.TransactionContextInterface, shipmentID string, sequence int, deviceID string, temperature float64) error {
+	return s.confirmLegDropoff(ctx, shipmentID, sequence, &handoffReading{deviceID: deviceID, temperature: temperature})
+}
+
+// attestHandoff checks the reading given with a handoff and returns the
+// attestation to store on the leg. A cold-chain shipment needs a reading;
+// an out-of-range one is recorded rather than refused, since the goods
+// change hands either way.
+func (s *SupplyChainContract) attestHandoff(ctx contractapi.TransactionContextInterface, shipmentID string, reading *handoffReading) (*TemperatureAttestation, error) {
+	shipment, err := s.QueryShipment(ctx, shipmentID)
+	if err != nil {
+		return nil, err
+	}
+	if reading == nil {
+		if shipment.TemperatureRange != nil {
+			return nil, newError(ErrInvalidArgument, "shipment %s is cold chain; its handoffs must be confirmed with a temperature reading", shipmentID)
+		}
+		return nil, nil
+	}
+
+	if reading.deviceID == "" {
+		return nil, newError(ErrInvalidArgument, "device ID must not be empty")
+	}
+	if err := checkTemperature(reading.temperature); err != nil {
+		return nil, err
+	}
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.requireCalibratedDevice(ctx, reading.deviceID, curTime); err != nil {
+		return nil, err
+	}
+	mspID, err := s.getClientMSPID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	attestation := &TemperatureAttestation{
+		DeviceID:    reading.deviceID,
+		Temperature: reading.temperature,
+		InRange:     true,
+		AttestedBy:  mspID,
+		AttestedAt:  curTime,
+	}
+	if r := shipment.TemperatureRange; r != nil {
+		attestation.InRange = reading.temperature >= r.Min && reading.temperature <= r.Max
+	}
+	return attestation, nil
+}
+
+// checkTemperature rejects a physically implausible temperature
+func checkTemperature(temperature float64) error {
+	if temperature < minTemperature || temperature > maxTemperature {
+		return newError(ErrInvalidArgument, "temperature %g is outside the range %g to %g", temperature, minTemperature, maxTemperature)
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const configObjectType = "config"
+
+// Deployment environments
+const (
+	EnvironmentDevelopment = "development"
+	EnvironmentProduction  = "production"
+)
+
+// ContractConfig holds the contract-wide settings managed by admins
+type ContractConfig struct {
+	Environment       string `json:"environment"`
+	SandboxEnabled    bool   `json:"sandbox_enabled"`
+	TimeOffsetSeconds int64  `json:"time_offset_seconds"`
+	// AmendmentWindowHours is how long after creation a product's creator may
+	// correct it with AmendProduct; zero means defaultAmendmentWindowHours
+	AmendmentWindowHours int `json:"amendment_window_hours"`
+	// TelemetryStorage is where raw sensor readings go; empty means public
+	TelemetryStorage    string `json:"telemetry_storage"`
+	TelemetryCollection string `json:"telemetry_collection"`
+	// Free time before detention and demurrage become chargeable; zero
+	// means defaultDetentionFreeMinutes and defaultDemurrageFreeMinutes
+	DetentionFreeMinutes int `json:"detention_free_minutes"`
+	DemurrageFreeMinutes int `json:"demurrage_free_minutes"`
+	// Retention periods for PruneTelemetry and ArchiveProducts; zero
+	// disables the corresponding pruning
+	TelemetryRetentionMonths int `json:"telemetry_retention_months"`
+	ProductRetentionYears    int `json:"product_retention_years"`
+	// ReplayWindowMinutes is how far a signed payload's signed_at may be
+	// from the transaction time; zero means defaultReplayWindowMinutes
+	ReplayWindowMinutes int `json:"replay_window_minutes"`
+	// EventSourcing appends a ChangeEvent for every product mutation
+	EventSourcing bool `json:"event_sourcing"`
+	// StateEncoding is how products are written; empty means json
+	StateEncoding string `json:"state_encoding"`
+	// IDSchemes are the product ID schemes per category; none means
+	// free-form IDs
+	IDSchemes []IDSchemeRule `json:"id_schemes"`
+	// CategoryRules are the validation rules of the products per category
+	CategoryRules []CategoryRuleSet `json:"category_rules"`
+	// HopPolicies limit the transfers of the products per category
+	HopPolicies []HopPolicy `json:"hop_policies"`
+	// OwnerEndorsement restricts the endorsement of a transferred product
+	// to its new owner's organization
+	OwnerEndorsement bool `json:"owner_endorsement"`
+	// SummaryCallers are the chaincodes allowed to call GetProductSummary
+	SummaryCallers []string `json:"summary_callers"`
+	UpdatedAt      string   `json:"updated_at"`
+}
+
+// GetContractConfig returns the current contract configuration. A channel
+// that has never been configured gets the zero config, which behaves like
+// production for every guarded feature.
+func (s *SupplyChainContract) GetContractConfig(ctx contractapi.TransactionContextInterface) (*ContractConfig, error) {
+	key, err := s.makeKey(ctx, configObjectType, "contract")
+	if err != nil {
+		return nil, err
+	}
+
+	config := ContractConfig{IDSchemes: []IDSchemeRule{}, CategoryRules: []CategoryRuleSet{}, HopPolicies: []HopPolicy{}, SummaryCallers: []string{}}
+	if _, err := s.getState(ctx, key, &config); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+// SetEnvironment declares whether this channel is a development or a
+// production deployment. Production is a one-way switch: once set it cannot
+// be reverted, and it turns off every sandbox feature.
+func (s *SupplyChainContract) SetEnvironment(ctx contractapi.TransactionContextInterface, environment string) error {
+	if err := s.requireRole(ctx, RoleAdmin); err != nil {
+		return err
+	}
+	if environment != EnvironmentDevelopment && environment != EnvironmentProduction {
+		return newError(ErrInvalidArgument, "unknown environment %s", environment)
+	}
+
+	config, err := s.GetContractConfig(ctx)
+	if err != nil {
+		return err
+	}
+	if config.Environment == EnvironmentProduction && environment != EnvironmentProduction {
+		return newError(ErrConflict, "a production deployment cannot be switched back to %s", environment)
+	}
+
+	config.Environment = environment
+	if environment == EnvironmentProduction {
+		config.SandboxEnabled = false
+		config.TimeOffsetSeconds = 0
+	}
+
+	return s.putContractConfig(ctx, config)
+}
+
+// SetAmendmentWindow sets how many hours after creation a product may still be amended
+func (s *SupplyChainContract) SetAmendmentWindow(ctx contractapi.TransactionContextInterface, hours int) error {
+	if err := s.requireRole(ctx, RoleAdmin); err != nil {
+		return err
+	}
+	if hours <= 0 {
+		return newError(ErrInvalidArgument, "amendment window must be at least one hour")
+	}
+
+	config, err := s.GetContractConfig(ctx)
+	if err != nil {
+		return err
+	}
+
+	config.AmendmentWindowHours = hours
+	return s.putContractConfig(ctx, config)
+}
+
+// putContractConfig stamps and writes the contract configuration
+func (s *SupplyChainContract) putContractConfig(ctx contractapi.TransactionContextInterface, config *ContractConfig) error {
+	// Stamp with the raw transaction time; the sandbox offset must not leak
+	// into the record of when the configuration itself changed
+	txTimestamp, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return wrapError(err, "failed to get transaction timestamp")
+	}
+	config.UpdatedAt = txTimestamp.AsTime().UTC().Format(time.RFC3339)
+
+	key, err := s.makeKey(ctx, configObjectType, "contract")
+	if err != nil {
+		return err
+	}
+	return s.putState(ctx, key, config)
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	credentialObjectType         = "credential"
+	credentialSubjectIndexName   = "subject~credential"
+	verifiableCredentialBaseType = "VerifiableCredential"
+)
+
+// Credential is a verifiable credential accepted by the certification
+// subsystem: a signed attestation by an issuer DID about a subject, such as
+// an organic or fair-trade certification of a product or a participant.
+// Document is the credential exactly as it was signed; ProofValue is the
+// base64 signature over it by the issuer key VerificationMethod.
+type Credential struct {
+	ID                 string   `json:"id"`
+	Types              []string `json:"types"`
+	Issuer             string   `json:"issuer"`
+	Subject            string   `json:"subject"`
+	IssuanceDate       string   `json:"issuance_date"`
+	ExpirationDate     string   `json:"expiration_date"`
+	VerificationMethod string   `json:"verification_method"`
+	ProofValue         string   `json:"proof_value"`
+	Document           string   `json:"document"`
+	SubmittedBy        string   `json:"submitted_by"`
+	// SubjectType is "product" or "lot" for a credential issued by a
+	// registered certifier with IssueCredential, and empty otherwise
+	SubjectType string `json:"subject_type"`
+	// Revoked is set by the issuer with RevokeCredential
+	Revoked   bool   `json:"revoked"`
+	CreatedAt string `json:"created_at"`
+}
+
+// CredentialVerification is the result of checking a stored credential
+// against its issuer's current DID document
+type CredentialVerification struct {
+	ID     string `json:"id"`
+	Valid  bool   `json:"valid"`
+	Reason string `json:"reason"`
+}
+
+// verifiableCredential holds the parts of a W3C verifiable credential the
+// contract reads
+type verifiableCredential struct {
+	ID                string                 `json:"id"`
+	Type              []string               `json:"type"`
+	Issuer            string                 `json:"issuer"`
+	IssuanceDate      string                 `json:"issuanceDate"`
+	ExpirationDate    string                 `json:"expirationDate"`
+	CredentialSubject map[string]interface{} `json:"credentialSubject"`
+}
+
+// SubmitCredential accepts a verifiable credential after verifying its
+// proof in the chaincode. credentialJSON is the W3C credential without its
+// proof; proofValue is the base64 signature over those exact bytes by
+// verificationMethod, a key of the issuer's registered DID. The credential
+// must be neither expired nor issued in the future.
+func (s *SupplyChainContract) SubmitCredential(ctx contractapi.TransactionContextInterface, credentialJSON, verificationMethod, proofValue string) (*Credential, error) {
+	credential, err := s.newCredential(ctx, credentialJSON, verificationMethod, proofValue)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.putCredential(ctx, credential); err != nil {
+		return nil, err
+	}
+	return credential, nil
+}
+
+// GetCredential returns a submitted credential
+func (s *SupplyChainContract) GetCredential(ctx contractapi.TransactionContextInterface, id string) (*Credential, error) {
+	key, err := s.makeKey(ctx, credentialObjectType, id)
+	if err != nil {
+		return nil, err
+	}
+	var credential Credential
+	exists, err := s.getState(ctx, key, &credential)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, newError(ErrNotFound, "credential %s does not exist", id)
+	}
+	return &credential, nil
+}
+
+// GetCredentialsBySubject returns the credentials submitted about a subject
+func (s *SupplyChainContract) GetCredentialsBySubject(ctx contractapi.TransactionContextInterface, subject string) ([]*Credential, error) {
+	ids, err := s.getIndexedIDs(ctx, credentialSubjectIndexName, subject)
+	if err != nil {
+		return nil, err
+	}
+	credentials := []*Credential{}
+	for _, id := range ids {
+		credential, err := s.GetCredential(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		credentials = append(credentials, credential)
+	}
+	return credentials, nil
+}
+
+// VerifyCredential checks a submitted credential again: it stops verifying
+// once it expires or is revoked, or its issuer removes the key or
+// deactivates the DID
+func (s *SupplyChainContract) VerifyCredential(ctx contractapi.TransactionContextInterface, id string) (*CredentialVerification, error) {
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+	credential, err := s.GetCredential(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &CredentialVerification{ID: id, Valid: true}
+	if err := s.verifyCredential(ctx, credential, curTime); err != nil {
+		result.Valid = false
+		result.Reason = errorMessage(err)
+	}
+	return result, nil
+}
+
+// newCredential parses and verifies a credential with a detached proof, as
+// accepted by SubmitCredential
+func (s *SupplyChainContract) newCredential(ctx contractapi.TransactionContextInterface, credentialJSON, verificationMethod, proofValue string) (*Credential, error) {
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	vc, err := parseVerifiableCredential(credentialJSON)
+	if err != nil {
+		return nil, err
+	}
+	clientID, err := s.getClientID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	credential := Credential{
+		ID:                 vc.ID,
+		Types:              vc.Type,
+		Issuer:             vc.Issuer,
+		Subject:            vc.subject(),
+		IssuanceDate:       vc.IssuanceDate,
+		ExpirationDate:     vc.ExpirationDate,
+		VerificationMethod: verificationMethod,
+		ProofValue:         proofValue,
+		Document:           credentialJSON,
+		SubmittedBy:        clientID,
+		CreatedAt:          curTime,
+	}
+	if err := s.verifyCredential(ctx, &credential, curTime); err != nil {
+		return nil, err
+	}
+	return &credential, nil
+}
+
+// parseVerifiableCredential parses a W3C credential and checks that it has
+// an id, an issuer, a subject and the VerifiableCredential type
+func parseVerifiableCredential(credentialJSON string) (*verifiableCredential, error) {
+	var vc verifiableCredential
+	if err := json.Unmarshal([]byte(credentialJSON), &vc); err != nil {
+		return nil, newError(ErrInvalidArgument, "failed to parse credential: %v", err)
+	}
+	switch {
+	case vc.ID == "":
+		return nil, newError(ErrInvalidArgument, "credential has no id")
+	case vc.Issuer == "":
+		return nil, newError(ErrInvalidArgument, "credential %s has no issuer", vc.ID)
+	case vc.subject() == "":
+		return nil, newError(ErrInvalidArgument, "credential %s has no credentialSubject id", vc.ID)
+	}
+	hasBaseType := false
+	for _, t := range vc.Type {
+		hasBaseType = hasBaseType || t == verifiableCredentialBaseType
+	}
+	if !hasBaseType {
+		return nil, newError(ErrInvalidArgument, "credential %s is not of type %s", vc.ID, verifiableCredentialBaseType)
+	}
+	return &vc, nil
+}
+
+// subject returns the id of the credential's subject
+func (vc *verifiableCredential) subject() string {
+	subject, _ := vc.CredentialSubject["id"].(string)
+	return subject
+}
+
+// putCredential anchors a new credential and indexes it by subject
+func (s *SupplyChainContract) putCredential(ctx contractapi.TransactionContextInterface, credential *Credential) error {
+	key, err := s.makeKey(ctx, credentialObjectType, credential.ID)
+	if err != nil {
+		return err
+	}
+	var existing Credential
+	exists, err := s.getState(ctx, key, &existing)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return newError(ErrAlreadyExists, "credential %s has already been submitted", credential.ID)
+	}
+
+	if err := s.putState(ctx, key, credential); err != nil {
+		return err
+	}
+	return s.putIndexKey(ctx, credentialSubjectIndexName, credential.Subject, credential.ID)
+}
+
+// verifyCredential checks a credential's validity period at time now and
+// its proof against the issuer's DID document
+func (s *SupplyChainContract) verifyCredential(ctx contractapi.TransactionContextInterface, credential *Credential, now string) error {
+	if credential.Revoked {
+		return newError(ErrConflict, "credential %s has been revoked by its issuer", credential.ID)
+	}
+	nowTime, err := time.Parse(time.RFC3339, now)
+	if err != nil {
+		return err
+	}
+	issued, err := time.Parse(time.RFC3339, credential.IssuanceDate)
+	if err != nil {
+		return newError(ErrInvalidArgument, "credential %s has an invalid issuanceDate: %v", credential.ID, err)
+	}
+	if issued.After(nowTime) {
+		return newError(ErrInvalidArgument, "credential %s is not valid before %s", credential.ID, credential.IssuanceDate)
+	}
+	if credential.ExpirationDate != "" {
+		expires, err := time.Parse(time.RFC3339, credential.ExpirationDate)
+		if err != nil {
+			return newError(ErrInvalidArgument, "credential %s has an invalid expirationDate: %v", credential.ID, err)
+		}
+		if !nowTime.Before(expires) {
+			return newError(ErrInvalidArgument, "credential %s expired at %s", credential.ID, credential.ExpirationDate)
+		}
+	}
+
+	if !strings.HasPrefix(credential.VerificationMethod, credential.Issuer+"#") {
+		return newError(ErrInvalidArgument, "verification method %s is not a key of issuer %s", credential.VerificationMethod, credential.Issuer)
+	}
+	issuer, err := s.ResolveDID(ctx, credential.Issuer)
+	if err != nil {
+		return err
+	}
+	if issuer.Deactivated {
+		return newError(ErrConflict, "issuer DID %s is deactivated", credential.Issuer)
+	}
+	method := issuer.verificationMethod(credential.VerificationMethod)
+	if method == nil {
+		return newError(ErrInvalidArgument, "issuer DID %s has no verification method %s", credential.Issuer, credential.VerificationMethod)
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(credential.ProofValue)
+	if err != nil {
+		return newError(ErrInvalidArgument, "proof value must be base64 encoded: %v", err)
+	}
+	valid, err := verifySignature(method.PublicKey, []byte(credential.Document), sig)
+	if err != nil {
+		return wrapError(err, "%s", credential.VerificationMethod)
+	}
+	if !valid {
+		return newError(ErrForbidden, "the proof of credential %s does not verify with %s", credential.ID, credential.VerificationMethod)
+	}
+	return nil
+}
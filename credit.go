@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	creditLimitObjectType = "creditlimit"
+
+	// creditLimitTransientKey is the transient field carrying a private credit limit
+	creditLimitTransientKey = "limit"
+)
+
+// CreditLimit is the most a buyer may owe a seller. A limit kept in a
+// private data collection is recorded publicly with only its Collection;
+// the amount itself is in the collection under the same key.
+type CreditLimit struct {
+	Seller     string  `json:"seller"`
+	Buyer      string  `json:"buyer"`
+	Limit      float64 `json:"limit"`
+	Collection string  `json:"collection"`
+	UpdatedBy  string  `json:"updated_by"`
+	UpdatedAt  string  `json:"updated_at"`
+}
+
+// CreditExposure is a buyer's position against a seller's credit limit
+type CreditExposure struct {
+	Seller       string  `json:"seller"`
+	Buyer        string  `json:"buyer"`
+	Limit        float64 `json:"limit"`
+	OpenInvoices float64 `json:"open_invoices"`
+	Available    float64 `json:"available"`
+}
+
+// SetCreditLimit sets the public credit limit the calling organization
+// grants a buyer
+func (s *SupplyChainContract) SetCreditLimit(ctx contractapi.TransactionContextInterface, buyer string, limit float64) error {
+	if limit < 0 {
+		return newError(ErrInvalidArgument, "credit limit must not be negative")
+	}
+	return s.putCreditLimit(ctx, buyer, limit, "")
+}
+
+// SetPrivateCreditLimit sets a credit limit the calling organization grants
+// a buyer, kept in a private data collection. The amount is passed in the
+// transient field "limit" so it never appears in the transaction.
+func (s *SupplyChainContract) SetPrivateCreditLimit(ctx contractapi.TransactionContextInterface, buyer, collection string) error {
+	if collection == "" {
+		return newError(ErrInvalidArgument, "collection must not be empty")
+	}
+	transient, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return wrapError(err, "failed to read transient data")
+	}
+	value, ok := transient[creditLimitTransientKey]
+	if !ok {
+		return newError(ErrInvalidArgument, "transient field %s is required", creditLimitTransientKey)
+	}
+	limit, err := strconv.ParseFloat(string(value), 64)
+	if err != nil || limit < 0 {
+		return newError(ErrInvalidArgument, "transient field %s must be a non-negative number", creditLimitTransientKey)
+	}
+	return s.putCreditLimit(ctx, buyer, limit, collection)
+}
+
+// GetCreditExposure returns a buyer's open invoices against the limit a
+// seller grants it. Reading a private limit requires access to its collection.
+func (s *SupplyChainContract) GetCreditExposure(ctx contractapi.TransactionContextInterface, seller, buyer string) (*CreditExposure, error) {
+	limit, err := s.findCreditLimit(ctx, seller, buyer)
+	if err != nil {
+		return nil, err
+	}
+	if limit == nil {
+		return nil, newError(ErrNotFound, "%s has no credit limit with %s", buyer, seller)
+	}
+	open, err := s.openInvoiceTotal(ctx, seller, buyer)
+	if err != nil {
+		return nil, err
+	}
+	return &CreditExposure{Seller: seller, Buyer: buyer, Limit: limit.Limit, OpenInvoices: open, Available: limit.Limit - open}, nil
+}
+
+// ApproveCreditOverride lets the seller's finance role accept an order
+// beyond the buyer's credit limit
+func (s *SupplyChainContract) ApproveCreditOverride(ctx contractapi.TransactionContextInterface, orderID string) error {
+	if err := s.requireRole(ctx, RoleFinance); err != nil {
+		return err
+	}
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	order, err := s.requireOrderSeller(ctx, orderID)
+	if err != nil {
+		return err
+	}
+	if order.Status != OrderCreated {
+		return newError(ErrConflict, "order %s is %s and no longer needs an override", orderID, order.Status)
+	}
+	clientID, err := s.getClientID(ctx)
+	if err != nil {
+		return err
+	}
+
+	order.CreditOverrideBy = clientID
+	order.UpdatedAt = curTime
+	return s.putPurchaseOrder(ctx, order)
+}
+
+// checkCreditLimit rejects an order that would take the buyer's open
+// invoices with the seller over its credit limit. Buyers without a limit
+// are not checked.
+func (s *SupplyChainContract) checkCreditLimit(ctx contractapi.TransactionContextInterface, order *PurchaseOrder) error {
+	limit, err := s.findCreditLimit(ctx, order.Seller, order.Buyer)
+	if err != nil || limit == nil {
+		return err
+	}
+	open, err := s.openInvoiceTotal(ctx, order.Seller, order.Buyer)
+	if err != nil {
+		return err
+	}
+	if open+order.Amount > limit.Limit {
+		return newError(ErrConflict, "order %s of %g on top of %g in open invoices exceeds the credit limit of %g for %s; a finance override is required",
+			order.ID, order.Amount, open, limit.Limit, order.Buyer)
+	}
+	return nil
+}
+
+// openInvoiceTotal sums the unpaid invoices a seller has issued a buyer
+func (s *SupplyChainContract) openInvoiceTotal(ctx contractapi.TransactionContextInterface, seller, buyer string) (float64, error) {
+	invoices, err := s.GetInvoicesByPayer(ctx, buyer)
+	if err != nil {
+		return 0, err
+	}
+	var total float64
+	for _, invoice := range invoices {
+		if invoice.Issuer == seller && invoice.Status == InvoiceOpen {
+			total += invoice.Amount
+		}
+	}
+	return total, nil
+}
+
+// putCreditLimit writes the caller's limit for a buyer, publicly or to a
+// private collection with a public record of where it is kept
+func (s *SupplyChainContract) putCreditLimit(ctx contractapi.TransactionContextInterface, buyer string, limit float64, collection string) error {
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	if buyer == "" {
+		return newError(ErrInvalidArgument, "buyer must not be empty")
+	}
+	seller, err := s.getClientMSPID(ctx)
+	if err != nil {
+		return err
+	}
+	clientID, err := s.getClientID(ctx)
+	if err != nil {
+		return err
+	}
+
+	record := CreditLimit{
+		Seller:     seller,
+		Buyer:      buyer,
+		Limit:      limit,
+		Collection: collection,
+		UpdatedBy:  clientID,
+		UpdatedAt:  curTime,
+	}
+	key, err := s.makeKey(ctx, creditLimitObjectType, seller, buyer)
+	if err != nil {
+		return err
+	}
+	if collection == "" {
+		return s.putState(ctx, key, &record)
+	}
+
+	recordJSON, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if err := ctx.GetStub().PutPrivateData(collection, key, recordJSON); err != nil {
+		return wrapError(err, "failed to put to private collection %s", collection)
+	}
+	record.Limit = 0
+	return s.putState(ctx, key, &record)
+}
+
+// findCreditLimit returns the limit a seller grants a buyer, reading it
+// from its private collection when needed, or nil if none is set
+func (s *SupplyChainContract) findCreditLimit(ctx contractapi.TransactionContextInterface, seller, buyer string) (*CreditLimit, error) {
+	key, err := s.makeKey(ctx, creditLimitObjectType, seller, buyer)
+	if err != nil {
+		return nil, err
+	}
+	var record CreditLimit
+	exists, err := s.getState(ctx, key, &record)
+	if err != nil || !exists {
+		return nil, err
+	}
+	if record.Collection == "" {
+		return &record, nil
+	}
+
+	recordJSON, err := ctx.GetStub().GetPrivateData(record.Collection, key)
+	if err != nil {
+		return nil, wrapError(err, "failed to read private collection %s", record.Collection)
+	}
+	if recordJSON == nil {
+		return nil, newError(ErrNotFound, "credit limit for %s is not available in collection %s", buyer, record.Collection)
+	}
+	var private CreditLimit
+	if err := json.Unmarshal(recordJSON, &private); err != nil {
+		return nil, err
+	}
+	return &private, nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/common"
+	"github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// productSummaryVersion is the version of the ProductSummary layout. Fields
+// are only ever added; a change that would break callers gets a new version.
+const productSummaryVersion = 1
+
+// ProductSummary is the stable view of a product for other chaincodes on
+// the channel. A product that does not exist is reported with Exists unset
+// rather than as an error, so callers need not parse error messages.
+type ProductSummary struct {
+	Version   int    `json:"version"`
+	ID        string `json:"id"`
+	Exists    bool   `json:"exists"`
+	Status    string `json:"status"`
+	Owner     string `json:"owner"`
+	Archived  bool   `json:"archived"`
+	Frozen    bool   `json:"frozen"`
+	UpdatedAt string `json:"updated_at"`
+}
+
+// SetSummaryCallers sets the chaincodes allowed to call GetProductSummary
+// through InvokeChaincode
+func (s *SupplyChainContract) SetSummaryCallers(ctx contractapi.TransactionContextInterface, chaincodes []string) error {
+	if err := s.requireRole(ctx, RoleAdmin); err != nil {
+		return err
+	}
+	config, err := s.GetContractConfig(ctx)
+	if err != nil {
+		return err
+	}
+
+	config.SummaryCallers = mergeIDs(chaincodes, nil)
+	return s.putContractConfig(ctx, config)
+}
+
+// GetProductSummary returns the ProductSummary of a product. It is meant
+// for other chaincodes, which call it with InvokeChaincode and must be
+// listed with SetSummaryCallers; clients calling it directly need the admin
+// or finance role.
+func (s *SupplyChainContract) GetProductSummary(ctx contractapi.TransactionContextInterface, id string) (*ProductSummary, error) {
+	if err := s.checkSummaryCaller(ctx); err != nil {
+		return nil, err
+	}
+
+	summary := &ProductSummary{Version: productSummaryVersion, ID: id}
+	productJSON, err := ctx.GetStub().GetState(id)
+	if err != nil {
+		return nil, wrapError(err, "failed to read from world state")
+	}
+	if productJSON == nil {
+		return summary, nil
+	}
+	var product Product
+	if err := decodeProduct(productJSON, &product); err != nil {
+		return nil, err
+	}
+
+	summary.Exists = true
+	summary.Status = product.Status
+	summary.Owner = product.Owner
+	summary.Archived = product.Archived
+	summary.Frozen = product.Frozen
+	summary.UpdatedAt = product.UpdatedAt
+	return summary, nil
+}
+
+// checkSummaryCaller applies the access policy of GetProductSummary. When
+// one chaincode invokes another, the proposal still names the chaincode the
+// client invoked, which is how the calling chaincode is recognised.
+func (s *SupplyChainContract) checkSummaryCaller(ctx contractapi.TransactionContextInterface) error {
+	caller, err := proposalChaincode(ctx)
+	if err != nil {
+		return err
+	}
+	if caller != "" {
+		config, err := s.GetContractConfig(ctx)
+		if err != nil {
+			return err
+		}
+		for _, allowed := range config.SummaryCallers {
+			if caller == allowed {
+				return nil
+			}
+		}
+	}
+
+	for _, role := range []string{RoleAdmin, RoleFinance} {
+		ok, err := s.hasRole(ctx, role)
+		if err != nil || ok {
+			return err
+		}
+	}
+	if caller == "" {
+		return newError(ErrForbidden, "GetProductSummary is for chaincodes allowed with SetSummaryCallers, or callers with the %s or %s role", RoleAdmin, RoleFinance)
+	}
+	return newError(ErrForbidden, "chaincode %s is not allowed to call GetProductSummary", caller)
+}
+
+// proposalChaincode returns the name of the chaincode the transaction
+// proposal invokes, or an empty string if the proposal is not available
+func proposalChaincode(ctx contractapi.TransactionContextInterface) (string, error) {
+	signedProposal, err := ctx.GetStub().GetSignedProposal()
+	if err != nil || signedProposal == nil {
+		return "", err
+	}
+
+	var proposal peer.Proposal
+	if err := proto.Unmarshal(signedProposal.GetProposalBytes(), &proposal); err != nil {
+		return "", wrapError(err, "failed to parse the proposal")
+	}
+	var header common.Header
+	if err := proto.Unmarshal(proposal.GetHeader(), &header); err != nil {
+		return "", wrapError(err, "failed to parse the proposal header")
+	}
+	var channelHeader common.ChannelHeader
+	if err := proto.Unmarshal(header.GetChannelHeader(), &channelHeader); err != nil {
+		return "", wrapError(err, "failed to parse the channel header")
+	}
+	var extension peer.ChaincodeHeaderExtension
+	if err := proto.Unmarshal(channelHeader.GetExtension(), &extension); err != nil {
+		return "", wrapError(err, "failed to parse the chaincode header extension")
+	}
+	return extension.GetChaincodeId().GetName(), nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const custodyAssignmentObjectType = "custody"
+
+// CustodyAssignment records which vehicle and driver held a shipment from
+// EffectiveAt until the next assignment. VehicleRef and DriverRef are
+// SHA-256 hashes of the carrier's own identifiers, so no personal data is
+// visible channel-wide; the carrier can resolve them during an investigation.
+type CustodyAssignment struct {
+	ID          string `json:"id"`
+	ShipmentID  string `json:"shipment_id"`
+	VehicleRef  string `json:"vehicle_ref"`
+	DriverRef   string `json:"driver_ref"`
+	EffectiveAt string `json:"effective_at"`
+	AssignedBy  string `json:"assigned_by"`
+	CreatedAt   string `json:"created_at"`
+}
+
+// AssignCustody records a vehicle and driver taking over a shipment.
+// effectiveAt (RFC3339) defaults to the transaction time. Earlier
+// assignments are kept as the change history. Only the shipment's carrier,
+// the carrier of a leg in transit or an admin can assign custody.
+func (s *SupplyChainContract) AssignCustody(ctx contractapi.TransactionContextInterface, shipmentID, vehicleRef, driverRef, effectiveAt string) (*CustodyAssignment, error) {
+	if err := s.requireABACRole(ctx, RoleCarrier, "assign custody"); err != nil {
+		return nil, err
+	}
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if !isSHA256Hex(vehicleRef) || !isSHA256Hex(driverRef) {
+		return nil, newError(ErrInvalidArgument, "vehicle and driver references must be hex SHA-256 hashes, not the identifiers themselves")
+	}
+	if effectiveAt == "" {
+		effectiveAt = curTime
+	} else {
+		parsed, err := time.Parse(time.RFC3339, effectiveAt)
+		if err != nil {
+			return nil, newError(ErrInvalidArgument, "effective_at must be an RFC3339 timestamp: %v", err)
+		}
+		effectiveAt = parsed.UTC().Format(time.RFC3339)
+	}
+	shipment, err := s.QueryShipment(ctx, shipmentID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.requireCarrier(ctx, shipment, LegInTransit, "assign custody of"); err != nil {
+		return nil, err
+	}
+	clientID, err := s.getClientID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	assignment := CustodyAssignment{
+		ID:          ctx.GetStub().GetTxID(),
+		ShipmentID:  shipmentID,
+		VehicleRef:  vehicleRef,
+		DriverRef:   driverRef,
+		EffectiveAt: effectiveAt,
+		AssignedBy:  clientID,
+		CreatedAt:   curTime,
+	}
+	// Keyed by effective time so a partial key scan returns the history in order
+	key, err := s.makeKey(ctx, custodyAssignmentObjectType, shipmentID, effectiveAt, assignment.ID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.putState(ctx, key, &assignment); err != nil {
+		return nil, err
+	}
+
+	return &assignment, nil
+}
+
+// GetCustodyHistory returns every vehicle and driver assignment of a shipment, ordered by effective time
+func (s *SupplyChainContract) GetCustodyHistory(ctx contractapi.TransactionContextInterface, shipmentID string) ([]*CustodyAssignment, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(custodyAssignmentObjectType, []string{shipmentID})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var assignments []*CustodyAssignment
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var assignment CustodyAssignment
+		if err := json.Unmarshal(queryResponse.Value, &assignment); err != nil {
+			return nil, err
+		}
+		assignments = append(assignments, &assignment)
+	}
+
+	return assignments, nil
+}
+
+// GetCustodyAt returns the assignment that held a shipment at a given time
+// (RFC3339), e.g. when it passed a checkpoint
+func (s *SupplyChainContract) GetCustodyAt(ctx contractapi.TransactionContextInterface, shipmentID, at string) (*CustodyAssignment, error) {
+	parsed, err := time.Parse(time.RFC3339, at)
+	if err != nil {
+		return nil, newError(ErrInvalidArgument, "at must be an RFC3339 timestamp: %v", err)
+	}
+	at = parsed.UTC().Format(time.RFC3339)
+
+	assignments, err := s.GetCustodyHistory(ctx, shipmentID)
+	if err != nil {
+		return nil, err
+	}
+	var current *CustodyAssignment
+	for _, assignment := range assignments {
+		if assignment.EffectiveAt > at {
+			break
+		}
+		current = assignment
+	}
+	if current == nil {
+		return nil, newError(ErrNotFound, "shipment with ID %s had no custody assignment at %s", shipmentID, at)
+	}
+
+	return current, nil
+}
+
+// isSHA256Hex reports whether value looks like a hex encoded SHA-256 digest
+func isSHA256Hex(value string) bool {
+	decoded, err := hex.DecodeString(value)
+	return err == nil && len(decoded) == 32
+}
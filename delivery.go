@@ -0,0 +1,147 @@
+package main
+
+import (
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const proofOfDeliveryObjectType = "pod"
+
+// ProofOfDelivery anchors the evidence of a delivery on the ledger. The
+// signature and photo themselves are kept off-chain; only their hashes are
+// stored.
+type ProofOfDelivery struct {
+	ShipmentID    string `json:"shipment_id"`
+	SignerName    string `json:"signer_name"`
+	SignatureHash string `json:"signature_hash"`
+	PhotoHash     string `json:"photo_hash"`
+	Geo           string `json:"geo"`
+	ConfirmedBy   string `json:"confirmed_by"`
+	ConsigneeMSP  string `json:"consignee_msp"`
+	TxID          string `json:"tx_id"`
+	ConfirmedAt   string `json:"confirmed_at"`
+}
+
+// ConfirmDelivery records the proof of delivery of a shipment that is in
+// transit, delayed or partially received. Only the consignee organization
+// may call it. In the same transaction every product
+// on the manifest is marked Delivered and the shipment is finalized as
+// Received, so delivery and evidence cannot diverge. Shipments with open
+// discrepancies must have them resolved first.
+func (s *SupplyChainContract) ConfirmDelivery(ctx contractapi.TransactionContextInterface, shipmentID, signerName, signatureHash, photoHash, geo string) (*ProofOfDelivery, error) {
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	shipment, err := s.QueryShipment(ctx, shipmentID)
+	if err != nil {
+		return nil, err
+	}
+	if shipment.ConsigneeMSP == "" {
+		return nil, newError(ErrConflict, "shipment with ID %s has no consignee", shipmentID)
+	}
+	switch shipment.Status {
+	case ShipmentInTransit, ShipmentDelayed, ShipmentPartiallyReceived:
+	default:
+		return nil, newError(ErrConflict, "shipment with ID %s is %s and cannot be delivered", shipmentID, shipment.Status)
+	}
+	mspID, err := s.getClientMSPID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if mspID != shipment.ConsigneeMSP {
+		return nil, newError(ErrForbidden, "only the consignee %s can confirm delivery of shipment %s", shipment.ConsigneeMSP, shipmentID)
+	}
+	if signerName == "" || signatureHash == "" {
+		return nil, newError(ErrInvalidArgument, "a proof of delivery needs the signer name and signature hash")
+	}
+
+	key, err := s.makeKey(ctx, proofOfDeliveryObjectType, shipmentID)
+	if err != nil {
+		return nil, err
+	}
+	var existing ProofOfDelivery
+	exists, err := s.getState(ctx, key, &existing)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return nil, newError(ErrAlreadyExists, "delivery of shipment %s was already confirmed at %s", shipmentID, existing.ConfirmedAt)
+	}
+	open, err := s.hasOpenDiscrepancies(ctx, shipmentID, "")
+	if err != nil {
+		return nil, err
+	}
+	if open {
+		return nil, newError(ErrConflict, "shipment with ID %s has open discrepancies", shipmentID)
+	}
+
+	received := make(map[string]bool, len(shipment.ReceivedProductIDs))
+	for _, productID := range shipment.ReceivedProductIDs {
+		received[productID] = true
+	}
+	for _, productID := range shipment.ProductIDs {
+		if received[productID] {
+			continue
+		}
+		product, err := s.QueryProduct(ctx, productID)
+		if err != nil {
+			return nil, err
+		}
+		before := *product
+		if err := s.setProductStatus(ctx, product, "Delivered", nil); err != nil {
+			return nil, err
+		}
+		product.UpdatedAt = curTime
+		if err := s.putIndexedProduct(ctx, before, product); err != nil {
+			return nil, err
+		}
+		shipment.ReceivedProductIDs = append(shipment.ReceivedProductIDs, productID)
+	}
+	shipment.Status = ShipmentReceived
+	shipment.ReceivedAt = curTime
+	shipment.UpdatedAt = curTime
+	if err := s.putShipment(ctx, shipment); err != nil {
+		return nil, err
+	}
+
+	clientID, err := s.getClientID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	pod := ProofOfDelivery{
+		ShipmentID:    shipmentID,
+		SignerName:    signerName,
+		SignatureHash: signatureHash,
+		PhotoHash:     photoHash,
+		Geo:           geo,
+		ConfirmedBy:   clientID,
+		ConsigneeMSP:  mspID,
+		TxID:          ctx.GetStub().GetTxID(),
+		ConfirmedAt:   curTime,
+	}
+	if err := s.putState(ctx, key, &pod); err != nil {
+		return nil, err
+	}
+
+	return &pod, nil
+}
+
+// QueryProofOfDelivery retrieves the proof of delivery of a shipment
+func (s *SupplyChainContract) QueryProofOfDelivery(ctx contractapi.TransactionContextInterface, shipmentID string) (*ProofOfDelivery, error) {
+	key, err := s.makeKey(ctx, proofOfDeliveryObjectType, shipmentID)
+	if err != nil {
+		return nil, err
+	}
+
+	var pod ProofOfDelivery
+	exists, err := s.getState(ctx, key, &pod)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, newError(ErrNotFound, "shipment with ID %s has no proof of delivery", shipmentID)
+	}
+
+	return &pod, nil
+}
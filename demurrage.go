@@ -0,0 +1,208 @@
+package main
+
+import (
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	chargeableTimeObjectType    = "charge"
+	chargeFacilityIndexName     = "charge~facility"
+	chargePartyIndexName        = "charge~party"
+	defaultDetentionFreeMinutes = 120
+	defaultDemurrageFreeMinutes = 1440
+)
+
+// Chargeable time types
+const (
+	ChargeDetention = "Detention"
+	ChargeDemurrage = "Demurrage"
+)
+
+// ChargeableTime is time beyond the free allowance that a party can be
+// billed for. Detention is the wait between arrival (or the appointment
+// start, if the shipment arrived early) and unloading, charged to the
+// facility. Demurrage is the time between unloading and release, charged to
+// the consignee.
+type ChargeableTime struct {
+	ID           string `json:"id"`
+	Type         string `json:"type"`
+	Facility     string `json:"facility"`
+	ShipmentID   string `json:"shipment_id"`
+	SlotID       string `json:"slot_id"`
+	ChargedParty string `json:"charged_party"`
+	From         string `json:"from"`
+	To           string `json:"to"`
+	FreeMinutes  int    `json:"free_minutes"`
+	Minutes      int    `json:"minutes"`
+	CreatedAt    string `json:"created_at"`
+}
+
+// FacilityChargeReport totals the chargeable time recorded at a facility
+type FacilityChargeReport struct {
+	Facility         string            `json:"facility"`
+	DetentionMinutes int               `json:"detention_minutes"`
+	DemurrageMinutes int               `json:"demurrage_minutes"`
+	Records          []*ChargeableTime `json:"records"`
+}
+
+// SetFreeTime sets how many minutes of detention and demurrage are free before time becomes chargeable
+func (s *SupplyChainContract) SetFreeTime(ctx contractapi.TransactionContextInterface, detentionMinutes, demurrageMinutes int) error {
+	if err := s.requireRole(ctx, RoleAdmin); err != nil {
+		return err
+	}
+	if detentionMinutes <= 0 || demurrageMinutes <= 0 {
+		return newError(ErrInvalidArgument, "free time must be positive")
+	}
+
+	config, err := s.GetContractConfig(ctx)
+	if err != nil {
+		return err
+	}
+
+	config.DetentionFreeMinutes = detentionMinutes
+	config.DemurrageFreeMinutes = demurrageMinutes
+	return s.putContractConfig(ctx, config)
+}
+
+// GetChargeableTimeByParty returns every chargeable time record billed to a party
+func (s *SupplyChainContract) GetChargeableTimeByParty(ctx contractapi.TransactionContextInterface, party string) ([]*ChargeableTime, error) {
+	return s.getChargeableTimes(ctx, chargePartyIndexName, party)
+}
+
+// GetFacilityChargeReport returns the chargeable time recorded at a facility with its totals
+func (s *SupplyChainContract) GetFacilityChargeReport(ctx contractapi.TransactionContextInterface, facility string) (*FacilityChargeReport, error) {
+	records, err := s.getChargeableTimes(ctx, chargeFacilityIndexName, facility)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &FacilityChargeReport{Facility: facility, Records: records}
+	for _, record := range records {
+		switch record.Type {
+		case ChargeDetention:
+			report.DetentionMinutes += record.Minutes
+		case ChargeDemurrage:
+			report.DemurrageMinutes += record.Minutes
+		}
+	}
+
+	return report, nil
+}
+
+// getChargeableTimes resolves the chargeable time records found under an index prefix
+func (s *SupplyChainContract) getChargeableTimes(ctx contractapi.TransactionContextInterface, indexName, prefix string) ([]*ChargeableTime, error) {
+	chargeIDs, err := s.getIndexedIDs(ctx, indexName, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	records := []*ChargeableTime{}
+	for _, chargeID := range chargeIDs {
+		key, err := s.makeKey(ctx, chargeableTimeObjectType, chargeID)
+		if err != nil {
+			return nil, err
+		}
+		var record ChargeableTime
+		exists, err := s.getState(ctx, key, &record)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			return nil, newError(ErrNotFound, "chargeable time record %s does not exist", chargeID)
+		}
+		records = append(records, &record)
+	}
+
+	return records, nil
+}
+
+// putChargeableTime computes the detention and demurrage of a released dock
+// visit and writes a record for each one that exceeds its free time
+func (s *SupplyChainContract) putChargeableTime(ctx contractapi.TransactionContextInterface, slot *DockSlot) error {
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	config, err := s.GetContractConfig(ctx)
+	if err != nil {
+		return err
+	}
+	shipment, err := s.QueryShipment(ctx, slot.ShipmentID)
+	if err != nil {
+		return err
+	}
+
+	detentionFree := config.DetentionFreeMinutes
+	if detentionFree == 0 {
+		detentionFree = defaultDetentionFreeMinutes
+	}
+	demurrageFree := config.DemurrageFreeMinutes
+	if demurrageFree == 0 {
+		demurrageFree = defaultDemurrageFreeMinutes
+	}
+	consignee := shipment.ConsigneeMSP
+	if consignee == "" {
+		consignee = shipment.Destination
+	}
+
+	waitStart := slot.ArrivedAt
+	if slot.Start > waitStart {
+		waitStart = slot.Start
+	}
+	charges := []ChargeableTime{
+		{Type: ChargeDetention, ChargedParty: slot.Facility, From: waitStart, To: slot.UnloadedAt, FreeMinutes: detentionFree},
+		{Type: ChargeDemurrage, ChargedParty: consignee, From: slot.UnloadedAt, To: slot.ReleasedAt, FreeMinutes: demurrageFree},
+	}
+
+	for _, charge := range charges {
+		minutes, err := minutesBetween(charge.From, charge.To)
+		if err != nil {
+			return err
+		}
+		if minutes <= charge.FreeMinutes {
+			continue
+		}
+
+		charge.ID = slot.ID + "-" + charge.Type
+		charge.Facility = slot.Facility
+		charge.ShipmentID = slot.ShipmentID
+		charge.SlotID = slot.ID
+		charge.Minutes = minutes - charge.FreeMinutes
+		charge.CreatedAt = curTime
+
+		key, err := s.makeKey(ctx, chargeableTimeObjectType, charge.ID)
+		if err != nil {
+			return err
+		}
+		if err := s.putState(ctx, key, &charge); err != nil {
+			return err
+		}
+		if err := s.putIndexKey(ctx, chargeFacilityIndexName, charge.Facility, charge.ID); err != nil {
+			return err
+		}
+		if err := s.putIndexKey(ctx, chargePartyIndexName, charge.ChargedParty, charge.ID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// minutesBetween returns the whole minutes from one RFC3339 time to another,
+// or zero if to is not after from
+func minutesBetween(from, to string) (int, error) {
+	start, err := time.Parse(time.RFC3339, from)
+	if err != nil {
+		return 0, err
+	}
+	end, err := time.Parse(time.RFC3339, to)
+	if err != nil {
+		return 0, err
+	}
+	if !end.After(start) {
+		return 0, nil
+	}
+	return int(end.Sub(start) / time.Minute), nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const depositEntryObjectType = "deposit"
+
+// Deposit entry types
+const (
+	DepositCharge = "Charge"
+	DepositRefund = "Refund"
+)
+
+// DepositEntry is one line of a partner's returnable-packaging deposit
+// ledger. Charges are positive and refunds negative, so a partner's net
+// balance is the sum of its entries. Only the asset's owner can charge a
+// partner, by issuing the asset, and refund it, by confirming the return.
+type DepositEntry struct {
+	ID        string  `json:"id"`
+	Partner   string  `json:"partner"`
+	Owner     string  `json:"owner"`
+	AssetID   string  `json:"asset_id"`
+	Type      string  `json:"type"`
+	Amount    float64 `json:"amount"`
+	TxID      string  `json:"tx_id"`
+	CreatedAt string  `json:"created_at"`
+}
+
+// DepositBalance summarizes a partner's deposit ledger for invoicing
+type DepositBalance struct {
+	Partner  string          `json:"partner"`
+	Charged  float64         `json:"charged"`
+	Refunded float64         `json:"refunded"`
+	Net      float64         `json:"net"`
+	Entries  []*DepositEntry `json:"entries"`
+}
+
+// GetDepositBalance returns a partner's deposit entries and net balance
+func (s *SupplyChainContract) GetDepositBalance(ctx contractapi.TransactionContextInterface, partner string) (*DepositBalance, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(depositEntryObjectType, []string{partner})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	balance := &DepositBalance{Partner: partner, Entries: []*DepositEntry{}}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var entry DepositEntry
+		if err := json.Unmarshal(queryResponse.Value, &entry); err != nil {
+			return nil, err
+		}
+		if entry.Amount >= 0 {
+			balance.Charged += entry.Amount
+		} else {
+			balance.Refunded -= entry.Amount
+		}
+		balance.Net += entry.Amount
+		balance.Entries = append(balance.Entries, &entry)
+	}
+
+	// Keys are ordered by transaction ID, not time
+	sort.SliceStable(balance.Entries, func(i, j int) bool { return balance.Entries[i].CreatedAt < balance.Entries[j].CreatedAt })
+
+	return balance, nil
+}
+
+// putDepositEntry charges (issue) or refunds (return) an asset's deposit to
+// a partner. Assets without a deposit write no entry.
+func (s *SupplyChainContract) putDepositEntry(ctx contractapi.TransactionContextInterface, asset *ReturnableAsset, partner, entryType string) error {
+	if asset.DepositAmount == 0 {
+		return nil
+	}
+
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	amount := asset.DepositAmount
+	if entryType == DepositRefund {
+		amount = -amount
+	}
+	txID := ctx.GetStub().GetTxID()
+	entry := DepositEntry{
+		ID:        txID + "-" + asset.ID,
+		Partner:   partner,
+		Owner:     asset.Owner,
+		AssetID:   asset.ID,
+		Type:      entryType,
+		Amount:    amount,
+		TxID:      txID,
+		CreatedAt: curTime,
+	}
+
+	key, err := s.makeKey(ctx, depositEntryObjectType, partner, entry.ID)
+	if err != nil {
+		return err
+	}
+	return s.putState(ctx, key, &entry)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// EventDeprecatedCall is emitted when a legacy entry point is invoked. The
+// transaction's own event, if it sets one, takes precedence; the call is
+// also reported as a WarningDeprecatedCall warning, which product events
+// and TxResult carry.
+const EventDeprecatedCall = "DeprecatedCall"
+
+// WarningDeprecatedCall is the warning code reported for calls to legacy
+// entry points
+const WarningDeprecatedCall = "DEPRECATED_CALL"
+
+// DeprecatedMethod is a legacy entry point kept for clients that have not
+// migrated yet, and the method that replaces it
+type DeprecatedMethod struct {
+	Method      string `json:"method"`
+	Replacement string `json:"replacement"`
+	Note        string `json:"note"`
+}
+
+// DeprecatedCallEvent is the payload of EventDeprecatedCall
+type DeprecatedCallEvent struct {
+	Method      string `json:"method"`
+	Replacement string `json:"replacement"`
+	MSPID       string `json:"msp_id"`
+	TxID        string `json:"tx_id"`
+}
+
+// deprecatedMethods lists every legacy entry point. A versioned entry point
+// keeps the old signature and adapts its arguments to the current
+// implementation; GetOrgUsage shows which organizations still call it.
+var deprecatedMethods = map[string]DeprecatedMethod{
+	"UpdateProductV1": {
+		Method:      "UpdateProductV1",
+		Replacement: "UpdateProduct",
+		Note:        "pass the changed fields as a ProductPatch JSON object instead of positional strings",
+	},
+}
+
+// GetDeprecatedMethods returns the legacy entry points still available,
+// ordered by name
+func (s *SupplyChainContract) GetDeprecatedMethods(ctx contractapi.TransactionContextInterface) ([]DeprecatedMethod, error) {
+	methods := []DeprecatedMethod{}
+	for _, method := range deprecatedMethods {
+		methods = append(methods, method)
+	}
+	sort.Slice(methods, func(i, j int) bool {
+		return methods[i].Method < methods[j].Method
+	})
+	return methods, nil
+}
+
+// warnDeprecated raises WarningDeprecatedCall and emits EventDeprecatedCall
+// when the transaction invokes a legacy entry point
+func (s *SupplyChainContract) warnDeprecated(ctx contractapi.TransactionContextInterface) error {
+	deprecated, ok := deprecatedMethods[transactionName(ctx)]
+	if !ok {
+		return nil
+	}
+	mspID, err := s.getClientMSPID(ctx)
+	if err != nil {
+		return err
+	}
+
+	txID := ctx.GetStub().GetTxID()
+	addWarning(ctx, WarningDeprecatedCall, "", "%s is deprecated, use %s: %s", deprecated.Method, deprecated.Replacement, deprecated.Note)
+
+	event := DeprecatedCallEvent{Method: deprecated.Method, Replacement: deprecated.Replacement, MSPID: mspID, TxID: txID}
+	eventJSON, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if err := ctx.GetStub().SetEvent(EventDeprecatedCall, eventJSON); err != nil {
+		return wrapError(err, "failed to set event")
+	}
+	return nil
+}
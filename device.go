@@ -0,0 +1,420 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const deviceObjectType = "device"
+
+// signingKeys is the rotating key state of a device or oracle. PublicKey
+// is the PEM encoded key it signs with. After a rotation the previous key
+// keeps verifying until PreviousKeyValidUntil, so payloads signed before
+// the signer picked up its new key are not lost.
+type signingKeys struct {
+	PublicKey             string `json:"public_key"`
+	PreviousPublicKey     string `json:"previous_public_key"`
+	PreviousKeyValidUntil string `json:"previous_key_valid_until"`
+	// KeyRevoked is set on revocation; no key verifies until the next
+	// rotation
+	KeyRevoked bool `json:"key_revoked"`
+	// RevokedKeys holds the SHA-256 fingerprints of revoked keys, which can
+	// never be rotated back in
+	RevokedKeys []string `json:"revoked_keys"`
+}
+
+// Device is a registered IoT temperature logger, signing its readings with
+// its signingKeys
+type Device struct {
+	ID string `json:"id"`
+	signingKeys
+	Owner             string `json:"owner"`
+	CalibrationExpiry string `json:"calibration_expiry"`
+	RegisteredBy      string `json:"registered_by"`
+	CreatedAt         string `json:"created_at"`
+	UpdatedAt         string `json:"updated_at"`
+}
+
+// RegisterDevice adds a logger to the device registry. calibrationExpiry is
+// the RFC3339 time its current calibration certificate runs out. Only
+// carriers and admins can register devices.
+func (s *SupplyChainContract) RegisterDevice(ctx contractapi.TransactionContextInterface, id, publicKey, owner, calibrationExpiry string) error {
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	isCarrier, err := s.hasRole(ctx, RoleCarrier)
+	if err != nil {
+		return err
+	}
+	isAdmin, err := s.hasRole(ctx, RoleAdmin)
+	if err != nil {
+		return err
+	}
+	if !isCarrier && !isAdmin {
+		return newError(ErrForbidden, "only carriers and admins can register devices")
+	}
+
+	if id == "" {
+		return newError(ErrInvalidArgument, "device ID must not be empty")
+	}
+	if err := validatePublicKey(publicKey); err != nil {
+		return err
+	}
+	expiry, err := time.Parse(time.RFC3339, calibrationExpiry)
+	if err != nil {
+		return newError(ErrInvalidArgument, "calibration expiry must be an RFC3339 timestamp: %v", err)
+	}
+
+	key, err := s.makeKey(ctx, deviceObjectType, id)
+	if err != nil {
+		return err
+	}
+	var existing Device
+	exists, err := s.getState(ctx, key, &existing)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return newError(ErrAlreadyExists, "device with ID %s already exists", id)
+	}
+	clientID, err := s.getClientID(ctx)
+	if err != nil {
+		return err
+	}
+
+	device := Device{
+		ID:                id,
+		signingKeys:       signingKeys{PublicKey: publicKey, RevokedKeys: []string{}},
+		Owner:             owner,
+		CalibrationExpiry: expiry.UTC().Format(time.RFC3339),
+		RegisteredBy:      clientID,
+		CreatedAt:         curTime,
+		UpdatedAt:         curTime,
+	}
+	return s.putState(ctx, key, &device)
+}
+
+// RecordDeviceCalibration records a new calibration of a device, moving its
+// calibration expiry. Only inspectors, the registrant or an admin can
+// record one.
+func (s *SupplyChainContract) RecordDeviceCalibration(ctx contractapi.TransactionContextInterface, id, calibrationExpiry string) error {
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	expiry, err := time.Parse(time.RFC3339, calibrationExpiry)
+	if err != nil {
+		return newError(ErrInvalidArgument, "calibration expiry must be an RFC3339 timestamp: %v", err)
+	}
+	device, err := s.QueryDevice(ctx, id)
+	if err != nil {
+		return err
+	}
+	isInspector, err := s.hasRole(ctx, RoleInspector)
+	if err != nil {
+		return err
+	}
+	if !isInspector {
+		if err := s.requireDeviceManager(ctx, device, "record calibrations"); err != nil {
+			return err
+		}
+	}
+
+	device.CalibrationExpiry = expiry.UTC().Format(time.RFC3339)
+	device.UpdatedAt = curTime
+	return s.putDevice(ctx, device)
+}
+
+// maxKeyOverlapMinutes bounds how long a rotated-out key keeps verifying
+const maxKeyOverlapMinutes = 30 * 24 * 60
+
+// RotateDeviceKey replaces a device's signing key. The old key keeps
+// verifying for overlapMinutes so that readings already signed with it can
+// still be submitted; zero retires it at once. Only the registrant or an
+// admin can rotate a key.
+func (s *SupplyChainContract) RotateDeviceKey(ctx contractapi.TransactionContextInterface, id, newPublicKey string, overlapMinutes int) error {
+	device, err := s.QueryDevice(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := s.requireDeviceManager(ctx, device, "rotate the keys"); err != nil {
+		return err
+	}
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	txTime, err := rawTxTime(ctx)
+	if err != nil {
+		return err
+	}
+	if err := device.rotate("device "+id, newPublicKey, overlapMinutes, txTime); err != nil {
+		return err
+	}
+	device.UpdatedAt = curTime
+	return s.putDevice(ctx, device)
+}
+
+// RevokeDeviceKey revokes every key of a device, for when it has been
+// compromised. Signed readings are refused until RotateDeviceKey installs a
+// new key, and the revoked keys can never be rotated back in.
+func (s *SupplyChainContract) RevokeDeviceKey(ctx contractapi.TransactionContextInterface, id string) error {
+	device, err := s.QueryDevice(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := s.requireDeviceManager(ctx, device, "revoke the keys"); err != nil {
+		return err
+	}
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	if err := device.revoke("device " + id); err != nil {
+		return err
+	}
+	device.UpdatedAt = curTime
+	return s.putDevice(ctx, device)
+}
+
+// QueryDevice retrieves a single device from the registry by ID
+func (s *SupplyChainContract) QueryDevice(ctx contractapi.TransactionContextInterface, id string) (*Device, error) {
+	key, err := s.makeKey(ctx, deviceObjectType, id)
+	if err != nil {
+		return nil, err
+	}
+
+	var device Device
+	exists, err := s.getState(ctx, key, &device)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, newError(ErrNotFound, "device with ID %s does not exist", id)
+	}
+	// Devices registered before key rotation existed have no revocation list
+	if device.RevokedKeys == nil {
+		device.RevokedKeys = []string{}
+	}
+
+	return &device, nil
+}
+
+// putDevice writes a device back to the registry
+func (s *SupplyChainContract) putDevice(ctx contractapi.TransactionContextInterface, device *Device) error {
+	key, err := s.makeKey(ctx, deviceObjectType, device.ID)
+	if err != nil {
+		return err
+	}
+	return s.putState(ctx, key, device)
+}
+
+// requireDeviceManager checks that the caller registered the device or is an
+// admin. action describes the refused operation in the error.
+func (s *SupplyChainContract) requireDeviceManager(ctx contractapi.TransactionContextInterface, device *Device, action string) error {
+	clientID, err := s.getClientID(ctx)
+	if err != nil {
+		return err
+	}
+	if clientID == device.RegisteredBy {
+		return nil
+	}
+	isAdmin, err := s.hasRole(ctx, RoleAdmin)
+	if err != nil {
+		return err
+	}
+	if !isAdmin {
+		return newError(ErrForbidden, "only the registrant or an admin can %s of device %s", action, device.ID)
+	}
+	return nil
+}
+
+// requireCalibratedDevice checks that a device is registered and was in
+// calibration at the time (RFC3339) a reading was taken
+func (s *SupplyChainContract) requireCalibratedDevice(ctx contractapi.TransactionContextInterface, id, recordedAt string) (*Device, error) {
+	device, err := s.QueryDevice(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	expiry, err := time.Parse(time.RFC3339, device.CalibrationExpiry)
+	if err != nil {
+		return nil, wrapError(err, "device %s has an invalid calibration expiry", id)
+	}
+	recorded, err := time.Parse(time.RFC3339, recordedAt)
+	if err != nil {
+		return nil, err
+	}
+	if recorded.After(expiry) {
+		return nil, newError(ErrConflict, "device %s was out of calibration at %s (calibration expired %s)", id, recordedAt, device.CalibrationExpiry)
+	}
+
+	return device, nil
+}
+
+// verifyDeviceSignature checks a base64 signature of message against the
+// device's keys at time now
+func verifyDeviceSignature(device *Device, message []byte, signature string, now time.Time) error {
+	valid, err := device.verify("device "+device.ID, message, signature, now)
+	if err != nil {
+		return err
+	}
+	if !valid {
+		return newError(ErrForbidden, "signature does not match the registered key of device %s", device.ID)
+	}
+	return nil
+}
+
+// rotate replaces the signing key of signer (e.g. "device d1"). The old key
+// keeps verifying for overlapMinutes after txTime; zero retires it at once.
+// A revoked key can neither be rotated back in nor overlap with the new one.
+func (k *signingKeys) rotate(signer, newPublicKey string, overlapMinutes int, txTime time.Time) error {
+	if overlapMinutes < 0 || overlapMinutes > maxKeyOverlapMinutes {
+		return newError(ErrInvalidArgument, "overlap must be between 0 and %d minutes", maxKeyOverlapMinutes)
+	}
+	if err := validatePublicKey(newPublicKey); err != nil {
+		return err
+	}
+	fingerprint, err := keyFingerprint(newPublicKey)
+	if err != nil {
+		return err
+	}
+	for _, revoked := range k.RevokedKeys {
+		if revoked == fingerprint {
+			return newError(ErrConflict, "key %s was revoked and cannot be used again", fingerprint)
+		}
+	}
+	currentFingerprint, err := keyFingerprint(k.PublicKey)
+	if err != nil {
+		return err
+	}
+	if currentFingerprint == fingerprint {
+		return newError(ErrInvalidArgument, "new key of %s must differ from the current one", signer)
+	}
+	if k.KeyRevoked && overlapMinutes > 0 {
+		return newError(ErrConflict, "the key of %s was revoked and cannot overlap with the new one", signer)
+	}
+
+	k.PreviousPublicKey = ""
+	k.PreviousKeyValidUntil = ""
+	if overlapMinutes > 0 {
+		k.PreviousPublicKey = k.PublicKey
+		k.PreviousKeyValidUntil = txTime.Add(time.Duration(overlapMinutes) * time.Minute).Format(time.RFC3339)
+	}
+	k.PublicKey = newPublicKey
+	k.KeyRevoked = false
+	return nil
+}
+
+// revoke revokes the current key of signer and any key still in overlap
+func (k *signingKeys) revoke(signer string) error {
+	if k.KeyRevoked {
+		return newError(ErrConflict, "the key of %s is already revoked", signer)
+	}
+	for _, publicKey := range []string{k.PublicKey, k.PreviousPublicKey} {
+		if publicKey == "" {
+			continue
+		}
+		fingerprint, err := keyFingerprint(publicKey)
+		if err != nil {
+			return err
+		}
+		k.RevokedKeys = append(k.RevokedKeys, fingerprint)
+	}
+	k.PreviousPublicKey = ""
+	k.PreviousKeyValidUntil = ""
+	k.KeyRevoked = true
+	return nil
+}
+
+// verify reports whether a base64 signature of message matches the current
+// key of signer or, until its overlap ends at time now, the key it was
+// rotated from. ECDSA (ASN.1) and RSA (PKCS #1 v1.5) signatures are over
+// the SHA-256 digest; Ed25519 signatures are over the message itself.
+func (k *signingKeys) verify(signer string, message []byte, signature string, now time.Time) (bool, error) {
+	if k.KeyRevoked {
+		return false, newError(ErrConflict, "the key of %s is revoked", signer)
+	}
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return false, newError(ErrInvalidArgument, "signature must be base64 encoded: %v", err)
+	}
+
+	valid, err := verifySignature(k.PublicKey, message, sig)
+	if err != nil {
+		return false, wrapError(err, "%s", signer)
+	}
+	if !valid && k.PreviousPublicKey != "" {
+		validUntil, err := time.Parse(time.RFC3339, k.PreviousKeyValidUntil)
+		if err != nil {
+			return false, wrapError(err, "%s has an invalid key overlap end", signer)
+		}
+		if !now.After(validUntil) {
+			if valid, err = verifySignature(k.PreviousPublicKey, message, sig); err != nil {
+				return false, wrapError(err, "%s", signer)
+			}
+		}
+	}
+	return valid, nil
+}
+
+// verifySignature checks a raw signature of message against a PEM encoded key
+func verifySignature(publicKeyPEM string, message, sig []byte) (bool, error) {
+	publicKey, err := parsePublicKey(publicKeyPEM)
+	if err != nil {
+		return false, err
+	}
+
+	digest := sha256.Sum256(message)
+	switch key := publicKey.(type) {
+	case *ecdsa.PublicKey:
+		return ecdsa.VerifyASN1(key, digest[:], sig), nil
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig) == nil, nil
+	case ed25519.PublicKey:
+		return ed25519.Verify(key, message, sig), nil
+	default:
+		return false, newError(ErrInvalidArgument, "unsupported key type %T", publicKey)
+	}
+}
+
+// keyFingerprint returns the hex SHA-256 of a PEM encoded key's DER bytes
+func keyFingerprint(publicKey string) (string, error) {
+	block, _ := pem.Decode([]byte(publicKey))
+	if block == nil {
+		return "", newError(ErrInvalidArgument, "public key must be PEM encoded")
+	}
+	digest := sha256.Sum256(block.Bytes)
+	return hex.EncodeToString(digest[:]), nil
+}
+
+// validatePublicKey checks that a key is a PEM encoded PKIX public key
+func validatePublicKey(publicKey string) error {
+	_, err := parsePublicKey(publicKey)
+	return err
+}
+
+// parsePublicKey decodes a PEM encoded PKIX public key
+func parsePublicKey(publicKey string) (interface{}, error) {
+	block, _ := pem.Decode([]byte(publicKey))
+	if block == nil {
+		return nil, newError(ErrInvalidArgument, "public key must be PEM encoded")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, newError(ErrInvalidArgument, "invalid public key: %v", err)
+	}
+	return key, nil
+}
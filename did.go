@@ -0,0 +1,258 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const didObjectType = "did"
+
+// VerificationMethod is a public key a DID subject proves control with. ID
+// is the DID URL of the key, the DID followed by #fragment.
+type VerificationMethod struct {
+	ID        string `json:"id"`
+	PublicKey string `json:"public_key"`
+}
+
+// DIDDocument is the on-ledger record a DID resolves to. Controller is the
+// organization that registered the DID and alone may change it; a
+// deactivated DID still resolves but verifies nothing.
+type DIDDocument struct {
+	ID                  string               `json:"id"`
+	Controller          string               `json:"controller"`
+	VerificationMethods []VerificationMethod `json:"verification_methods"`
+	Deactivated         bool                 `json:"deactivated"`
+	CreatedAt           string               `json:"created_at"`
+	UpdatedAt           string               `json:"updated_at"`
+}
+
+// RegisterDID records a DID controlled by the calling organization with its
+// first verification method, a PEM encoded public key
+func (s *SupplyChainContract) RegisterDID(ctx contractapi.TransactionContextInterface, did, fragment, publicKey string) error {
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	if !didPattern.MatchString(did) {
+		return newError(ErrInvalidArgument, "invalid DID %q: expected did:<method>:<identifier>", did)
+	}
+	method, err := newVerificationMethod(did, fragment, publicKey)
+	if err != nil {
+		return err
+	}
+
+	existing, err := s.findDID(ctx, did)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return newError(ErrAlreadyExists, "DID %s is already registered", did)
+	}
+	mspID, err := s.getClientMSPID(ctx)
+	if err != nil {
+		return err
+	}
+
+	doc := DIDDocument{
+		ID:                  did,
+		Controller:          mspID,
+		VerificationMethods: []VerificationMethod{*method},
+		CreatedAt:           curTime,
+		UpdatedAt:           curTime,
+	}
+	return s.putDID(ctx, &doc)
+}
+
+// AddVerificationMethod adds a key to a DID, e.g. ahead of a key rotation
+func (s *SupplyChainContract) AddVerificationMethod(ctx contractapi.TransactionContextInterface, did, fragment, publicKey string) error {
+	doc, err := s.didForUpdate(ctx, did)
+	if err != nil {
+		return err
+	}
+	method, err := newVerificationMethod(did, fragment, publicKey)
+	if err != nil {
+		return err
+	}
+	if doc.verificationMethod(method.ID) != nil {
+		return newError(ErrAlreadyExists, "DID %s already has verification method %s", did, method.ID)
+	}
+
+	doc.VerificationMethods = append(doc.VerificationMethods, *method)
+	return s.putDID(ctx, doc)
+}
+
+// RemoveVerificationMethod removes a key from a DID. Credentials proved
+// with the key no longer verify.
+func (s *SupplyChainContract) RemoveVerificationMethod(ctx contractapi.TransactionContextInterface, did, methodID string) error {
+	doc, err := s.didForUpdate(ctx, did)
+	if err != nil {
+		return err
+	}
+	methods := []VerificationMethod{}
+	for _, method := range doc.VerificationMethods {
+		if method.ID != methodID {
+			methods = append(methods, method)
+		}
+	}
+	if len(methods) == len(doc.VerificationMethods) {
+		return newError(ErrNotFound, "DID %s has no verification method %s", did, methodID)
+	}
+
+	doc.VerificationMethods = methods
+	return s.putDID(ctx, doc)
+}
+
+// DeactivateDID permanently deactivates a DID
+func (s *SupplyChainContract) DeactivateDID(ctx contractapi.TransactionContextInterface, did string) error {
+	doc, err := s.didForUpdate(ctx, did)
+	if err != nil {
+		return err
+	}
+	doc.Deactivated = true
+	return s.putDID(ctx, doc)
+}
+
+// ResolveDID returns the document a DID resolves to
+func (s *SupplyChainContract) ResolveDID(ctx contractapi.TransactionContextInterface, did string) (*DIDDocument, error) {
+	doc, err := s.findDID(ctx, did)
+	if err != nil {
+		return nil, err
+	}
+	if doc == nil {
+		return nil, newError(ErrNotFound, "DID %s is not registered", did)
+	}
+	return doc, nil
+}
+
+// SetProductDID gives a product a registered DID. Only the owning
+// organization or an admin can set it.
+func (s *SupplyChainContract) SetProductDID(ctx contractapi.TransactionContextInterface, id, did string) error {
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	product, err := s.QueryProduct(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := checkNotFrozen(product); err != nil {
+		return err
+	}
+	if err := s.requireProductOwner(ctx, product, "set the DID of"); err != nil {
+		return err
+	}
+	if err := s.requireActiveDID(ctx, did); err != nil {
+		return err
+	}
+
+	product.DID = did
+	product.UpdatedAt = curTime
+	return s.putProduct(ctx, product)
+}
+
+// SetParticipantDID gives a registered participant a registered DID
+func (s *SupplyChainContract) SetParticipantDID(ctx contractapi.TransactionContextInterface, id, did string) error {
+	if err := s.requireRole(ctx, RoleAdmin); err != nil {
+		return err
+	}
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	participant, err := s.GetParticipant(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := s.requireActiveDID(ctx, did); err != nil {
+		return err
+	}
+
+	participant.DID = did
+	participant.UpdatedAt = curTime
+	key, err := s.makeKey(ctx, participantObjectType, id)
+	if err != nil {
+		return err
+	}
+	return s.putState(ctx, key, participant)
+}
+
+// verificationMethod returns the method with the given DID URL, or nil
+func (d *DIDDocument) verificationMethod(id string) *VerificationMethod {
+	for i := range d.VerificationMethods {
+		if d.VerificationMethods[i].ID == id {
+			return &d.VerificationMethods[i]
+		}
+	}
+	return nil
+}
+
+// newVerificationMethod checks a key and names it did#fragment
+func newVerificationMethod(did, fragment, publicKey string) (*VerificationMethod, error) {
+	if fragment == "" || strings.ContainsAny(fragment, "#/?") {
+		return nil, newError(ErrInvalidArgument, "invalid verification method fragment %q", fragment)
+	}
+	if err := validatePublicKey(publicKey); err != nil {
+		return nil, err
+	}
+	return &VerificationMethod{ID: did + "#" + fragment, PublicKey: publicKey}, nil
+}
+
+// requireActiveDID returns an error unless did is registered and active
+func (s *SupplyChainContract) requireActiveDID(ctx contractapi.TransactionContextInterface, did string) error {
+	doc, err := s.ResolveDID(ctx, did)
+	if err != nil {
+		return err
+	}
+	if doc.Deactivated {
+		return newError(ErrConflict, "DID %s is deactivated", did)
+	}
+	return nil
+}
+
+// didForUpdate returns an active DID the calling organization controls
+func (s *SupplyChainContract) didForUpdate(ctx contractapi.TransactionContextInterface, did string) (*DIDDocument, error) {
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := s.ResolveDID(ctx, did)
+	if err != nil {
+		return nil, err
+	}
+	if doc.Deactivated {
+		return nil, newError(ErrConflict, "DID %s is deactivated", did)
+	}
+	mspID, err := s.getClientMSPID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if mspID != doc.Controller {
+		return nil, newError(ErrForbidden, "only the controller %s can change DID %s", doc.Controller, did)
+	}
+	doc.UpdatedAt = curTime
+	return doc, nil
+}
+
+// findDID returns a DID document, or nil if the DID is not registered
+func (s *SupplyChainContract) findDID(ctx contractapi.TransactionContextInterface, did string) (*DIDDocument, error) {
+	key, err := s.makeKey(ctx, didObjectType, did)
+	if err != nil {
+		return nil, err
+	}
+	var doc DIDDocument
+	exists, err := s.getState(ctx, key, &doc)
+	if err != nil || !exists {
+		return nil, err
+	}
+	return &doc, nil
+}
+
+// putDID writes a DID document
+func (s *SupplyChainContract) putDID(ctx contractapi.TransactionContextInterface, doc *DIDDocument) error {
+	key, err := s.makeKey(ctx, didObjectType, doc.ID)
+	if err != nil {
+		return err
+	}
+	return s.putState(ctx, key, doc)
+}
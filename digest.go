@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Digest is everything that needs an organization's attention since a point
+// in time, compiled in one read for a daily summary. Every list is oldest
+// first.
+type Digest struct {
+	Org         string `json:"org"`
+	Since       string `json:"since"`
+	GeneratedAt string `json:"generated_at"`
+	// NewOrders are purchase orders placed with the org since then that it
+	// has not accepted yet
+	NewOrders []*PurchaseOrder `json:"new_orders"`
+	// NewChargebacks are chargebacks taken against the org since then that
+	// it has not answered yet
+	NewChargebacks []*Chargeback `json:"new_chargebacks"`
+	// Recalls are products the org owns that were recalled since then
+	Recalls []*Product `json:"recalls"`
+	// OverdueReceipts are shipments consigned to the org that have arrived,
+	// by their last leg or their ETA, and whose receipt it has not yet
+	// acknowledged
+	OverdueReceipts []*Shipment `json:"overdue_receipts"`
+	// BreachedSLAs are legs the org carries or receives whose SLA due time
+	// passed since then without a delivery in time
+	BreachedSLAs []*ShipmentLeg `json:"breached_slas"`
+}
+
+// GenerateDigest compiles the digest of an organization (MSP ID) since an
+// RFC3339 timestamp. Only the organization itself or an admin may read it.
+func (s *SupplyChainContract) GenerateDigest(ctx contractapi.TransactionContextInterface, org, since string) (*Digest, error) {
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+	mspID, err := s.getClientMSPID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if mspID != org {
+		if err := s.requireRole(ctx, RoleAdmin); err != nil {
+			return nil, err
+		}
+	}
+	sinceTime, err := time.Parse(time.RFC3339, since)
+	if err != nil {
+		return nil, newError(ErrInvalidArgument, "since must be an RFC3339 timestamp: %v", err)
+	}
+	since = sinceTime.UTC().Format(time.RFC3339)
+
+	digest := &Digest{
+		Org:             org,
+		Since:           since,
+		GeneratedAt:     curTime,
+		NewOrders:       []*PurchaseOrder{},
+		NewChargebacks:  []*Chargeback{},
+		Recalls:         []*Product{},
+		OverdueReceipts: []*Shipment{},
+		BreachedSLAs:    []*ShipmentLeg{},
+	}
+
+	if err := s.digestOrders(ctx, digest); err != nil {
+		return nil, err
+	}
+	chargebacks, err := s.GetSupplierChargebacks(ctx, org)
+	if err != nil {
+		return nil, err
+	}
+	for _, chargeback := range chargebacks {
+		if chargeback.Status == ChargebackOpen && chargeback.CreatedAt >= since {
+			digest.NewChargebacks = append(digest.NewChargebacks, chargeback)
+		}
+	}
+	products, err := s.GetProductsByOwner(ctx, org)
+	if err != nil {
+		return nil, err
+	}
+	for _, product := range products {
+		if product.Status == "Recalled" && product.UpdatedAt >= since {
+			digest.Recalls = append(digest.Recalls, product)
+		}
+	}
+	sort.SliceStable(digest.Recalls, func(i, j int) bool {
+		return digest.Recalls[i].UpdatedAt < digest.Recalls[j].UpdatedAt
+	})
+	if err := s.digestShipments(ctx, digest); err != nil {
+		return nil, err
+	}
+
+	return digest, nil
+}
+
+// digestOrders adds the orders awaiting the org's acceptance
+func (s *SupplyChainContract) digestOrders(ctx contractapi.TransactionContextInterface, digest *Digest) error {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(purchaseOrderObjectType, []string{})
+	if err != nil {
+		return err
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return err
+		}
+
+		var order PurchaseOrder
+		if err := json.Unmarshal(queryResponse.Value, &order); err != nil {
+			return err
+		}
+		if order.Seller == digest.Org && order.Status == OrderCreated && order.CreatedAt >= digest.Since {
+			digest.NewOrders = append(digest.NewOrders, &order)
+		}
+	}
+
+	sort.SliceStable(digest.NewOrders, func(i, j int) bool {
+		return digest.NewOrders[i].CreatedAt < digest.NewOrders[j].CreatedAt
+	})
+	return nil
+}
+
+// digestShipments adds the unacknowledged arrivals and SLA breaches of the
+// shipments the org receives or carries a leg of
+func (s *SupplyChainContract) digestShipments(ctx contractapi.TransactionContextInterface, digest *Digest) error {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(shipmentObjectType, []string{})
+	if err != nil {
+		return err
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return err
+		}
+
+		var shipment Shipment
+		if err := json.Unmarshal(queryResponse.Value, &shipment); err != nil {
+			return err
+		}
+		legs, err := s.GetShipmentLegs(ctx, shipment.ID)
+		if err != nil {
+			return err
+		}
+
+		consignee := shipment.ConsigneeMSP == digest.Org
+		for _, leg := range legs {
+			if !consignee && leg.CarrierMSP != digest.Org {
+				continue
+			}
+			if leg.SLADueAt < digest.Since || leg.SLADueAt >= digest.GeneratedAt {
+				continue
+			}
+			if leg.Status != LegDelivered || !leg.SLAMet {
+				digest.BreachedSLAs = append(digest.BreachedSLAs, leg)
+			}
+		}
+
+		if !consignee || shipment.Status == ShipmentReceived || shipment.Status == ShipmentCancelled {
+			continue
+		}
+		arrived := shipment.ETA != "" && shipment.ETA < digest.GeneratedAt
+		if len(legs) > 0 && legs[len(legs)-1].Status == LegDelivered {
+			arrived = true
+		}
+		if arrived {
+			digest.OverdueReceipts = append(digest.OverdueReceipts, &shipment)
+		}
+	}
+
+	sort.SliceStable(digest.OverdueReceipts, func(i, j int) bool {
+		return digest.OverdueReceipts[i].CreatedAt < digest.OverdueReceipts[j].CreatedAt
+	})
+	sort.SliceStable(digest.BreachedSLAs, func(i, j int) bool {
+		return digest.BreachedSLAs[i].SLADueAt < digest.BreachedSLAs[j].SLADueAt
+	})
+	return nil
+}
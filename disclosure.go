@@ -0,0 +1,304 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	disclosureObjectType       = "disclosure"
+	disclosureProductIndexName = "disclosure~product"
+)
+
+// disclosableFields are the fields a disclosure may reveal. Ownership,
+// creator and pricing data are deliberately absent. origins and allergens
+// are derived the same way as GetProductOrigins and GetProductAllergens.
+var disclosableFields = map[string]bool{
+	"id":            true,
+	"name":          true,
+	"status":        true,
+	"description":   true,
+	"category":      true,
+	"manufacturer":  true,
+	"serial_number": true,
+	"lot_id":        true,
+	"created_at":    true,
+	"origins":       true,
+	"allergens":     true,
+}
+
+// Disclosure is a redacted view of a product that its holder can share
+// outside the channel. Each field holds the JSON encoding of its value and
+// Hash is SHA-256(salt || JSON of fields, keys sorted). Only the hash and the
+// field names are kept on the ledger, so a verifier can check the values
+// without the ledger revealing them.
+type Disclosure struct {
+	ID        string            `json:"id"`
+	ProductID string            `json:"product_id"`
+	Audience  string            `json:"audience"`
+	Fields    map[string]string `json:"fields"`
+	Salt      string            `json:"salt"`
+	Hash      string            `json:"hash"`
+	CreatedAt string            `json:"created_at"`
+}
+
+// DisclosureRecord is the ledger commitment to a disclosure
+type DisclosureRecord struct {
+	ID         string   `json:"id"`
+	ProductID  string   `json:"product_id"`
+	Audience   string   `json:"audience"`
+	FieldNames []string `json:"field_names"`
+	Hash       string   `json:"hash"`
+	CreatedBy  string   `json:"created_by"`
+	CreatedAt  string   `json:"created_at"`
+}
+
+// DisclosureVerification is the outcome of VerifyDisclosure
+type DisclosureVerification struct {
+	DisclosureID string `json:"disclosure_id"`
+	Valid        bool   `json:"valid"`
+	Reason       string `json:"reason"`
+}
+
+// GenerateDisclosure builds a view of a product revealing only the fields
+// named in fieldsJSON (a JSON array) for the given audience, and commits its
+// hash to the ledger. Only the product's owner can disclose it.
+func (s *SupplyChainContract) GenerateDisclosure(ctx contractapi.TransactionContextInterface, productID, fieldsJSON, audience string) (*Disclosure, error) {
+	var fieldNames []string
+	if err := json.Unmarshal([]byte(fieldsJSON), &fieldNames); err != nil {
+		return nil, newError(ErrInvalidArgument, "failed to parse fields: %v", err)
+	}
+	if len(fieldNames) == 0 {
+		return nil, newError(ErrInvalidArgument, "a disclosure needs at least one field")
+	}
+	if audience == "" {
+		return nil, newError(ErrInvalidArgument, "audience must not be empty")
+	}
+	fieldNames = mergeIDs(fieldNames, nil)
+	for _, name := range fieldNames {
+		if !disclosableFields[name] {
+			return nil, newError(ErrInvalidArgument, "field %s cannot be disclosed", name)
+		}
+	}
+
+	product, err := s.QueryProduct(ctx, productID)
+	if err != nil {
+		return nil, err
+	}
+	mspID, err := s.getClientMSPID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if mspID != product.Owner {
+		return nil, newError(ErrForbidden, "only the owner %s can disclose product %s", product.Owner, productID)
+	}
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+	clientID, err := s.getClientID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	fields, err := s.disclosureFields(ctx, product, fieldNames)
+	if err != nil {
+		return nil, err
+	}
+
+	// The salt must be the same on every endorser, so it comes from the
+	// transaction rather than a random source
+	txID := ctx.GetStub().GetTxID()
+	salt := sha256.Sum256([]byte(txID + "|" + productID))
+	disclosure := &Disclosure{
+		ID:        txID,
+		ProductID: productID,
+		Audience:  audience,
+		Fields:    fields,
+		Salt:      hex.EncodeToString(salt[:]),
+		CreatedAt: curTime,
+	}
+	disclosure.Hash, err = disclosureHash(disclosure)
+	if err != nil {
+		return nil, err
+	}
+
+	record := DisclosureRecord{
+		ID:         disclosure.ID,
+		ProductID:  productID,
+		Audience:   audience,
+		FieldNames: fieldNames,
+		Hash:       disclosure.Hash,
+		CreatedBy:  clientID,
+		CreatedAt:  curTime,
+	}
+	key, err := s.makeKey(ctx, disclosureObjectType, record.ID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.putState(ctx, key, &record); err != nil {
+		return nil, err
+	}
+	if err := s.putIndexKey(ctx, disclosureProductIndexName, productID, record.ID); err != nil {
+		return nil, err
+	}
+
+	return disclosure, nil
+}
+
+// QueryDisclosureRecord retrieves the ledger commitment of a disclosure by ID
+func (s *SupplyChainContract) QueryDisclosureRecord(ctx contractapi.TransactionContextInterface, id string) (*DisclosureRecord, error) {
+	key, err := s.makeKey(ctx, disclosureObjectType, id)
+	if err != nil {
+		return nil, err
+	}
+
+	var record DisclosureRecord
+	exists, err := s.getState(ctx, key, &record)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, newError(ErrNotFound, "disclosure with ID %s does not exist", id)
+	}
+
+	return &record, nil
+}
+
+// GetProductDisclosures returns the commitments of every disclosure of a
+// product, oldest first
+func (s *SupplyChainContract) GetProductDisclosures(ctx contractapi.TransactionContextInterface, productID string) ([]*DisclosureRecord, error) {
+	disclosureIDs, err := s.getIndexedIDs(ctx, disclosureProductIndexName, productID)
+	if err != nil {
+		return nil, err
+	}
+
+	records := []*DisclosureRecord{}
+	for _, disclosureID := range disclosureIDs {
+		record, err := s.QueryDisclosureRecord(ctx, disclosureID)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+
+	// Keys are ordered by transaction ID, not time
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].CreatedAt < records[j].CreatedAt
+	})
+	return records, nil
+}
+
+// VerifyDisclosure checks a disclosure, as JSON, against its commitment:
+// the hash must match and the disclosure must not add, drop or rename
+// fields. It does not compare against the product's current state, which
+// may have moved on since the disclosure was made.
+func (s *SupplyChainContract) VerifyDisclosure(ctx contractapi.TransactionContextInterface, disclosureJSON string) (*DisclosureVerification, error) {
+	var disclosure Disclosure
+	if err := json.Unmarshal([]byte(disclosureJSON), &disclosure); err != nil {
+		return nil, newError(ErrInvalidArgument, "failed to parse disclosure: %v", err)
+	}
+	record, err := s.QueryDisclosureRecord(ctx, disclosure.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &DisclosureVerification{DisclosureID: record.ID}
+	fieldNames := make([]string, 0, len(disclosure.Fields))
+	for name := range disclosure.Fields {
+		fieldNames = append(fieldNames, name)
+	}
+	sort.Strings(fieldNames)
+
+	hash, err := disclosureHash(&disclosure)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case disclosure.ProductID != record.ProductID || disclosure.Audience != record.Audience:
+		result.Reason = "product or audience does not match the ledger"
+	case !equalStrings(fieldNames, record.FieldNames):
+		result.Reason = "disclosed fields do not match the ledger"
+	case hash != record.Hash:
+		result.Reason = "field values do not match the ledger hash"
+	default:
+		result.Valid = true
+	}
+	return result, nil
+}
+
+// disclosureFields collects the JSON value of each named field
+func (s *SupplyChainContract) disclosureFields(ctx contractapi.TransactionContextInterface, product *Product, fieldNames []string) (map[string]string, error) {
+	productJSON, err := json.Marshal(product)
+	if err != nil {
+		return nil, err
+	}
+	var productFields map[string]json.RawMessage
+	if err := json.Unmarshal(productJSON, &productFields); err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string]string, len(fieldNames))
+	for _, name := range fieldNames {
+		var value interface{}
+		switch name {
+		case "origins":
+			originIDs, err := s.productOriginIDs(ctx, product)
+			if err != nil {
+				return nil, err
+			}
+			if value, err = s.queryOrigins(ctx, originIDs); err != nil {
+				return nil, err
+			}
+		case "allergens":
+			if value, err = s.productAllergens(ctx, product); err != nil {
+				return nil, err
+			}
+		default:
+			fields[name] = string(productFields[name])
+			continue
+		}
+
+		valueJSON, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		fields[name] = string(valueJSON)
+	}
+	return fields, nil
+}
+
+// disclosureHash is SHA-256(salt || fields JSON). json.Marshal sorts map
+// keys, so the encoding does not depend on the order the fields were shared in.
+func disclosureHash(disclosure *Disclosure) (string, error) {
+	salt, err := hex.DecodeString(disclosure.Salt)
+	if err != nil {
+		return "", newError(ErrInvalidArgument, "salt must be hex-encoded")
+	}
+	fieldsJSON, err := json.Marshal(disclosure.Fields)
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	h.Write(salt)
+	h.Write(fieldsJSON)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// equalStrings reports whether two string slices hold the same values in the same order
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	disputeObjectType       = "dispute"
+	disputeProductIndexName = "dispute~product"
+)
+
+// Dispute case types
+const (
+	DisputeDiversion     = "Diversion"
+	DisputeLCDiscrepancy = "LCDiscrepancy"
+)
+
+// Dispute case statuses
+const (
+	DisputeOpen     = "Open"
+	DisputeResolved = "Resolved"
+)
+
+// DisputeCase is a case raised for review against a product, or against
+// another record named by Reference. Party and Market identify who and
+// where the case concerns, when known.
+type DisputeCase struct {
+	ID          string `json:"id"`
+	Type        string `json:"type"`
+	ProductID   string `json:"product_id"`
+	Reference   string `json:"reference"`
+	Party       string `json:"party"`
+	Market      string `json:"market"`
+	Description string `json:"description"`
+	Status      string `json:"status"`
+	OpenedBy    string `json:"opened_by"`
+	Resolution  string `json:"resolution"`
+	ResolvedBy  string `json:"resolved_by"`
+	CreatedAt   string `json:"created_at"`
+	UpdatedAt   string `json:"updated_at"`
+}
+
+// QueryDispute retrieves a single dispute case from the ledger by ID
+func (s *SupplyChainContract) QueryDispute(ctx contractapi.TransactionContextInterface, id string) (*DisputeCase, error) {
+	key, err := s.makeKey(ctx, disputeObjectType, id)
+	if err != nil {
+		return nil, err
+	}
+
+	var dispute DisputeCase
+	exists, err := s.getState(ctx, key, &dispute)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, newError(ErrNotFound, "dispute with ID %s does not exist", id)
+	}
+
+	return &dispute, nil
+}
+
+// GetProductDisputes returns every dispute case raised against a product, oldest first
+func (s *SupplyChainContract) GetProductDisputes(ctx contractapi.TransactionContextInterface, productID string) ([]*DisputeCase, error) {
+	disputeIDs, err := s.getIndexedIDs(ctx, disputeProductIndexName, productID)
+	if err != nil {
+		return nil, err
+	}
+
+	disputes := []*DisputeCase{}
+	for _, disputeID := range disputeIDs {
+		dispute, err := s.QueryDispute(ctx, disputeID)
+		if err != nil {
+			return nil, err
+		}
+		disputes = append(disputes, dispute)
+	}
+
+	// Keys are ordered by transaction ID, not time
+	sort.SliceStable(disputes, func(i, j int) bool {
+		return disputes[i].CreatedAt < disputes[j].CreatedAt
+	})
+	return disputes, nil
+}
+
+// GetOpenDisputes returns every dispute case awaiting resolution, optionally
+// narrowed to one case type
+func (s *SupplyChainContract) GetOpenDisputes(ctx contractapi.TransactionContextInterface, disputeType string) ([]*DisputeCase, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(disputeObjectType, []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	disputes := []*DisputeCase{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var dispute DisputeCase
+		if err := json.Unmarshal(queryResponse.Value, &dispute); err != nil {
+			return nil, err
+		}
+		if dispute.Status != DisputeOpen || (disputeType != "" && dispute.Type != disputeType) {
+			continue
+		}
+		disputes = append(disputes, &dispute)
+	}
+
+	// Keys are ordered by transaction ID, not time
+	sort.SliceStable(disputes, func(i, j int) bool {
+		return disputes[i].CreatedAt < disputes[j].CreatedAt
+	})
+	return disputes, nil
+}
+
+// ResolveDispute closes an open dispute case with a resolution note
+func (s *SupplyChainContract) ResolveDispute(ctx contractapi.TransactionContextInterface, id, resolution string) error {
+	if err := s.requireRole(ctx, RoleAdmin); err != nil {
+		return err
+	}
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	dispute, err := s.QueryDispute(ctx, id)
+	if err != nil {
+		return err
+	}
+	if dispute.Status != DisputeOpen {
+		return newError(ErrConflict, "dispute %s is already %s", id, dispute.Status)
+	}
+	if resolution == "" {
+		return newError(ErrInvalidArgument, "resolution must not be empty")
+	}
+	clientID, err := s.getClientID(ctx)
+	if err != nil {
+		return err
+	}
+
+	dispute.Status = DisputeResolved
+	dispute.Resolution = resolution
+	dispute.ResolvedBy = clientID
+	dispute.UpdatedAt = curTime
+
+	key, err := s.makeKey(ctx, disputeObjectType, id)
+	if err != nil {
+		return err
+	}
+	return s.putState(ctx, key, dispute)
+}
+
+// openDispute writes a new open case against a product or a referenced
+// record and indexes it under the product, if any
+func (s *SupplyChainContract) openDispute(ctx contractapi.TransactionContextInterface, disputeType, productID, reference, party, market, description string) (*DisputeCase, error) {
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+	clientID, err := s.getClientID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	subject := productID
+	if subject == "" {
+		subject = reference
+	}
+	dispute := DisputeCase{
+		// A transaction may open cases on several products, so the ID includes the subject
+		ID:          ctx.GetStub().GetTxID() + "-" + subject,
+		Type:        disputeType,
+		ProductID:   productID,
+		Reference:   reference,
+		Party:       party,
+		Market:      market,
+		Description: description,
+		Status:      DisputeOpen,
+		OpenedBy:    clientID,
+		CreatedAt:   curTime,
+		UpdatedAt:   curTime,
+	}
+
+	key, err := s.makeKey(ctx, disputeObjectType, dispute.ID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.putState(ctx, key, &dispute); err != nil {
+		return nil, err
+	}
+	if productID != "" {
+		if err := s.putIndexKey(ctx, disputeProductIndexName, productID, dispute.ID); err != nil {
+			return nil, err
+		}
+	}
+	return &dispute, nil
+}
+
+// hasOpenDispute reports whether a product already has an open case of a
+// type in a market, so repeated sightings do not pile up duplicate cases
+func (s *SupplyChainContract) hasOpenDispute(ctx contractapi.TransactionContextInterface, disputeType, productID, market string) (bool, error) {
+	disputes, err := s.GetProductDisputes(ctx, productID)
+	if err != nil {
+		return false, err
+	}
+	for _, dispute := range disputes {
+		if dispute.Status == DisputeOpen && dispute.Type == disputeType && dispute.Market == market {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	productScanObjectType     = "scan"
+	distributionPathIndexName = "distribution~path"
+)
+
+// ProductScan records a product seen in a market, e.g. by a field
+// inspector or a point-of-sale scanner. DisputeID is set when the sighting
+// opened a diversion case.
+type ProductScan struct {
+	ID        string `json:"id"`
+	ProductID string `json:"product_id"`
+	Market    string `json:"market"`
+	Location  string `json:"location"`
+	ScannedBy string `json:"scanned_by"`
+	DisputeID string `json:"dispute_id"`
+	CreatedAt string `json:"created_at"`
+}
+
+// RecordProductScan records a sighting of a product in a market and opens a
+// diversion case if no participant on the product's distribution path is
+// authorized for that market
+func (s *SupplyChainContract) RecordProductScan(ctx contractapi.TransactionContextInterface, productID, market, location string) (*ProductScan, error) {
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if market == "" {
+		return nil, newError(ErrInvalidArgument, "market must not be empty")
+	}
+	product, err := s.QueryProduct(ctx, productID)
+	if err != nil {
+		return nil, err
+	}
+	clientID, err := s.getClientID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	scan := ProductScan{
+		ID:        ctx.GetStub().GetTxID(),
+		ProductID: productID,
+		Market:    market,
+		Location:  location,
+		ScannedBy: clientID,
+		CreatedAt: curTime,
+	}
+
+	authorized, err := s.authorizedMarkets(ctx, product)
+	if err != nil {
+		return nil, err
+	}
+	if len(authorized) > 0 && !authorized[market] {
+		dispute, err := s.openDiversion(ctx, product, "", market, fmt.Sprintf("product scanned at %s in unauthorized market %s", location, market))
+		if err != nil {
+			return nil, err
+		}
+		if dispute != nil {
+			scan.DisputeID = dispute.ID
+		}
+	}
+
+	key, err := s.makeKey(ctx, productScanObjectType, productID, scan.ID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.putState(ctx, key, &scan); err != nil {
+		return nil, err
+	}
+	return &scan, nil
+}
+
+// GetProductScans returns every recorded sighting of a product, oldest first
+func (s *SupplyChainContract) GetProductScans(ctx contractapi.TransactionContextInterface, productID string) ([]*ProductScan, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(productScanObjectType, []string{productID})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	scans := []*ProductScan{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var scan ProductScan
+		if err := json.Unmarshal(queryResponse.Value, &scan); err != nil {
+			return nil, err
+		}
+		scans = append(scans, &scan)
+	}
+
+	// Keys are ordered by transaction ID, not time
+	sort.SliceStable(scans, func(i, j int) bool {
+		return scans[i].CreatedAt < scans[j].CreatedAt
+	})
+	return scans, nil
+}
+
+// checkTransferDiversion opens a diversion case when a product is handed to
+// a registered participant none of whose markets is authorized by the
+// product's distribution path so far, then adds the new owner to the path
+func (s *SupplyChainContract) checkTransferDiversion(ctx contractapi.TransactionContextInterface, product *Product, newOwner string) error {
+	participant, err := s.findParticipant(ctx, newOwner)
+	if err != nil {
+		return err
+	}
+	if participant != nil && len(participant.Markets) > 0 {
+		authorized, err := s.authorizedMarkets(ctx, product)
+		if err != nil {
+			return err
+		}
+		diverted := len(authorized) > 0
+		for _, market := range participant.Markets {
+			if authorized[market] {
+				diverted = false
+			}
+		}
+		if diverted {
+			markets := strings.Join(participant.Markets, ",")
+			description := fmt.Sprintf("product transferred to %s operating in unauthorized market(s) %s", newOwner, markets)
+			if _, err := s.openDiversion(ctx, product, newOwner, markets, description); err != nil {
+				return err
+			}
+		}
+	}
+
+	return s.putIndexKey(ctx, distributionPathIndexName, product.ID, newOwner)
+}
+
+// authorizedMarkets returns the markets of every registered participant on
+// a product's distribution path: its manufacturer, current owner and every
+// owner it was transferred to. An empty result means no participant on the
+// path has a market mapping, so diversion cannot be judged.
+func (s *SupplyChainContract) authorizedMarkets(ctx contractapi.TransactionContextInterface, product *Product) (map[string]bool, error) {
+	path, err := s.getIndexedIDs(ctx, distributionPathIndexName, product.ID)
+	if err != nil {
+		return nil, err
+	}
+	path = mergeIDs(path, []string{product.Manufacturer, product.Owner})
+
+	authorized := make(map[string]bool)
+	for _, party := range path {
+		participant, err := s.findParticipant(ctx, party)
+		if err != nil {
+			return nil, err
+		}
+		if participant == nil {
+			continue
+		}
+		for _, market := range participant.Markets {
+			authorized[market] = true
+		}
+	}
+	return authorized, nil
+}
+
+// openDiversion opens a diversion case unless one is already open for the
+// product in the same market. It returns nil if no new case was opened.
+func (s *SupplyChainContract) openDiversion(ctx contractapi.TransactionContextInterface, product *Product, party, market, description string) (*DisputeCase, error) {
+	open, err := s.hasOpenDispute(ctx, DisputeDiversion, product.ID, market)
+	if err != nil || open {
+		return nil, err
+	}
+	return s.openDispute(ctx, DisputeDiversion, product.ID, "", party, market, description)
+}
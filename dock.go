@@ -0,0 +1,359 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const dockSlotObjectType = "dock"
+
+// Dock slot statuses
+const (
+	DockSlotRequested = "Requested"
+	DockSlotConfirmed = "Confirmed"
+	DockSlotMissed    = "Missed"
+)
+
+// Dock events, recorded in this order
+const (
+	DockEventArrived  = "Arrived"
+	DockEventUnloaded = "Unloaded"
+	DockEventReleased = "Released"
+)
+
+// dockEarlyArrival is how long before its window a shipment can arrive for
+// an appointment
+const dockEarlyArrival = 24 * time.Hour
+
+// DockSlot is an appointment for a shipment at a facility's dock. Start and
+// End are RFC3339 times in UTC, as are the times the shipment arrived, was
+// unloaded and was released.
+type DockSlot struct {
+	ID         string `json:"id"`
+	Facility   string `json:"facility"`
+	ShipmentID string `json:"shipment_id"`
+	Start      string `json:"start"`
+	End        string `json:"end"`
+	Status     string `json:"status"`
+	BookedBy   string `json:"booked_by"`
+	ArrivedAt  string `json:"arrived_at"`
+	UnloadedAt string `json:"unloaded_at"`
+	ReleasedAt string `json:"released_at"`
+	CreatedAt  string `json:"created_at"`
+	UpdatedAt  string `json:"updated_at"`
+}
+
+// BookDockSlot requests a dock appointment for a shipment. window is an
+// RFC3339 interval "start/end". The request fails if it overlaps a requested
+// or confirmed appointment at the same facility.
+func (s *SupplyChainContract) BookDockSlot(ctx contractapi.TransactionContextInterface, facility, shipmentID, window string) (*DockSlot, error) {
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if facility == "" {
+		return nil, newError(ErrInvalidArgument, "facility must not be empty")
+	}
+	start, end, err := parseWindow(window)
+	if err != nil {
+		return nil, err
+	}
+	if end <= curTime {
+		return nil, newError(ErrInvalidArgument, "window %s has already ended", window)
+	}
+	if _, err := s.QueryShipment(ctx, shipmentID); err != nil {
+		return nil, err
+	}
+
+	slots, err := s.GetFacilityDockSlots(ctx, facility)
+	if err != nil {
+		return nil, err
+	}
+	for _, slot := range slots {
+		if slot.Status == DockSlotMissed {
+			continue
+		}
+		if slot.Start < end && start < slot.End {
+			return nil, newError(ErrConflict, "window overlaps appointment %s (%s/%s) at %s", slot.ID, slot.Start, slot.End, facility)
+		}
+	}
+
+	clientID, err := s.getClientID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	slot := DockSlot{
+		ID:         ctx.GetStub().GetTxID(),
+		Facility:   facility,
+		ShipmentID: shipmentID,
+		Start:      start,
+		End:        end,
+		Status:     DockSlotRequested,
+		BookedBy:   clientID,
+		CreatedAt:  curTime,
+		UpdatedAt:  curTime,
+	}
+	if err := s.putDockSlot(ctx, &slot); err != nil {
+		return nil, err
+	}
+
+	return &slot, nil
+}
+
+// ConfirmDockSlot confirms a requested appointment before its window ends.
+// Only the facility or an admin can confirm it.
+func (s *SupplyChainContract) ConfirmDockSlot(ctx contractapi.TransactionContextInterface, facility, slotID string) error {
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	slot, err := s.QueryDockSlot(ctx, facility, slotID)
+	if err != nil {
+		return err
+	}
+	if err := s.requireFacility(ctx, slot, "confirm"); err != nil {
+		return err
+	}
+	if slot.Status != DockSlotRequested {
+		return newError(ErrConflict, "appointment %s is %s, not %s", slotID, slot.Status, DockSlotRequested)
+	}
+	if curTime >= slot.End {
+		return newError(ErrConflict, "the window of appointment %s has already ended", slotID)
+	}
+
+	slot.Status = DockSlotConfirmed
+	slot.UpdatedAt = curTime
+	return s.putDockSlot(ctx, slot)
+}
+
+// MarkDockSlotMissed records that a shipment did not arrive for its
+// appointment. It is only possible once the window has ended without an
+// arrival, and frees the window for other bookings. Only the facility or an
+// admin can mark an appointment missed.
+func (s *SupplyChainContract) MarkDockSlotMissed(ctx contractapi.TransactionContextInterface, facility, slotID string) error {
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	slot, err := s.QueryDockSlot(ctx, facility, slotID)
+	if err != nil {
+		return err
+	}
+	if err := s.requireFacility(ctx, slot, "mark missed"); err != nil {
+		return err
+	}
+	if slot.Status == DockSlotMissed {
+		return newError(ErrConflict, "appointment %s is already marked missed", slotID)
+	}
+	if slot.ArrivedAt != "" {
+		return newError(ErrConflict, "the shipment arrived for appointment %s at %s", slotID, slot.ArrivedAt)
+	}
+	if curTime < slot.End {
+		return newError(ErrConflict, "the window of appointment %s has not ended yet", slotID)
+	}
+
+	slot.Status = DockSlotMissed
+	slot.UpdatedAt = curTime
+	return s.putDockSlot(ctx, slot)
+}
+
+// RecordDockEvent records the arrival, unloading or release of a shipment at
+// its confirmed appointment. at (RFC3339) defaults to the transaction time;
+// it cannot lie in the future or more than dockEarlyArrival before the
+// window. Events must be recorded in order; the release computes the
+// chargeable detention and demurrage time of the visit. Only the facility
+// or an admin can record events, and the shipment's carrier its arrival.
+func (s *SupplyChainContract) RecordDockEvent(ctx contractapi.TransactionContextInterface, facility, slotID, event, at string) error {
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	if at == "" {
+		at = curTime
+	} else {
+		parsed, err := time.Parse(time.RFC3339, at)
+		if err != nil {
+			return newError(ErrInvalidArgument, "event time must be an RFC3339 timestamp: %v", err)
+		}
+		at = parsed.UTC().Format(time.RFC3339)
+	}
+	if at > curTime {
+		return newError(ErrInvalidArgument, "event time %s is in the future", at)
+	}
+	slot, err := s.QueryDockSlot(ctx, facility, slotID)
+	if err != nil {
+		return err
+	}
+	if slot.Status != DockSlotConfirmed {
+		return newError(ErrConflict, "appointment %s is %s, not %s", slotID, slot.Status, DockSlotConfirmed)
+	}
+	start, err := time.Parse(time.RFC3339, slot.Start)
+	if err != nil {
+		return err
+	}
+	if earliest := start.Add(-dockEarlyArrival).Format(time.RFC3339); at < earliest {
+		return newError(ErrInvalidArgument, "event time %s is more than %v before appointment %s", at, dockEarlyArrival, slotID)
+	}
+	if err := s.requireDockEventRecorder(ctx, slot, event); err != nil {
+		return err
+	}
+
+	switch event {
+	case DockEventArrived:
+		if slot.ArrivedAt != "" {
+			return newError(ErrConflict, "arrival for appointment %s is already recorded", slotID)
+		}
+		slot.ArrivedAt = at
+	case DockEventUnloaded:
+		if slot.ArrivedAt == "" || slot.UnloadedAt != "" || at < slot.ArrivedAt {
+			return newError(ErrConflict, "unloading must be recorded once, after the arrival")
+		}
+		slot.UnloadedAt = at
+	case DockEventReleased:
+		if slot.UnloadedAt == "" || slot.ReleasedAt != "" || at < slot.UnloadedAt {
+			return newError(ErrConflict, "release must be recorded once, after the unloading")
+		}
+		slot.ReleasedAt = at
+		if err := s.putChargeableTime(ctx, slot); err != nil {
+			return err
+		}
+	default:
+		return newError(ErrInvalidArgument, "unknown dock event %s", event)
+	}
+
+	slot.UpdatedAt = curTime
+	return s.putDockSlot(ctx, slot)
+}
+
+// QueryDockSlot retrieves a single appointment from a facility's calendar
+func (s *SupplyChainContract) QueryDockSlot(ctx contractapi.TransactionContextInterface, facility, slotID string) (*DockSlot, error) {
+	key, err := s.makeKey(ctx, dockSlotObjectType, facility, slotID)
+	if err != nil {
+		return nil, err
+	}
+
+	var slot DockSlot
+	exists, err := s.getState(ctx, key, &slot)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, newError(ErrNotFound, "appointment %s does not exist at %s", slotID, facility)
+	}
+
+	return &slot, nil
+}
+
+// GetFacilityDockSlots returns a facility's calendar, ordered by start time
+func (s *SupplyChainContract) GetFacilityDockSlots(ctx contractapi.TransactionContextInterface, facility string) ([]*DockSlot, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(dockSlotObjectType, []string{facility})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var slots []*DockSlot
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var slot DockSlot
+		if err := json.Unmarshal(queryResponse.Value, &slot); err != nil {
+			return nil, err
+		}
+		slots = append(slots, &slot)
+	}
+
+	// Keys are ordered by transaction ID, not time
+	sort.SliceStable(slots, func(i, j int) bool { return slots[i].Start < slots[j].Start })
+
+	return slots, nil
+}
+
+// putDockSlot is a helper method for inserting or updating an appointment in the ledger
+func (s *SupplyChainContract) putDockSlot(ctx contractapi.TransactionContextInterface, slot *DockSlot) error {
+	key, err := s.makeKey(ctx, dockSlotObjectType, slot.Facility, slot.ID)
+	if err != nil {
+		return err
+	}
+	return s.putState(ctx, key, slot)
+}
+
+// requireFacility checks that the caller's organization runs the facility
+// of an appointment, directly or as its registered participant, or that the
+// caller is an admin. action describes the refused operation in the error.
+func (s *SupplyChainContract) requireFacility(ctx contractapi.TransactionContextInterface, slot *DockSlot, action string) error {
+	mspID, err := s.getClientMSPID(ctx)
+	if err != nil {
+		return err
+	}
+	if mspID == slot.Facility {
+		return nil
+	}
+	participant, err := s.findParticipant(ctx, slot.Facility)
+	if err != nil {
+		return err
+	}
+	if participant != nil && participant.MSPID == mspID {
+		return nil
+	}
+	isAdmin, err := s.hasRole(ctx, RoleAdmin)
+	if err != nil {
+		return err
+	}
+	if !isAdmin {
+		return newError(ErrForbidden, "only the facility %s can %s appointment %s", slot.Facility, action, slot.ID)
+	}
+	return nil
+}
+
+// requireDockEventRecorder checks that the caller can record event at an
+// appointment: the facility or an admin, or for the arrival also the
+// shipment's carrier
+func (s *SupplyChainContract) requireDockEventRecorder(ctx contractapi.TransactionContextInterface, slot *DockSlot, event string) error {
+	if event == DockEventArrived {
+		shipment, err := s.QueryShipment(ctx, slot.ShipmentID)
+		if err != nil {
+			return err
+		}
+		mspID, err := s.getClientMSPID(ctx)
+		if err != nil {
+			return err
+		}
+		if mspID == shipment.Carrier {
+			return nil
+		}
+	}
+	return s.requireFacility(ctx, slot, "record events of")
+}
+
+// parseWindow splits an RFC3339 interval "start/end" and returns both ends
+// normalized to UTC
+func parseWindow(window string) (string, string, error) {
+	parts := strings.Split(window, "/")
+	if len(parts) != 2 {
+		return "", "", newError(ErrInvalidArgument, "window must be an interval of the form start/end")
+	}
+	start, err := time.Parse(time.RFC3339, parts[0])
+	if err != nil {
+		return "", "", newError(ErrInvalidArgument, "window start must be an RFC3339 timestamp: %v", err)
+	}
+	end, err := time.Parse(time.RFC3339, parts[1])
+	if err != nil {
+		return "", "", newError(ErrInvalidArgument, "window end must be an RFC3339 timestamp: %v", err)
+	}
+	if !end.After(start) {
+		return "", "", newError(ErrInvalidArgument, "window must end after it starts")
+	}
+	return start.UTC().Format(time.RFC3339), end.UTC().Format(time.RFC3339), nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const tradeDocumentObjectType = "document"
+
+// TradeDocument anchors a trade document (bill of lading, commercial
+// invoice, certificate of origin...) to an order. The document itself is
+// kept off-chain; only its SHA-256 hash is stored.
+type TradeDocument struct {
+	OrderID    string `json:"order_id"`
+	Type       string `json:"type"`
+	Hash       string `json:"hash"`
+	AttachedBy string `json:"attached_by"`
+	AttachedAt string `json:"attached_at"`
+}
+
+// AttachOrderDocument attaches the hash of a document of the given type to
+// an order, replacing an earlier document of that type. Only the buyer or
+// seller may attach documents.
+func (s *SupplyChainContract) AttachOrderDocument(ctx contractapi.TransactionContextInterface, orderID, docType, hash string) error {
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.requireOrderParty(ctx, orderID); err != nil {
+		return err
+	}
+	if docType == "" {
+		return newError(ErrInvalidArgument, "document type must not be empty")
+	}
+	if !isSHA256Hex(hash) {
+		return newError(ErrInvalidArgument, "document hash must be a hex-encoded SHA-256 digest")
+	}
+	mspID, err := s.getClientMSPID(ctx)
+	if err != nil {
+		return err
+	}
+
+	document := TradeDocument{
+		OrderID:    orderID,
+		Type:       docType,
+		Hash:       hash,
+		AttachedBy: mspID,
+		AttachedAt: curTime,
+	}
+	key, err := s.makeKey(ctx, tradeDocumentObjectType, orderID, docType)
+	if err != nil {
+		return err
+	}
+	return s.putState(ctx, key, &document)
+}
+
+// GetOrderDocuments returns the documents attached to an order, by type
+func (s *SupplyChainContract) GetOrderDocuments(ctx contractapi.TransactionContextInterface, orderID string) ([]*TradeDocument, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(tradeDocumentObjectType, []string{orderID})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	documents := []*TradeDocument{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var document TradeDocument
+		if err := json.Unmarshal(queryResponse.Value, &document); err != nil {
+			return nil, err
+		}
+		documents = append(documents, &document)
+	}
+
+	return documents, nil
+}
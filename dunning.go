@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const dunningObjectType = "dunning"
+
+// EventDunningEscalated is emitted when an overdue invoice moves up a dunning level
+const EventDunningEscalated = "DunningEscalated"
+
+// Dunning levels, in escalation order
+const (
+	DunningReminder    = "Reminder"
+	DunningFinalNotice = "FinalNotice"
+	DunningCollections = "Collections"
+)
+
+var dunningLevels = []string{DunningReminder, DunningFinalNotice, DunningCollections}
+
+// DunningRecord is one escalation step on an overdue invoice
+type DunningRecord struct {
+	InvoiceID   string  `json:"invoice_id"`
+	Level       string  `json:"level"`
+	Payer       string  `json:"payer"`
+	Amount      float64 `json:"amount"`
+	DaysOverdue int     `json:"days_overdue"`
+	Note        string  `json:"note"`
+	RecordedBy  string  `json:"recorded_by"`
+	RecordedAt  string  `json:"recorded_at"`
+}
+
+// ReceivablesAging buckets an issuer's open invoices by days overdue as of
+// the transaction time
+type ReceivablesAging struct {
+	Issuer     string  `json:"issuer"`
+	AsOf       string  `json:"as_of"`
+	Current    float64 `json:"current"`
+	Days1To30  float64 `json:"days_1_to_30"`
+	Days31To60 float64 `json:"days_31_to_60"`
+	Days61To90 float64 `json:"days_61_to_90"`
+	Over90     float64 `json:"over_90"`
+	Total      float64 `json:"total"`
+}
+
+// EscalateDunning moves an overdue invoice to the next dunning level:
+// reminder, final notice, then collections. Only the issuer may escalate.
+func (s *SupplyChainContract) EscalateDunning(ctx contractapi.TransactionContextInterface, invoiceID, note string) (*DunningRecord, error) {
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	invoice, err := s.QueryInvoice(ctx, invoiceID)
+	if err != nil {
+		return nil, err
+	}
+	mspID, err := s.getClientMSPID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if mspID != invoice.Issuer {
+		return nil, newError(ErrForbidden, "only the issuer %s can escalate invoice %s", invoice.Issuer, invoiceID)
+	}
+	if invoice.PaymentState != PaymentOverdue {
+		return nil, newError(ErrConflict, "invoice %s is %s, not overdue", invoiceID, invoice.PaymentState)
+	}
+
+	next := 0
+	for i, level := range dunningLevels {
+		if level == invoice.DunningLevel {
+			next = i + 1
+		}
+	}
+	if next == len(dunningLevels) {
+		return nil, newError(ErrConflict, "invoice %s is already in %s", invoiceID, DunningCollections)
+	}
+	clientID, err := s.getClientID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	record := DunningRecord{
+		InvoiceID:   invoiceID,
+		Level:       dunningLevels[next],
+		Payer:       invoice.Payer,
+		Amount:      invoice.Amount,
+		DaysOverdue: invoice.DaysOverdue,
+		Note:        note,
+		RecordedBy:  clientID,
+		RecordedAt:  curTime,
+	}
+	// Keyed by level so a partial key scan returns the escalations in order
+	key, err := s.makeKey(ctx, dunningObjectType, invoiceID, fmt.Sprintf("%d", next))
+	if err != nil {
+		return nil, err
+	}
+	if err := s.putState(ctx, key, &record); err != nil {
+		return nil, err
+	}
+
+	invoice.DunningLevel = record.Level
+	invoice.UpdatedAt = curTime
+	if err := s.putInvoice(ctx, invoice); err != nil {
+		return nil, err
+	}
+
+	eventJSON, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.GetStub().SetEvent(EventDunningEscalated, eventJSON); err != nil {
+		return nil, wrapError(err, "failed to set event")
+	}
+
+	return &record, nil
+}
+
+// GetDunningHistory returns the escalations of an invoice in order
+func (s *SupplyChainContract) GetDunningHistory(ctx contractapi.TransactionContextInterface, invoiceID string) ([]*DunningRecord, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(dunningObjectType, []string{invoiceID})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	records := []*DunningRecord{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var record DunningRecord
+		if err := json.Unmarshal(queryResponse.Value, &record); err != nil {
+			return nil, err
+		}
+		records = append(records, &record)
+	}
+
+	return records, nil
+}
+
+// GetReceivablesAging buckets the open invoices an organization has issued
+// by how many days overdue they are
+func (s *SupplyChainContract) GetReceivablesAging(ctx contractapi.TransactionContextInterface, issuer string) (*ReceivablesAging, error) {
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+	invoices, err := s.GetInvoicesByIssuer(ctx, issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	aging := &ReceivablesAging{Issuer: issuer, AsOf: curTime}
+	for _, invoice := range invoices {
+		if invoice.Status != InvoiceOpen {
+			continue
+		}
+		switch {
+		case invoice.DaysOverdue == 0:
+			aging.Current += invoice.Amount
+		case invoice.DaysOverdue <= 30:
+			aging.Days1To30 += invoice.Amount
+		case invoice.DaysOverdue <= 60:
+			aging.Days31To60 += invoice.Amount
+		case invoice.DaysOverdue <= 90:
+			aging.Days61To90 += invoice.Amount
+		default:
+			aging.Over90 += invoice.Amount
+		}
+		aging.Total += invoice.Amount
+	}
+	return aging, nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"unicode"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	fingerprintIndexName    = "fingerprint"
+	duplicateFlagObjectType = "duplicate"
+)
+
+// WarningDuplicateSuspected is the code reported for probable duplicate registrations
+const WarningDuplicateSuspected = "DUPLICATE_SUSPECTED"
+
+// DuplicateFlag records a product that was created despite matching an
+// existing product, so data-quality reviewers can follow up
+type DuplicateFlag struct {
+	Code         string   `json:"code"`
+	ProductID    string   `json:"product_id"`
+	MatchesIDs   []string `json:"matches_ids"`
+	OverriddenBy string   `json:"overridden_by"`
+	CreatedAt    string   `json:"created_at"`
+}
+
+// GetDuplicateFlags returns every duplicate flag awaiting review
+func (s *SupplyChainContract) GetDuplicateFlags(ctx contractapi.TransactionContextInterface) ([]*DuplicateFlag, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(duplicateFlagObjectType, []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var flags []*DuplicateFlag
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var flag DuplicateFlag
+		if err := json.Unmarshal(queryResponse.Value, &flag); err != nil {
+			return nil, err
+		}
+		flags = append(flags, &flag)
+	}
+
+	return flags, nil
+}
+
+// checkDuplicate looks the product's fingerprint up among existing products.
+// A match fails the creation unless allowDuplicate is set, in which case a
+// duplicate flag is written and the transaction warns about it. Either way the product's own fingerprint is
+// indexed so later registrations are compared against it.
+func (s *SupplyChainContract) checkDuplicate(ctx contractapi.TransactionContextInterface, product *Product, allowDuplicate bool) error {
+	fingerprint := productFingerprint(product)
+
+	matches, err := s.getIndexedIDs(ctx, fingerprintIndexName, fingerprint...)
+	if err != nil {
+		return err
+	}
+	if len(matches) > 0 {
+		if !allowDuplicate {
+			return newError(WarningDuplicateSuspected, "product %s matches existing product(s) %s on manufacturer, name and serial number",
+				product.ID, strings.Join(matches, ", "))
+		}
+
+		flag := DuplicateFlag{
+			Code:         WarningDuplicateSuspected,
+			ProductID:    product.ID,
+			MatchesIDs:   matches,
+			OverriddenBy: product.CreatedBy,
+			CreatedAt:    product.CreatedAt,
+		}
+		key, err := s.makeKey(ctx, duplicateFlagObjectType, product.ID)
+		if err != nil {
+			return err
+		}
+		if err := s.putState(ctx, key, &flag); err != nil {
+			return err
+		}
+		addWarning(ctx, WarningDuplicateSuspected, product.ID, "product %s matches existing product(s) %s on manufacturer, name and serial number",
+			product.ID, strings.Join(matches, ", "))
+	}
+
+	return s.putIndexKey(ctx, fingerprintIndexName, append(fingerprint, product.ID)...)
+}
+
+// refingerprint moves the fingerprint of a serialized product whose name was
+// amended. The new fingerprint is checked like a new registration, without
+// the duplicate override.
+func (s *SupplyChainContract) refingerprint(ctx contractapi.TransactionContextInterface, before, after *Product) error {
+	if before.SerialNumber == "" {
+		return nil
+	}
+	if normalizeForMatch(before.Name) == normalizeForMatch(after.Name) {
+		return nil
+	}
+	if err := s.delIndexKey(ctx, fingerprintIndexName, append(productFingerprint(before), before.ID)...); err != nil {
+		return err
+	}
+	return s.checkDuplicate(ctx, after, false)
+}
+
+// dropFingerprint removes a deleted product's fingerprint and duplicate
+// flag, so later registrations are no longer compared against it
+func (s *SupplyChainContract) dropFingerprint(ctx contractapi.TransactionContextInterface, product *Product) error {
+	if product.SerialNumber != "" {
+		if err := s.delIndexKey(ctx, fingerprintIndexName, append(productFingerprint(product), product.ID)...); err != nil {
+			return err
+		}
+	}
+	key, err := s.makeKey(ctx, duplicateFlagObjectType, product.ID)
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().DelState(key)
+}
+
+// productFingerprint is the normalized (manufacturer, name, serial) tuple used for duplicate detection
+func productFingerprint(product *Product) []string {
+	return []string{normalizeForMatch(product.Manufacturer), normalizeForMatch(product.Name), normalizeForMatch(product.SerialNumber)}
+}
+
+// normalizeForMatch lowercases a value and drops everything but letters and
+// digits, so "ACME Corp." and "acme corp" compare equal
+func normalizeForMatch(value string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(value) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	embargoObjectType       = "embargo"
+	earlySaleFlagObjectType = "earlysale"
+)
+
+// Embargo scopes: a single product, or every product of a model (product name)
+const (
+	EmbargoProduct = "product"
+	EmbargoModel   = "model"
+)
+
+// Embargo holds back a product or model from retailers in a market until LiftsAt
+type Embargo struct {
+	Scope     string `json:"scope"`
+	Target    string `json:"target"`
+	Market    string `json:"market"`
+	LiftsAt   string `json:"lifts_at"`
+	SetBy     string `json:"set_by"`
+	UpdatedAt string `json:"updated_at"`
+}
+
+// EarlySaleFlag records a sale in a market before the product's embargo lifted
+type EarlySaleFlag struct {
+	ProductID string `json:"product_id"`
+	Market    string `json:"market"`
+	SoldAt    string `json:"sold_at"`
+	LiftsAt   string `json:"lifts_at"`
+	CreatedAt string `json:"created_at"`
+}
+
+// SetEmbargo sets the launch date (RFC3339) before which a product or model
+// cannot be transferred to retailers in a market, replacing any earlier date
+func (s *SupplyChainContract) SetEmbargo(ctx contractapi.TransactionContextInterface, scope, target, market, liftsAt string) error {
+	if err := s.requireRole(ctx, RoleAdmin); err != nil {
+		return err
+	}
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	if scope != EmbargoProduct && scope != EmbargoModel {
+		return newError(ErrInvalidArgument, "embargo scope must be %s or %s", EmbargoProduct, EmbargoModel)
+	}
+	if target == "" || market == "" {
+		return newError(ErrInvalidArgument, "embargo target and market must not be empty")
+	}
+	lifts, err := time.Parse(time.RFC3339, liftsAt)
+	if err != nil {
+		return newError(ErrInvalidArgument, "lifts_at must be an RFC3339 timestamp: %v", err)
+	}
+	clientID, err := s.getClientID(ctx)
+	if err != nil {
+		return err
+	}
+
+	embargo := Embargo{
+		Scope:     scope,
+		Target:    target,
+		Market:    market,
+		LiftsAt:   lifts.UTC().Format(time.RFC3339),
+		SetBy:     clientID,
+		UpdatedAt: curTime,
+	}
+	key, err := s.makeKey(ctx, embargoObjectType, scope, target, market)
+	if err != nil {
+		return err
+	}
+	return s.putState(ctx, key, &embargo)
+}
+
+// GetProductEmbargoes returns the embargoes that apply to a product, both
+// on the product itself and on its model
+func (s *SupplyChainContract) GetProductEmbargoes(ctx contractapi.TransactionContextInterface, productID string) ([]*Embargo, error) {
+	product, err := s.QueryProduct(ctx, productID)
+	if err != nil {
+		return nil, err
+	}
+	return s.productEmbargoes(ctx, product)
+}
+
+// productEmbargoes collects the product and model embargoes of a product
+func (s *SupplyChainContract) productEmbargoes(ctx contractapi.TransactionContextInterface, product *Product) ([]*Embargo, error) {
+	embargoes := []*Embargo{}
+	for _, scope := range [][]string{{EmbargoProduct, product.ID}, {EmbargoModel, product.Name}} {
+		resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(embargoObjectType, scope)
+		if err != nil {
+			return nil, err
+		}
+		for resultsIterator.HasNext() {
+			queryResponse, err := resultsIterator.Next()
+			if err != nil {
+				resultsIterator.Close()
+				return nil, err
+			}
+
+			var embargo Embargo
+			if err := json.Unmarshal(queryResponse.Value, &embargo); err != nil {
+				resultsIterator.Close()
+				return nil, err
+			}
+			embargoes = append(embargoes, &embargo)
+		}
+		resultsIterator.Close()
+	}
+	return embargoes, nil
+}
+
+// activeEmbargo returns the embargo holding a product back in a market at
+// time at (RFC3339), or nil if it may be sold there
+func (s *SupplyChainContract) activeEmbargo(ctx contractapi.TransactionContextInterface, product *Product, market, at string) (*Embargo, error) {
+	embargoes, err := s.productEmbargoes(ctx, product)
+	if err != nil {
+		return nil, err
+	}
+	for _, embargo := range embargoes {
+		if embargo.Market == market && at < embargo.LiftsAt {
+			return embargo, nil
+		}
+	}
+	return nil, nil
+}
+
+// checkEmbargo rejects transferring a product to a registered retailer in a
+// market where it is still under embargo
+func (s *SupplyChainContract) checkEmbargo(ctx contractapi.TransactionContextInterface, product *Product, newOwner string) error {
+	embargo, err := s.transferEmbargo(ctx, product, newOwner)
+	if err != nil {
+		return err
+	}
+	if embargo != nil {
+		return newError(ErrConflict, "product %s is under embargo in %s until %s and cannot go to retailer %s", product.ID, embargo.Market, embargo.LiftsAt, newOwner)
+	}
+	return nil
+}
+
+// transferEmbargo returns the embargo, if any, that forbids handing a
+// product to newOwner
+func (s *SupplyChainContract) transferEmbargo(ctx contractapi.TransactionContextInterface, product *Product, newOwner string) (*Embargo, error) {
+	participant, err := s.findParticipant(ctx, newOwner)
+	if err != nil {
+		return nil, err
+	}
+	if participant == nil || participant.Role != ParticipantRetailer {
+		return nil, nil
+	}
+
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, market := range participant.Markets {
+		embargo, err := s.activeEmbargo(ctx, product, market, curTime)
+		if err != nil {
+			return nil, err
+		}
+		if embargo != nil {
+			return embargo, nil
+		}
+	}
+	return nil, nil
+}
+
+// GetEarlySaleFlags returns every sale recorded before its embargo lifted
+func (s *SupplyChainContract) GetEarlySaleFlags(ctx contractapi.TransactionContextInterface) ([]*EarlySaleFlag, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(earlySaleFlagObjectType, []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	flags := []*EarlySaleFlag{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var flag EarlySaleFlag
+		if err := json.Unmarshal(queryResponse.Value, &flag); err != nil {
+			return nil, err
+		}
+		flags = append(flags, &flag)
+	}
+
+	return flags, nil
+}
+
+// flagEarlySale writes an early-sale flag when a sale of product in market at
+// soldAt (RFC3339) predates an embargo that applies there. It reports whether
+// a flag was raised.
+func (s *SupplyChainContract) flagEarlySale(ctx contractapi.TransactionContextInterface, product *Product, market, soldAt string) (bool, error) {
+	embargo, err := s.activeEmbargo(ctx, product, market, soldAt)
+	if err != nil || embargo == nil {
+		return false, err
+	}
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	flag := EarlySaleFlag{
+		ProductID: product.ID,
+		Market:    market,
+		SoldAt:    soldAt,
+		LiftsAt:   embargo.LiftsAt,
+		CreatedAt: curTime,
+	}
+	key, err := s.makeKey(ctx, earlySaleFlagObjectType, product.ID, market, soldAt)
+	if err != nil {
+		return false, err
+	}
+	return true, s.putState(ctx, key, &flag)
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Stored product encodings
+const (
+	StateEncodingJSON     = "json"
+	StateEncodingProtobuf = "protobuf"
+)
+
+// productSchemaVersion is the version of the product record, written as
+// schema_version in JSON and as field 1 of every protobuf-encoded product.
+// Since the field is written first, a protobuf value always starts with its
+// tag byte, which no JSON document can start with; that is how reads tell
+// the encodings apart. A change to the record bumps the version and adds a
+// step to productMigrations.
+const productSchemaVersion = 1
+
+// productMigrations upgrade a product record by one version: entry i turns
+// a version i record into a version i+1 record. Version 0 records, written
+// before versioning, already have the version 1 layout.
+var productMigrations = []func(*Product){
+	func(*Product) {},
+}
+
+// Field numbers of the protobuf product layout. Numbers are never reused.
+const (
+	productFieldSchemaVersion protowire.Number = iota + 1
+	productFieldID
+	productFieldName
+	productFieldStatus
+	productFieldOwner
+	productFieldCreatedAt
+	productFieldUpdatedAt
+	productFieldDescription
+	productFieldCategory
+	productFieldCreatedBy
+	productFieldPreviousOwner
+	productFieldLotID
+	productFieldManufacturer
+	productFieldSerialNumber
+	productFieldArchived
+	productFieldFrozen
+	productFieldFreezeReason
+	productFieldDID
+)
+
+// ReencodeResult reports one page of ReencodeProducts. Bookmark is empty
+// once every product has been visited.
+type ReencodeResult struct {
+	Encoding  string `json:"encoding"`
+	Scanned   int    `json:"scanned"`
+	Reencoded int    `json:"reencoded"`
+	Bookmark  string `json:"bookmark"`
+}
+
+// MigrationResult reports one page of MigrateProducts. Bookmark is empty
+// once every product has been visited.
+type MigrationResult struct {
+	SchemaVersion int    `json:"schema_version"`
+	Scanned       int    `json:"scanned"`
+	Migrated      int    `json:"migrated"`
+	Bookmark      string `json:"bookmark"`
+}
+
+// SetStateEncoding sets how products are written from now on: json (the
+// default) or the smaller, faster to decode protobuf. Reads accept both, so
+// existing products stay readable; ReencodeProducts converts them. CouchDB
+// rich queries (saved queries) only see JSON products, so a network relying
+// on them should stay on json.
+func (s *SupplyChainContract) SetStateEncoding(ctx contractapi.TransactionContextInterface, encoding string) error {
+	if err := s.requireRole(ctx, RoleAdmin); err != nil {
+		return err
+	}
+	if encoding != StateEncodingJSON && encoding != StateEncodingProtobuf {
+		return newError(ErrInvalidArgument, "encoding must be %s or %s", StateEncodingJSON, StateEncodingProtobuf)
+	}
+
+	config, err := s.GetContractConfig(ctx)
+	if err != nil {
+		return err
+	}
+
+	config.StateEncoding = encoding
+	return s.putContractConfig(ctx, config)
+}
+
+// ReencodeProducts rewrites one page of products in the configured
+// encoding, leaving those already in it untouched. Pass the returned
+// bookmark to continue; the content of the products does not change, so no
+// change events are recorded.
+func (s *SupplyChainContract) ReencodeProducts(ctx contractapi.TransactionContextInterface, pageSize int, bookmark string) (*ReencodeResult, error) {
+	if err := s.requireRole(ctx, RoleAdmin); err != nil {
+		return nil, err
+	}
+	if err := s.checkPageSize(ctx, pageSize); err != nil {
+		return nil, err
+	}
+	config, err := s.GetContractConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	encoding := config.stateEncoding()
+
+	resultsIterator, metadata, err := ctx.GetStub().GetStateByRangeWithPagination("", "", int32(pageSize), bookmark)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	result := &ReencodeResult{Encoding: encoding, Bookmark: metadata.GetBookmark()}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		result.Scanned++
+		if isProtoState(queryResponse.Value) == (encoding == StateEncodingProtobuf) {
+			continue
+		}
+
+		var product Product
+		if err := decodeProduct(queryResponse.Value, &product); err != nil {
+			return nil, wrapError(err, "failed to decode product %s", queryResponse.Key)
+		}
+		value, err := encodeProduct(&product, encoding)
+		if err != nil {
+			return nil, err
+		}
+		if err := ctx.GetStub().PutState(queryResponse.Key, value); err != nil {
+			return nil, wrapError(err, "failed to put to world state")
+		}
+		result.Reencoded++
+	}
+
+	return result, nil
+}
+
+// MigrateProducts upgrades one page of products written with an older
+// schema version to the current one, in the encoding each is stored in.
+// Reads through the contract upgrade old records as they go, but rich
+// queries see records as stored, so a ledger should be migrated after every
+// version change.
+// Pass the returned bookmark to continue; as with ReencodeProducts, no
+// change events are recorded.
+func (s *SupplyChainContract) MigrateProducts(ctx contractapi.TransactionContextInterface, pageSize int, bookmark string) (*MigrationResult, error) {
+	if err := s.requireRole(ctx, RoleAdmin); err != nil {
+		return nil, err
+	}
+	if err := s.checkPageSize(ctx, pageSize); err != nil {
+		return nil, err
+	}
+
+	resultsIterator, metadata, err := ctx.GetStub().GetStateByRangeWithPagination("", "", int32(pageSize), bookmark)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	result := &MigrationResult{SchemaVersion: productSchemaVersion, Bookmark: metadata.GetBookmark()}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		result.Scanned++
+
+		var product Product
+		if err := decodeStoredProduct(queryResponse.Value, &product); err != nil {
+			return nil, wrapError(err, "failed to decode product %s", queryResponse.Key)
+		}
+		if product.SchemaVersion == productSchemaVersion {
+			continue
+		}
+		if err := upgradeProduct(&product); err != nil {
+			return nil, wrapError(err, "product %s", queryResponse.Key)
+		}
+		encoding := StateEncodingJSON
+		if isProtoState(queryResponse.Value) {
+			encoding = StateEncodingProtobuf
+		}
+		value, err := encodeProduct(&product, encoding)
+		if err != nil {
+			return nil, err
+		}
+		if err := ctx.GetStub().PutState(queryResponse.Key, value); err != nil {
+			return nil, wrapError(err, "failed to put to world state")
+		}
+		result.Migrated++
+	}
+
+	return result, nil
+}
+
+// stateEncoding returns the configured product encoding
+func (c *ContractConfig) stateEncoding() string {
+	if c.StateEncoding == "" {
+		return StateEncodingJSON
+	}
+	return c.StateEncoding
+}
+
+// isProtoState reports whether a stored product value is protobuf-encoded
+func isProtoState(value []byte) bool {
+	return len(value) > 0 && value[0] == byte(protowire.EncodeTag(productFieldSchemaVersion, protowire.VarintType))
+}
+
+// decodeProduct reads a stored product in either encoding, upgraded to the
+// current schema version
+func decodeProduct(value []byte, product *Product) error {
+	if err := decodeStoredProduct(value, product); err != nil {
+		return err
+	}
+	return upgradeProduct(product)
+}
+
+// upgradeProduct applies the migrations from the product's schema version
+// to the current one
+func upgradeProduct(product *Product) error {
+	if product.SchemaVersion > productSchemaVersion {
+		return newError(ErrConflict, "product schema version %d is newer than this contract supports", product.SchemaVersion)
+	}
+	for product.SchemaVersion < productSchemaVersion {
+		productMigrations[product.SchemaVersion](product)
+		product.SchemaVersion++
+	}
+	return nil
+}
+
+// decodeStoredProduct reads a stored product in either encoding as it was
+// written, without upgrading it
+func decodeStoredProduct(value []byte, product *Product) error {
+	*product = Product{}
+	if !isProtoState(value) {
+		return json.Unmarshal(value, product)
+	}
+
+	for len(value) > 0 {
+		number, fieldType, n := protowire.ConsumeTag(value)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		value = value[n:]
+
+		var field *string
+		var flag *bool
+		switch number {
+		case productFieldID:
+			field = &product.ID
+		case productFieldName:
+			field = &product.Name
+		case productFieldStatus:
+			field = &product.Status
+		case productFieldOwner:
+			field = &product.Owner
+		case productFieldCreatedAt:
+			field = &product.CreatedAt
+		case productFieldUpdatedAt:
+			field = &product.UpdatedAt
+		case productFieldDescription:
+			field = &product.Description
+		case productFieldCategory:
+			field = &product.Category
+		case productFieldCreatedBy:
+			field = &product.CreatedBy
+		case productFieldPreviousOwner:
+			field = &product.PreviousOwner
+		case productFieldLotID:
+			field = &product.LotID
+		case productFieldManufacturer:
+			field = &product.Manufacturer
+		case productFieldSerialNumber:
+			field = &product.SerialNumber
+		case productFieldFreezeReason:
+			field = &product.FreezeReason
+		case productFieldDID:
+			field = &product.DID
+		case productFieldArchived:
+			flag = &product.Archived
+		case productFieldFrozen:
+			flag = &product.Frozen
+		}
+
+		switch {
+		case field != nil && fieldType == protowire.BytesType:
+			str, n := protowire.ConsumeString(value)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			*field = str
+			value = value[n:]
+		case (flag != nil || number == productFieldSchemaVersion) && fieldType == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(value)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			if flag != nil {
+				*flag = protowire.DecodeBool(v)
+			} else {
+				product.SchemaVersion = int(v)
+			}
+			value = value[n:]
+		default:
+			// Fields added by a later layout are skipped
+			n := protowire.ConsumeFieldValue(number, fieldType, value)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			value = value[n:]
+		}
+	}
+	return nil
+}
+
+// encodeProduct returns the stored form of a product in an encoding
+func encodeProduct(product *Product, encoding string) ([]byte, error) {
+	if encoding != StateEncodingProtobuf {
+		stored := *product
+		stored.SchemaVersion = productSchemaVersion
+		return json.Marshal(&stored)
+	}
+
+	b := protowire.AppendTag(nil, productFieldSchemaVersion, protowire.VarintType)
+	b = protowire.AppendVarint(b, productSchemaVersion)
+	for _, field := range []struct {
+		number protowire.Number
+		value  string
+	}{
+		{productFieldID, product.ID},
+		{productFieldName, product.Name},
+		{productFieldStatus, product.Status},
+		{productFieldOwner, product.Owner},
+		{productFieldCreatedAt, product.CreatedAt},
+		{productFieldUpdatedAt, product.UpdatedAt},
+		{productFieldDescription, product.Description},
+		{productFieldCategory, product.Category},
+		{productFieldCreatedBy, product.CreatedBy},
+		{productFieldPreviousOwner, product.PreviousOwner},
+		{productFieldLotID, product.LotID},
+		{productFieldManufacturer, product.Manufacturer},
+		{productFieldSerialNumber, product.SerialNumber},
+		{productFieldFreezeReason, product.FreezeReason},
+		{productFieldDID, product.DID},
+	} {
+		if field.value == "" {
+			continue
+		}
+		b = protowire.AppendTag(b, field.number, protowire.BytesType)
+		b = protowire.AppendString(b, field.value)
+	}
+	for _, field := range []struct {
+		number protowire.Number
+		value  bool
+	}{
+		{productFieldArchived, product.Archived},
+		{productFieldFrozen, product.Frozen},
+	} {
+		if !field.value {
+			continue
+		}
+		b = protowire.AppendTag(b, field.number, protowire.VarintType)
+		b = protowire.AppendVarint(b, protowire.EncodeBool(true))
+	}
+	return b, nil
+}
+
+// productStateJSON returns a stored product value as JSON, converting it if
+// it is protobuf-encoded
+func productStateJSON(value []byte) ([]byte, error) {
+	if !isProtoState(value) {
+		return value, nil
+	}
+	var product Product
+	if err := decodeProduct(value, &product); err != nil {
+		return nil, err
+	}
+	return json.Marshal(&product)
+}
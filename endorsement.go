@@ -0,0 +1,94 @@
+package main
+
+import (
+	"github.com/hyperledger/fabric-chaincode-go/pkg/statebased"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// ProductEndorsement lists the organizations whose peers must endorse any
+// change to a product. An empty list means the chaincode's endorsement
+// policy applies.
+type ProductEndorsement struct {
+	ProductID string   `json:"product_id"`
+	Orgs      []string `json:"orgs"`
+}
+
+// SetOwnerEndorsement turns state-based endorsement of products on or off.
+// While it is on, every ownership change sets a key-level endorsement
+// policy on the product, so only the new owner's peers can endorse its
+// later updates. Owners must then be MSP IDs or registered participants:
+// a product handed to anything else could never be changed again.
+func (s *SupplyChainContract) SetOwnerEndorsement(ctx contractapi.TransactionContextInterface, enabled bool) error {
+	if err := s.requireRole(ctx, RoleAdmin); err != nil {
+		return err
+	}
+	config, err := s.GetContractConfig(ctx)
+	if err != nil {
+		return err
+	}
+
+	config.OwnerEndorsement = enabled
+	return s.putContractConfig(ctx, config)
+}
+
+// GetProductEndorsement returns the organizations that must endorse changes
+// to a product
+func (s *SupplyChainContract) GetProductEndorsement(ctx contractapi.TransactionContextInterface, id string) (*ProductEndorsement, error) {
+	exists, err := s.ProductExists(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, newError(ErrNotFound, "product with ID %s does not exist", id)
+	}
+	policy, err := ctx.GetStub().GetStateValidationParameter(id)
+	if err != nil {
+		return nil, wrapError(err, "failed to read the endorsement policy")
+	}
+
+	result := &ProductEndorsement{ProductID: id, Orgs: []string{}}
+	if len(policy) == 0 {
+		return result, nil
+	}
+	ep, err := statebased.NewStateEP(policy)
+	if err != nil {
+		return nil, err
+	}
+	result.Orgs = append(result.Orgs, ep.ListOrgs()...)
+	return result, nil
+}
+
+// setOwnerEndorsement makes the organization acting for owner the only one
+// that can endorse changes to a product, if owner endorsement is enabled.
+// A registered participant is represented by its MSP ID.
+func (s *SupplyChainContract) setOwnerEndorsement(ctx contractapi.TransactionContextInterface, productID, owner string) error {
+	config, err := s.GetContractConfig(ctx)
+	if err != nil || !config.OwnerEndorsement {
+		return err
+	}
+	mspID := owner
+	participant, err := s.findParticipant(ctx, owner)
+	if err != nil {
+		return err
+	}
+	if participant != nil {
+		mspID = participant.MSPID
+	}
+
+	ep, err := statebased.NewStateEP(nil)
+	if err != nil {
+		return err
+	}
+	// Fabric 2 channels use node OUs, where endorsements come from peers
+	if err := ep.AddOrgs(statebased.RoleTypePeer, mspID); err != nil {
+		return err
+	}
+	policy, err := ep.Policy()
+	if err != nil {
+		return err
+	}
+	if err := ctx.GetStub().SetStateValidationParameter(productID, policy); err != nil {
+		return wrapError(err, "failed to set the endorsement policy of product %s", productID)
+	}
+	return nil
+}
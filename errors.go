@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Error codes of ChaincodeError. ErrPaused, WarningDuplicateSuspected and
+// WarningQuotaExceeded are codes too.
+const (
+	ErrNotFound        = "NOT_FOUND"
+	ErrAlreadyExists   = "ALREADY_EXISTS"
+	ErrForbidden       = "FORBIDDEN"
+	ErrInvalidArgument = "INVALID_ARGUMENT"
+	ErrConflict        = "CONFLICT"
+)
+
+// ChaincodeError is an error with a code clients can branch on. Its message
+// is the error serialized as JSON, which is what clients receive; errors
+// that are not JSON carry no code.
+type ChaincodeError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *ChaincodeError) Error() string {
+	errorJSON, err := json.Marshal(e)
+	if err != nil {
+		return e.Code + ": " + e.Message
+	}
+	return string(errorJSON)
+}
+
+// newError returns a ChaincodeError with a formatted message
+func newError(code, format string, args ...interface{}) error {
+	return &ChaincodeError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// wrapError prefixes the message of err, keeping its code
+func wrapError(err error, format string, args ...interface{}) error {
+	prefix := fmt.Sprintf(format, args...)
+	var chaincodeErr *ChaincodeError
+	if errors.As(err, &chaincodeErr) {
+		return &ChaincodeError{Code: chaincodeErr.Code, Message: prefix + ": " + chaincodeErr.Message}
+	}
+	return fmt.Errorf("%s: %v", prefix, err)
+}
+
+// errorMessage returns the message of err without the JSON a ChaincodeError
+// is serialized as, for results that report an error as text
+func errorMessage(err error) string {
+	var chaincodeErr *ChaincodeError
+	if errors.As(err, &chaincodeErr) {
+		return chaincodeErr.Message
+	}
+	return err.Error()
+}
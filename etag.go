@@ -0,0 +1,57 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// ProductWithETag is the result of QueryProductWithETag. When NotModified is
+// set the caller's copy is current and Product is left empty.
+type ProductWithETag struct {
+	Product     Product `json:"product"`
+	ETag        string  `json:"etag"`
+	NotModified bool    `json:"not_modified"`
+}
+
+// QueryProductWithETag returns a product with its ETag, a hash of the
+// product's state that every peer computes alike. If ifNoneMatch is the
+// current ETag only the ETag is returned, so a gateway can answer a
+// conditional request without the full product.
+func (s *SupplyChainContract) QueryProductWithETag(ctx contractapi.TransactionContextInterface, id, ifNoneMatch string) (*ProductWithETag, error) {
+	productJSON, err := ctx.GetStub().GetState(id)
+	if err != nil {
+		return nil, wrapError(err, "failed to read from world state")
+	}
+	if productJSON == nil {
+		return nil, newError(ErrNotFound, "product with ID %s does not exist", id)
+	}
+
+	result := &ProductWithETag{ETag: stateETag(productJSON)}
+	if ifNoneMatch != "" && ifNoneMatch == result.ETag {
+		result.NotModified = true
+		return result, nil
+	}
+	if err := decodeProduct(productJSON, &result.Product); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// stateETag is the ETag of a stored value: the hex SHA-256 of its bytes
+func stateETag(value []byte) string {
+	digest := sha256.Sum256(value)
+	return hex.EncodeToString(digest[:])
+}
+
+// listETag is the ETag of a list of stored values, which changes if any
+// value changes or the list gains, loses or reorders an entry
+func listETag(values [][]byte) string {
+	h := sha256.New()
+	for _, value := range values {
+		digest := sha256.Sum256(value)
+		h.Write(digest[:])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Product lifecycle events. EventProductDeleted is declared with DeleteProduct.
+const (
+	EventProductCreated     = "ProductCreated"
+	EventProductUpdated     = "ProductUpdated"
+	EventProductTransferred = "ProductTransferred"
+)
+
+// ChangeTransfer is the action of EventProductTransferred. The change log
+// records a transfer as an update.
+const ChangeTransfer = "transfer"
+
+// ProductEvent is the payload of the product lifecycle events. Changes holds
+// the fields the transaction changed as JSON values, as in the change log.
+// A transaction keeps only its last event, so the lifecycle event replaces
+// EventWatchedProductChanged and lists the product's watchers itself, and
+// carries the warnings the transaction raised.
+type ProductEvent struct {
+	ProductID string        `json:"product_id"`
+	Action    string        `json:"action"`
+	Changes   []FieldChange `json:"changes"`
+	Actor     string        `json:"actor"`
+	MSPID     string        `json:"msp_id"`
+	TxID      string        `json:"tx_id"`
+	Timestamp string        `json:"timestamp"`
+	Watchers  []Watch       `json:"watchers"`
+	Warnings  []Warning     `json:"warnings"`
+}
+
+// emitProductEvent sets a lifecycle event for a product that went from
+// before to after; before is nil for a created product and after for a
+// deleted one
+func (s *SupplyChainContract) emitProductEvent(ctx contractapi.TransactionContextInterface, name, action string, before, after *Product) error {
+	var beforeJSON, afterJSON []byte
+	var err error
+	productID := ""
+	if before != nil {
+		productID = before.ID
+		if beforeJSON, err = json.Marshal(before); err != nil {
+			return err
+		}
+	}
+	if after != nil {
+		productID = after.ID
+		if afterJSON, err = json.Marshal(after); err != nil {
+			return err
+		}
+	}
+	changes, err := diffFields(beforeJSON, afterJSON)
+	if err != nil {
+		return err
+	}
+
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	clientID, err := s.getClientID(ctx)
+	if err != nil {
+		return err
+	}
+	mspID, err := s.getClientMSPID(ctx)
+	if err != nil {
+		return err
+	}
+	watches, err := s.GetProductWatchers(ctx, productID)
+	if err != nil {
+		return err
+	}
+
+	event := ProductEvent{
+		ProductID: productID,
+		Action:    action,
+		Changes:   changes,
+		Actor:     clientID,
+		MSPID:     mspID,
+		TxID:      ctx.GetStub().GetTxID(),
+		Timestamp: curTime,
+		Watchers:  watches,
+		Warnings:  txWarnings(ctx),
+	}
+	eventJSON, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if err := ctx.GetStub().SetEvent(name, eventJSON); err != nil {
+		return wrapError(err, "failed to set event")
+	}
+	return nil
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	shelfLifeRuleObjectType = "shelfliferule"
+	excursionObjectType     = "excursion"
+)
+
+// EventExpiryUpdated is emitted when an excursion shortens the shelf life of one or more lots
+const EventExpiryUpdated = "ExpiryUpdated"
+
+// ShelfLifeRule is an admin-managed penalty: an excursion that reaches
+// MinTemperature for at least MinDurationMinutes takes PenaltyHours off the
+// expiry date of every affected lot
+type ShelfLifeRule struct {
+	ID                 string  `json:"id"`
+	MinTemperature     float64 `json:"min_temperature"`
+	MinDurationMinutes int     `json:"min_duration_minutes"`
+	PenaltyHours       int     `json:"penalty_hours"`
+	UpdatedAt          string  `json:"updated_at"`
+}
+
+// Excursion records a period during which a shipment left its temperature range
+type Excursion struct {
+	ID              string   `json:"id"`
+	ShipmentID      string   `json:"shipment_id"`
+	PeakTemperature float64  `json:"peak_temperature"`
+	DurationMinutes int      `json:"duration_minutes"`
+	StartedAt       string   `json:"started_at"`
+	RuleID          string   `json:"rule_id"`
+	PenaltyHours    int      `json:"penalty_hours"`
+	AffectedLotIDs  []string `json:"affected_lot_ids"`
+	RecordedBy      string   `json:"recorded_by"`
+	RecordedAt      string   `json:"recorded_at"`
+}
+
+// LotExpiryUpdate is the expiry change applied to one lot
+type LotExpiryUpdate struct {
+	LotID          string `json:"lot_id"`
+	PreviousExpiry string `json:"previous_expiry"`
+	ExpiryDate     string `json:"expiry_date"`
+}
+
+// ExpiryUpdatedEvent is the payload of EventExpiryUpdated
+type ExpiryUpdatedEvent struct {
+	ExcursionID string            `json:"excursion_id"`
+	ShipmentID  string            `json:"shipment_id"`
+	Updates     []LotExpiryUpdate `json:"updates"`
+}
+
+// SetShelfLifeRule adds or replaces a shelf-life penalty rule
+func (s *SupplyChainContract) SetShelfLifeRule(ctx contractapi.TransactionContextInterface, id string, minTemperature float64, minDurationMinutes, penaltyHours int) error {
+	if err := s.requireRole(ctx, RoleAdmin); err != nil {
+		return err
+	}
+	if id == "" {
+		return newError(ErrInvalidArgument, "rule ID must not be empty")
+	}
+	if minDurationMinutes < 0 || penaltyHours <= 0 {
+		return newError(ErrInvalidArgument, "minimum duration must not be negative and penalty must be positive")
+	}
+
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	rule := ShelfLifeRule{
+		ID:                 id,
+		MinTemperature:     minTemperature,
+		MinDurationMinutes: minDurationMinutes,
+		PenaltyHours:       penaltyHours,
+		UpdatedAt:          curTime,
+	}
+	key, err := s.makeKey(ctx, shelfLifeRuleObjectType, id)
+	if err != nil {
+		return err
+	}
+	return s.putState(ctx, key, &rule)
+}
+
+// RemoveShelfLifeRule deletes a shelf-life penalty rule. Penalties already
+// applied are unaffected.
+func (s *SupplyChainContract) RemoveShelfLifeRule(ctx contractapi.TransactionContextInterface, id string) error {
+	if err := s.requireRole(ctx, RoleAdmin); err != nil {
+		return err
+	}
+
+	key, err := s.makeKey(ctx, shelfLifeRuleObjectType, id)
+	if err != nil {
+		return err
+	}
+	var rule ShelfLifeRule
+	exists, err := s.getState(ctx, key, &rule)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return newError(ErrNotFound, "shelf-life rule %s does not exist", id)
+	}
+	return ctx.GetStub().DelState(key)
+}
+
+// GetShelfLifeRules returns every shelf-life penalty rule
+func (s *SupplyChainContract) GetShelfLifeRules(ctx contractapi.TransactionContextInterface) ([]*ShelfLifeRule, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(shelfLifeRuleObjectType, []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var rules []*ShelfLifeRule
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var rule ShelfLifeRule
+		if err := json.Unmarshal(queryResponse.Value, &rule); err != nil {
+			return nil, err
+		}
+		rules = append(rules, &rule)
+	}
+
+	return rules, nil
+}
+
+// RecordExcursion records a cold-chain excursion on a shipment and applies
+// the most severe matching shelf-life rule to the lots of the shipped
+// products. Lots without an expiry date are listed as affected but not
+// changed. An ExpiryUpdated event lists every lot whose expiry moved. Only
+// the shipment's carrier, its receiver or an admin can record an excursion,
+// and only once per start time.
+func (s *SupplyChainContract) RecordExcursion(ctx contractapi.TransactionContextInterface, shipmentID string, peakTemperature float64, durationMinutes int, startedAt string) (*Excursion, error) {
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if durationMinutes <= 0 {
+		return nil, newError(ErrInvalidArgument, "duration must be positive")
+	}
+	started, err := time.Parse(time.RFC3339, startedAt)
+	if err != nil {
+		return nil, newError(ErrInvalidArgument, "started_at must be an RFC3339 timestamp: %v", err)
+	}
+	shipment, err := s.QueryShipment(ctx, shipmentID)
+	if err != nil {
+		return nil, err
+	}
+	mspID, err := s.getClientMSPID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if mspID != shipment.Carrier && mspID != shipmentReceiver(shipment) {
+		isAdmin, err := s.hasRole(ctx, RoleAdmin)
+		if err != nil {
+			return nil, err
+		}
+		if !isAdmin {
+			return nil, newError(ErrForbidden, "only the carrier or receiver of shipment %s can record excursions on it", shipmentID)
+		}
+	}
+	startedAt = started.UTC().Format(time.RFC3339)
+	key, err := s.makeKey(ctx, excursionObjectType, shipmentID, startedAt)
+	if err != nil {
+		return nil, err
+	}
+	var existing Excursion
+	exists, err := s.getState(ctx, key, &existing)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return nil, newError(ErrAlreadyExists, "an excursion starting at %s is already recorded for shipment %s", startedAt, shipmentID)
+	}
+	clientID, err := s.getClientID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	excursion := Excursion{
+		ID:              ctx.GetStub().GetTxID(),
+		ShipmentID:      shipmentID,
+		PeakTemperature: peakTemperature,
+		DurationMinutes: durationMinutes,
+		StartedAt:       startedAt,
+		AffectedLotIDs:  []string{},
+		RecordedBy:      clientID,
+		RecordedAt:      curTime,
+	}
+
+	rules, err := s.GetShelfLifeRules(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, rule := range rules {
+		if peakTemperature >= rule.MinTemperature && durationMinutes >= rule.MinDurationMinutes && rule.PenaltyHours > excursion.PenaltyHours {
+			excursion.RuleID = rule.ID
+			excursion.PenaltyHours = rule.PenaltyHours
+		}
+	}
+
+	for _, productID := range shipment.ProductIDs {
+		product, err := s.QueryProduct(ctx, productID)
+		if err != nil {
+			return nil, err
+		}
+		if product.LotID != "" {
+			excursion.AffectedLotIDs = mergeIDs(excursion.AffectedLotIDs, []string{product.LotID})
+		}
+	}
+
+	event := ExpiryUpdatedEvent{ExcursionID: excursion.ID, ShipmentID: shipmentID, Updates: []LotExpiryUpdate{}}
+	if excursion.PenaltyHours > 0 {
+		for _, lotID := range excursion.AffectedLotIDs {
+			lot, err := s.QueryLot(ctx, lotID)
+			if err != nil {
+				return nil, err
+			}
+			if lot.ExpiryDate == "" {
+				continue
+			}
+			expiry, err := time.Parse(time.RFC3339, lot.ExpiryDate)
+			if err != nil {
+				return nil, wrapError(err, "lot %s has an invalid expiry date", lotID)
+			}
+
+			update := LotExpiryUpdate{
+				LotID:          lotID,
+				PreviousExpiry: lot.ExpiryDate,
+				ExpiryDate:     expiry.Add(-time.Duration(excursion.PenaltyHours) * time.Hour).Format(time.RFC3339),
+			}
+			lot.ExpiryDate = update.ExpiryDate
+			lot.UpdatedAt = curTime
+			if err := s.putLot(ctx, lot); err != nil {
+				return nil, err
+			}
+			event.Updates = append(event.Updates, update)
+		}
+	}
+
+	if err := s.putState(ctx, key, &excursion); err != nil {
+		return nil, err
+	}
+
+	if len(event.Updates) > 0 {
+		eventJSON, err := json.Marshal(event)
+		if err != nil {
+			return nil, err
+		}
+		if err := ctx.GetStub().SetEvent(EventExpiryUpdated, eventJSON); err != nil {
+			return nil, wrapError(err, "failed to set event")
+		}
+	}
+
+	return &excursion, nil
+}
+
+// GetShipmentExcursions returns every excursion recorded for a shipment, ordered by start time
+func (s *SupplyChainContract) GetShipmentExcursions(ctx contractapi.TransactionContextInterface, shipmentID string) ([]*Excursion, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(excursionObjectType, []string{shipmentID})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var excursions []*Excursion
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var excursion Excursion
+		if err := json.Unmarshal(queryResponse.Value, &excursion); err != nil {
+			return nil, err
+		}
+		excursions = append(excursions, &excursion)
+	}
+
+	// Keys are ordered by transaction ID, not time
+	sort.SliceStable(excursions, func(i, j int) bool { return excursions[i].StartedAt < excursions[j].StartedAt })
+
+	return excursions, nil
+}
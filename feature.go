@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"regexp"
+	"sort"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const featureFlagObjectType = "featureflag"
+
+// featureNamePattern keeps flag names to lower case words joined by
+// underscores, so a typo cannot silently create a second flag
+var featureNamePattern = regexp.MustCompile(`^[a-z][a-z0-9]*(_[a-z0-9]+)*$`)
+
+// FeatureFlag gates a behavior change that is rolled out gradually. Enabled
+// is the consortium-wide default and OrgOverrides turns the feature on or off
+// for single organizations ahead of or behind it. A feature that has never
+// been set is off.
+type FeatureFlag struct {
+	Name         string          `json:"name"`
+	Enabled      bool            `json:"enabled"`
+	OrgOverrides map[string]bool `json:"org_overrides"`
+	UpdatedBy    string          `json:"updated_by"`
+	UpdatedAt    string          `json:"updated_at"`
+}
+
+// SetFeatureFlag sets whether a feature is on by default
+func (s *SupplyChainContract) SetFeatureFlag(ctx contractapi.TransactionContextInterface, name string, enabled bool) error {
+	flag, err := s.featureFlagForUpdate(ctx, name)
+	if err != nil {
+		return err
+	}
+	flag.Enabled = enabled
+	return s.putFeatureFlag(ctx, flag)
+}
+
+// SetOrgFeatureFlag turns a feature on or off for one organization,
+// whatever the default
+func (s *SupplyChainContract) SetOrgFeatureFlag(ctx contractapi.TransactionContextInterface, name, mspID string, enabled bool) error {
+	if mspID == "" {
+		return newError(ErrInvalidArgument, "MSP ID must not be empty")
+	}
+	flag, err := s.featureFlagForUpdate(ctx, name)
+	if err != nil {
+		return err
+	}
+	flag.OrgOverrides[mspID] = enabled
+	return s.putFeatureFlag(ctx, flag)
+}
+
+// ClearOrgFeatureFlag makes an organization follow the feature's default again
+func (s *SupplyChainContract) ClearOrgFeatureFlag(ctx contractapi.TransactionContextInterface, name, mspID string) error {
+	flag, err := s.featureFlagForUpdate(ctx, name)
+	if err != nil {
+		return err
+	}
+	if _, ok := flag.OrgOverrides[mspID]; !ok {
+		return newError(ErrNotFound, "%s has no override for feature %s", mspID, name)
+	}
+	delete(flag.OrgOverrides, mspID)
+	return s.putFeatureFlag(ctx, flag)
+}
+
+// GetFeatureFlag returns a feature flag. A feature that has never been set
+// is returned switched off.
+func (s *SupplyChainContract) GetFeatureFlag(ctx contractapi.TransactionContextInterface, name string) (*FeatureFlag, error) {
+	key, err := s.makeKey(ctx, featureFlagObjectType, name)
+	if err != nil {
+		return nil, err
+	}
+
+	flag := FeatureFlag{Name: name}
+	if _, err := s.getState(ctx, key, &flag); err != nil {
+		return nil, err
+	}
+	if flag.OrgOverrides == nil {
+		flag.OrgOverrides = make(map[string]bool)
+	}
+	return &flag, nil
+}
+
+// GetFeatureFlags returns every feature flag that has been set, ordered by name
+func (s *SupplyChainContract) GetFeatureFlags(ctx contractapi.TransactionContextInterface) ([]*FeatureFlag, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(featureFlagObjectType, []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	flags := []*FeatureFlag{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var flag FeatureFlag
+		if err := json.Unmarshal(queryResponse.Value, &flag); err != nil {
+			return nil, err
+		}
+		flags = append(flags, &flag)
+	}
+
+	sort.Slice(flags, func(i, j int) bool {
+		return flags[i].Name < flags[j].Name
+	})
+	return flags, nil
+}
+
+// IsFeatureEnabled reports whether a feature is on for the caller's organization
+func (s *SupplyChainContract) IsFeatureEnabled(ctx contractapi.TransactionContextInterface, name string) (bool, error) {
+	return s.featureEnabled(ctx, name)
+}
+
+// featureEnabled reports whether a feature is on for the caller's
+// organization. Subsystems rolled out behind a flag consult it before
+// applying their new behavior.
+func (s *SupplyChainContract) featureEnabled(ctx contractapi.TransactionContextInterface, name string) (bool, error) {
+	flag, err := s.GetFeatureFlag(ctx, name)
+	if err != nil {
+		return false, err
+	}
+	mspID, err := s.getClientMSPID(ctx)
+	if err != nil {
+		return false, err
+	}
+	if enabled, ok := flag.OrgOverrides[mspID]; ok {
+		return enabled, nil
+	}
+	return flag.Enabled, nil
+}
+
+// featureFlagForUpdate checks that the caller is an admin and returns the
+// flag to modify
+func (s *SupplyChainContract) featureFlagForUpdate(ctx contractapi.TransactionContextInterface, name string) (*FeatureFlag, error) {
+	if err := s.requireRole(ctx, RoleAdmin); err != nil {
+		return nil, err
+	}
+	if !featureNamePattern.MatchString(name) {
+		return nil, newError(ErrInvalidArgument, "feature name %q must be lower case words joined by underscores", name)
+	}
+	return s.GetFeatureFlag(ctx, name)
+}
+
+// putFeatureFlag stamps and writes a feature flag
+func (s *SupplyChainContract) putFeatureFlag(ctx contractapi.TransactionContextInterface, flag *FeatureFlag) error {
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	clientID, err := s.getClientID(ctx)
+	if err != nil {
+		return err
+	}
+	flag.UpdatedBy = clientID
+	flag.UpdatedAt = curTime
+
+	key, err := s.makeKey(ctx, featureFlagObjectType, flag.Name)
+	if err != nil {
+		return err
+	}
+	return s.putState(ctx, key, flag)
+}
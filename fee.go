@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	feeRuleObjectType     = "feerule"
+	transferFeeObjectType = "fee"
+)
+
+// Who pays a transfer fee
+const (
+	FeePayableBySeller = "seller"
+	FeePayableByBuyer  = "buyer"
+)
+
+// FeeRule is an admin-managed marketplace or platform fee. Every priced
+// transfer above Threshold owes Payee RatePercent of the price plus
+// FlatAmount, paid by the seller or the buyer as set by PayableBy.
+type FeeRule struct {
+	ID          string  `json:"id"`
+	Threshold   float64 `json:"threshold"`
+	RatePercent float64 `json:"rate_percent"`
+	FlatAmount  float64 `json:"flat_amount"`
+	PayableBy   string  `json:"payable_by"`
+	Payee       string  `json:"payee"`
+	UpdatedAt   string  `json:"updated_at"`
+}
+
+// TransferFee is the fee charged under one rule on one transfer, invoiced
+// to the paying party
+type TransferFee struct {
+	ReceiptID      string  `json:"receipt_id"`
+	RuleID         string  `json:"rule_id"`
+	ProductID      string  `json:"product_id"`
+	TransferAmount float64 `json:"transfer_amount"`
+	FeeAmount      float64 `json:"fee_amount"`
+	Payer          string  `json:"payer"`
+	Payee          string  `json:"payee"`
+	InvoiceID      string  `json:"invoice_id"`
+	CreatedAt      string  `json:"created_at"`
+}
+
+// SetFeeRule adds or replaces a transfer fee rule
+func (s *SupplyChainContract) SetFeeRule(ctx contractapi.TransactionContextInterface, id string, threshold, ratePercent, flatAmount float64, payableBy, payee string) error {
+	if err := s.requireRole(ctx, RoleAdmin); err != nil {
+		return err
+	}
+	if id == "" {
+		return newError(ErrInvalidArgument, "rule ID must not be empty")
+	}
+	if threshold < 0 || ratePercent < 0 || flatAmount < 0 || ratePercent+flatAmount == 0 {
+		return newError(ErrInvalidArgument, "threshold, rate and flat amount must not be negative and the fee must not be zero")
+	}
+	if payableBy != FeePayableBySeller && payableBy != FeePayableByBuyer {
+		return newError(ErrInvalidArgument, "fee must be payable by %s or %s", FeePayableBySeller, FeePayableByBuyer)
+	}
+	if payee == "" {
+		return newError(ErrInvalidArgument, "payee must not be empty")
+	}
+
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	rule := FeeRule{
+		ID:          id,
+		Threshold:   threshold,
+		RatePercent: ratePercent,
+		FlatAmount:  flatAmount,
+		PayableBy:   payableBy,
+		Payee:       payee,
+		UpdatedAt:   curTime,
+	}
+	key, err := s.makeKey(ctx, feeRuleObjectType, id)
+	if err != nil {
+		return err
+	}
+	return s.putState(ctx, key, &rule)
+}
+
+// RemoveFeeRule deletes a transfer fee rule. Fees already charged are unaffected.
+func (s *SupplyChainContract) RemoveFeeRule(ctx contractapi.TransactionContextInterface, id string) error {
+	if err := s.requireRole(ctx, RoleAdmin); err != nil {
+		return err
+	}
+
+	key, err := s.makeKey(ctx, feeRuleObjectType, id)
+	if err != nil {
+		return err
+	}
+	var rule FeeRule
+	exists, err := s.getState(ctx, key, &rule)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return newError(ErrNotFound, "fee rule %s does not exist", id)
+	}
+	return ctx.GetStub().DelState(key)
+}
+
+// GetFeeRules returns every transfer fee rule
+func (s *SupplyChainContract) GetFeeRules(ctx contractapi.TransactionContextInterface) ([]*FeeRule, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(feeRuleObjectType, []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	rules := []*FeeRule{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var rule FeeRule
+		if err := json.Unmarshal(queryResponse.Value, &rule); err != nil {
+			return nil, err
+		}
+		rules = append(rules, &rule)
+	}
+
+	return rules, nil
+}
+
+// GetTransferFees returns the fees charged on a transfer
+func (s *SupplyChainContract) GetTransferFees(ctx contractapi.TransactionContextInterface, receiptID string) ([]*TransferFee, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(transferFeeObjectType, []string{receiptID})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	fees := []*TransferFee{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var fee TransferFee
+		if err := json.Unmarshal(queryResponse.Value, &fee); err != nil {
+			return nil, err
+		}
+		fees = append(fees, &fee)
+	}
+
+	return fees, nil
+}
+
+// chargeTransferFees applies every fee rule whose threshold the transfer
+// price exceeds, writing a fee record and an invoice to the paying party
+func (s *SupplyChainContract) chargeTransferFees(ctx contractapi.TransactionContextInterface, receipt *TransferReceipt) error {
+	rules, err := s.GetFeeRules(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, rule := range rules {
+		if receipt.Amount <= rule.Threshold {
+			continue
+		}
+
+		payer := receipt.FromOwner
+		if rule.PayableBy == FeePayableByBuyer {
+			payer = receipt.ToOwner
+		}
+		fee := TransferFee{
+			ReceiptID:      receipt.ID,
+			RuleID:         rule.ID,
+			ProductID:      receipt.ProductID,
+			TransferAmount: receipt.Amount,
+			FeeAmount:      math.Round((receipt.Amount*rule.RatePercent/100+rule.FlatAmount)*100) / 100,
+			Payer:          payer,
+			Payee:          rule.Payee,
+			InvoiceID:      "fee-" + receipt.ID + "-" + rule.ID,
+			CreatedAt:      receipt.Timestamp,
+		}
+
+		description := fmt.Sprintf("%s fee on transfer of product %s", rule.ID, receipt.ProductID)
+		if _, err := s.createInvoice(ctx, fee.InvoiceID, fee.Payee, fee.Payer, fee.FeeAmount, description, receipt.ID, defaultPaymentTerms); err != nil {
+			return err
+		}
+		key, err := s.makeKey(ctx, transferFeeObjectType, receipt.ID, rule.ID)
+		if err != nil {
+			return err
+		}
+		if err := s.putState(ctx, key, &fee); err != nil {
+			return err
+		}
+	}
+	return nil
+}
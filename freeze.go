@@ -0,0 +1,55 @@
+package main
+
+import (
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// FreezeProduct puts a regulatory hold on a product. Until it is unfrozen
+// the product cannot be updated, transferred, archived or deleted. Only
+// callers with the regulator role can freeze products.
+func (s *SupplyChainContract) FreezeProduct(ctx contractapi.TransactionContextInterface, id, reason string) error {
+	if reason == "" {
+		return newError(ErrInvalidArgument, "a reason is required to freeze a product")
+	}
+	return s.setFrozen(ctx, id, true, reason)
+}
+
+// UnfreezeProduct lifts the regulatory hold on a product
+func (s *SupplyChainContract) UnfreezeProduct(ctx contractapi.TransactionContextInterface, id string) error {
+	return s.setFrozen(ctx, id, false, "")
+}
+
+// setFrozen is the shared implementation of FreezeProduct and UnfreezeProduct
+func (s *SupplyChainContract) setFrozen(ctx contractapi.TransactionContextInterface, id string, frozen bool, reason string) error {
+	if err := s.requireRole(ctx, RoleRegulator); err != nil {
+		return err
+	}
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	product, err := s.QueryProduct(ctx, id)
+	if err != nil {
+		return err
+	}
+	if product.Frozen == frozen {
+		if frozen {
+			return newError(ErrConflict, "product %s is already frozen", id)
+		}
+		return newError(ErrConflict, "product %s is not frozen", id)
+	}
+
+	product.Frozen = frozen
+	product.FreezeReason = reason
+	product.UpdatedAt = curTime
+	return s.putProduct(ctx, product)
+}
+
+// checkNotFrozen returns an error if a product is under a regulatory hold
+func checkNotFrozen(product *Product) error {
+	if product.Frozen {
+		return newError(ErrConflict, "product %s is frozen by a regulator: %s", product.ID, product.FreezeReason)
+	}
+	return nil
+}
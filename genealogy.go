@@ -0,0 +1,246 @@
+package main
+
+import (
+	"strconv"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Trace directions
+const (
+	TraceBackward = "backward"
+	TraceForward  = "forward"
+	TraceBoth     = "both"
+)
+
+// Node types that only exist in traces
+const (
+	NodeOrigin = "origin"
+	NodeSale   = "sale"
+
+	RelationOrigin = "origin"
+	RelationSale   = "sale"
+)
+
+// TraceEntry is one source or recipient reached by a lot trace. Level is
+// the number of transformation steps between it and the traced lot, and
+// ReachedFrom the node it was reached from over Relation. Party is who
+// holds it, where known: the consignee or destination of a shipment, the
+// retailer of a sale, the owner of a product.
+type TraceEntry struct {
+	Type        string `json:"type"`
+	ID          string `json:"id"`
+	Label       string `json:"label"`
+	Party       string `json:"party"`
+	Direction   string `json:"direction"`
+	Level       int    `json:"level"`
+	ReachedFrom string `json:"reached_from"`
+	Relation    string `json:"relation"`
+}
+
+// LotTrace is one page of a TraceLot result. Total counts every entry of
+// the trace and Bookmark is empty once the last page has been read.
+type LotTrace struct {
+	LotID     string        `json:"lot_id"`
+	Direction string        `json:"direction"`
+	Depth     int           `json:"depth"`
+	Entries   []*TraceEntry `json:"entries"`
+	Total     int           `json:"total"`
+	Truncated bool          `json:"truncated"`
+	Bookmark  string        `json:"bookmark"`
+}
+
+type traceVisit struct {
+	productID string
+	direction string
+	level     int
+}
+
+// TraceLot returns the genealogy of a lot: backward, the input products,
+// their lots and the origins they came from; forward, the products made
+// from it, the shipments that carried them and the sales that ended them.
+// Both gives both, without tracing a source forward again into its other
+// uses. depth bounds the transformation steps followed from the lot.
+//
+// The trace is recomputed on every call and paged in a stable order; pass
+// the returned bookmark to read the next page. Truncated is set when the
+// node limit was hit before the requested depth was fully explored.
+func (s *SupplyChainContract) TraceLot(ctx contractapi.TransactionContextInterface, lotID, direction string, depth, pageSize int, bookmark string) (*LotTrace, error) {
+	if direction != TraceBackward && direction != TraceForward && direction != TraceBoth {
+		return nil, newError(ErrInvalidArgument, "direction must be %s, %s or %s", TraceBackward, TraceForward, TraceBoth)
+	}
+	if depth < 1 || depth > maxGraphDepth {
+		return nil, newError(ErrInvalidArgument, "depth must be between 1 and %d", maxGraphDepth)
+	}
+	if err := s.checkPageSize(ctx, pageSize); err != nil {
+		return nil, err
+	}
+	offset := 0
+	if bookmark != "" {
+		var err error
+		offset, err = strconv.Atoi(bookmark)
+		if err != nil || offset < 0 {
+			return nil, newError(ErrInvalidArgument, "invalid bookmark %q", bookmark)
+		}
+	}
+
+	lot, err := s.QueryLot(ctx, lotID)
+	if err != nil {
+		return nil, err
+	}
+
+	trace := &LotTrace{LotID: lotID, Direction: direction, Depth: depth}
+	var entries []*TraceEntry
+	seen := map[string]bool{NodeLot + "|" + lotID: true}
+	add := func(entry *TraceEntry) bool {
+		nodeKey := entry.Type + "|" + entry.ID
+		if seen[nodeKey] {
+			return false
+		}
+		if len(seen) >= maxGraphNodes {
+			trace.Truncated = true
+			return false
+		}
+		seen[nodeKey] = true
+		entries = append(entries, entry)
+		return true
+	}
+
+	var queue []traceVisit
+	if direction != TraceForward {
+		for _, originID := range lot.OriginIDs {
+			if err := s.traceOrigin(ctx, originID, lotID, 0, add); err != nil {
+				return nil, err
+			}
+		}
+	}
+	productIDs, err := s.getIndexedIDs(ctx, lotProductIndexName, lotID)
+	if err != nil {
+		return nil, err
+	}
+	for _, productID := range productIDs {
+		product, err := s.QueryProduct(ctx, productID)
+		if err != nil {
+			return nil, err
+		}
+		add(&TraceEntry{Type: NodeProduct, ID: product.ID, Label: product.Name, Party: product.Owner, Direction: direction, ReachedFrom: lotID, Relation: RelationLot})
+		if direction != TraceForward {
+			queue = append(queue, traceVisit{productID: productID, direction: TraceBackward})
+		}
+		if direction != TraceBackward {
+			queue = append(queue, traceVisit{productID: productID, direction: TraceForward})
+		}
+	}
+
+	for len(queue) > 0 {
+		visit := queue[0]
+		queue = queue[1:]
+
+		if visit.direction == TraceForward {
+			if err := s.traceRecipients(ctx, visit.productID, visit.level, add); err != nil {
+				return nil, err
+			}
+		}
+		if visit.level >= depth {
+			continue
+		}
+
+		step := s.GetProductSources
+		if visit.direction == TraceForward {
+			step = s.GetProductUses
+		}
+		transformation, err := step(ctx, visit.productID)
+		if err != nil {
+			return nil, err
+		}
+		if transformation == nil {
+			continue
+		}
+		nextIDs := transformation.InputIDs
+		if visit.direction == TraceForward {
+			nextIDs = transformation.OutputIDs
+		}
+		for _, nextID := range nextIDs {
+			product, err := s.QueryProduct(ctx, nextID)
+			if err != nil {
+				return nil, err
+			}
+			if !add(&TraceEntry{Type: NodeProduct, ID: product.ID, Label: product.Name, Party: product.Owner, Direction: visit.direction, Level: visit.level + 1, ReachedFrom: visit.productID, Relation: RelationTransformation}) {
+				continue
+			}
+			queue = append(queue, traceVisit{productID: nextID, direction: visit.direction, level: visit.level + 1})
+			if product.LotID == "" {
+				continue
+			}
+			nextLot, err := s.QueryLot(ctx, product.LotID)
+			if err != nil {
+				return nil, err
+			}
+			add(&TraceEntry{Type: NodeLot, ID: nextLot.ID, Label: nextLot.Name, Direction: visit.direction, Level: visit.level + 1, ReachedFrom: product.ID, Relation: RelationLot})
+			if visit.direction == TraceBackward {
+				for _, originID := range nextLot.OriginIDs {
+					if err := s.traceOrigin(ctx, originID, nextLot.ID, visit.level+1, add); err != nil {
+						return nil, err
+					}
+				}
+			}
+		}
+	}
+
+	trace.Total = len(entries)
+	trace.Entries = []*TraceEntry{}
+	if offset < len(entries) {
+		end := offset + pageSize
+		if end < len(entries) {
+			trace.Bookmark = strconv.Itoa(end)
+		} else {
+			end = len(entries)
+		}
+		trace.Entries = entries[offset:end]
+	}
+	return trace, nil
+}
+
+// traceOrigin adds the origin a lot was sourced from
+func (s *SupplyChainContract) traceOrigin(ctx contractapi.TransactionContextInterface, originID, lotID string, level int, add func(*TraceEntry) bool) error {
+	origin, err := s.QueryOrigin(ctx, originID)
+	if err != nil {
+		return err
+	}
+	add(&TraceEntry{Type: NodeOrigin, ID: origin.ID, Label: origin.Name, Party: origin.Region, Direction: TraceBackward, Level: level, ReachedFrom: lotID, Relation: RelationOrigin})
+	return nil
+}
+
+// traceRecipients adds the shipments that carried a product and the sale
+// that ended its journey
+func (s *SupplyChainContract) traceRecipients(ctx contractapi.TransactionContextInterface, productID string, level int, add func(*TraceEntry) bool) error {
+	shipmentIDs, err := s.getIndexedIDs(ctx, productShipmentIndexName, productID)
+	if err != nil {
+		return err
+	}
+	for _, shipmentID := range shipmentIDs {
+		shipment, err := s.QueryShipment(ctx, shipmentID)
+		if err != nil {
+			return err
+		}
+		party := shipment.ConsigneeMSP
+		if party == "" {
+			party = shipment.Destination
+		}
+		add(&TraceEntry{Type: NodeShipment, ID: shipment.ID, Label: shipment.Origin + " -> " + shipment.Destination, Party: party, Direction: TraceForward, Level: level, ReachedFrom: productID, Relation: RelationShipment})
+	}
+
+	key, err := s.makeKey(ctx, saleObjectType, productID)
+	if err != nil {
+		return err
+	}
+	var sale Sale
+	exists, err := s.getState(ctx, key, &sale)
+	if err != nil {
+		return err
+	}
+	if exists {
+		add(&TraceEntry{Type: NodeSale, ID: productID, Label: sale.Region, Party: sale.Retailer, Direction: TraceForward, Level: level, ReachedFrom: productID, Relation: RelationSale})
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	// maxGraphDepth and maxGraphNodes bound the work a single graph query can do
+	maxGraphDepth = 5
+	maxGraphNodes = 500
+)
+
+// Node types and the relations that only exist in graph responses
+const (
+	NodeProduct  = "product"
+	NodeLot      = "lot"
+	NodeShipment = "shipment"
+
+	RelationLot            = "lot"
+	RelationShipment       = "shipment"
+	RelationTransformation = "transformation"
+)
+
+// GraphNode is one product, lot or shipment in a product graph
+type GraphNode struct {
+	ID     string `json:"id"`
+	Type   string `json:"type"`
+	Label  string `json:"label"`
+	Status string `json:"status"`
+}
+
+// GraphEdge links a container (parent, bundle, lot or shipment) to a product
+// it holds, or a transformation input to one of its outputs
+type GraphEdge struct {
+	From     string `json:"from"`
+	To       string `json:"to"`
+	Relation string `json:"relation"`
+}
+
+// ProductGraph is the neighbourhood of a product returned by GetProductGraph
+type ProductGraph struct {
+	RootID    string      `json:"root_id"`
+	Depth     int         `json:"depth"`
+	Nodes     []GraphNode `json:"nodes"`
+	Edges     []GraphEdge `json:"edges"`
+	Truncated bool        `json:"truncated"`
+}
+
+type graphVisit struct {
+	nodeType string
+	id       string
+	level    int
+}
+
+// GetProductGraph returns every product, lot and shipment reachable from a
+// product within depth hops, following component, bundle, lot, shipment and
+// transformation links in both directions. Truncated is set when the node
+// limit was hit before the requested depth was fully explored.
+func (s *SupplyChainContract) GetProductGraph(ctx contractapi.TransactionContextInterface, id string, depth int) (*ProductGraph, error) {
+	if depth < 1 || depth > maxGraphDepth {
+		return nil, newError(ErrInvalidArgument, "depth must be between 1 and %d", maxGraphDepth)
+	}
+
+	graph := &ProductGraph{RootID: id, Depth: depth, Nodes: []GraphNode{}, Edges: []GraphEdge{}}
+	seenNodes := make(map[string]bool)
+	seenEdges := make(map[string]bool)
+	queue := []graphVisit{{nodeType: NodeProduct, id: id}}
+	seenNodes[NodeProduct+"|"+id] = true
+
+	addEdge := func(from, to, relation string) {
+		edgeKey := from + "|" + relation + "|" + to
+		if !seenEdges[edgeKey] {
+			seenEdges[edgeKey] = true
+			graph.Edges = append(graph.Edges, GraphEdge{From: from, To: to, Relation: relation})
+		}
+	}
+	enqueue := func(nodeType, nodeID string, level int) {
+		nodeKey := nodeType + "|" + nodeID
+		if seenNodes[nodeKey] {
+			return
+		}
+		if len(seenNodes) >= maxGraphNodes {
+			graph.Truncated = true
+			return
+		}
+		seenNodes[nodeKey] = true
+		queue = append(queue, graphVisit{nodeType: nodeType, id: nodeID, level: level})
+	}
+
+	for len(queue) > 0 {
+		visit := queue[0]
+		queue = queue[1:]
+		next := visit.level + 1
+		expand := visit.level < depth
+
+		switch visit.nodeType {
+		case NodeProduct:
+			product, err := s.QueryProduct(ctx, visit.id)
+			if err != nil {
+				return nil, err
+			}
+			graph.Nodes = append(graph.Nodes, GraphNode{ID: product.ID, Type: NodeProduct, Label: product.Name, Status: product.Status})
+			if !expand {
+				continue
+			}
+
+			for _, relation := range []string{RelationComponent, RelationBundle} {
+				children, err := s.getRelated(ctx, relationIndexName, product.ID, relation)
+				if err != nil {
+					return nil, err
+				}
+				for _, childID := range children {
+					addEdge(product.ID, childID, relation)
+					enqueue(NodeProduct, childID, next)
+				}
+				containers, err := s.getRelated(ctx, reverseRelationIndexName, product.ID, relation)
+				if err != nil {
+					return nil, err
+				}
+				for _, containerID := range containers {
+					addEdge(containerID, product.ID, relation)
+					enqueue(NodeProduct, containerID, next)
+				}
+			}
+
+			if product.LotID != "" {
+				addEdge(product.LotID, product.ID, RelationLot)
+				enqueue(NodeLot, product.LotID, next)
+			}
+
+			source, err := s.GetProductSources(ctx, product.ID)
+			if err != nil {
+				return nil, err
+			}
+			if source != nil {
+				for _, inputID := range source.InputIDs {
+					addEdge(inputID, product.ID, RelationTransformation)
+					enqueue(NodeProduct, inputID, next)
+				}
+			}
+			use, err := s.GetProductUses(ctx, product.ID)
+			if err != nil {
+				return nil, err
+			}
+			if use != nil {
+				for _, outputID := range use.OutputIDs {
+					addEdge(product.ID, outputID, RelationTransformation)
+					enqueue(NodeProduct, outputID, next)
+				}
+			}
+
+			shipmentIDs, err := s.getIndexedIDs(ctx, productShipmentIndexName, product.ID)
+			if err != nil {
+				return nil, err
+			}
+			for _, shipmentID := range shipmentIDs {
+				addEdge(shipmentID, product.ID, RelationShipment)
+				enqueue(NodeShipment, shipmentID, next)
+			}
+
+		case NodeLot:
+			lot, err := s.QueryLot(ctx, visit.id)
+			if err != nil {
+				return nil, err
+			}
+			graph.Nodes = append(graph.Nodes, GraphNode{ID: lot.ID, Type: NodeLot, Label: lot.Name})
+			if !expand {
+				continue
+			}
+
+			productIDs, err := s.getIndexedIDs(ctx, lotProductIndexName, lot.ID)
+			if err != nil {
+				return nil, err
+			}
+			for _, productID := range productIDs {
+				addEdge(lot.ID, productID, RelationLot)
+				enqueue(NodeProduct, productID, next)
+			}
+
+		case NodeShipment:
+			shipment, err := s.QueryShipment(ctx, visit.id)
+			if err != nil {
+				return nil, err
+			}
+			graph.Nodes = append(graph.Nodes, GraphNode{ID: shipment.ID, Type: NodeShipment, Label: shipment.Origin + " -> " + shipment.Destination, Status: shipment.Status})
+			if !expand {
+				continue
+			}
+
+			for _, productID := range shipment.ProductIDs {
+				addEdge(shipment.ID, productID, RelationShipment)
+				enqueue(NodeProduct, productID, next)
+			}
+		}
+	}
+
+	return graph, nil
+}
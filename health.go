@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	// healthSampleSize is how many products HealthCheck runs the integrity
+	// checks on
+	healthSampleSize = 20
+	// maxClockSkew is how far a stored timestamp may lie after the
+	// transaction time before the clock is reported as suspect
+	maxClockSkew = 5 * time.Minute
+)
+
+// Health check names
+const (
+	HealthConfig     = "config"
+	HealthIndexes    = "indexes"
+	HealthClock      = "clock"
+	HealthCollection = "collection"
+)
+
+// Health check outcomes
+const (
+	HealthOK   = "ok"
+	HealthWarn = "warn"
+	HealthFail = "fail"
+)
+
+// HealthCheckResult is the outcome of one HealthCheck check
+type HealthCheckResult struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Detail string `json:"detail"`
+}
+
+// HealthReport is the outcome of HealthCheck. Healthy is false if any check
+// failed; warnings do not count against it.
+type HealthReport struct {
+	Healthy   bool                `json:"healthy"`
+	Checks    []HealthCheckResult `json:"checks"`
+	CheckedAt string              `json:"checked_at"`
+}
+
+// HealthCheck is a cheap smoke test for after an upgrade. It checks that
+// the contract configuration is present and readable, runs the integrity
+// checks of VerifyIntegrity on the first healthSampleSize products, checks
+// the transaction clock against the sample's timestamps, and checks that
+// the configured telemetry collection can be read from this peer.
+func (s *SupplyChainContract) HealthCheck(ctx contractapi.TransactionContextInterface) (*HealthReport, error) {
+	txTime, err := s.getTxTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+	report := &HealthReport{Healthy: true, Checks: []HealthCheckResult{}, CheckedAt: txTime.Format(time.RFC3339)}
+	check := func(name, status, format string, args ...interface{}) {
+		report.Checks = append(report.Checks, HealthCheckResult{Name: name, Status: status, Detail: fmt.Sprintf(format, args...)})
+		if status == HealthFail {
+			report.Healthy = false
+		}
+	}
+
+	configKey, err := s.makeKey(ctx, configObjectType, "contract")
+	if err != nil {
+		return nil, err
+	}
+	var config ContractConfig
+	exists, err := s.getState(ctx, configKey, &config)
+	switch {
+	case err != nil:
+		check(HealthConfig, HealthFail, "configuration cannot be read: %s", errorMessage(err))
+	case !exists:
+		check(HealthConfig, HealthWarn, "no configuration has been set; production defaults apply")
+	default:
+		check(HealthConfig, HealthOK, "%s environment, last changed %s", config.Environment, config.UpdatedAt)
+	}
+
+	resultsIterator, _, err := ctx.GetStub().GetStateByRangeWithPagination("", "", healthSampleSize, "")
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var sampled int
+	var findings []IntegrityFinding
+	var latest time.Time
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		sampled++
+
+		var product Product
+		if err := decodeProduct(queryResponse.Value, &product); err != nil {
+			findings = append(findings, IntegrityFinding{ProductID: queryResponse.Key, Check: CheckDecode, Message: errorMessage(err)})
+			continue
+		}
+		productFindings, err := s.checkProductIntegrity(ctx, &product)
+		if err != nil {
+			return nil, err
+		}
+		findings = append(findings, productFindings...)
+		if updatedAt, err := time.Parse(time.RFC3339, product.UpdatedAt); err == nil && updatedAt.After(latest) {
+			latest = updatedAt
+		}
+	}
+	if len(findings) > 0 {
+		check(HealthIndexes, HealthFail, "%d of %d sampled products have findings, first: %s %s", len(findings), sampled, findings[0].ProductID, findings[0].Message)
+	} else {
+		check(HealthIndexes, HealthOK, "%d sampled products are consistent", sampled)
+	}
+
+	switch {
+	case latest.Sub(txTime) > maxClockSkew:
+		check(HealthClock, HealthFail, "a sampled product was updated at %s, after the transaction time", latest.Format(time.RFC3339))
+	case config.sandboxActive() && config.TimeOffsetSeconds != 0:
+		check(HealthClock, HealthWarn, "sandbox time offset of %d seconds is in effect", config.TimeOffsetSeconds)
+	default:
+		check(HealthClock, HealthOK, "transaction time %s", txTime.Format(time.RFC3339))
+	}
+
+	if config.TelemetryStorage != TelemetryStoragePrivate {
+		check(HealthCollection, HealthOK, "telemetry is stored publicly")
+	} else if _, err := ctx.GetStub().GetPrivateData(config.TelemetryCollection, configKey); err != nil {
+		check(HealthCollection, HealthFail, "collection %s cannot be read: %v", config.TelemetryCollection, err)
+	} else {
+		check(HealthCollection, HealthOK, "collection %s is readable", config.TelemetryCollection)
+	}
+
+	return report, nil
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// maxExportProducts bounds the products one ExportHistories call may cover
+const maxExportProducts = 100
+
+// ProductVersion is one committed write of a product. Product is the state
+// the transaction wrote; it is empty when IsDelete is set.
+type ProductVersion struct {
+	ProductID string  `json:"product_id"`
+	TxID      string  `json:"tx_id"`
+	Timestamp string  `json:"timestamp"`
+	IsDelete  bool    `json:"is_delete"`
+	Product   Product `json:"product"`
+}
+
+// GetProductHistory returns every version of a product recorded in the
+// ledger's history database, oldest first. It needs history enabled on the
+// peer, which is the default.
+func (s *SupplyChainContract) GetProductHistory(ctx contractapi.TransactionContextInterface, id string) ([]*ProductVersion, error) {
+	historyIterator, err := ctx.GetStub().GetHistoryForKey(id)
+	if err != nil {
+		return nil, wrapError(err, "failed to read the history of %s", id)
+	}
+	defer historyIterator.Close()
+
+	versions := []*ProductVersion{}
+	var commitTimes []time.Time
+	for historyIterator.HasNext() {
+		modification, err := historyIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		version := ProductVersion{ProductID: id, TxID: modification.TxId, IsDelete: modification.IsDelete}
+		var commitTime time.Time
+		if modification.Timestamp != nil {
+			commitTime = modification.Timestamp.AsTime().UTC()
+			version.Timestamp = commitTime.Format(time.RFC3339)
+		}
+		if !modification.IsDelete {
+			if err := decodeProduct(modification.Value, &version.Product); err != nil {
+				return nil, err
+			}
+		}
+		versions = append(versions, &version)
+		commitTimes = append(commitTimes, commitTime)
+	}
+	if len(versions) == 0 {
+		return nil, newError(ErrNotFound, "product with ID %s has no history", id)
+	}
+
+	// The peer's order is not part of the API, so order by transaction time
+	order := make([]int, len(versions))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return commitTimes[order[i]].Before(commitTimes[order[j]])
+	})
+	sorted := make([]*ProductVersion, len(versions))
+	for i, index := range order {
+		sorted[i] = versions[index]
+	}
+	return sorted, nil
+}
+
+// GetProductDiff returns the fields that differ between the versions of a
+// product written by transactions txIDa and txIDb. Values are JSON; a side
+// that deleted the product has no values.
+func (s *SupplyChainContract) GetProductDiff(ctx contractapi.TransactionContextInterface, id, txIDa, txIDb string) ([]FieldChange, error) {
+	historyIterator, err := ctx.GetStub().GetHistoryForKey(id)
+	if err != nil {
+		return nil, wrapError(err, "failed to read the history of %s", id)
+	}
+	defer historyIterator.Close()
+
+	versions := make(map[string][]byte)
+	found := make(map[string]bool)
+	for historyIterator.HasNext() {
+		modification, err := historyIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		if modification.TxId != txIDa && modification.TxId != txIDb {
+			continue
+		}
+		found[modification.TxId] = true
+		if !modification.IsDelete {
+			versions[modification.TxId] = modification.Value
+		}
+	}
+	for _, txID := range []string{txIDa, txIDb} {
+		if !found[txID] {
+			return nil, newError(ErrNotFound, "transaction %s did not write product %s", txID, id)
+		}
+	}
+
+	return diffFields(versions[txIDa], versions[txIDb])
+}
+
+// HistoryExportPage is one page of ExportHistories results. Bookmark is
+// empty once every history has been read.
+type HistoryExportPage struct {
+	Versions []*ProductVersion `json:"versions"`
+	Bookmark string            `json:"bookmark"`
+}
+
+// exportBookmark is where an export stopped: after version TxID of ProductID
+type exportBookmark struct {
+	ProductID string `json:"product_id"`
+	TxID      string `json:"tx_id"`
+}
+
+// ExportHistories returns up to pageSize versions from the histories of
+// the products in idsJSON (a JSON array of up to maxExportProducts IDs),
+// product by product in ID order. since (RFC3339) is optional and leaves
+// out older versions. Pass the returned bookmark, with the same IDs and
+// since, to read the next page.
+func (s *SupplyChainContract) ExportHistories(ctx contractapi.TransactionContextInterface, idsJSON, since string, pageSize int, bookmark string) (*HistoryExportPage, error) {
+	if err := s.checkPageSize(ctx, pageSize); err != nil {
+		return nil, err
+	}
+	var ids []string
+	if err := json.Unmarshal([]byte(idsJSON), &ids); err != nil {
+		return nil, newError(ErrInvalidArgument, "failed to parse product IDs: %v", err)
+	}
+	ids = mergeIDs(ids, nil)
+	if len(ids) == 0 || len(ids) > maxExportProducts {
+		return nil, newError(ErrInvalidArgument, "an export must cover between 1 and %d products", maxExportProducts)
+	}
+	var sinceTime time.Time
+	if since != "" {
+		parsed, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			return nil, newError(ErrInvalidArgument, "since must be an RFC3339 timestamp: %v", err)
+		}
+		sinceTime = parsed
+	}
+
+	start, resumeAfter := 0, ""
+	if bookmark != "" {
+		var position exportBookmark
+		bookmarkJSON, err := base64.StdEncoding.DecodeString(bookmark)
+		if err == nil {
+			err = json.Unmarshal(bookmarkJSON, &position)
+		}
+		if err != nil {
+			return nil, newError(ErrInvalidArgument, "invalid bookmark: %v", err)
+		}
+		start = sort.SearchStrings(ids, position.ProductID)
+		if start == len(ids) || ids[start] != position.ProductID {
+			return nil, newError(ErrInvalidArgument, "bookmark is for product %s, which is not in this export", position.ProductID)
+		}
+		resumeAfter = position.TxID
+	}
+
+	page := &HistoryExportPage{Versions: []*ProductVersion{}}
+	for _, id := range ids[start:] {
+		full, err := s.exportHistory(ctx, id, sinceTime, resumeAfter, pageSize, page)
+		if err != nil {
+			return nil, err
+		}
+		if full {
+			last := page.Versions[len(page.Versions)-1]
+			bookmarkJSON, err := json.Marshal(exportBookmark{ProductID: last.ProductID, TxID: last.TxID})
+			if err != nil {
+				return nil, err
+			}
+			page.Bookmark = base64.StdEncoding.EncodeToString(bookmarkJSON)
+			return page, nil
+		}
+		resumeAfter = ""
+	}
+	return page, nil
+}
+
+// exportHistory appends the versions of one product written at or after
+// since, other than those up to and including resumeAfter, until the page
+// holds pageSize versions. It reports whether the page filled up.
+func (s *SupplyChainContract) exportHistory(ctx contractapi.TransactionContextInterface, id string, since time.Time, resumeAfter string, pageSize int, page *HistoryExportPage) (bool, error) {
+	historyIterator, err := ctx.GetStub().GetHistoryForKey(id)
+	if err != nil {
+		return false, wrapError(err, "failed to read the history of %s", id)
+	}
+	defer historyIterator.Close()
+
+	skipping := resumeAfter != ""
+	for historyIterator.HasNext() {
+		modification, err := historyIterator.Next()
+		if err != nil {
+			return false, err
+		}
+		if skipping {
+			skipping = modification.TxId != resumeAfter
+			continue
+		}
+		var commitTime time.Time
+		if modification.Timestamp != nil {
+			commitTime = modification.Timestamp.AsTime().UTC()
+		}
+		if commitTime.Before(since) {
+			continue
+		}
+		if len(page.Versions) == pageSize {
+			return true, nil
+		}
+
+		version := ProductVersion{ProductID: id, TxID: modification.TxId, IsDelete: modification.IsDelete, Timestamp: commitTime.Format(time.RFC3339)}
+		if !modification.IsDelete {
+			if err := decodeProduct(modification.Value, &version.Product); err != nil {
+				return false, err
+			}
+		}
+		page.Versions = append(page.Versions, &version)
+	}
+	return false, nil
+}
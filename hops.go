@@ -0,0 +1,166 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const ownershipHopsObjectType = "hops"
+
+// HopPolicy limits how the products of a category may change hands; the
+// policy with an empty category applies to every other category. MaxHops
+// caps the number of transfers over a product's life, zero meaning no cap.
+// Tiers are participant roles in the order products must pass through them:
+// a transfer may stay within the current owner's tier or move to the next
+// one, but never skip a tier or hand the product to an unregistered party.
+// Ownership reversals, which need a reason, may move back a tier.
+type HopPolicy struct {
+	Category string   `json:"category"`
+	MaxHops  int      `json:"max_hops"`
+	Tiers    []string `json:"tiers"`
+}
+
+// OwnershipHops counts the transfers of a product
+type OwnershipHops struct {
+	ProductID string `json:"product_id"`
+	Hops      int    `json:"hops"`
+	UpdatedAt string `json:"updated_at"`
+}
+
+// SetHopPolicy sets the hop policy of a category's products; an empty
+// category sets the default. A policy with no cap and no tiers removes the
+// category's policy.
+func (s *SupplyChainContract) SetHopPolicy(ctx contractapi.TransactionContextInterface, category string, maxHops int, tiers []string) error {
+	if err := s.requireRole(ctx, RoleAdmin); err != nil {
+		return err
+	}
+	if maxHops < 0 {
+		return newError(ErrInvalidArgument, "max hops must not be negative")
+	}
+	seen := make(map[string]bool, len(tiers))
+	for _, tier := range tiers {
+		if !participantRoles[tier] {
+			return newError(ErrInvalidArgument, "unknown participant role %s", tier)
+		}
+		if seen[tier] {
+			return newError(ErrInvalidArgument, "tier %s is listed more than once", tier)
+		}
+		seen[tier] = true
+	}
+
+	config, err := s.GetContractConfig(ctx)
+	if err != nil {
+		return err
+	}
+	policies := []HopPolicy{}
+	for _, policy := range config.HopPolicies {
+		if policy.Category != category {
+			policies = append(policies, policy)
+		}
+	}
+	if maxHops > 0 || len(tiers) > 0 {
+		policies = append(policies, HopPolicy{Category: category, MaxHops: maxHops, Tiers: append([]string{}, tiers...)})
+	}
+	sort.Slice(policies, func(i, j int) bool {
+		return policies[i].Category < policies[j].Category
+	})
+
+	config.HopPolicies = policies
+	return s.putContractConfig(ctx, config)
+}
+
+// GetOwnershipHops returns how many times a product has changed hands
+func (s *SupplyChainContract) GetOwnershipHops(ctx contractapi.TransactionContextInterface, productID string) (*OwnershipHops, error) {
+	key, err := s.makeKey(ctx, ownershipHopsObjectType, productID)
+	if err != nil {
+		return nil, err
+	}
+	hops := OwnershipHops{ProductID: productID}
+	if _, err := s.getState(ctx, key, &hops); err != nil {
+		return nil, err
+	}
+	return &hops, nil
+}
+
+// checkOwnershipPolicy rejects handing a product to newOwner if its
+// category's hop policy forbids it, then counts the transfer
+func (s *SupplyChainContract) checkOwnershipPolicy(ctx contractapi.TransactionContextInterface, product *Product, newOwner string) error {
+	hops, err := s.GetOwnershipHops(ctx, product.ID)
+	if err != nil {
+		return err
+	}
+	policy, err := s.hopPolicy(ctx, product.Category)
+	if err != nil {
+		return err
+	}
+
+	if policy.MaxHops > 0 && hops.Hops >= policy.MaxHops {
+		return newError(ErrConflict, "product %s has changed hands %d times, the most its category allows", product.ID, hops.Hops)
+	}
+	if len(policy.Tiers) > 0 && !isOwnershipReversal(product, newOwner) {
+		current, err := s.ownerTier(ctx, policy, product.Owner)
+		if err != nil {
+			return err
+		}
+		next, err := s.ownerTier(ctx, policy, newOwner)
+		if err != nil {
+			return err
+		}
+		switch {
+		case next < 0:
+			return newError(ErrForbidden, "%s is not a registered %v and cannot receive product %s", newOwner, policy.Tiers, product.ID)
+		case next < current:
+			return newError(ErrConflict, "product %s cannot move back from %s to %s", product.ID, policy.Tiers[current], policy.Tiers[next])
+		case next > current+1:
+			return newError(ErrConflict, "product %s must pass through %s before reaching %s", product.ID, policy.Tiers[current+1], policy.Tiers[next])
+		}
+	}
+
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	hops.Hops++
+	hops.UpdatedAt = curTime
+	key, err := s.makeKey(ctx, ownershipHopsObjectType, product.ID)
+	if err != nil {
+		return err
+	}
+	return s.putState(ctx, key, hops)
+}
+
+// hopPolicy returns the policy for a category: its own, else the default,
+// else no policy
+func (s *SupplyChainContract) hopPolicy(ctx contractapi.TransactionContextInterface, category string) (*HopPolicy, error) {
+	config, err := s.GetContractConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	policy := &HopPolicy{}
+	for i := range config.HopPolicies {
+		switch config.HopPolicies[i].Category {
+		case category:
+			return &config.HopPolicies[i], nil
+		case "":
+			policy = &config.HopPolicies[i]
+		}
+	}
+	return policy, nil
+}
+
+// ownerTier returns the position of a party's participant role in the
+// policy's tiers, or -1 if the party is not registered with one of them
+func (s *SupplyChainContract) ownerTier(ctx contractapi.TransactionContextInterface, policy *HopPolicy, owner string) (int, error) {
+	participant, err := s.findParticipant(ctx, owner)
+	if err != nil || participant == nil {
+		return -1, err
+	}
+	for i, tier := range policy.Tiers {
+		if tier == participant.Role {
+			return i, nil
+		}
+	}
+	return -1, nil
+}
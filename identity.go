@@ -0,0 +1,127 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// roleAttribute is the certificate attribute (issued by the Fabric CA) that
+// carries the roles of the invoking identity, comma separated
+const roleAttribute = "role"
+
+// Roles
+const (
+	RoleAdmin        = "admin"
+	RoleCarrier      = "carrier"
+	RoleFinance      = "finance"
+	RoleBank         = "bank"
+	RoleInspector    = "inspector"
+	RoleManufacturer = "manufacturer"
+	RoleRegulator    = "regulator"
+)
+
+// getClientMSPID returns the MSP ID of the invoking organization
+func (s *SupplyChainContract) getClientMSPID(ctx contractapi.TransactionContextInterface) (string, error) {
+	mspID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return "", wrapError(err, "failed to get client MSP ID")
+	}
+	return mspID, nil
+}
+
+// getClientID returns the unique ID of the invoking identity
+func (s *SupplyChainContract) getClientID(ctx contractapi.TransactionContextInterface) (string, error) {
+	id, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return "", wrapError(err, "failed to get client identity")
+	}
+	return id, nil
+}
+
+// hasRole reports whether the invoking identity holds the given role. In the
+// default program roles are certificate attributes; in any other program
+// they are the roles assigned there, and channel admins are admins.
+func (s *SupplyChainContract) hasRole(ctx contractapi.TransactionContextInterface, role string) (bool, error) {
+	program, err := s.getProgram(ctx)
+	if err != nil {
+		return false, err
+	}
+	certRole, err := s.hasCertRole(ctx, role)
+	if program == "" || err != nil || (certRole && role == RoleAdmin) {
+		return certRole, err
+	}
+
+	clientID, err := s.getClientID(ctx)
+	if err != nil {
+		return false, err
+	}
+	assigned, err := s.GetProgramRoles(ctx, clientID)
+	if err != nil {
+		return false, err
+	}
+	for _, r := range assigned.Roles {
+		if r == role {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// hasCertRole reports whether the invoking identity's certificate carries the given role
+func (s *SupplyChainContract) hasCertRole(ctx contractapi.TransactionContextInterface, role string) (bool, error) {
+	value, found, err := ctx.GetClientIdentity().GetAttributeValue(roleAttribute)
+	if err != nil {
+		return false, wrapError(err, "failed to read client attributes")
+	}
+	if !found {
+		return false, nil
+	}
+	for _, r := range strings.Split(value, ",") {
+		if strings.TrimSpace(r) == role {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// requireRole returns an error unless the invoking identity carries the given role
+func (s *SupplyChainContract) requireRole(ctx contractapi.TransactionContextInterface, role string) error {
+	ok, err := s.hasRole(ctx, role)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return newError(ErrForbidden, "caller does not have the %s role", role)
+	}
+	return nil
+}
+
+// requireProductOwner returns a permission error unless the caller belongs
+// to the organization that owns the product, or acts for the registered
+// participant owning it, or is an admin. action names
+// the refused operation in the error.
+func (s *SupplyChainContract) requireProductOwner(ctx contractapi.TransactionContextInterface, product *Product, action string) error {
+	mspID, err := s.getClientMSPID(ctx)
+	if err != nil {
+		return err
+	}
+	if mspID == product.Owner {
+		return nil
+	}
+	participant, err := s.findParticipant(ctx, product.Owner)
+	if err != nil {
+		return err
+	}
+	if participant != nil && participant.MSPID == mspID {
+		return nil
+	}
+	isAdmin, err := s.hasRole(ctx, RoleAdmin)
+	if err != nil {
+		return err
+	}
+	if !isAdmin {
+		return newError(ErrForbidden, "%s is not the owner %s of product %s and cannot %s it", mspID, product.Owner, product.ID, action)
+	}
+	return nil
+}
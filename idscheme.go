@@ -0,0 +1,235 @@
+package main
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Product ID schemes
+const (
+	IDSchemeFree   = "free"
+	IDSchemeGS1    = "gs1"
+	IDSchemePrefix = "prefix"
+	IDSchemeUUID   = "uuid"
+	IDSchemeDID    = "did"
+)
+
+// IDSchemeRule selects the ID scheme of the products of a category; the
+// rule with an empty category applies to every other category. Prefix and
+// Digits configure the prefix scheme, Digits being the sequence length (zero
+// for any length).
+type IDSchemeRule struct {
+	Category string `json:"category"`
+	Scheme   string `json:"scheme"`
+	Prefix   string `json:"prefix"`
+	Digits   int    `json:"digits"`
+}
+
+// ParsedID is a product ID broken into the parts its scheme defines
+type ParsedID struct {
+	ID     string            `json:"id"`
+	Scheme string            `json:"scheme"`
+	Parts  map[string]string `json:"parts"`
+}
+
+// idScheme validates and parses the IDs of one scheme. parse is only called
+// with an ID that passed validate.
+type idScheme interface {
+	validate(id string, rule *IDSchemeRule) error
+	parse(id string, rule *IDSchemeRule) map[string]string
+}
+
+var idSchemes = map[string]idScheme{
+	IDSchemeFree:   freeIDScheme{},
+	IDSchemeGS1:    gs1IDScheme{},
+	IDSchemePrefix: prefixIDScheme{},
+	IDSchemeUUID:   uuidIDScheme{},
+	IDSchemeDID:    didIDScheme{},
+}
+
+// SetIDScheme sets the ID scheme new products of a category must follow; an
+// empty category sets the default. Since the configuration belongs to the
+// program, each program chooses its own schemes.
+func (s *SupplyChainContract) SetIDScheme(ctx contractapi.TransactionContextInterface, category, scheme, prefix string, digits int) error {
+	if err := s.requireRole(ctx, RoleAdmin); err != nil {
+		return err
+	}
+	if _, ok := idSchemes[scheme]; !ok {
+		return newError(ErrInvalidArgument, "unknown ID scheme %s", scheme)
+	}
+	if scheme == IDSchemePrefix && prefix == "" {
+		return newError(ErrInvalidArgument, "the %s scheme needs a prefix", IDSchemePrefix)
+	}
+	if digits < 0 {
+		return newError(ErrInvalidArgument, "digits must not be negative")
+	}
+
+	config, err := s.GetContractConfig(ctx)
+	if err != nil {
+		return err
+	}
+
+	rules := []IDSchemeRule{}
+	for _, rule := range config.IDSchemes {
+		if rule.Category != category {
+			rules = append(rules, rule)
+		}
+	}
+	if scheme != IDSchemeFree || category != "" {
+		rules = append(rules, IDSchemeRule{Category: category, Scheme: scheme, Prefix: prefix, Digits: digits})
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		return rules[i].Category < rules[j].Category
+	})
+
+	config.IDSchemes = rules
+	return s.putContractConfig(ctx, config)
+}
+
+// ParseProductID validates an ID against the scheme of a category and
+// returns its parts, without creating anything
+func (s *SupplyChainContract) ParseProductID(ctx contractapi.TransactionContextInterface, id, category string) (*ParsedID, error) {
+	rule, err := s.idSchemeRule(ctx, category)
+	if err != nil {
+		return nil, err
+	}
+	scheme := idSchemes[rule.Scheme]
+	if err := scheme.validate(id, rule); err != nil {
+		return nil, wrapError(err, "invalid %s product ID %q", rule.Scheme, id)
+	}
+	return &ParsedID{ID: id, Scheme: rule.Scheme, Parts: scheme.parse(id, rule)}, nil
+}
+
+// checkProductID returns an error unless id follows the ID scheme of category
+func (s *SupplyChainContract) checkProductID(ctx contractapi.TransactionContextInterface, id, category string) error {
+	_, err := s.ParseProductID(ctx, id, category)
+	return err
+}
+
+// idSchemeRule returns the rule for a category: its own, else the default,
+// else free-form IDs
+func (s *SupplyChainContract) idSchemeRule(ctx contractapi.TransactionContextInterface, category string) (*IDSchemeRule, error) {
+	config, err := s.GetContractConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rule := &IDSchemeRule{Scheme: IDSchemeFree}
+	for i := range config.IDSchemes {
+		switch config.IDSchemes[i].Category {
+		case category:
+			return &config.IDSchemes[i], nil
+		case "":
+			rule = &config.IDSchemes[i]
+		}
+	}
+	return rule, nil
+}
+
+// freeIDScheme accepts any non-empty ID
+type freeIDScheme struct{}
+
+func (freeIDScheme) validate(id string, rule *IDSchemeRule) error {
+	if strings.TrimSpace(id) == "" {
+		return newError(ErrInvalidArgument, "ID must not be empty")
+	}
+	return nil
+}
+
+func (freeIDScheme) parse(id string, rule *IDSchemeRule) map[string]string {
+	return map[string]string{}
+}
+
+// gs1IDScheme accepts a GS1 element string holding a GTIN and optionally a
+// serial number, e.g. (01)09506000134352(21)A100
+type gs1IDScheme struct{}
+
+var gs1Pattern = regexp.MustCompile(`^\(01\)([0-9]{14})(?:\(21\)([!-~]{1,20}))?$`)
+
+func (gs1IDScheme) validate(id string, rule *IDSchemeRule) error {
+	match := gs1Pattern.FindStringSubmatch(id)
+	if match == nil {
+		return newError(ErrInvalidArgument, "expected (01) and a 14-digit GTIN, optionally followed by (21) and a serial")
+	}
+	if !gs1CheckDigitValid(match[1]) {
+		return newError(ErrInvalidArgument, "GTIN %s has a wrong check digit", match[1])
+	}
+	return nil
+}
+
+func (gs1IDScheme) parse(id string, rule *IDSchemeRule) map[string]string {
+	match := gs1Pattern.FindStringSubmatch(id)
+	return map[string]string{"gtin": match[1], "serial": match[2]}
+}
+
+// gs1CheckDigitValid reports whether the last digit of a GS1 number is the
+// check digit of the others: weights 3 and 1 alternate from the right
+func gs1CheckDigitValid(digits string) bool {
+	sum := 0
+	for i := len(digits) - 2; i >= 0; i-- {
+		d := int(digits[i] - '0')
+		if (len(digits)-2-i)%2 == 0 {
+			d *= 3
+		}
+		sum += d
+	}
+	return int(digits[len(digits)-1]-'0') == (10-sum%10)%10
+}
+
+// prefixIDScheme accepts the configured prefix followed by a decimal sequence
+// number, e.g. ACME-000123 for prefix ACME- and 6 digits
+type prefixIDScheme struct{}
+
+func (prefixIDScheme) validate(id string, rule *IDSchemeRule) error {
+	if !strings.HasPrefix(id, rule.Prefix) {
+		return newError(ErrInvalidArgument, "expected prefix %s", rule.Prefix)
+	}
+	sequence := strings.TrimPrefix(id, rule.Prefix)
+	if sequence == "" || strings.Trim(sequence, "0123456789") != "" {
+		return newError(ErrInvalidArgument, "expected a decimal sequence number after %s", rule.Prefix)
+	}
+	if rule.Digits > 0 && len(sequence) != rule.Digits {
+		return newError(ErrInvalidArgument, "expected a %d-digit sequence number", rule.Digits)
+	}
+	return nil
+}
+
+func (prefixIDScheme) parse(id string, rule *IDSchemeRule) map[string]string {
+	return map[string]string{"prefix": rule.Prefix, "sequence": strings.TrimPrefix(id, rule.Prefix)}
+}
+
+// uuidIDScheme accepts a UUID in its canonical hyphenated form
+type uuidIDScheme struct{}
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+func (uuidIDScheme) validate(id string, rule *IDSchemeRule) error {
+	if !uuidPattern.MatchString(id) {
+		return newError(ErrInvalidArgument, "expected a UUID such as 123e4567-e89b-12d3-a456-426614174000")
+	}
+	return nil
+}
+
+func (uuidIDScheme) parse(id string, rule *IDSchemeRule) map[string]string {
+	return map[string]string{"version": id[14:15]}
+}
+
+// didIDScheme accepts a decentralized identifier, did:<method>:<identifier>
+type didIDScheme struct{}
+
+var didPattern = regexp.MustCompile(`^did:([a-z0-9]+):([A-Za-z0-9._%-]+(?::[A-Za-z0-9._%-]+)*)$`)
+
+func (didIDScheme) validate(id string, rule *IDSchemeRule) error {
+	if !didPattern.MatchString(id) {
+		return newError(ErrInvalidArgument, "expected did:<method>:<identifier>")
+	}
+	return nil
+}
+
+func (didIDScheme) parse(id string, rule *IDSchemeRule) map[string]string {
+	match := didPattern.FindStringSubmatch(id)
+	return map[string]string{"method": match[1], "identifier": match[2]}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Product indexes, so lookups by owner, category and status are partial
+// composite key scans that work on LevelDB
+const (
+	ownerProductIndexName    = "owner~product"
+	categoryProductIndexName = "category~product"
+	statusProductIndexName   = "status~product"
+)
+
+// productIndexes lists every product index with the field it is keyed by.
+// An index added here is maintained by indexProduct and filled by
+// RebuildProductIndexes.
+var productIndexes = []struct {
+	name  string
+	field func(product *Product) string
+}{
+	{ownerProductIndexName, func(product *Product) string { return product.Owner }},
+	{categoryProductIndexName, func(product *Product) string { return product.Category }},
+	{statusProductIndexName, func(product *Product) string { return product.Status }},
+}
+
+// IndexRebuildResult reports one page of an index rebuild. Bookmark is
+// empty once every product has been visited.
+type IndexRebuildResult struct {
+	Scanned  int    `json:"scanned"`
+	Bookmark string `json:"bookmark"`
+}
+
+// RebuildProductIndexes writes the index entries of one page of products.
+// Products written before an index existed are missing from it until they
+// are next changed; a network upgrading the contract runs this once, from
+// an empty bookmark until the returned bookmark is empty.
+func (s *SupplyChainContract) RebuildProductIndexes(ctx contractapi.TransactionContextInterface, pageSize int, bookmark string) (*IndexRebuildResult, error) {
+	if err := s.requireRole(ctx, RoleAdmin); err != nil {
+		return nil, err
+	}
+	if err := s.checkPageSize(ctx, pageSize); err != nil {
+		return nil, err
+	}
+
+	resultsIterator, metadata, err := ctx.GetStub().GetStateByRangeWithPagination("", "", int32(pageSize), bookmark)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	result := &IndexRebuildResult{Bookmark: metadata.GetBookmark()}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		var product Product
+		if err := decodeProduct(queryResponse.Value, &product); err != nil {
+			return nil, wrapError(err, "failed to decode product %s", queryResponse.Key)
+		}
+		if err := s.indexProduct(ctx, nil, &product); err != nil {
+			return nil, err
+		}
+		result.Scanned++
+	}
+
+	return result, nil
+}
+
+// indexProduct moves a product's index entries from the values in before
+// to those in after. before is nil for a new product and after for a
+// deleted one.
+func (s *SupplyChainContract) indexProduct(ctx contractapi.TransactionContextInterface, before, after *Product) error {
+	for _, index := range productIndexes {
+		var oldValue, newValue string
+		if before != nil {
+			oldValue = index.field(before)
+		}
+		if after != nil {
+			newValue = index.field(after)
+		}
+		if before != nil && after != nil && oldValue == newValue {
+			continue
+		}
+		if before != nil {
+			if err := s.delIndexKey(ctx, index.name, oldValue, before.ID); err != nil {
+				return err
+			}
+		}
+		if after != nil {
+			if err := s.putIndexKey(ctx, index.name, newValue, after.ID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// putIndexedProduct writes a product changed from before and moves its
+// index entries with it. Every transaction that changes an indexed field
+// writes the product through here, so the product and its index entries
+// are updated in the same write set.
+func (s *SupplyChainContract) putIndexedProduct(ctx contractapi.TransactionContextInterface, before Product, after *Product) error {
+	if err := s.putProduct(ctx, after); err != nil {
+		return err
+	}
+	return s.indexProduct(ctx, &before, after)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// identifierPattern is the character set of IDs and owners: printable ASCII
+// without spaces. It keeps key separators, control characters and lookalike
+// Unicode out of composite keys while allowing every ID scheme, including
+// GS1 element strings, and MSP IDs.
+var identifierPattern = regexp.MustCompile(`^[!-~]+$`)
+
+// fieldRule describes what an input field may hold
+type fieldRule struct {
+	required  bool
+	maxLength int
+	// identifier restricts the field to identifierPattern; other fields
+	// may hold any printable text
+	identifier bool
+}
+
+// Rules of the product fields
+var (
+	productIDRule    = fieldRule{required: true, maxLength: 64, identifier: true}
+	ownerRule        = fieldRule{required: true, maxLength: 128, identifier: true}
+	productNameRule  = fieldRule{required: true, maxLength: 128}
+	descriptionRule  = fieldRule{maxLength: 1024}
+	categoryRule     = fieldRule{maxLength: 64}
+	statusRule       = fieldRule{maxLength: 32, identifier: true}
+	manufacturerRule = fieldRule{maxLength: 128}
+	serialNumberRule = fieldRule{maxLength: 64}
+
+	// productRefRule is for IDs of existing products, which may predate
+	// productIDRule
+	productRefRule = fieldRule{required: true, maxLength: 256}
+)
+
+// optional returns the rule with the field no longer required, for fields
+// where empty means "leave unchanged"
+func (r fieldRule) optional() fieldRule {
+	r.required = false
+	return r
+}
+
+// inputField is one argument to validate. value points at the argument so
+// validateFields can trim it in place.
+type inputField struct {
+	name  string
+	value *string
+	rule  fieldRule
+}
+
+// field names an argument for validateFields
+func field(name string, value *string, rule fieldRule) inputField {
+	return inputField{name: name, value: value, rule: rule}
+}
+
+// validateFields trims surrounding whitespace from every field and checks it
+// against its rule. The error names each invalid field and what is wrong
+// with it.
+func validateFields(fields ...inputField) error {
+	var problems []string
+	for _, f := range fields {
+		*f.value = strings.TrimSpace(*f.value)
+		if problem := f.rule.check(*f.value); problem != "" {
+			problems = append(problems, f.name+" "+problem)
+		}
+	}
+	if len(problems) > 0 {
+		return &ChaincodeError{Code: ErrInvalidArgument, Message: strings.Join(problems, "; ")}
+	}
+	return nil
+}
+
+// check returns what is wrong with value, or an empty string if it is valid
+func (r fieldRule) check(value string) string {
+	if value == "" {
+		if r.required {
+			return "must not be empty"
+		}
+		return ""
+	}
+	if !utf8.ValidString(value) {
+		return "must be valid UTF-8"
+	}
+	if length := utf8.RuneCountInString(value); length > r.maxLength {
+		return fmt.Sprintf("must be at most %d characters, got %d", r.maxLength, length)
+	}
+	if r.identifier {
+		if !identifierPattern.MatchString(value) {
+			return "may only contain printable ASCII characters other than spaces"
+		}
+		return ""
+	}
+	for _, c := range value {
+		if unicode.IsControl(c) && c != '\n' && c != '\t' {
+			return "must not contain control characters"
+		}
+	}
+	return ""
+}
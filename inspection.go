@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const inspectionObjectType = "inspection"
+
+// Inspection results
+const (
+	InspectionPassed = "Passed"
+	InspectionFailed = "Failed"
+)
+
+// Inspection is a quality or quantity inspection of a shipment. The report
+// is kept off-chain; only its SHA-256 hash is stored.
+type Inspection struct {
+	ID          string `json:"id"`
+	ShipmentID  string `json:"shipment_id"`
+	Result      string `json:"result"`
+	ReportHash  string `json:"report_hash"`
+	Notes       string `json:"notes"`
+	Inspector   string `json:"inspector"`
+	InspectedAt string `json:"inspected_at"`
+}
+
+// RecordInspection records the outcome of an inspection of a shipment.
+// Only identities with the inspector role may record inspections.
+func (s *SupplyChainContract) RecordInspection(ctx contractapi.TransactionContextInterface, shipmentID, result, reportHash, notes string) (*Inspection, error) {
+	if err := s.requireRole(ctx, RoleInspector); err != nil {
+		return nil, err
+	}
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if result != InspectionPassed && result != InspectionFailed {
+		return nil, newError(ErrInvalidArgument, "inspection result must be %s or %s", InspectionPassed, InspectionFailed)
+	}
+	if reportHash != "" && !isSHA256Hex(reportHash) {
+		return nil, newError(ErrInvalidArgument, "report hash must be a hex-encoded SHA-256 digest")
+	}
+	if _, err := s.QueryShipment(ctx, shipmentID); err != nil {
+		return nil, err
+	}
+	clientID, err := s.getClientID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	inspection := Inspection{
+		ID:          ctx.GetStub().GetTxID(),
+		ShipmentID:  shipmentID,
+		Result:      result,
+		ReportHash:  reportHash,
+		Notes:       notes,
+		Inspector:   clientID,
+		InspectedAt: curTime,
+	}
+	// Keyed by time so a partial key scan returns the inspections in order
+	key, err := s.makeKey(ctx, inspectionObjectType, shipmentID, curTime, inspection.ID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.putState(ctx, key, &inspection); err != nil {
+		return nil, err
+	}
+	return &inspection, nil
+}
+
+// GetShipmentInspections returns the inspections of a shipment, oldest first
+func (s *SupplyChainContract) GetShipmentInspections(ctx contractapi.TransactionContextInterface, shipmentID string) ([]*Inspection, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(inspectionObjectType, []string{shipmentID})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	inspections := []*Inspection{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var inspection Inspection
+		if err := json.Unmarshal(queryResponse.Value, &inspection); err != nil {
+			return nil, err
+		}
+		inspections = append(inspections, &inspection)
+	}
+
+	return inspections, nil
+}
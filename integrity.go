@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Integrity checks
+const (
+	CheckDecode    = "decode"
+	CheckStatus    = "status"
+	CheckTimestamp = "timestamp"
+	CheckLot       = "lot"
+	CheckRelation  = "relation"
+	CheckShipment  = "shipment"
+)
+
+// IntegrityFinding describes one inconsistency found by VerifyIntegrity
+type IntegrityFinding struct {
+	ProductID string `json:"product_id"`
+	Check     string `json:"check"`
+	Message   string `json:"message"`
+}
+
+// IntegrityReport is one page of VerifyIntegrity results. Bookmark is empty
+// once the last page has been checked.
+type IntegrityReport struct {
+	Checked  int                `json:"checked"`
+	Findings []IntegrityFinding `json:"findings"`
+	Bookmark string             `json:"bookmark"`
+}
+
+// VerifyIntegrity checks one page of products for consistency with the rest
+// of the ledger: the status is a known one, timestamps parse, the product's
+// lot exists and indexes it, component edges have their reverse edge, and
+// every shipment index entry points at a shipment that lists the product.
+// Pass the returned bookmark to check the next page.
+func (s *SupplyChainContract) VerifyIntegrity(ctx contractapi.TransactionContextInterface, pageSize int, bookmark string) (*IntegrityReport, error) {
+	if err := s.checkPageSize(ctx, pageSize); err != nil {
+		return nil, err
+	}
+
+	resultsIterator, metadata, err := ctx.GetStub().GetStateByRangeWithPagination("", "", int32(pageSize), bookmark)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	report := &IntegrityReport{Findings: []IntegrityFinding{}, Bookmark: metadata.GetBookmark()}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		report.Checked++
+
+		var product Product
+		if err := decodeProduct(queryResponse.Value, &product); err != nil {
+			report.Findings = append(report.Findings, IntegrityFinding{ProductID: queryResponse.Key, Check: CheckDecode, Message: errorMessage(err)})
+			continue
+		}
+
+		findings, err := s.checkProductIntegrity(ctx, &product)
+		if err != nil {
+			return nil, err
+		}
+		report.Findings = append(report.Findings, findings...)
+	}
+
+	return report, nil
+}
+
+// checkProductIntegrity runs every integrity check against a single product
+func (s *SupplyChainContract) checkProductIntegrity(ctx contractapi.TransactionContextInterface, product *Product) ([]IntegrityFinding, error) {
+	var findings []IntegrityFinding
+	report := func(check, format string, args ...interface{}) {
+		findings = append(findings, IntegrityFinding{ProductID: product.ID, Check: check, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if !isValidProductStatus(product.Status) {
+		report(CheckStatus, "unknown status %q", product.Status)
+	}
+	if _, err := time.Parse(time.RFC3339, product.CreatedAt); err != nil {
+		report(CheckTimestamp, "created_at %q is not an RFC3339 timestamp", product.CreatedAt)
+	}
+	if _, err := time.Parse(time.RFC3339, product.UpdatedAt); err != nil {
+		report(CheckTimestamp, "updated_at %q is not an RFC3339 timestamp", product.UpdatedAt)
+	}
+
+	if product.LotID != "" {
+		exists, err := s.lotExists(ctx, product.LotID)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			report(CheckLot, "lot %s does not exist", product.LotID)
+		} else {
+			indexed, err := s.indexKeyExists(ctx, lotProductIndexName, product.LotID, product.ID)
+			if err != nil {
+				return nil, err
+			}
+			if !indexed {
+				report(CheckLot, "lot %s does not index the product", product.LotID)
+			}
+		}
+	}
+
+	for _, relation := range []string{RelationComponent, RelationBundle} {
+		children, err := s.getRelated(ctx, relationIndexName, product.ID, relation)
+		if err != nil {
+			return nil, err
+		}
+		for _, childID := range children {
+			reverse, err := s.indexKeyExists(ctx, reverseRelationIndexName, childID, relation, product.ID)
+			if err != nil {
+				return nil, err
+			}
+			if !reverse {
+				report(CheckRelation, "%s edge to %s has no reverse edge", relation, childID)
+			}
+			exists, err := s.ProductExists(ctx, childID)
+			if err != nil {
+				return nil, err
+			}
+			if !exists {
+				report(CheckRelation, "%s %s does not exist", relation, childID)
+			}
+		}
+	}
+
+	shipmentIDs, err := s.getIndexedIDs(ctx, productShipmentIndexName, product.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, shipmentID := range shipmentIDs {
+		key, err := s.makeKey(ctx, shipmentObjectType, shipmentID)
+		if err != nil {
+			return nil, err
+		}
+		var shipment Shipment
+		exists, err := s.getState(ctx, key, &shipment)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			report(CheckShipment, "indexed shipment %s does not exist", shipmentID)
+			continue
+		}
+		if !containsString(shipment.ProductIDs, product.ID) {
+			report(CheckShipment, "indexed shipment %s does not list the product", shipmentID)
+		}
+	}
+
+	return findings, nil
+}
+
+// containsString reports whether values contains value
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
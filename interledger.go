@@ -0,0 +1,292 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	externalNetworkObjectType   = "externalnetwork"
+	externalReferenceObjectType = "externalref"
+	externalAssetProductIndex   = "network~asset~product"
+)
+
+// Partner network types
+const (
+	ExternalNetworkSigned     = "signed"
+	ExternalNetworkUnverified = "unverified"
+)
+
+// ExternalNetwork is a partner ledger products can be cross-referenced to.
+// Type selects how references to it are verified; PublicKey is the PEM key
+// of a signed network.
+type ExternalNetwork struct {
+	ID           string `json:"id"`
+	Type         string `json:"type"`
+	Name         string `json:"name"`
+	PublicKey    string `json:"public_key"`
+	RegisteredBy string `json:"registered_by"`
+	CreatedAt    string `json:"created_at"`
+}
+
+// ExternalReference links a product to the asset tracking the same item on
+// a partner network. Proof is the evidence the network's verifier checked;
+// Verified is false for networks whose references cannot be verified.
+type ExternalReference struct {
+	ProductID string `json:"product_id"`
+	NetworkID string `json:"network_id"`
+	AssetID   string `json:"asset_id"`
+	Proof     string `json:"proof"`
+	Verified  bool   `json:"verified"`
+	AddedBy   string `json:"added_by"`
+	CreatedAt string `json:"created_at"`
+}
+
+// externalVerifier checks the proof of a reference to one type of network.
+// A network type is supported by adding its verifier to externalVerifiers.
+type externalVerifier interface {
+	// validateNetwork checks a network's registration
+	validateNetwork(network *ExternalNetwork) error
+	// verify checks a reference's proof and reports whether it could be
+	// verified at all
+	verify(network *ExternalNetwork, ref *ExternalReference) (bool, error)
+}
+
+var externalVerifiers = map[string]externalVerifier{
+	ExternalNetworkSigned:     signedNetworkVerifier{},
+	ExternalNetworkUnverified: unverifiedNetworkVerifier{},
+}
+
+// RegisterExternalNetwork registers a partner network. A signed network
+// needs the PEM key it attests references with.
+func (s *SupplyChainContract) RegisterExternalNetwork(ctx contractapi.TransactionContextInterface, id, networkType, name, publicKey string) error {
+	if err := s.requireRole(ctx, RoleAdmin); err != nil {
+		return err
+	}
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	if id == "" {
+		return newError(ErrInvalidArgument, "network ID must not be empty")
+	}
+	verifier, ok := externalVerifiers[networkType]
+	if !ok {
+		return newError(ErrInvalidArgument, "unknown network type %s", networkType)
+	}
+
+	key, err := s.makeKey(ctx, externalNetworkObjectType, id)
+	if err != nil {
+		return err
+	}
+	var existing ExternalNetwork
+	exists, err := s.getState(ctx, key, &existing)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return newError(ErrAlreadyExists, "network %s is already registered", id)
+	}
+	clientID, err := s.getClientID(ctx)
+	if err != nil {
+		return err
+	}
+
+	network := ExternalNetwork{
+		ID:           id,
+		Type:         networkType,
+		Name:         name,
+		PublicKey:    publicKey,
+		RegisteredBy: clientID,
+		CreatedAt:    curTime,
+	}
+	if err := verifier.validateNetwork(&network); err != nil {
+		return err
+	}
+	return s.putState(ctx, key, &network)
+}
+
+// GetExternalNetwork returns a registered partner network
+func (s *SupplyChainContract) GetExternalNetwork(ctx contractapi.TransactionContextInterface, id string) (*ExternalNetwork, error) {
+	key, err := s.makeKey(ctx, externalNetworkObjectType, id)
+	if err != nil {
+		return nil, err
+	}
+	var network ExternalNetwork
+	exists, err := s.getState(ctx, key, &network)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, newError(ErrNotFound, "network %s is not registered", id)
+	}
+	return &network, nil
+}
+
+// AddExternalReference links a product to an asset on a partner network,
+// after the network's verifier has checked proof. Only the owning
+// organization or an admin can add references.
+func (s *SupplyChainContract) AddExternalReference(ctx contractapi.TransactionContextInterface, productID, networkID, assetID, proof string) (*ExternalReference, error) {
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+	product, err := s.QueryProduct(ctx, productID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.requireProductOwner(ctx, product, "add external references to"); err != nil {
+		return nil, err
+	}
+	network, err := s.GetExternalNetwork(ctx, networkID)
+	if err != nil {
+		return nil, err
+	}
+	if assetID == "" {
+		return nil, newError(ErrInvalidArgument, "asset ID must not be empty")
+	}
+
+	key, err := s.makeKey(ctx, externalReferenceObjectType, productID, networkID, assetID)
+	if err != nil {
+		return nil, err
+	}
+	var existing ExternalReference
+	exists, err := s.getState(ctx, key, &existing)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return nil, newError(ErrAlreadyExists, "product %s already references %s on %s", productID, assetID, networkID)
+	}
+	clientID, err := s.getClientID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ref := ExternalReference{
+		ProductID: productID,
+		NetworkID: networkID,
+		AssetID:   assetID,
+		Proof:     proof,
+		AddedBy:   clientID,
+		CreatedAt: curTime,
+	}
+	if ref.Verified, err = externalVerifiers[network.Type].verify(network, &ref); err != nil {
+		return nil, err
+	}
+
+	if err := s.putState(ctx, key, &ref); err != nil {
+		return nil, err
+	}
+	if err := s.putIndexKey(ctx, externalAssetProductIndex, networkID, assetID, productID); err != nil {
+		return nil, err
+	}
+	return &ref, nil
+}
+
+// RemoveExternalReference removes a product's link to a partner asset
+func (s *SupplyChainContract) RemoveExternalReference(ctx contractapi.TransactionContextInterface, productID, networkID, assetID string) error {
+	product, err := s.QueryProduct(ctx, productID)
+	if err != nil {
+		return err
+	}
+	if err := s.requireProductOwner(ctx, product, "remove external references from"); err != nil {
+		return err
+	}
+	key, err := s.makeKey(ctx, externalReferenceObjectType, productID, networkID, assetID)
+	if err != nil {
+		return err
+	}
+	var ref ExternalReference
+	exists, err := s.getState(ctx, key, &ref)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return newError(ErrNotFound, "product %s does not reference %s on %s", productID, assetID, networkID)
+	}
+
+	if err := ctx.GetStub().DelState(key); err != nil {
+		return wrapError(err, "failed to delete from world state")
+	}
+	return s.delIndexKey(ctx, externalAssetProductIndex, networkID, assetID, productID)
+}
+
+// GetExternalReferences returns a product's links to partner networks
+func (s *SupplyChainContract) GetExternalReferences(ctx contractapi.TransactionContextInterface, productID string) ([]*ExternalReference, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(externalReferenceObjectType, []string{productID})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	refs := []*ExternalReference{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		var ref ExternalReference
+		if err := json.Unmarshal(queryResponse.Value, &ref); err != nil {
+			return nil, err
+		}
+		refs = append(refs, &ref)
+	}
+	return refs, nil
+}
+
+// GetProductsByExternalAsset returns the products linked to an asset on a
+// partner network
+func (s *SupplyChainContract) GetProductsByExternalAsset(ctx contractapi.TransactionContextInterface, networkID, assetID string) ([]*Product, error) {
+	productIDs, err := s.getIndexedIDs(ctx, externalAssetProductIndex, networkID, assetID)
+	if err != nil {
+		return nil, err
+	}
+	products := []*Product{}
+	for _, productID := range productIDs {
+		product, err := s.QueryProduct(ctx, productID)
+		if err != nil {
+			return nil, err
+		}
+		products = append(products, product)
+	}
+	return products, nil
+}
+
+// signedNetworkVerifier verifies references a partner network attests by
+// signing networkID|assetID|productID with its registered key
+type signedNetworkVerifier struct{}
+
+func (signedNetworkVerifier) validateNetwork(network *ExternalNetwork) error {
+	return validatePublicKey(network.PublicKey)
+}
+
+func (signedNetworkVerifier) verify(network *ExternalNetwork, ref *ExternalReference) (bool, error) {
+	sig, err := base64.StdEncoding.DecodeString(ref.Proof)
+	if err != nil || len(sig) == 0 {
+		return false, newError(ErrInvalidArgument, "the proof for network %s must be a base64 signature", network.ID)
+	}
+	message := network.ID + "|" + ref.AssetID + "|" + ref.ProductID
+	valid, err := verifySignature(network.PublicKey, []byte(message), sig)
+	if err != nil {
+		return false, wrapError(err, "network %s", network.ID)
+	}
+	if !valid {
+		return false, newError(ErrForbidden, "the proof does not match the key of network %s", network.ID)
+	}
+	return true, nil
+}
+
+// unverifiedNetworkVerifier accepts references to networks that offer no
+// proof; the proof blob is kept as supplied
+type unverifiedNetworkVerifier struct{}
+
+func (unverifiedNetworkVerifier) validateNetwork(network *ExternalNetwork) error {
+	return nil
+}
+
+func (unverifiedNetworkVerifier) verify(network *ExternalNetwork, ref *ExternalReference) (bool, error) {
+	return false, nil
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	invoiceObjectType      = "invoice"
+	invoicePayerIndexName  = "invoice~payer"
+	invoiceIssuerIndexName = "invoice~issuer"
+)
+
+// Invoice statuses
+const (
+	InvoiceOpen = "Open"
+	InvoicePaid = "Paid"
+)
+
+// Payment states derived from the due date
+const (
+	PaymentDue     = "Due"
+	PaymentOverdue = "Overdue"
+	PaymentPaid    = "Paid"
+)
+
+// Payment terms are "net-N": payable within N days of issue
+const (
+	defaultPaymentTerms = "net-30"
+	maxPaymentTermDays  = 365
+)
+
+// Invoice is an amount owed by Payer to Issuer. Reference points at what
+// the invoice is for, e.g. a transfer receipt.
+type Invoice struct {
+	ID          string  `json:"id"`
+	Issuer      string  `json:"issuer"`
+	Payer       string  `json:"payer"`
+	Amount      float64 `json:"amount"`
+	Description string  `json:"description"`
+	Reference   string  `json:"reference"`
+	// Terms are the payment terms the due date follows from, e.g. net-30
+	Terms        string `json:"terms"`
+	DueDate      string `json:"due_date"`
+	Status       string `json:"status"`
+	DunningLevel string `json:"dunning_level"`
+	// PaymentState and DaysOverdue are derived from the transaction time
+	// whenever the invoice is read; they are not kept on the ledger
+	PaymentState string `json:"payment_state"`
+	DaysOverdue  int    `json:"days_overdue"`
+	PaidAt       string `json:"paid_at"`
+	CreatedAt    string `json:"created_at"`
+	UpdatedAt    string `json:"updated_at"`
+}
+
+// QueryInvoice retrieves a single invoice from the ledger by ID, with its
+// payment state as of the transaction time
+func (s *SupplyChainContract) QueryInvoice(ctx contractapi.TransactionContextInterface, id string) (*Invoice, error) {
+	invoice, err := s.getInvoice(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	now, err := s.getTxTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := setPaymentState(invoice, now); err != nil {
+		return nil, err
+	}
+	return invoice, nil
+}
+
+// IssueInvoice issues an invoice from the calling organization to a payer
+// on the default net-30 terms. reference is optional, e.g. the purchase
+// order being billed.
+func (s *SupplyChainContract) IssueInvoice(ctx contractapi.TransactionContextInterface, id, payer string, amount float64, description, reference string) error {
+	return s.IssueInvoiceWithTerms(ctx, id, payer, amount, description, reference, defaultPaymentTerms)
+}
+
+// IssueInvoiceWithTerms is IssueInvoice with explicit payment terms, e.g. net-60
+func (s *SupplyChainContract) IssueInvoiceWithTerms(ctx contractapi.TransactionContextInterface, id, payer string, amount float64, description, reference, terms string) error {
+	if id == "" || payer == "" {
+		return newError(ErrInvalidArgument, "invoice ID and payer must not be empty")
+	}
+	issuer, err := s.getClientMSPID(ctx)
+	if err != nil {
+		return err
+	}
+
+	key, err := s.makeKey(ctx, invoiceObjectType, id)
+	if err != nil {
+		return err
+	}
+	var existing Invoice
+	exists, err := s.getState(ctx, key, &existing)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return newError(ErrAlreadyExists, "invoice with ID %s already exists", id)
+	}
+
+	_, err = s.createInvoice(ctx, id, issuer, payer, amount, description, reference, terms)
+	return err
+}
+
+// GetInvoicesByPayer returns the invoices a party has to pay, oldest first
+func (s *SupplyChainContract) GetInvoicesByPayer(ctx contractapi.TransactionContextInterface, payer string) ([]*Invoice, error) {
+	return s.getInvoices(ctx, invoicePayerIndexName, payer)
+}
+
+// GetInvoicesByIssuer returns the invoices a party has issued, oldest first
+func (s *SupplyChainContract) GetInvoicesByIssuer(ctx contractapi.TransactionContextInterface, issuer string) ([]*Invoice, error) {
+	return s.getInvoices(ctx, invoiceIssuerIndexName, issuer)
+}
+
+// MarkInvoicePaid records that an open invoice has been paid. Only the
+// issuing organization or an admin may do so.
+func (s *SupplyChainContract) MarkInvoicePaid(ctx contractapi.TransactionContextInterface, id string) error {
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	invoice, err := s.getInvoice(ctx, id)
+	if err != nil {
+		return err
+	}
+	mspID, err := s.getClientMSPID(ctx)
+	if err != nil {
+		return err
+	}
+	isAdmin, err := s.hasRole(ctx, RoleAdmin)
+	if err != nil {
+		return err
+	}
+	if mspID != invoice.Issuer && !isAdmin {
+		return newError(ErrForbidden, "only the issuer %s can mark invoice %s paid", invoice.Issuer, id)
+	}
+	if invoice.Status != InvoiceOpen {
+		return newError(ErrConflict, "invoice %s is already %s", id, invoice.Status)
+	}
+
+	invoice.Status = InvoicePaid
+	invoice.PaidAt = curTime
+	invoice.UpdatedAt = curTime
+	return s.putInvoice(ctx, invoice)
+}
+
+// createInvoice writes a new open invoice and indexes it under both parties
+func (s *SupplyChainContract) createInvoice(ctx contractapi.TransactionContextInterface, id, issuer, payer string, amount float64, description, reference, terms string) (*Invoice, error) {
+	now, err := s.getTxTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+	curTime := now.Format(time.RFC3339)
+	if amount <= 0 {
+		return nil, newError(ErrInvalidArgument, "invoice amount must be positive")
+	}
+	days, err := paymentTermDays(terms)
+	if err != nil {
+		return nil, err
+	}
+
+	invoice := Invoice{
+		ID:          id,
+		Issuer:      issuer,
+		Payer:       payer,
+		Amount:      amount,
+		Description: description,
+		Reference:   reference,
+		Terms:       terms,
+		DueDate:     now.AddDate(0, 0, days).Format(time.RFC3339),
+		Status:      InvoiceOpen,
+		CreatedAt:   curTime,
+		UpdatedAt:   curTime,
+	}
+	if err := s.putInvoice(ctx, &invoice); err != nil {
+		return nil, err
+	}
+	if err := s.putIndexKey(ctx, invoicePayerIndexName, payer, id); err != nil {
+		return nil, err
+	}
+	if err := s.putIndexKey(ctx, invoiceIssuerIndexName, issuer, id); err != nil {
+		return nil, err
+	}
+	return &invoice, nil
+}
+
+// getInvoice reads an invoice as stored, without its payment state
+func (s *SupplyChainContract) getInvoice(ctx contractapi.TransactionContextInterface, id string) (*Invoice, error) {
+	key, err := s.makeKey(ctx, invoiceObjectType, id)
+	if err != nil {
+		return nil, err
+	}
+
+	var invoice Invoice
+	exists, err := s.getState(ctx, key, &invoice)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, newError(ErrNotFound, "invoice with ID %s does not exist", id)
+	}
+
+	return &invoice, nil
+}
+
+// putInvoice writes an invoice under its ID, leaving out the derived payment state
+func (s *SupplyChainContract) putInvoice(ctx contractapi.TransactionContextInterface, invoice *Invoice) error {
+	key, err := s.makeKey(ctx, invoiceObjectType, invoice.ID)
+	if err != nil {
+		return err
+	}
+	stored := *invoice
+	stored.PaymentState = ""
+	stored.DaysOverdue = 0
+	return s.putState(ctx, key, &stored)
+}
+
+// getInvoices resolves the invoice IDs indexed under a party
+func (s *SupplyChainContract) getInvoices(ctx contractapi.TransactionContextInterface, indexName, party string) ([]*Invoice, error) {
+	invoiceIDs, err := s.getIndexedIDs(ctx, indexName, party)
+	if err != nil {
+		return nil, err
+	}
+	now, err := s.getTxTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	invoices := []*Invoice{}
+	for _, invoiceID := range invoiceIDs {
+		invoice, err := s.getInvoice(ctx, invoiceID)
+		if err != nil {
+			return nil, err
+		}
+		if err := setPaymentState(invoice, now); err != nil {
+			return nil, err
+		}
+		invoices = append(invoices, invoice)
+	}
+
+	// Keys are ordered by invoice ID, not time
+	sort.SliceStable(invoices, func(i, j int) bool {
+		return invoices[i].CreatedAt < invoices[j].CreatedAt
+	})
+	return invoices, nil
+}
+
+// setPaymentState derives whether an invoice is paid, due or overdue at now
+func setPaymentState(invoice *Invoice, now time.Time) error {
+	invoice.DaysOverdue = 0
+	if invoice.Status == InvoicePaid {
+		invoice.PaymentState = PaymentPaid
+		return nil
+	}
+	dueDate, err := time.Parse(time.RFC3339, invoice.DueDate)
+	if err != nil {
+		return wrapError(err, "invoice %s has an invalid due date", invoice.ID)
+	}
+	invoice.PaymentState = PaymentDue
+	if now.After(dueDate) {
+		invoice.PaymentState = PaymentOverdue
+		invoice.DaysOverdue = int(math.Ceil(now.Sub(dueDate).Hours() / 24))
+	}
+	return nil
+}
+
+// paymentTermDays parses "net-N" payment terms into N days
+func paymentTermDays(terms string) (int, error) {
+	days, err := strconv.Atoi(strings.TrimPrefix(terms, "net-"))
+	if !strings.HasPrefix(terms, "net-") || err != nil || days < 0 || days > maxPaymentTermDays {
+		return 0, newError(ErrInvalidArgument, "payment terms must be net-N with N between 0 and %d, got %q", maxPaymentTermDays, terms)
+	}
+	return days, nil
+}
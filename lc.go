@@ -0,0 +1,270 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const letterOfCreditObjectType = "lc"
+
+// Letter of credit statuses
+const (
+	LCIssued     = "Issued"
+	LCDiscrepant = "Discrepant"
+	LCPayable    = "Payable"
+	LCPaid       = "Paid"
+)
+
+// LetterOfCredit is a bank's undertaking to pay the seller of an order once
+// the ledger shows the required facts: every shipment of the order
+// received, every required document attached and, when RequireInspection
+// is set, the latest inspection of every shipment passed.
+type LetterOfCredit struct {
+	ID                string   `json:"id"`
+	OrderID           string   `json:"order_id"`
+	IssuingBank       string   `json:"issuing_bank"`
+	Applicant         string   `json:"applicant"`
+	Beneficiary       string   `json:"beneficiary"`
+	Amount            float64  `json:"amount"`
+	ExpiresAt         string   `json:"expires_at"`
+	RequiredDocuments []string `json:"required_documents"`
+	RequireInspection bool     `json:"require_inspection"`
+	Status            string   `json:"status"`
+	Discrepancies     []string `json:"discrepancies"`
+	DisputeID         string   `json:"dispute_id"`
+	PresentedAt       string   `json:"presented_at"`
+	PaidAt            string   `json:"paid_at"`
+	CreatedAt         string   `json:"created_at"`
+	UpdatedAt         string   `json:"updated_at"`
+}
+
+// IssueLetterOfCredit records a letter of credit against an accepted order.
+// Only identities with the bank role may issue one; their organization is
+// the issuing bank. expiresAt is RFC3339.
+func (s *SupplyChainContract) IssueLetterOfCredit(ctx contractapi.TransactionContextInterface, id, orderID string, amount float64, expiresAt string, requiredDocuments []string, requireInspection bool) error {
+	if err := s.requireRole(ctx, RoleBank); err != nil {
+		return err
+	}
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	if id == "" {
+		return newError(ErrInvalidArgument, "letter of credit ID must not be empty")
+	}
+	if amount <= 0 {
+		return newError(ErrInvalidArgument, "letter of credit amount must be positive")
+	}
+	expires, err := time.Parse(time.RFC3339, expiresAt)
+	if err != nil {
+		return newError(ErrInvalidArgument, "expires_at must be an RFC3339 timestamp: %v", err)
+	}
+	expiresAt = expires.UTC().Format(time.RFC3339)
+	if expiresAt <= curTime {
+		return newError(ErrInvalidArgument, "letter of credit would already have expired at %s", expiresAt)
+	}
+	order, err := s.QueryPurchaseOrder(ctx, orderID)
+	if err != nil {
+		return err
+	}
+	if order.Status != OrderAccepted {
+		return newError(ErrConflict, "order %s is %s; letters of credit are issued against accepted orders", orderID, order.Status)
+	}
+
+	key, err := s.makeKey(ctx, letterOfCreditObjectType, id)
+	if err != nil {
+		return err
+	}
+	var existing LetterOfCredit
+	exists, err := s.getState(ctx, key, &existing)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return newError(ErrAlreadyExists, "letter of credit with ID %s already exists", id)
+	}
+	bank, err := s.getClientMSPID(ctx)
+	if err != nil {
+		return err
+	}
+
+	lc := LetterOfCredit{
+		ID:                id,
+		OrderID:           orderID,
+		IssuingBank:       bank,
+		Applicant:         order.Buyer,
+		Beneficiary:       order.Seller,
+		Amount:            amount,
+		ExpiresAt:         expiresAt,
+		RequiredDocuments: mergeIDs(requiredDocuments, nil),
+		RequireInspection: requireInspection,
+		Status:            LCIssued,
+		Discrepancies:     []string{},
+		CreatedAt:         curTime,
+		UpdatedAt:         curTime,
+	}
+	return s.putState(ctx, key, &lc)
+}
+
+// QueryLetterOfCredit retrieves a single letter of credit from the ledger by ID
+func (s *SupplyChainContract) QueryLetterOfCredit(ctx contractapi.TransactionContextInterface, id string) (*LetterOfCredit, error) {
+	key, err := s.makeKey(ctx, letterOfCreditObjectType, id)
+	if err != nil {
+		return nil, err
+	}
+
+	var lc LetterOfCredit
+	exists, err := s.getState(ctx, key, &lc)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, newError(ErrNotFound, "letter of credit with ID %s does not exist", id)
+	}
+
+	return &lc, nil
+}
+
+// PresentLetterOfCredit is called by the beneficiary to claim payment. The
+// required facts are checked against the ledger: if they all hold the
+// letter becomes Payable, otherwise it is marked Discrepant and a dispute
+// case listing the discrepancies is opened. A discrepant letter may be
+// presented again once the facts are in place.
+func (s *SupplyChainContract) PresentLetterOfCredit(ctx contractapi.TransactionContextInterface, id string) (*LetterOfCredit, error) {
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	lc, err := s.QueryLetterOfCredit(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	mspID, err := s.getClientMSPID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if mspID != lc.Beneficiary {
+		return nil, newError(ErrForbidden, "only the beneficiary %s can present letter of credit %s", lc.Beneficiary, id)
+	}
+	if lc.Status != LCIssued && lc.Status != LCDiscrepant {
+		return nil, newError(ErrConflict, "letter of credit %s is already %s", id, lc.Status)
+	}
+	if curTime >= lc.ExpiresAt {
+		return nil, newError(ErrConflict, "letter of credit %s expired at %s", id, lc.ExpiresAt)
+	}
+
+	discrepancies, err := s.lcDiscrepancies(ctx, lc)
+	if err != nil {
+		return nil, err
+	}
+
+	lc.Discrepancies = discrepancies
+	lc.PresentedAt = curTime
+	lc.UpdatedAt = curTime
+	if len(discrepancies) == 0 {
+		lc.Status = LCPayable
+	} else {
+		lc.Status = LCDiscrepant
+		dispute, err := s.openDispute(ctx, DisputeLCDiscrepancy, "", lc.ID, lc.Beneficiary, "", strings.Join(discrepancies, "; "))
+		if err != nil {
+			return nil, err
+		}
+		lc.DisputeID = dispute.ID
+	}
+
+	if err := s.putLetterOfCredit(ctx, lc); err != nil {
+		return nil, err
+	}
+	return lc, nil
+}
+
+// SettleLetterOfCredit records that the issuing bank has paid a payable letter of credit
+func (s *SupplyChainContract) SettleLetterOfCredit(ctx contractapi.TransactionContextInterface, id string) error {
+	if err := s.requireRole(ctx, RoleBank); err != nil {
+		return err
+	}
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	lc, err := s.QueryLetterOfCredit(ctx, id)
+	if err != nil {
+		return err
+	}
+	mspID, err := s.getClientMSPID(ctx)
+	if err != nil {
+		return err
+	}
+	if mspID != lc.IssuingBank {
+		return newError(ErrForbidden, "only the issuing bank %s can settle letter of credit %s", lc.IssuingBank, id)
+	}
+	if lc.Status != LCPayable {
+		return newError(ErrConflict, "letter of credit %s is %s, not payable", id, lc.Status)
+	}
+
+	lc.Status = LCPaid
+	lc.PaidAt = curTime
+	lc.UpdatedAt = curTime
+	return s.putLetterOfCredit(ctx, lc)
+}
+
+// lcDiscrepancies lists every required fact the ledger does not yet show
+func (s *SupplyChainContract) lcDiscrepancies(ctx contractapi.TransactionContextInterface, lc *LetterOfCredit) ([]string, error) {
+	discrepancies := []string{}
+
+	shipments, err := s.GetOrderShipments(ctx, lc.OrderID)
+	if err != nil {
+		return nil, err
+	}
+	if len(shipments) == 0 {
+		discrepancies = append(discrepancies, fmt.Sprintf("order %s has no shipments", lc.OrderID))
+	}
+	for _, shipment := range shipments {
+		if shipment.Status != ShipmentReceived {
+			discrepancies = append(discrepancies, fmt.Sprintf("shipment %s is %s, not received", shipment.ID, shipment.Status))
+		}
+		if !lc.RequireInspection {
+			continue
+		}
+		inspections, err := s.GetShipmentInspections(ctx, shipment.ID)
+		if err != nil {
+			return nil, err
+		}
+		if len(inspections) == 0 {
+			discrepancies = append(discrepancies, fmt.Sprintf("shipment %s has not been inspected", shipment.ID))
+		} else if latest := inspections[len(inspections)-1]; latest.Result != InspectionPassed {
+			discrepancies = append(discrepancies, fmt.Sprintf("shipment %s failed inspection %s", shipment.ID, latest.ID))
+		}
+	}
+
+	documents, err := s.GetOrderDocuments(ctx, lc.OrderID)
+	if err != nil {
+		return nil, err
+	}
+	attached := make(map[string]bool)
+	for _, document := range documents {
+		attached[document.Type] = true
+	}
+	for _, docType := range lc.RequiredDocuments {
+		if !attached[docType] {
+			discrepancies = append(discrepancies, fmt.Sprintf("document %s is missing", docType))
+		}
+	}
+
+	return discrepancies, nil
+}
+
+// putLetterOfCredit writes a letter of credit under its ID
+func (s *SupplyChainContract) putLetterOfCredit(ctx contractapi.TransactionContextInterface, lc *LetterOfCredit) error {
+	key, err := s.makeKey(ctx, letterOfCreditObjectType, lc.ID)
+	if err != nil {
+		return err
+	}
+	return s.putState(ctx, key, lc)
+}
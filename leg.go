@@ -0,0 +1,289 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	shipmentLegObjectType = "leg"
+
+	// maxShipmentLegs bounds the number of legs of one journey
+	maxShipmentLegs = 20
+)
+
+// Leg statuses
+const (
+	LegPending   = "Pending"
+	LegInTransit = "InTransit"
+	LegDelivered = "Delivered"
+)
+
+// ShipmentLeg is one carrier's part of a shipment's journey. CarrierMSP is
+// the organization responsible for the leg; it confirms both picking the
+// goods up and dropping them off, so the handoff between two legs carries
+// the confirmation of both carriers.
+type ShipmentLeg struct {
+	ShipmentID  string `json:"shipment_id"`
+	Sequence    int    `json:"sequence"`
+	CarrierMSP  string `json:"carrier_msp"`
+	Mode        string `json:"mode"`
+	From        string `json:"from"`
+	To          string `json:"to"`
+	SLADueAt    string `json:"sla_due_at"`
+	Status      string `json:"status"`
+	PickedUpAt  string `json:"picked_up_at"`
+	DeliveredAt string `json:"delivered_at"`
+	SLAMet      bool   `json:"sla_met"`
+	// The readings attested at pickup and dropoff, required for cold-chain
+	// shipments
+	PickupReading  *TemperatureAttestation `json:"pickup_reading,omitempty" metadata:",optional"`
+	DropoffReading *TemperatureAttestation `json:"dropoff_reading,omitempty" metadata:",optional"`
+	UpdatedAt      string                  `json:"updated_at"`
+}
+
+// AddShipmentLeg appends a leg to a shipment's journey. slaDueAt (RFC3339)
+// is when the leg's carrier must have delivered. Legs can only be added by
+// the shipper or an admin, while the journey has not started.
+func (s *SupplyChainContract) AddShipmentLeg(ctx contractapi.TransactionContextInterface, shipmentID, carrierMSP, mode, from, to, slaDueAt string) (*ShipmentLeg, error) {
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if carrierMSP == "" {
+		return nil, newError(ErrInvalidArgument, "carrier MSP ID must not be empty")
+	}
+	due, err := time.Parse(time.RFC3339, slaDueAt)
+	if err != nil {
+		return nil, newError(ErrInvalidArgument, "SLA due time must be an RFC3339 timestamp: %v", err)
+	}
+	shipment, err := s.QueryShipment(ctx, shipmentID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.requireShipper(ctx, shipment, "add legs to"); err != nil {
+		return nil, err
+	}
+
+	legs, err := s.GetShipmentLegs(ctx, shipmentID)
+	if err != nil {
+		return nil, err
+	}
+	if len(legs) >= maxShipmentLegs {
+		return nil, newError(ErrConflict, "a shipment can have at most %d legs", maxShipmentLegs)
+	}
+	if len(legs) > 0 {
+		if legs[0].Status != LegPending {
+			return nil, newError(ErrConflict, "the journey of shipment %s has already started", shipmentID)
+		}
+		if last := legs[len(legs)-1]; last.To != from {
+			return nil, newError(ErrInvalidArgument, "leg must start where the previous leg ends (%s)", last.To)
+		}
+	}
+
+	leg := ShipmentLeg{
+		ShipmentID: shipmentID,
+		Sequence:   len(legs) + 1,
+		CarrierMSP: carrierMSP,
+		Mode:       mode,
+		From:       from,
+		To:         to,
+		SLADueAt:   due.UTC().Format(time.RFC3339),
+		Status:     LegPending,
+		UpdatedAt:  curTime,
+	}
+	if err := s.putShipmentLeg(ctx, &leg); err != nil {
+		return nil, err
+	}
+
+	return &leg, nil
+}
+
+// ConfirmLegPickup is called by a leg's carrier when it takes over the
+// goods. Every earlier leg must have been delivered. Cold-chain shipments
+// need ConfirmLegPickupWithReading instead.
+func (s *SupplyChainContract) ConfirmLegPickup(ctx contractapi.TransactionContextInterface, shipmentID string, sequence int) error {
+	return s.confirmLegPickup(ctx, shipmentID, sequence, nil)
+}
+
+func (s *SupplyChainContract) confirmLegPickup(ctx contractapi.TransactionContextInterface, shipmentID string, sequence int, reading *handoffReading) error {
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := s.requireABACRole(ctx, RoleCarrier, "pick up shipment legs"); err != nil {
+		return err
+	}
+	leg, err := s.queryCarrierLeg(ctx, shipmentID, sequence)
+	if err != nil {
+		return err
+	}
+	if leg.Status != LegPending {
+		return newError(ErrConflict, "leg %d of shipment %s is %s, not %s", sequence, shipmentID, leg.Status, LegPending)
+	}
+	if sequence > 1 {
+		previous, err := s.QueryShipmentLeg(ctx, shipmentID, sequence-1)
+		if err != nil {
+			return err
+		}
+		if previous.Status != LegDelivered {
+			return newError(ErrConflict, "leg %d of shipment %s has not been delivered yet", sequence-1, shipmentID)
+		}
+	}
+	attestation, err := s.attestHandoff(ctx, shipmentID, reading)
+	if err != nil {
+		return err
+	}
+
+	leg.Status = LegInTransit
+	leg.PickupReading = attestation
+	leg.PickedUpAt = curTime
+	leg.UpdatedAt = curTime
+	return s.putShipmentLeg(ctx, leg)
+}
+
+// ConfirmLegDropoff is called by a leg's carrier when it hands the goods
+// over at the end of the leg. The leg's SLA is met if this happens by its
+// due time. Cold-chain shipments need ConfirmLegDropoffWithReading instead.
+func (s *SupplyChainContract) ConfirmLegDropoff(ctx contractapi.TransactionContextInterface, shipmentID string, sequence int) error {
+	return s.confirmLegDropoff(ctx, shipmentID, sequence, nil)
+}
+
+func (s *SupplyChainContract) confirmLegDropoff(ctx contractapi.TransactionContextInterface, shipmentID string, sequence int, reading *handoffReading) error {
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := s.requireABACRole(ctx, RoleCarrier, "drop off shipment legs"); err != nil {
+		return err
+	}
+	leg, err := s.queryCarrierLeg(ctx, shipmentID, sequence)
+	if err != nil {
+		return err
+	}
+	if leg.Status != LegInTransit {
+		return newError(ErrConflict, "leg %d of shipment %s is %s, not %s", sequence, shipmentID, leg.Status, LegInTransit)
+	}
+	attestation, err := s.attestHandoff(ctx, shipmentID, reading)
+	if err != nil {
+		return err
+	}
+
+	leg.Status = LegDelivered
+	leg.DropoffReading = attestation
+	leg.DeliveredAt = curTime
+	leg.SLAMet = curTime <= leg.SLADueAt
+	leg.UpdatedAt = curTime
+	return s.putShipmentLeg(ctx, leg)
+}
+
+// QueryShipmentLeg retrieves one leg of a shipment
+func (s *SupplyChainContract) QueryShipmentLeg(ctx contractapi.TransactionContextInterface, shipmentID string, sequence int) (*ShipmentLeg, error) {
+	key, err := s.makeKey(ctx, shipmentLegObjectType, shipmentID, legSequenceKey(sequence))
+	if err != nil {
+		return nil, err
+	}
+
+	var leg ShipmentLeg
+	exists, err := s.getState(ctx, key, &leg)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, newError(ErrNotFound, "shipment with ID %s has no leg %d", shipmentID, sequence)
+	}
+
+	return &leg, nil
+}
+
+// GetShipmentLegs returns the legs of a shipment in journey order
+func (s *SupplyChainContract) GetShipmentLegs(ctx contractapi.TransactionContextInterface, shipmentID string) ([]*ShipmentLeg, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(shipmentLegObjectType, []string{shipmentID})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var legs []*ShipmentLeg
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var leg ShipmentLeg
+		if err := json.Unmarshal(queryResponse.Value, &leg); err != nil {
+			return nil, err
+		}
+		legs = append(legs, &leg)
+	}
+
+	return legs, nil
+}
+
+// queryCarrierLeg returns a leg after checking that the caller is its carrier
+func (s *SupplyChainContract) queryCarrierLeg(ctx contractapi.TransactionContextInterface, shipmentID string, sequence int) (*ShipmentLeg, error) {
+	leg, err := s.QueryShipmentLeg(ctx, shipmentID, sequence)
+	if err != nil {
+		return nil, err
+	}
+	mspID, err := s.getClientMSPID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if mspID != leg.CarrierMSP {
+		return nil, newError(ErrForbidden, "only the carrier %s can confirm leg %d of shipment %s", leg.CarrierMSP, sequence, shipmentID)
+	}
+	return leg, nil
+}
+
+// requireCarrier returns an error unless the caller's organization is the
+// shipment's carrier or carries one of its legs in legStatus (in any status
+// when it is empty), or the caller is an admin. action names the refused
+// operation in the error.
+func (s *SupplyChainContract) requireCarrier(ctx contractapi.TransactionContextInterface, shipment *Shipment, legStatus, action string) error {
+	mspID, err := s.getClientMSPID(ctx)
+	if err != nil {
+		return err
+	}
+	if mspID == shipment.Carrier {
+		return nil
+	}
+	legs, err := s.GetShipmentLegs(ctx, shipment.ID)
+	if err != nil {
+		return err
+	}
+	for _, leg := range legs {
+		if leg.CarrierMSP == mspID && (legStatus == "" || leg.Status == legStatus) {
+			return nil
+		}
+	}
+	isAdmin, err := s.hasRole(ctx, RoleAdmin)
+	if err != nil {
+		return err
+	}
+	if !isAdmin {
+		return newError(ErrForbidden, "only a carrier of shipment %s can %s it", shipment.ID, action)
+	}
+	return nil
+}
+
+// putShipmentLeg is a helper method for inserting or updating a leg in the ledger
+func (s *SupplyChainContract) putShipmentLeg(ctx contractapi.TransactionContextInterface, leg *ShipmentLeg) error {
+	key, err := s.makeKey(ctx, shipmentLegObjectType, leg.ShipmentID, legSequenceKey(leg.Sequence))
+	if err != nil {
+		return err
+	}
+	return s.putState(ctx, key, leg)
+}
+
+// legSequenceKey zero-pads a leg number so keys sort in journey order
+func legSequenceKey(sequence int) string {
+	return fmt.Sprintf("%03d", sequence)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// FeatureStateMachine turns on enforcement of the product lifecycle state
+// machine below for every status change. It is off until an admin sets it,
+// because existing integrations ship and sell products straight from
+// Manufactured; until then only the final statuses are enforced, and
+// shipping and delivery check the shipment instead. Organizations can be
+// left out with an org override while their integrations still skip
+// lifecycle steps.
+const FeatureStateMachine = "state_machine"
+
+// productStatusTransitions is the product lifecycle state machine: the
+// statuses each status may move forward to. A recall can happen at any point
+// before a product is consumed; Recalled and Consumed are final. Products
+// that have not left their holder can be consumed as transformation inputs.
+// Corrections back to an earlier lifecycle status are downgrades, which are
+// allowed with a reason code instead of being listed here.
+var productStatusTransitions = map[string][]string{
+	"Manufactured":        {"QualityChecked", "Recalled", productStatusConsumed},
+	"QualityChecked":      {"Shipped", "Recalled", productStatusConsumed},
+	"Shipped":             {"InTransit", "Recalled"},
+	"InTransit":           {"Delivered", "Recalled"},
+	"Delivered":           {productStatusSold, "Recalled", productStatusConsumed},
+	productStatusSold:     {"Recalled"},
+	"Recalled":            {},
+	productStatusConsumed: {},
+}
+
+// StatusTransition lists the statuses a product in Status may move to
+type StatusTransition struct {
+	Status string   `json:"status"`
+	Next   []string `json:"next"`
+}
+
+// GetStatusTransitions returns the product lifecycle state machine in
+// lifecycle order, followed by the final statuses. IsFeatureEnabled with
+// FeatureStateMachine tells whether it is enforced for the caller.
+func (s *SupplyChainContract) GetStatusTransitions(ctx contractapi.TransactionContextInterface) ([]StatusTransition, error) {
+	statuses := []string{"Manufactured", "QualityChecked", "Shipped", "InTransit", "Delivered", productStatusSold, "Recalled", productStatusConsumed}
+	transitions := []StatusTransition{}
+	for _, status := range statuses {
+		transitions = append(transitions, StatusTransition{Status: status, Next: append([]string{}, productStatusTransitions[status]...)})
+	}
+	return transitions, nil
+}
+
+// checkStatusTransition returns an error unless a product may move from one
+// status to another. Staying in the same status and downgrades, which the
+// caller guards with a reason code, are not checked. A product whose status
+// predates the state machine may move to any known status to repair it.
+func checkStatusTransition(from, to string) error {
+	if !isValidProductStatus(to) {
+		return newError(ErrInvalidArgument, "unknown product status %s", to)
+	}
+	if from == to || isStatusDowngrade(from, to) {
+		return nil
+	}
+	next, known := productStatusTransitions[from]
+	if !known {
+		return nil
+	}
+	for _, status := range next {
+		if status == to {
+			return nil
+		}
+	}
+	if len(next) == 0 {
+		return newError(ErrConflict, "illegal status transition from %s to %s: %s is a final status", from, to, from)
+	}
+	return newError(ErrConflict, "illegal status transition from %s to %s: %s can only move to %s", from, to, from, strings.Join(next, " or "))
+}
+
+// setProductStatus moves a product to status. Every transaction that
+// changes a product's status goes through it: frozen and archived products
+// are refused, downgrades need the reason the caller has already checked,
+// marking products InTransit needs the carrier role and, while the
+// state_machine feature is on, the move must be a legal transition.
+// Recalled and Consumed products stay final even with the feature off.
+func (s *SupplyChainContract) setProductStatus(ctx contractapi.TransactionContextInterface, product *Product, status string, reason *changeReason) error {
+	if err := s.checkProductStatusChange(ctx, product.Status, status, reason); err != nil {
+		return err
+	}
+	if status == product.Status {
+		return nil
+	}
+	if product.Archived {
+		return newError(ErrConflict, "product %s is archived and must be restored first", product.ID)
+	}
+	if err := checkNotFrozen(product); err != nil {
+		return err
+	}
+	product.Status = status
+	return nil
+}
+
+// checkProductStatusChange returns an error unless a product may move from
+// one status to another, as enforced by setProductStatus. Bulk updates call
+// it once up front for all the products they move.
+func (s *SupplyChainContract) checkProductStatusChange(ctx contractapi.TransactionContextInterface, from, to string, reason *changeReason) error {
+	if !isValidProductStatus(to) {
+		return newError(ErrInvalidArgument, "unknown product status %s", to)
+	}
+	if from == to {
+		return nil
+	}
+	if isStatusDowngrade(from, to) && reason == nil {
+		return newError(ErrConflict, "%s to %s is a downgrade and needs UpdateProductWithReason", from, to)
+	}
+	enabled, err := s.featureEnabled(ctx, FeatureStateMachine)
+	if err != nil {
+		return err
+	}
+	// Final statuses are final even while the feature is off
+	next, known := productStatusTransitions[from]
+	if enabled || (known && len(next) == 0) {
+		if err := checkStatusTransition(from, to); err != nil {
+			return err
+		}
+	}
+	if to == "InTransit" {
+		return s.requireABACRole(ctx, RoleCarrier, "mark products InTransit")
+	}
+	return nil
+}
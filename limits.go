@@ -0,0 +1,125 @@
+package main
+
+import (
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	queryLimitObjectType = "querylimit"
+
+	// maxPageSize caps the page size of every paginated query unless the
+	// caller's organization has an override
+	maxPageSize = 200
+	// maxResultCap is the ceiling for per-organization overrides
+	maxResultCap = 1000
+)
+
+// QueryLimits are the page size and result caps of one organization's
+// clients. MaxPageSize applies to paginated queries and MaxResults to
+// calls that cap how many items they process, like AdvanceStatusByFilter.
+type QueryLimits struct {
+	MSPID       string `json:"msp_id"`
+	MaxPageSize int    `json:"max_page_size"`
+	MaxResults  int    `json:"max_results"`
+	SetBy       string `json:"set_by"`
+	UpdatedAt   string `json:"updated_at"`
+}
+
+// SetOrgQueryLimits overrides the page size and result caps for an
+// organization, up to maxResultCap. Zero restores the default for that
+// limit.
+func (s *SupplyChainContract) SetOrgQueryLimits(ctx contractapi.TransactionContextInterface, mspID string, pageSize, maxResults int) error {
+	if err := s.requireRole(ctx, RoleAdmin); err != nil {
+		return err
+	}
+	if mspID == "" {
+		return newError(ErrInvalidArgument, "MSP ID must not be empty")
+	}
+	for _, limit := range []int{pageSize, maxResults} {
+		if limit < 0 || limit > maxResultCap {
+			return newError(ErrInvalidArgument, "limits must be between 0 and %d", maxResultCap)
+		}
+	}
+
+	key, err := s.makeKey(ctx, queryLimitObjectType, mspID)
+	if err != nil {
+		return err
+	}
+	if pageSize == 0 && maxResults == 0 {
+		if err := ctx.GetStub().DelState(key); err != nil {
+			return wrapError(err, "failed to delete from world state")
+		}
+		return nil
+	}
+
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	clientID, err := s.getClientID(ctx)
+	if err != nil {
+		return err
+	}
+	limits := QueryLimits{
+		MSPID:       mspID,
+		MaxPageSize: pageSize,
+		MaxResults:  maxResults,
+		SetBy:       clientID,
+		UpdatedAt:   curTime,
+	}
+	return s.putState(ctx, key, &limits)
+}
+
+// GetOrgQueryLimits returns the limits in effect for an organization, with
+// defaults filled in where no override is set
+func (s *SupplyChainContract) GetOrgQueryLimits(ctx contractapi.TransactionContextInterface, mspID string) (*QueryLimits, error) {
+	key, err := s.makeKey(ctx, queryLimitObjectType, mspID)
+	if err != nil {
+		return nil, err
+	}
+
+	limits := QueryLimits{MSPID: mspID}
+	if _, err := s.getState(ctx, key, &limits); err != nil {
+		return nil, err
+	}
+	if limits.MaxPageSize == 0 {
+		limits.MaxPageSize = maxPageSize
+	}
+	if limits.MaxResults == 0 {
+		limits.MaxResults = maxPageSize
+	}
+	return &limits, nil
+}
+
+// callerQueryLimits returns the limits of the calling organization
+func (s *SupplyChainContract) callerQueryLimits(ctx contractapi.TransactionContextInterface) (*QueryLimits, error) {
+	mspID, err := s.getClientMSPID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.GetOrgQueryLimits(ctx, mspID)
+}
+
+// checkPageSize validates a client-supplied page size against the caller's limits
+func (s *SupplyChainContract) checkPageSize(ctx contractapi.TransactionContextInterface, pageSize int) error {
+	limits, err := s.callerQueryLimits(ctx)
+	if err != nil {
+		return err
+	}
+	if pageSize < 1 || pageSize > limits.MaxPageSize {
+		return newError(ErrInvalidArgument, "page size must be between 1 and %d", limits.MaxPageSize)
+	}
+	return nil
+}
+
+// checkResultCap validates a client-supplied item cap against the caller's limits
+func (s *SupplyChainContract) checkResultCap(ctx contractapi.TransactionContextInterface, maxItems int) error {
+	limits, err := s.callerQueryLimits(ctx)
+	if err != nil {
+		return err
+	}
+	if maxItems < 1 || maxItems > limits.MaxResults {
+		return newError(ErrInvalidArgument, "max items must be between 1 and %d", limits.MaxResults)
+	}
+	return nil
+}
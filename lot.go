@@ -0,0 +1,249 @@
+package main
+
+import (
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	lotObjectType       = "lot"
+	lotProductIndexName = "lot~product"
+)
+
+// Lot groups products produced together, e.g. in one production run or
+// batch. Raw-material lots record the origins they were sourced from and
+// food lots declare their ingredients and allergens. ExpiryDate starts at
+// the label date and is brought forward by cold-chain excursions.
+// Manufacturer is the organization (MSP ID) that created the lot.
+type Lot struct {
+	ID              string   `json:"id"`
+	Name            string   `json:"name"`
+	Description     string   `json:"description"`
+	Manufacturer    string   `json:"manufacturer"`
+	OriginIDs       []string `json:"origin_ids"`
+	Ingredients     []string `json:"ingredients"`
+	Allergens       []string `json:"allergens"`
+	LabelExpiryDate string   `json:"label_expiry_date"`
+	ExpiryDate      string   `json:"expiry_date"`
+	CreatedAt       string   `json:"created_at"`
+	UpdatedAt       string   `json:"updated_at"`
+}
+
+// CreateLot registers a new lot. With ABAC enabled only manufacturers can
+// create lots.
+func (s *SupplyChainContract) CreateLot(ctx contractapi.TransactionContextInterface, id, name, description string) error {
+	return s.createLot(ctx, id, name, description, nil)
+}
+
+// CreateRawMaterialLot registers a new lot of raw materials sourced from the
+// given origins
+func (s *SupplyChainContract) CreateRawMaterialLot(ctx contractapi.TransactionContextInterface, id, name, description string, originIDs []string) error {
+	if len(originIDs) == 0 {
+		return newError(ErrInvalidArgument, "a raw-material lot needs at least one origin")
+	}
+	for _, originID := range originIDs {
+		if _, err := s.QueryOrigin(ctx, originID); err != nil {
+			return err
+		}
+	}
+	return s.createLot(ctx, id, name, description, originIDs)
+}
+
+// createLot is the shared implementation of CreateLot and CreateRawMaterialLot
+func (s *SupplyChainContract) createLot(ctx contractapi.TransactionContextInterface, id, name, description string, originIDs []string) error {
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := s.requireABACRole(ctx, RoleManufacturer, "create lots"); err != nil {
+		return err
+	}
+	mspID, err := s.getClientMSPID(ctx)
+	if err != nil {
+		return err
+	}
+
+	exists, err := s.lotExists(ctx, id)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return newError(ErrAlreadyExists, "lot with ID %s already exists", id)
+	}
+
+	lot := Lot{
+		ID:           id,
+		Name:         name,
+		Description:  description,
+		Manufacturer: mspID,
+		OriginIDs:    mergeIDs(originIDs, nil),
+		Ingredients:  []string{},
+		Allergens:    []string{},
+		CreatedAt:    curTime,
+		UpdatedAt:    curTime,
+	}
+
+	return s.putLot(ctx, &lot)
+}
+
+// QueryLot retrieves a single lot from the ledger by ID
+func (s *SupplyChainContract) QueryLot(ctx contractapi.TransactionContextInterface, id string) (*Lot, error) {
+	key, err := s.makeKey(ctx, lotObjectType, id)
+	if err != nil {
+		return nil, err
+	}
+
+	var lot Lot
+	exists, err := s.getState(ctx, key, &lot)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, newError(ErrNotFound, "lot with ID %s does not exist", id)
+	}
+
+	return &lot, nil
+}
+
+// SetLotExpiryDate sets the label expiry date (RFC3339) of a lot. It can
+// only be set once, by the lot's manufacturer or an admin; later changes
+// come from shelf-life penalties.
+func (s *SupplyChainContract) SetLotExpiryDate(ctx contractapi.TransactionContextInterface, lotID, expiryDate string) error {
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	expiry, err := time.Parse(time.RFC3339, expiryDate)
+	if err != nil {
+		return newError(ErrInvalidArgument, "expiry date must be an RFC3339 timestamp: %v", err)
+	}
+	lot, err := s.QueryLot(ctx, lotID)
+	if err != nil {
+		return err
+	}
+	if err := s.requireLotManufacturer(ctx, lot, "set its expiry date"); err != nil {
+		return err
+	}
+	if lot.LabelExpiryDate != "" {
+		return newError(ErrConflict, "lot %s already has expiry date %s", lotID, lot.LabelExpiryDate)
+	}
+
+	lot.LabelExpiryDate = expiry.UTC().Format(time.RFC3339)
+	lot.ExpiryDate = lot.LabelExpiryDate
+	lot.UpdatedAt = curTime
+	return s.putLot(ctx, lot)
+}
+
+// requireLotManufacturer checks that the caller belongs to the lot's
+// manufacturer or is an admin. action describes the refused operation in
+// the error.
+func (s *SupplyChainContract) requireLotManufacturer(ctx contractapi.TransactionContextInterface, lot *Lot, action string) error {
+	mspID, err := s.getClientMSPID(ctx)
+	if err != nil {
+		return err
+	}
+	if mspID == lot.Manufacturer {
+		return nil
+	}
+	isAdmin, err := s.hasRole(ctx, RoleAdmin)
+	if err != nil {
+		return err
+	}
+	if !isAdmin {
+		return newError(ErrForbidden, "only the manufacturer %s of lot %s can %s", lot.Manufacturer, lot.ID, action)
+	}
+	return nil
+}
+
+// AssignProductToLot records that a product belongs to a lot, moving it out
+// of any lot it was previously assigned to. Food products can only join lots
+// whose ingredients have been declared. Only the product's owner or an admin
+// can assign it, with the manufacturer role while ABAC is enabled, and
+// frozen products cannot be moved.
+func (s *SupplyChainContract) AssignProductToLot(ctx contractapi.TransactionContextInterface, productID, lotID string) error {
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	lot, err := s.QueryLot(ctx, lotID)
+	if err != nil {
+		return err
+	}
+	product, err := s.QueryProduct(ctx, productID)
+	if err != nil {
+		return err
+	}
+	if err := checkNotFrozen(product); err != nil {
+		return err
+	}
+	if err := s.requireProductOwner(ctx, product, "assign"); err != nil {
+		return err
+	}
+	if err := s.requireABACRole(ctx, RoleManufacturer, "assign products to lots"); err != nil {
+		return err
+	}
+	if isFoodCategory(product.Category) && len(lot.Ingredients) == 0 {
+		return newError(ErrConflict, "lot %s has no ingredient declaration, which is required for %s products", lotID, CategoryFood)
+	}
+	if product.LotID == lotID {
+		return newError(ErrAlreadyExists, "product with ID %s is already in lot %s", productID, lotID)
+	}
+
+	if product.LotID != "" {
+		if err := s.delIndexKey(ctx, lotProductIndexName, product.LotID, productID); err != nil {
+			return err
+		}
+	}
+	if err := s.putIndexKey(ctx, lotProductIndexName, lotID, productID); err != nil {
+		return err
+	}
+
+	product.LotID = lotID
+	product.UpdatedAt = curTime
+	return s.putProduct(ctx, product)
+}
+
+// GetLotProducts returns every product assigned to a lot
+func (s *SupplyChainContract) GetLotProducts(ctx contractapi.TransactionContextInterface, lotID string) ([]*Product, error) {
+	productIDs, err := s.getIndexedIDs(ctx, lotProductIndexName, lotID)
+	if err != nil {
+		return nil, err
+	}
+
+	var products []*Product
+	for _, productID := range productIDs {
+		product, err := s.QueryProduct(ctx, productID)
+		if err != nil {
+			return nil, err
+		}
+		products = append(products, product)
+	}
+
+	return products, nil
+}
+
+// lotExists is a helper method to check if a lot exists in the ledger
+func (s *SupplyChainContract) lotExists(ctx contractapi.TransactionContextInterface, id string) (bool, error) {
+	key, err := s.makeKey(ctx, lotObjectType, id)
+	if err != nil {
+		return false, err
+	}
+	lotJSON, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return false, wrapError(err, "failed to read from world state")
+	}
+	return lotJSON != nil, nil
+}
+
+// putLot is a helper method for inserting or updating a lot in the ledger
+func (s *SupplyChainContract) putLot(ctx contractapi.TransactionContextInterface, lot *Lot) error {
+	key, err := s.makeKey(ctx, lotObjectType, lot.ID)
+	if err != nil {
+		return err
+	}
+	return s.putState(ctx, key, lot)
+}
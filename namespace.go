@@ -0,0 +1,395 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	"github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// programTransientKey is the transient field that selects the program a
+// transaction runs in. Without it the transaction runs in the default
+// program, whose keys are the unprefixed ones used before programs existed.
+const programTransientKey = "program"
+
+// programIDPattern keeps program IDs short and free of key separators
+var programIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,31}$`)
+
+// programContext is the transaction context of every transaction function.
+// Its stub confines all reads, writes and queries to the caller's program;
+// warnings collects the transaction's warnings for its TxResult; readOnly
+// is set by lockWrites.
+type programContext struct {
+	contractapi.TransactionContext
+	stub     *programStub
+	warnings []Warning
+	readOnly bool
+}
+
+// GetTransactionContextHandler makes every transaction run with a programContext
+func (s *SupplyChainContract) GetTransactionContextHandler() contractapi.SettableTransactionContextInterface {
+	return new(programContext)
+}
+
+// GetStub returns the program-scoped stub. An invalid program selection
+// falls back to the default program here; checkProgram refuses the
+// transaction before it touches any state.
+func (c *programContext) GetStub() shim.ChaincodeStubInterface {
+	if c.stub == nil {
+		base := c.TransactionContext.GetStub()
+		program, _ := programFromStub(base)
+		c.stub = &programStub{ChaincodeStubInterface: base, program: program}
+	}
+	if c.readOnly {
+		return &readOnlyStub{ChaincodeStubInterface: c.stub}
+	}
+	return c.stub
+}
+
+// channelContext returns a context whose stub is not scoped to the
+// transaction's program, for state that applies to every program
+func channelContext(ctx contractapi.TransactionContextInterface) contractapi.TransactionContextInterface {
+	c, ok := ctx.(*programContext)
+	if !ok {
+		return ctx
+	}
+	var stub shim.ChaincodeStubInterface = c.TransactionContext.GetStub()
+	if c.readOnly {
+		stub = &readOnlyStub{ChaincodeStubInterface: stub}
+	}
+	return &unscopedContext{TransactionContextInterface: ctx, stub: stub}
+}
+
+// unscopedContext hands the channel-wide stub to the code reading and
+// writing through it
+type unscopedContext struct {
+	contractapi.TransactionContextInterface
+	stub shim.ChaincodeStubInterface
+}
+
+// GetStub returns the stub that is not scoped to a program
+func (c *unscopedContext) GetStub() shim.ChaincodeStubInterface {
+	return c.stub
+}
+
+// programFromStub returns the program selected in the transient data
+func programFromStub(stub shim.ChaincodeStubInterface) (string, error) {
+	transient, err := stub.GetTransient()
+	if err != nil {
+		return "", wrapError(err, "failed to read transient data")
+	}
+	program := string(transient[programTransientKey])
+	if program != "" && !programIDPattern.MatchString(program) {
+		return "", newError(ErrInvalidArgument, "program ID %q must be up to 32 lower case letters, digits and hyphens", program)
+	}
+	return program, nil
+}
+
+// getProgram returns the program the transaction runs in; empty is the
+// default program
+func (s *SupplyChainContract) getProgram(ctx contractapi.TransactionContextInterface) (string, error) {
+	return programFromStub(ctx.GetStub())
+}
+
+// programStub prefixes every key with the program. Composite keys get the
+// object type "#<program>#<type>" and plain product keys become composite
+// keys of type "#<program>", so the default program's keys and range scans
+// are unchanged and a program's keys never fall inside them. Rich query
+// results are filtered down to the program's keys.
+type programStub struct {
+	shim.ChaincodeStubInterface
+	program string
+}
+
+func (p *programStub) plainType() string {
+	return "#" + p.program
+}
+
+func (p *programStub) scopedType(objectType string) string {
+	if p.program == "" {
+		return objectType
+	}
+	return "#" + p.program + "#" + objectType
+}
+
+// mapKey maps a key used by the contract to the key stored in the ledger.
+// Composite keys were scoped when they were created.
+func (p *programStub) mapKey(key string) (string, error) {
+	if p.program == "" || strings.HasPrefix(key, "\x00") {
+		return key, nil
+	}
+	return p.ChaincodeStubInterface.CreateCompositeKey(p.plainType(), []string{key})
+}
+
+// unmapKey is the inverse of mapKey for keys read back from the ledger
+func (p *programStub) unmapKey(key string) string {
+	if p.program == "" {
+		return key
+	}
+	if prefix := "\x00" + p.plainType() + "\x00"; strings.HasPrefix(key, prefix) {
+		return strings.TrimSuffix(strings.TrimPrefix(key, prefix), "\x00")
+	}
+	return key
+}
+
+// ownsKey reports whether a ledger key belongs to the program
+func (p *programStub) ownsKey(key string) bool {
+	if p.program == "" {
+		return !strings.HasPrefix(key, "\x00#")
+	}
+	prefix := "\x00" + p.plainType()
+	return strings.HasPrefix(key, prefix+"\x00") || strings.HasPrefix(key, prefix+"#")
+}
+
+// checkFullRange refuses ranges other than the full product range, which is
+// the only one a program's products can be scanned with
+func (p *programStub) checkFullRange(startKey, endKey string) error {
+	if startKey != "" || endKey != "" {
+		return newError(ErrInvalidArgument, "only full range scans are supported in program %s", p.program)
+	}
+	return nil
+}
+
+func (p *programStub) GetState(key string) ([]byte, error) {
+	key, err := p.mapKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return p.ChaincodeStubInterface.GetState(key)
+}
+
+func (p *programStub) PutState(key string, value []byte) error {
+	key, err := p.mapKey(key)
+	if err != nil {
+		return err
+	}
+	return p.ChaincodeStubInterface.PutState(key, value)
+}
+
+func (p *programStub) DelState(key string) error {
+	key, err := p.mapKey(key)
+	if err != nil {
+		return err
+	}
+	return p.ChaincodeStubInterface.DelState(key)
+}
+
+func (p *programStub) SetStateValidationParameter(key string, ep []byte) error {
+	key, err := p.mapKey(key)
+	if err != nil {
+		return err
+	}
+	return p.ChaincodeStubInterface.SetStateValidationParameter(key, ep)
+}
+
+func (p *programStub) GetStateValidationParameter(key string) ([]byte, error) {
+	key, err := p.mapKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return p.ChaincodeStubInterface.GetStateValidationParameter(key)
+}
+
+func (p *programStub) GetStateByRange(startKey, endKey string) (shim.StateQueryIteratorInterface, error) {
+	if p.program == "" {
+		return p.ChaincodeStubInterface.GetStateByRange(startKey, endKey)
+	}
+	if err := p.checkFullRange(startKey, endKey); err != nil {
+		return nil, err
+	}
+	it, err := p.ChaincodeStubInterface.GetStateByPartialCompositeKey(p.plainType(), []string{})
+	if err != nil {
+		return nil, err
+	}
+	return &programIterator{StateQueryIteratorInterface: it, stub: p}, nil
+}
+
+func (p *programStub) GetStateByRangeWithPagination(startKey, endKey string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *peer.QueryResponseMetadata, error) {
+	if p.program == "" {
+		return p.ChaincodeStubInterface.GetStateByRangeWithPagination(startKey, endKey, pageSize, bookmark)
+	}
+	if err := p.checkFullRange(startKey, endKey); err != nil {
+		return nil, nil, err
+	}
+	it, metadata, err := p.ChaincodeStubInterface.GetStateByPartialCompositeKeyWithPagination(p.plainType(), []string{}, pageSize, bookmark)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &programIterator{StateQueryIteratorInterface: it, stub: p}, metadata, nil
+}
+
+func (p *programStub) CreateCompositeKey(objectType string, attributes []string) (string, error) {
+	return p.ChaincodeStubInterface.CreateCompositeKey(p.scopedType(objectType), attributes)
+}
+
+func (p *programStub) SplitCompositeKey(compositeKey string) (string, []string, error) {
+	objectType, attributes, err := p.ChaincodeStubInterface.SplitCompositeKey(compositeKey)
+	if err != nil {
+		return "", nil, err
+	}
+	return strings.TrimPrefix(objectType, p.scopedType("")), attributes, nil
+}
+
+func (p *programStub) GetStateByPartialCompositeKey(objectType string, keys []string) (shim.StateQueryIteratorInterface, error) {
+	return p.ChaincodeStubInterface.GetStateByPartialCompositeKey(p.scopedType(objectType), keys)
+}
+
+func (p *programStub) GetStateByPartialCompositeKeyWithPagination(objectType string, keys []string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *peer.QueryResponseMetadata, error) {
+	return p.ChaincodeStubInterface.GetStateByPartialCompositeKeyWithPagination(p.scopedType(objectType), keys, pageSize, bookmark)
+}
+
+func (p *programStub) GetQueryResult(query string) (shim.StateQueryIteratorInterface, error) {
+	it, err := p.ChaincodeStubInterface.GetQueryResult(query)
+	if err != nil {
+		return nil, err
+	}
+	return &programIterator{StateQueryIteratorInterface: it, stub: p, filter: true}, nil
+}
+
+func (p *programStub) GetQueryResultWithPagination(query string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *peer.QueryResponseMetadata, error) {
+	it, metadata, err := p.ChaincodeStubInterface.GetQueryResultWithPagination(query, pageSize, bookmark)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &programIterator{StateQueryIteratorInterface: it, stub: p, filter: true}, metadata, nil
+}
+
+func (p *programStub) GetHistoryForKey(key string) (shim.HistoryQueryIteratorInterface, error) {
+	key, err := p.mapKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return p.ChaincodeStubInterface.GetHistoryForKey(key)
+}
+
+func (p *programStub) GetPrivateData(collection, key string) ([]byte, error) {
+	key, err := p.mapKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return p.ChaincodeStubInterface.GetPrivateData(collection, key)
+}
+
+func (p *programStub) GetPrivateDataHash(collection, key string) ([]byte, error) {
+	key, err := p.mapKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return p.ChaincodeStubInterface.GetPrivateDataHash(collection, key)
+}
+
+func (p *programStub) PutPrivateData(collection, key string, value []byte) error {
+	key, err := p.mapKey(key)
+	if err != nil {
+		return err
+	}
+	return p.ChaincodeStubInterface.PutPrivateData(collection, key, value)
+}
+
+func (p *programStub) DelPrivateData(collection, key string) error {
+	key, err := p.mapKey(key)
+	if err != nil {
+		return err
+	}
+	return p.ChaincodeStubInterface.DelPrivateData(collection, key)
+}
+
+func (p *programStub) PurgePrivateData(collection, key string) error {
+	key, err := p.mapKey(key)
+	if err != nil {
+		return err
+	}
+	return p.ChaincodeStubInterface.PurgePrivateData(collection, key)
+}
+
+func (p *programStub) SetPrivateDataValidationParameter(collection, key string, ep []byte) error {
+	key, err := p.mapKey(key)
+	if err != nil {
+		return err
+	}
+	return p.ChaincodeStubInterface.SetPrivateDataValidationParameter(collection, key, ep)
+}
+
+func (p *programStub) GetPrivateDataValidationParameter(collection, key string) ([]byte, error) {
+	key, err := p.mapKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return p.ChaincodeStubInterface.GetPrivateDataValidationParameter(collection, key)
+}
+
+func (p *programStub) GetPrivateDataByRange(collection, startKey, endKey string) (shim.StateQueryIteratorInterface, error) {
+	if p.program == "" {
+		return p.ChaincodeStubInterface.GetPrivateDataByRange(collection, startKey, endKey)
+	}
+	if err := p.checkFullRange(startKey, endKey); err != nil {
+		return nil, err
+	}
+	it, err := p.ChaincodeStubInterface.GetPrivateDataByPartialCompositeKey(collection, p.plainType(), []string{})
+	if err != nil {
+		return nil, err
+	}
+	return &programIterator{StateQueryIteratorInterface: it, stub: p}, nil
+}
+
+func (p *programStub) GetPrivateDataByPartialCompositeKey(collection, objectType string, keys []string) (shim.StateQueryIteratorInterface, error) {
+	return p.ChaincodeStubInterface.GetPrivateDataByPartialCompositeKey(collection, p.scopedType(objectType), keys)
+}
+
+func (p *programStub) GetPrivateDataQueryResult(collection, query string) (shim.StateQueryIteratorInterface, error) {
+	it, err := p.ChaincodeStubInterface.GetPrivateDataQueryResult(collection, query)
+	if err != nil {
+		return nil, err
+	}
+	return &programIterator{StateQueryIteratorInterface: it, stub: p, filter: true}, nil
+}
+
+// programIterator hands plain product keys back to the contract as they
+// were written and, for rich queries, skips results of other programs
+type programIterator struct {
+	shim.StateQueryIteratorInterface
+	stub   *programStub
+	filter bool
+	next   *queryresult.KV
+	err    error
+}
+
+func (it *programIterator) HasNext() bool {
+	if !it.filter {
+		return it.StateQueryIteratorInterface.HasNext()
+	}
+	for it.next == nil && it.err == nil && it.StateQueryIteratorInterface.HasNext() {
+		kv, err := it.StateQueryIteratorInterface.Next()
+		if err != nil {
+			// Next reports the error
+			it.err = err
+			break
+		}
+		if it.stub.ownsKey(kv.Key) {
+			it.next = kv
+		}
+	}
+	return it.next != nil || it.err != nil
+}
+
+func (it *programIterator) Next() (*queryresult.KV, error) {
+	var kv *queryresult.KV
+	if it.filter {
+		if !it.HasNext() {
+			return nil, newError(ErrNotFound, "no more results")
+		}
+		if it.err != nil {
+			return nil, it.err
+		}
+		kv, it.next = it.next, nil
+	} else {
+		var err error
+		if kv, err = it.StateQueryIteratorInterface.Next(); err != nil {
+			return nil, err
+		}
+	}
+	return &queryresult.KV{Namespace: kv.Namespace, Key: it.stub.unmapKey(kv.Key), Value: kv.Value}, nil
+}
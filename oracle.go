@@ -0,0 +1,178 @@
+package main
+
+import (
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const oracleObjectType = "oracle"
+
+// Oracle is a registered off-chain data source, such as a price or weather
+// feed, signing the data it reports with its signingKeys
+type Oracle struct {
+	ID string `json:"id"`
+	signingKeys
+	Name         string `json:"name"`
+	RegisteredBy string `json:"registered_by"`
+	CreatedAt    string `json:"created_at"`
+	UpdatedAt    string `json:"updated_at"`
+}
+
+// RegisterOracle adds a data source with its PEM encoded public key to the
+// oracle registry. Only admins can register oracles.
+func (s *SupplyChainContract) RegisterOracle(ctx contractapi.TransactionContextInterface, id, name, publicKey string) error {
+	if err := s.requireRole(ctx, RoleAdmin); err != nil {
+		return err
+	}
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	if id == "" {
+		return newError(ErrInvalidArgument, "oracle ID must not be empty")
+	}
+	if err := validatePublicKey(publicKey); err != nil {
+		return err
+	}
+
+	key, err := s.makeKey(ctx, oracleObjectType, id)
+	if err != nil {
+		return err
+	}
+	var existing Oracle
+	exists, err := s.getState(ctx, key, &existing)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return newError(ErrAlreadyExists, "oracle with ID %s already exists", id)
+	}
+	clientID, err := s.getClientID(ctx)
+	if err != nil {
+		return err
+	}
+
+	oracle := Oracle{
+		ID:           id,
+		signingKeys:  signingKeys{PublicKey: publicKey, RevokedKeys: []string{}},
+		Name:         name,
+		RegisteredBy: clientID,
+		CreatedAt:    curTime,
+		UpdatedAt:    curTime,
+	}
+	return s.putState(ctx, key, &oracle)
+}
+
+// RotateOracleKey replaces an oracle's signing key. The old key keeps
+// verifying for overlapMinutes so that data already signed with it can
+// still be submitted; zero retires it at once. Only the registrant or an
+// admin can rotate a key.
+func (s *SupplyChainContract) RotateOracleKey(ctx contractapi.TransactionContextInterface, id, newPublicKey string, overlapMinutes int) error {
+	oracle, err := s.QueryOracle(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := s.requireOracleManager(ctx, oracle, "rotate the keys"); err != nil {
+		return err
+	}
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	txTime, err := rawTxTime(ctx)
+	if err != nil {
+		return err
+	}
+	if err := oracle.rotate("oracle "+id, newPublicKey, overlapMinutes, txTime); err != nil {
+		return err
+	}
+	oracle.UpdatedAt = curTime
+	return s.putOracle(ctx, oracle)
+}
+
+// RevokeOracleKey revokes the current key of a compromised oracle and any
+// key still in overlap. Nothing verifies until a new key is rotated in.
+// Only the registrant or an admin can revoke a key.
+func (s *SupplyChainContract) RevokeOracleKey(ctx contractapi.TransactionContextInterface, id string) error {
+	oracle, err := s.QueryOracle(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := s.requireOracleManager(ctx, oracle, "revoke the keys"); err != nil {
+		return err
+	}
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	if err := oracle.revoke("oracle " + id); err != nil {
+		return err
+	}
+	oracle.UpdatedAt = curTime
+	return s.putOracle(ctx, oracle)
+}
+
+// VerifyOracleSignature reports whether a base64 signature of message was
+// made with a key of the oracle that verifies at the transaction time,
+// including a rotated-out key still in its overlap
+func (s *SupplyChainContract) VerifyOracleSignature(ctx contractapi.TransactionContextInterface, id, message, signature string) (bool, error) {
+	oracle, err := s.QueryOracle(ctx, id)
+	if err != nil {
+		return false, err
+	}
+	txTime, err := rawTxTime(ctx)
+	if err != nil {
+		return false, err
+	}
+	return oracle.verify("oracle "+id, []byte(message), signature, txTime)
+}
+
+// QueryOracle retrieves a single oracle from the registry by ID
+func (s *SupplyChainContract) QueryOracle(ctx contractapi.TransactionContextInterface, id string) (*Oracle, error) {
+	key, err := s.makeKey(ctx, oracleObjectType, id)
+	if err != nil {
+		return nil, err
+	}
+
+	var oracle Oracle
+	exists, err := s.getState(ctx, key, &oracle)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, newError(ErrNotFound, "oracle with ID %s does not exist", id)
+	}
+	if oracle.RevokedKeys == nil {
+		oracle.RevokedKeys = []string{}
+	}
+
+	return &oracle, nil
+}
+
+// putOracle writes an oracle back to the registry
+func (s *SupplyChainContract) putOracle(ctx contractapi.TransactionContextInterface, oracle *Oracle) error {
+	key, err := s.makeKey(ctx, oracleObjectType, oracle.ID)
+	if err != nil {
+		return err
+	}
+	return s.putState(ctx, key, oracle)
+}
+
+// requireOracleManager checks that the caller registered the oracle or is
+// an admin. action describes the refused operation in the error.
+func (s *SupplyChainContract) requireOracleManager(ctx contractapi.TransactionContextInterface, oracle *Oracle, action string) error {
+	clientID, err := s.getClientID(ctx)
+	if err != nil {
+		return err
+	}
+	if clientID == oracle.RegisteredBy {
+		return nil
+	}
+	isAdmin, err := s.hasRole(ctx, RoleAdmin)
+	if err != nil {
+		return err
+	}
+	if !isAdmin {
+		return newError(ErrForbidden, "only the registrant or an admin can %s of oracle %s", action, oracle.ID)
+	}
+	return nil
+}
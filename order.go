@@ -0,0 +1,419 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	purchaseOrderObjectType = "order"
+	orderShipmentIndexName  = "order~shipment"
+	shipmentOrderIndexName  = "shipment~order"
+	orderProductIndexName   = "order~product"
+
+	// maxOrderLineItems bounds the line items of one order
+	maxOrderLineItems = 100
+)
+
+// Purchase order statuses
+const (
+	OrderCreated   = "Created"
+	OrderAccepted  = "Accepted"
+	OrderFulfilled = "Fulfilled"
+	OrderCancelled = "Cancelled"
+)
+
+// OrderLineItem orders Quantity products of a category. Transferred counts
+// the products transferred against it with TransferOwnershipForOrder.
+type OrderLineItem struct {
+	Category    string `json:"category"`
+	Quantity    int    `json:"quantity"`
+	Transferred int    `json:"transferred"`
+}
+
+// PurchaseOrder is an order placed by Buyer with Seller for Amount.
+// CreditOverrideBy is set when the seller's finance approved accepting the
+// order beyond the buyer's credit limit. Orders placed without line items
+// are not checked against the products transferred for them.
+type PurchaseOrder struct {
+	ID               string          `json:"id"`
+	Buyer            string          `json:"buyer"`
+	Seller           string          `json:"seller"`
+	Amount           float64         `json:"amount"`
+	LineItems        []OrderLineItem `json:"line_items,omitempty" metadata:",optional"`
+	Status           string          `json:"status"`
+	CreditOverrideBy string          `json:"credit_override_by"`
+	AcceptedAt       string          `json:"accepted_at"`
+	FulfilledAt      string          `json:"fulfilled_at"`
+	CancelledBy      string          `json:"cancelled_by"`
+	CancelReason     string          `json:"cancel_reason"`
+	CreatedAt        string          `json:"created_at"`
+	UpdatedAt        string          `json:"updated_at"`
+}
+
+// CreatePurchaseOrder places an order with a seller on behalf of the
+// calling organization
+func (s *SupplyChainContract) CreatePurchaseOrder(ctx contractapi.TransactionContextInterface, id, seller string, amount float64) error {
+	return s.createPurchaseOrder(ctx, id, seller, amount, nil)
+}
+
+// CreatePurchaseOrderWithItems is CreatePurchaseOrder with line items, a
+// JSON array of {"category", "quantity"}. Products are then transferred
+// against the order with TransferOwnershipForOrder, and the order can only
+// be fulfilled once every line item has been transferred in full.
+func (s *SupplyChainContract) CreatePurchaseOrderWithItems(ctx contractapi.TransactionContextInterface, id, seller string, amount float64, lineItemsJSON string) error {
+	var lineItems []OrderLineItem
+	if err := json.Unmarshal([]byte(lineItemsJSON), &lineItems); err != nil {
+		return newError(ErrInvalidArgument, "failed to parse line items: %v", err)
+	}
+	if len(lineItems) == 0 || len(lineItems) > maxOrderLineItems {
+		return newError(ErrInvalidArgument, "an order must have between 1 and %d line items", maxOrderLineItems)
+	}
+	categories := make(map[string]bool, len(lineItems))
+	for i := range lineItems {
+		item := &lineItems[i]
+		if err := validateFields(field("category", &item.Category, categoryRule)); err != nil {
+			return wrapError(err, "line item %d", i)
+		}
+		if item.Category == "" || item.Quantity <= 0 {
+			return newError(ErrInvalidArgument, "line item %d needs a category and a positive quantity", i)
+		}
+		if categories[item.Category] {
+			return newError(ErrInvalidArgument, "category %s appears in more than one line item", item.Category)
+		}
+		categories[item.Category] = true
+		item.Transferred = 0
+	}
+	return s.createPurchaseOrder(ctx, id, seller, amount, lineItems)
+}
+
+// createPurchaseOrder is the shared implementation of CreatePurchaseOrder
+// and CreatePurchaseOrderWithItems
+func (s *SupplyChainContract) createPurchaseOrder(ctx contractapi.TransactionContextInterface, id, seller string, amount float64, lineItems []OrderLineItem) error {
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	if id == "" || seller == "" {
+		return newError(ErrInvalidArgument, "order ID and seller must not be empty")
+	}
+	if amount <= 0 {
+		return newError(ErrInvalidArgument, "order amount must be positive")
+	}
+	buyer, err := s.getClientMSPID(ctx)
+	if err != nil {
+		return err
+	}
+	if buyer == seller {
+		return newError(ErrInvalidArgument, "an organization cannot order from itself")
+	}
+
+	key, err := s.makeKey(ctx, purchaseOrderObjectType, id)
+	if err != nil {
+		return err
+	}
+	var existing PurchaseOrder
+	exists, err := s.getState(ctx, key, &existing)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return newError(ErrAlreadyExists, "order with ID %s already exists", id)
+	}
+
+	order := PurchaseOrder{
+		ID:        id,
+		Buyer:     buyer,
+		Seller:    seller,
+		Amount:    amount,
+		LineItems: lineItems,
+		Status:    OrderCreated,
+		CreatedAt: curTime,
+		UpdatedAt: curTime,
+	}
+	return s.putState(ctx, key, &order)
+}
+
+// QueryPurchaseOrder retrieves a single purchase order from the ledger by ID
+func (s *SupplyChainContract) QueryPurchaseOrder(ctx contractapi.TransactionContextInterface, id string) (*PurchaseOrder, error) {
+	key, err := s.makeKey(ctx, purchaseOrderObjectType, id)
+	if err != nil {
+		return nil, err
+	}
+
+	var order PurchaseOrder
+	exists, err := s.getState(ctx, key, &order)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, newError(ErrNotFound, "order with ID %s does not exist", id)
+	}
+
+	return &order, nil
+}
+
+// AcceptPurchaseOrder confirms an order. Only the seller may accept, and
+// the buyer's credit limit with the seller must cover the order on top of
+// the buyer's open invoices, unless the seller's finance approved an override.
+func (s *SupplyChainContract) AcceptPurchaseOrder(ctx contractapi.TransactionContextInterface, id string) error {
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	order, err := s.requireOrderSeller(ctx, id)
+	if err != nil {
+		return err
+	}
+	if order.Status != OrderCreated {
+		return newError(ErrConflict, "order %s is %s and cannot be accepted", id, order.Status)
+	}
+	if order.CreditOverrideBy == "" {
+		if err := s.checkCreditLimit(ctx, order); err != nil {
+			return err
+		}
+	}
+
+	order.Status = OrderAccepted
+	order.AcceptedAt = curTime
+	order.UpdatedAt = curTime
+	return s.putPurchaseOrder(ctx, order)
+}
+
+// TransferOwnershipForOrder transfers a product from the seller to the
+// buyer of an accepted order, recording the order on the transfer receipt.
+// For an order with line items the product's category must have a line
+// item that is not yet transferred in full.
+func (s *SupplyChainContract) TransferOwnershipForOrder(ctx contractapi.TransactionContextInterface, id, orderID string) (*TxResult, error) {
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	order, err := s.requireOrderSeller(ctx, orderID)
+	if err != nil {
+		return nil, err
+	}
+	if order.Status != OrderAccepted {
+		return nil, newError(ErrConflict, "order %s is %s; products can only be transferred for accepted orders", orderID, order.Status)
+	}
+	product, err := s.QueryProduct(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if product.Owner == order.Buyer {
+		return nil, newError(ErrConflict, "product %s is already owned by the buyer %s", id, order.Buyer)
+	}
+	transferred, err := s.indexKeyExists(ctx, orderProductIndexName, orderID, id)
+	if err != nil {
+		return nil, err
+	}
+	if transferred {
+		return nil, newError(ErrAlreadyExists, "product %s has already been transferred for order %s", id, orderID)
+	}
+	if len(order.LineItems) > 0 {
+		item := order.lineItem(product.Category)
+		if item == nil {
+			return nil, newError(ErrInvalidArgument, "order %s has no line item for category %q of product %s", orderID, product.Category, id)
+		}
+		if item.Transferred >= item.Quantity {
+			return nil, newError(ErrConflict, "the %s line item of order %s is already transferred in full", item.Category, orderID)
+		}
+		item.Transferred++
+	}
+	if err := s.transferOwnership(ctx, id, order.Buyer, transferOptions{orderID: orderID}); err != nil {
+		return nil, err
+	}
+	if err := s.putIndexKey(ctx, orderProductIndexName, orderID, id); err != nil {
+		return nil, err
+	}
+
+	order.UpdatedAt = curTime
+	if err := s.putPurchaseOrder(ctx, order); err != nil {
+		return nil, err
+	}
+	return txResult(ctx), nil
+}
+
+// FulfillPurchaseOrder marks an accepted order as fulfilled. Only the
+// seller may fulfil, once every line item has been transferred in full.
+func (s *SupplyChainContract) FulfillPurchaseOrder(ctx contractapi.TransactionContextInterface, id string) error {
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	order, err := s.requireOrderSeller(ctx, id)
+	if err != nil {
+		return err
+	}
+	if order.Status != OrderAccepted {
+		return newError(ErrConflict, "order %s is %s and cannot be fulfilled", id, order.Status)
+	}
+	for _, item := range order.LineItems {
+		if item.Transferred < item.Quantity {
+			return newError(ErrConflict, "order %s has %d of %d %s products transferred", id, item.Transferred, item.Quantity, item.Category)
+		}
+	}
+
+	order.Status = OrderFulfilled
+	order.FulfilledAt = curTime
+	order.UpdatedAt = curTime
+	return s.putPurchaseOrder(ctx, order)
+}
+
+// CancelPurchaseOrder lets the buyer or the seller cancel an order that is
+// not yet accepted, or accepted but with nothing transferred for it yet
+func (s *SupplyChainContract) CancelPurchaseOrder(ctx contractapi.TransactionContextInterface, id, reason string) error {
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	order, err := s.requireOrderParty(ctx, id)
+	if err != nil {
+		return err
+	}
+	if order.Status != OrderCreated && order.Status != OrderAccepted {
+		return newError(ErrConflict, "order %s is %s and cannot be cancelled", id, order.Status)
+	}
+	if reason == "" {
+		return newError(ErrInvalidArgument, "cancel reason must not be empty")
+	}
+	productIDs, err := s.getIndexedIDs(ctx, orderProductIndexName, id)
+	if err != nil {
+		return err
+	}
+	if len(productIDs) > 0 {
+		return newError(ErrConflict, "product %s has already been transferred for order %s", productIDs[0], id)
+	}
+	mspID, err := s.getClientMSPID(ctx)
+	if err != nil {
+		return err
+	}
+
+	order.Status = OrderCancelled
+	order.CancelledBy = mspID
+	order.CancelReason = reason
+	order.UpdatedAt = curTime
+	return s.putPurchaseOrder(ctx, order)
+}
+
+// GetOrderProducts returns the IDs of the products transferred for an order
+func (s *SupplyChainContract) GetOrderProducts(ctx contractapi.TransactionContextInterface, orderID string) ([]string, error) {
+	if _, err := s.QueryPurchaseOrder(ctx, orderID); err != nil {
+		return nil, err
+	}
+	productIDs, err := s.getIndexedIDs(ctx, orderProductIndexName, orderID)
+	if err != nil {
+		return nil, err
+	}
+	if productIDs == nil {
+		productIDs = []string{}
+	}
+	return productIDs, nil
+}
+
+// AddOrderShipment records that a shipment fulfils part of an accepted order.
+// The shipment must go from the seller to the buyer and cannot fulfil
+// another order.
+func (s *SupplyChainContract) AddOrderShipment(ctx contractapi.TransactionContextInterface, orderID, shipmentID string) error {
+	order, err := s.requireOrderSeller(ctx, orderID)
+	if err != nil {
+		return err
+	}
+	if order.Status != OrderAccepted {
+		return newError(ErrConflict, "order %s is %s; only accepted orders can be shipped", orderID, order.Status)
+	}
+	shipment, err := s.QueryShipment(ctx, shipmentID)
+	if err != nil {
+		return err
+	}
+	if shipment.ShipperMSP != order.Seller || shipment.ConsigneeMSP != order.Buyer {
+		return newError(ErrConflict, "shipment %s does not go from the seller %s to the buyer %s of order %s", shipmentID, order.Seller, order.Buyer, orderID)
+	}
+	orderIDs, err := s.getIndexedIDs(ctx, shipmentOrderIndexName, shipmentID)
+	if err != nil {
+		return err
+	}
+	if len(orderIDs) > 0 {
+		return newError(ErrConflict, "shipment %s already fulfils order %s", shipmentID, orderIDs[0])
+	}
+
+	if err := s.putIndexKey(ctx, orderShipmentIndexName, orderID, shipmentID); err != nil {
+		return err
+	}
+	return s.putIndexKey(ctx, shipmentOrderIndexName, shipmentID, orderID)
+}
+
+// GetOrderShipments returns the shipments recorded against an order
+func (s *SupplyChainContract) GetOrderShipments(ctx contractapi.TransactionContextInterface, orderID string) ([]*Shipment, error) {
+	shipmentIDs, err := s.getIndexedIDs(ctx, orderShipmentIndexName, orderID)
+	if err != nil {
+		return nil, err
+	}
+
+	shipments := []*Shipment{}
+	for _, shipmentID := range shipmentIDs {
+		shipment, err := s.QueryShipment(ctx, shipmentID)
+		if err != nil {
+			return nil, err
+		}
+		shipments = append(shipments, shipment)
+	}
+	return shipments, nil
+}
+
+// lineItem returns the line item of a category, or nil if there is none
+func (o *PurchaseOrder) lineItem(category string) *OrderLineItem {
+	for i := range o.LineItems {
+		if o.LineItems[i].Category == category {
+			return &o.LineItems[i]
+		}
+	}
+	return nil
+}
+
+// requireOrderParty loads an order and checks that the caller is its buyer or seller
+func (s *SupplyChainContract) requireOrderParty(ctx contractapi.TransactionContextInterface, id string) (*PurchaseOrder, error) {
+	order, err := s.QueryPurchaseOrder(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	mspID, err := s.getClientMSPID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if mspID != order.Buyer && mspID != order.Seller {
+		return nil, newError(ErrForbidden, "only the buyer or seller can act on order %s", id)
+	}
+	return order, nil
+}
+
+// requireOrderSeller loads an order and checks that the caller belongs to its seller
+func (s *SupplyChainContract) requireOrderSeller(ctx contractapi.TransactionContextInterface, id string) (*PurchaseOrder, error) {
+	order, err := s.QueryPurchaseOrder(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	mspID, err := s.getClientMSPID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if mspID != order.Seller {
+		return nil, newError(ErrForbidden, "only the seller %s can act on order %s", order.Seller, id)
+	}
+	return order, nil
+}
+
+// putPurchaseOrder writes an order under its ID
+func (s *SupplyChainContract) putPurchaseOrder(ctx contractapi.TransactionContextInterface, order *PurchaseOrder) error {
+	key, err := s.makeKey(ctx, purchaseOrderObjectType, order.ID)
+	if err != nil {
+		return err
+	}
+	return s.putState(ctx, key, order)
+}
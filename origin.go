@@ -0,0 +1,198 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const originObjectType = "origin"
+
+// Origin types
+const (
+	OriginFarm  = "Farm"
+	OriginMine  = "Mine"
+	OriginPlant = "Plant"
+)
+
+// Origin is the farm, mine or plant a raw material was sourced from
+type Origin struct {
+	ID             string   `json:"id"`
+	Name           string   `json:"name"`
+	Type           string   `json:"type"`
+	Latitude       float64  `json:"latitude"`
+	Longitude      float64  `json:"longitude"`
+	Region         string   `json:"region"`
+	Certifications []string `json:"certifications"`
+	RegisteredBy   string   `json:"registered_by"`
+	CreatedAt      string   `json:"created_at"`
+	UpdatedAt      string   `json:"updated_at"`
+}
+
+// RegisterOrigin adds a new source of raw materials to the ledger. Only
+// admins can register origins, since lots cite their certifications.
+func (s *SupplyChainContract) RegisterOrigin(ctx contractapi.TransactionContextInterface, id, name, originType string, latitude, longitude float64, region string, certifications []string) error {
+	if err := s.requireRole(ctx, RoleAdmin); err != nil {
+		return err
+	}
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	switch originType {
+	case OriginFarm, OriginMine, OriginPlant:
+	default:
+		return newError(ErrInvalidArgument, "invalid origin type %q; must be %s, %s or %s", originType, OriginFarm, OriginMine, OriginPlant)
+	}
+	if latitude < -90 || latitude > 90 || longitude < -180 || longitude > 180 {
+		return newError(ErrInvalidArgument, "invalid coordinates %g, %g", latitude, longitude)
+	}
+
+	key, err := s.makeKey(ctx, originObjectType, id)
+	if err != nil {
+		return err
+	}
+	var existing Origin
+	exists, err := s.getState(ctx, key, &existing)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return newError(ErrAlreadyExists, "origin with ID %s already exists", id)
+	}
+
+	clientID, err := s.getClientID(ctx)
+	if err != nil {
+		return err
+	}
+
+	if certifications == nil {
+		certifications = []string{}
+	}
+	origin := Origin{
+		ID:             id,
+		Name:           name,
+		Type:           originType,
+		Latitude:       latitude,
+		Longitude:      longitude,
+		Region:         region,
+		Certifications: certifications,
+		RegisteredBy:   clientID,
+		CreatedAt:      curTime,
+		UpdatedAt:      curTime,
+	}
+
+	return s.putState(ctx, key, &origin)
+}
+
+// QueryOrigin retrieves a single origin from the ledger by ID
+func (s *SupplyChainContract) QueryOrigin(ctx contractapi.TransactionContextInterface, id string) (*Origin, error) {
+	key, err := s.makeKey(ctx, originObjectType, id)
+	if err != nil {
+		return nil, err
+	}
+
+	var origin Origin
+	exists, err := s.getState(ctx, key, &origin)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, newError(ErrNotFound, "origin with ID %s does not exist", id)
+	}
+
+	return &origin, nil
+}
+
+// GetProductOrigins answers which origins contributed to a product: those of
+// its lot, plus those carried through the transformation that produced it
+func (s *SupplyChainContract) GetProductOrigins(ctx contractapi.TransactionContextInterface, productID string) ([]*Origin, error) {
+	product, err := s.QueryProduct(ctx, productID)
+	if err != nil {
+		return nil, err
+	}
+
+	originIDs, err := s.productOriginIDs(ctx, product)
+	if err != nil {
+		return nil, err
+	}
+	return s.queryOrigins(ctx, originIDs)
+}
+
+// GetLotOrigins answers which origins contributed to a batch: the lot's own
+// origins plus those of every product assigned to it
+func (s *SupplyChainContract) GetLotOrigins(ctx contractapi.TransactionContextInterface, lotID string) ([]*Origin, error) {
+	lot, err := s.QueryLot(ctx, lotID)
+	if err != nil {
+		return nil, err
+	}
+
+	originIDs := lot.OriginIDs
+	products, err := s.GetLotProducts(ctx, lotID)
+	if err != nil {
+		return nil, err
+	}
+	for _, product := range products {
+		productOrigins, err := s.productOriginIDs(ctx, product)
+		if err != nil {
+			return nil, err
+		}
+		originIDs = mergeIDs(originIDs, productOrigins)
+	}
+
+	return s.queryOrigins(ctx, originIDs)
+}
+
+// productOriginIDs returns the sorted IDs of the origins behind a product.
+// Transformations store the union of their inputs' origins, so one step back
+// is enough to cover the whole chain.
+func (s *SupplyChainContract) productOriginIDs(ctx contractapi.TransactionContextInterface, product *Product) ([]string, error) {
+	var originIDs []string
+	if product.LotID != "" {
+		lot, err := s.QueryLot(ctx, product.LotID)
+		if err != nil {
+			return nil, err
+		}
+		originIDs = mergeIDs(originIDs, lot.OriginIDs)
+	}
+
+	source, err := s.GetProductSources(ctx, product.ID)
+	if err != nil {
+		return nil, err
+	}
+	if source != nil {
+		originIDs = mergeIDs(originIDs, source.OriginIDs)
+	}
+
+	return originIDs, nil
+}
+
+// queryOrigins resolves a list of origin IDs
+func (s *SupplyChainContract) queryOrigins(ctx contractapi.TransactionContextInterface, originIDs []string) ([]*Origin, error) {
+	origins := []*Origin{}
+	for _, originID := range originIDs {
+		origin, err := s.QueryOrigin(ctx, originID)
+		if err != nil {
+			return nil, err
+		}
+		origins = append(origins, origin)
+	}
+	return origins, nil
+}
+
+// mergeIDs returns the sorted union of two ID lists
+func mergeIDs(a, b []string) []string {
+	seen := make(map[string]bool)
+	merged := []string{}
+	for _, ids := range [][]string{a, b} {
+		for _, id := range ids {
+			if !seen[id] {
+				seen[id] = true
+				merged = append(merged, id)
+			}
+		}
+	}
+	sort.Strings(merged)
+	return merged
+}
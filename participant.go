@@ -0,0 +1,181 @@
+package main
+
+import (
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const participantObjectType = "participant"
+
+// Participant roles
+const (
+	ParticipantManufacturer = "Manufacturer"
+	ParticipantDistributor  = "Distributor"
+	ParticipantRetailer     = "Retailer"
+)
+
+var participantRoles = map[string]bool{
+	ParticipantManufacturer: true,
+	ParticipantDistributor:  true,
+	ParticipantRetailer:     true,
+}
+
+// FeatureParticipantRegistry makes product owners registered parties: while
+// it is on, products can only be created for or transferred to a registered,
+// active participant. Organizations can be brought in with an org override
+// once their parties are registered.
+const FeatureParticipantRegistry = "participant_registry"
+
+// Participant is a registered supply chain party. ID is the value used as
+// the owner in product records and MSPID the organization acting for it,
+// which is the ID itself for an organization owning products directly.
+// Markets are the markets (regions) the participant is authorized to operate
+// in. A participant that is no longer Active cannot receive products. DID
+// is set with SetParticipantDID.
+type Participant struct {
+	ID        string   `json:"id"`
+	MSPID     string   `json:"msp_id"`
+	Name      string   `json:"name"`
+	Role      string   `json:"role"`
+	Contact   string   `json:"contact"`
+	Markets   []string `json:"markets"`
+	Active    bool     `json:"active"`
+	DID       string   `json:"did"`
+	CreatedAt string   `json:"created_at"`
+	UpdatedAt string   `json:"updated_at"`
+}
+
+// RegisterParticipant adds a party to the participant registry. An empty
+// mspID registers the organization id itself.
+func (s *SupplyChainContract) RegisterParticipant(ctx contractapi.TransactionContextInterface, id, mspID, name, role, contact string, markets []string) error {
+	if err := s.requireRole(ctx, RoleAdmin); err != nil {
+		return err
+	}
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	if id == "" {
+		return newError(ErrInvalidArgument, "participant ID must not be empty")
+	}
+	if !participantRoles[role] {
+		return newError(ErrInvalidArgument, "unknown participant role %s", role)
+	}
+	if mspID == "" {
+		mspID = id
+	}
+
+	key, err := s.makeKey(ctx, participantObjectType, id)
+	if err != nil {
+		return err
+	}
+	var existing Participant
+	exists, err := s.getState(ctx, key, &existing)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return newError(ErrAlreadyExists, "participant with ID %s already exists", id)
+	}
+
+	participant := Participant{
+		ID:        id,
+		MSPID:     mspID,
+		Name:      name,
+		Role:      role,
+		Contact:   contact,
+		Markets:   mergeIDs(markets, nil),
+		Active:    true,
+		CreatedAt: curTime,
+		UpdatedAt: curTime,
+	}
+	return s.putState(ctx, key, &participant)
+}
+
+// UpdateParticipant replaces the details of a registered participant.
+// Deactivating a participant keeps the products it owns but stops it from
+// receiving more.
+func (s *SupplyChainContract) UpdateParticipant(ctx contractapi.TransactionContextInterface, id, name, role, contact string, markets []string, active bool) error {
+	if err := s.requireRole(ctx, RoleAdmin); err != nil {
+		return err
+	}
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	if !participantRoles[role] {
+		return newError(ErrInvalidArgument, "unknown participant role %s", role)
+	}
+
+	participant, err := s.GetParticipant(ctx, id)
+	if err != nil {
+		return err
+	}
+	participant.Name = name
+	participant.Role = role
+	participant.Contact = contact
+	participant.Markets = mergeIDs(markets, nil)
+	participant.Active = active
+	participant.UpdatedAt = curTime
+
+	key, err := s.makeKey(ctx, participantObjectType, id)
+	if err != nil {
+		return err
+	}
+	return s.putState(ctx, key, participant)
+}
+
+// GetParticipant retrieves a single participant from the registry by ID
+func (s *SupplyChainContract) GetParticipant(ctx contractapi.TransactionContextInterface, id string) (*Participant, error) {
+	participant, err := s.findParticipant(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if participant == nil {
+		return nil, newError(ErrNotFound, "participant with ID %s does not exist", id)
+	}
+	return participant, nil
+}
+
+// findParticipant returns a participant, or nil if the party is not registered
+func (s *SupplyChainContract) findParticipant(ctx contractapi.TransactionContextInterface, id string) (*Participant, error) {
+	key, err := s.makeKey(ctx, participantObjectType, id)
+	if err != nil {
+		return nil, err
+	}
+
+	var participant Participant
+	exists, err := s.getState(ctx, key, &participant)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, nil
+	}
+	return &participant, nil
+}
+
+// checkOwnerParticipant returns an error if a product cannot be owned by
+// owner: the owner is a deactivated participant, or the participant registry
+// is enabled and the owner is not registered
+func (s *SupplyChainContract) checkOwnerParticipant(ctx contractapi.TransactionContextInterface, owner string) error {
+	participant, err := s.findParticipant(ctx, owner)
+	if err != nil {
+		return err
+	}
+	if participant != nil {
+		if !participant.Active {
+			return newError(ErrConflict, "participant %s is deactivated and cannot own products", owner)
+		}
+		return nil
+	}
+
+	enabled, err := s.featureEnabled(ctx, FeatureParticipantRegistry)
+	if err != nil {
+		return err
+	}
+	if enabled {
+		return newError(ErrNotFound, "%s is not a registered participant", owner)
+	}
+	return nil
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	pauseObjectType         = "pause"
+	pauseProposalObjectType = "pauseproposal"
+
+	// pauseApprovalsRequired is how many different admins must call
+	// PauseContract or ResumeContract before it takes effect
+	pauseApprovalsRequired = 2
+	// pauseProposalValidHours is how long approvals wait for the rest
+	pauseProposalValidHours = 24
+)
+
+// Pause proposal actions
+const (
+	PauseActionPause  = "pause"
+	PauseActionResume = "resume"
+)
+
+// ErrPaused is the error code of every transaction refused while the
+// contract is paused
+const ErrPaused = "PAUSED"
+
+// readOnlyTransactions are the transaction functions that never write, which
+// stay available while the contract is paused. A new query must be added by
+// its exact name; while the contract is paused a listed function that tries
+// to write fails with a PAUSED error.
+var readOnlyTransactions = map[string]bool{
+	"CanTransfer": true, "ExportHistories": true, "GenerateDigest": true,
+	"GetAllProducts": true, "GetAllProductsPaginated": true,
+	"GetAllergens": true, "GetArchivedProducts": true,
+	"GetCategoryRules": true, "GetCertifier": true, "GetChangeEvents": true,
+	"GetChargeableTimeByParty": true, "GetChargebackAging": true,
+	"GetContractClock": true, "GetContractConfig": true,
+	"GetCredential": true, "GetCredentialsBySubject": true,
+	"GetCreditExposure": true, "GetCustodyAt": true,
+	"GetCustodyHistory": true, "GetDepositBalance": true,
+	"GetDeprecatedMethods": true, "GetDunningHistory": true,
+	"GetDuplicateFlags": true, "GetEarlySaleFlags": true,
+	"GetExternalNetwork": true, "GetExternalReferences": true,
+	"GetFacilityChargeReport": true, "GetFacilityDockSlots": true,
+	"GetFacilityYieldReport": true, "GetFeatureFlag": true,
+	"GetFeatureFlags": true, "GetFeeRules": true, "GetInclusionProof": true,
+	"GetInvoicesByIssuer": true, "GetInvoicesByPayer": true,
+	"GetLatestBenchmark": true, "GetLotOrigins": true, "GetLotProducts": true,
+	"GetOpenDisputes": true, "GetOrderDocuments": true,
+	"GetOrderProducts": true, "GetOrderShipments": true,
+	"GetOrgQueryLimits": true, "GetOrgQuotas": true, "GetOrgUsage": true,
+	"GetOverdueReturnables": true, "GetOwnershipHops": true,
+	"GetParticipant": true, "GetPauseProposal": true, "GetPauseStatus": true,
+	"GetPrivateDetails": true, "GetPrivateDetailsHash": true,
+	"GetProductAllergens": true, "GetProductAmendments": true,
+	"GetProductAuditTrail": true, "GetProductCertifications": true,
+	"GetProductDiff": true, "GetProductDisclosures": true,
+	"GetProductDisputes": true, "GetProductEmbargoes": true,
+	"GetProductEndorsement": true, "GetProductGraph": true,
+	"GetProductHistory": true, "GetProductOrigins": true,
+	"GetProductScans": true, "GetProductSources": true,
+	"GetProductSummary": true, "GetProductUses": true,
+	"GetProductWatchers": true, "GetProductsByCategory": true,
+	"GetProductsByExternalAsset": true, "GetProductsByOwner": true,
+	"GetProductsByStatus": true, "GetProgram": true, "GetProgramRoles": true,
+	"GetPromotions": true, "GetPruneRecords": true, "GetReasonCodes": true,
+	"GetRebateStatement": true, "GetReceivablesAging": true,
+	"GetReturnablesByHolder": true, "GetSavedQuery": true,
+	"GetSavedQueryVersions": true, "GetSellThroughReport": true,
+	"GetSensorReadings": true, "GetShelfLifeRules": true,
+	"GetShipmentCheckpoints": true, "GetShipmentDiscrepancies": true,
+	"GetShipmentExcursions": true, "GetShipmentInspections": true,
+	"GetShipmentLegs": true, "GetShipmentProducts": true,
+	"GetStatusTransitions": true, "GetSupplierChargebacks": true,
+	"GetTelemetrySummary": true, "GetTradeFinancePackage": true,
+	"GetTransferFees": true, "GetTransferReceiptsByDate": true,
+	"GetTransferReceiptsByParty": true, "GetUsageStats": true,
+	"GetWatchlist": true, "HealthCheck": true, "IsFeatureEnabled": true,
+	"ParseProductID": true, "PresentCredential": true, "ProductExists": true,
+	"QueryArchivedProduct": true, "QueryChargeback": true, "QueryDevice": true,
+	"QueryDisclosureRecord": true, "QueryDispute": true,
+	"QueryDockSlot": true, "QueryInvoice": true, "QueryLetterOfCredit": true,
+	"QueryLot": true, "QueryOracle": true, "QueryOrigin": true,
+	"QueryProduct": true, "QueryProductWithETag": true,
+	"QueryPromotion": true, "QueryProofOfDelivery": true,
+	"QueryPurchaseOrder": true, "QueryReturnableAsset": true,
+	"QueryRoutePlan": true, "QuerySale": true, "QueryShipment": true,
+	"QueryShipmentLeg": true, "QueryStateCheckpoint": true,
+	"QueryTransferReceipt": true, "QueryTransformation": true,
+	"ResolveDID": true, "RunSavedQuery": true, "TraceLot": true,
+	"Validate": true, "VerifyCredential": true, "VerifyDisclosure": true,
+	"VerifyInclusion": true, "VerifyIntegrity": true,
+	"VerifyOracleSignature": true, "VerifyPrivateDetails": true,
+	"VerifyTradeFinancePackage": true,
+}
+
+// ContractPause is the pause state of the contract
+type ContractPause struct {
+	Paused    bool     `json:"paused"`
+	Reason    string   `json:"reason"`
+	PausedAt  string   `json:"paused_at"`
+	PausedBy  []string `json:"paused_by"`
+	ResumedAt string   `json:"resumed_at"`
+	ResumedBy []string `json:"resumed_by"`
+}
+
+// PauseProposal collects admin approvals for pausing or resuming
+type PauseProposal struct {
+	Action     string   `json:"action"`
+	Reason     string   `json:"reason"`
+	Approvers  []string `json:"approvers"`
+	ProposedAt string   `json:"proposed_at"`
+}
+
+// PauseContract approves pausing the contract. Once pauseApprovalsRequired
+// different admins have approved within pauseProposalValidHours, every
+// transaction that can write is refused with a PAUSED error until the
+// contract is resumed; queries stay available. The reason of the first
+// approval is kept.
+func (s *SupplyChainContract) PauseContract(ctx contractapi.TransactionContextInterface, reason string) (*ContractPause, error) {
+	if reason == "" {
+		return nil, newError(ErrInvalidArgument, "a reason for pausing is required")
+	}
+	return s.approvePauseAction(ctx, PauseActionPause, reason)
+}
+
+// ResumeContract approves resuming a paused contract. It takes effect once
+// pauseApprovalsRequired different admins have approved.
+func (s *SupplyChainContract) ResumeContract(ctx contractapi.TransactionContextInterface) (*ContractPause, error) {
+	return s.approvePauseAction(ctx, PauseActionResume, "")
+}
+
+// GetPauseStatus returns the pause state of the contract. The pause is
+// shared by every program.
+func (s *SupplyChainContract) GetPauseStatus(ctx contractapi.TransactionContextInterface) (*ContractPause, error) {
+	channel := channelContext(ctx)
+	key, err := s.makeKey(channel, pauseObjectType, "contract")
+	if err != nil {
+		return nil, err
+	}
+
+	pause := ContractPause{PausedBy: []string{}, ResumedBy: []string{}}
+	if _, err := s.getState(channel, key, &pause); err != nil {
+		return nil, err
+	}
+	return &pause, nil
+}
+
+// GetPauseProposal returns the pending approvals for an action, pause or resume
+func (s *SupplyChainContract) GetPauseProposal(ctx contractapi.TransactionContextInterface, action string) (*PauseProposal, error) {
+	channel := channelContext(ctx)
+	key, err := s.makeKey(channel, pauseProposalObjectType, action)
+	if err != nil {
+		return nil, err
+	}
+
+	var proposal PauseProposal
+	exists, err := s.getState(channel, key, &proposal)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, newError(ErrNotFound, "no %s proposal is pending", action)
+	}
+	return &proposal, nil
+}
+
+// approvePauseAction adds the caller's approval to a pause or resume
+// proposal and applies it once it has enough approvals. The pause covers
+// every program, so only channel admins can approve, not program admins.
+func (s *SupplyChainContract) approvePauseAction(ctx contractapi.TransactionContextInterface, action, reason string) (*ContractPause, error) {
+	isChannelAdmin, err := s.hasCertRole(ctx, RoleAdmin)
+	if err != nil {
+		return nil, err
+	}
+	if !isChannelAdmin {
+		return nil, newError(ErrForbidden, "only channel admins can %s the contract", action)
+	}
+	pause, err := s.GetPauseStatus(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if pause.Paused == (action == PauseActionPause) {
+		return nil, newError(ErrConflict, "cannot %s: the contract is already in that state", action)
+	}
+
+	clientID, err := s.getClientID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+	txTime, err := s.getTxTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	channel := channelContext(ctx)
+	proposalKey, err := s.makeKey(channel, pauseProposalObjectType, action)
+	if err != nil {
+		return nil, err
+	}
+	var proposal PauseProposal
+	exists, err := s.getState(channel, proposalKey, &proposal)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		proposedAt, err := time.Parse(time.RFC3339, proposal.ProposedAt)
+		if err != nil {
+			return nil, err
+		}
+		if txTime.Sub(proposedAt) > pauseProposalValidHours*time.Hour {
+			exists = false
+		}
+	}
+	if !exists {
+		proposal = PauseProposal{Action: action, Reason: reason, Approvers: []string{}, ProposedAt: curTime}
+	}
+	for _, approver := range proposal.Approvers {
+		if approver == clientID {
+			return nil, newError(ErrConflict, "you have already approved this %s", action)
+		}
+	}
+	proposal.Approvers = append(proposal.Approvers, clientID)
+
+	if len(proposal.Approvers) < pauseApprovalsRequired {
+		if err := s.putState(channel, proposalKey, &proposal); err != nil {
+			return nil, err
+		}
+		return pause, nil
+	}
+
+	if err := channel.GetStub().DelState(proposalKey); err != nil {
+		return nil, wrapError(err, "failed to delete from world state")
+	}
+	if action == PauseActionPause {
+		pause.Paused = true
+		pause.Reason = proposal.Reason
+		pause.PausedAt = curTime
+		pause.PausedBy = proposal.Approvers
+	} else {
+		pause.Paused = false
+		pause.ResumedAt = curTime
+		pause.ResumedBy = proposal.Approvers
+	}
+	key, err := s.makeKey(channel, pauseObjectType, "contract")
+	if err != nil {
+		return nil, err
+	}
+	if err := s.putState(channel, key, pause); err != nil {
+		return nil, err
+	}
+	return pause, nil
+}
+
+// checkPaused refuses a transaction that may write while the contract is
+// paused. It reports whether the contract is paused for a read-only
+// transaction, whose writes are then refused by lockWrites.
+func (s *SupplyChainContract) checkPaused(ctx contractapi.TransactionContextInterface) (bool, error) {
+	function := transactionName(ctx)
+	if function == "PauseContract" || function == "ResumeContract" {
+		return false, nil
+	}
+
+	pause, err := s.GetPauseStatus(ctx)
+	if err != nil {
+		return false, err
+	}
+	if !pause.Paused {
+		return false, nil
+	}
+	if readOnlyTransactions[function] {
+		return true, nil
+	}
+	return false, newError(ErrPaused, "the contract has been paused since %s (%s); only queries are available", pause.PausedAt, pause.Reason)
+}
+
+// lockWrites makes every later write of the transaction fail, so a function
+// wrongly listed in readOnlyTransactions cannot write while paused
+func lockWrites(ctx contractapi.TransactionContextInterface) {
+	if c, ok := ctx.(*programContext); ok {
+		c.readOnly = true
+	}
+}
+
+// readOnlyStub refuses every write
+type readOnlyStub struct {
+	shim.ChaincodeStubInterface
+}
+
+func errPausedWrite() error {
+	return newError(ErrPaused, "the contract is paused and this query tried to write")
+}
+
+func (r *readOnlyStub) PutState(key string, value []byte) error {
+	return errPausedWrite()
+}
+
+func (r *readOnlyStub) DelState(key string) error {
+	return errPausedWrite()
+}
+
+func (r *readOnlyStub) SetStateValidationParameter(key string, ep []byte) error {
+	return errPausedWrite()
+}
+
+func (r *readOnlyStub) PutPrivateData(collection, key string, value []byte) error {
+	return errPausedWrite()
+}
+
+func (r *readOnlyStub) DelPrivateData(collection, key string) error {
+	return errPausedWrite()
+}
+
+func (r *readOnlyStub) PurgePrivateData(collection, key string) error {
+	return errPausedWrite()
+}
+
+func (r *readOnlyStub) SetPrivateDataValidationParameter(collection, key string, ep []byte) error {
+	return errPausedWrite()
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	privateDetailsObjectType = "privatedetails"
+
+	// privateDetailsTransientKey is the transient field carrying the
+	// PrivateProductDetails JSON
+	privateDetailsTransientKey = "details"
+)
+
+// PrivateProductDetails are an organization's commercially sensitive terms
+// for a product. They are kept in the organization's implicit private data
+// collection, so only its own peers hold them.
+type PrivateProductDetails struct {
+	ProductID     string  `json:"product_id"`
+	Price         float64 `json:"price"`
+	Currency      string  `json:"currency"`
+	SupplierTerms string  `json:"supplier_terms"`
+	Margin        float64 `json:"margin"`
+	InternalNotes string  `json:"internal_notes"`
+	UpdatedBy     string  `json:"updated_by"`
+	UpdatedAt     string  `json:"updated_at"`
+}
+
+// PrivateDetailsHash is the public trace of an organization's private
+// details for a product: the hex SHA-256 of the stored details, against
+// which a copy shared with another party can be checked
+type PrivateDetailsHash struct {
+	ProductID string `json:"product_id"`
+	MSPID     string `json:"msp_id"`
+	Hash      string `json:"hash"`
+	UpdatedAt string `json:"updated_at"`
+}
+
+// PutPrivateDetails stores the calling organization's private details for
+// a product. The details are passed as JSON in the transient field
+// "details" so they never appear in the transaction; only their hash is
+// written to the public ledger.
+func (s *SupplyChainContract) PutPrivateDetails(ctx contractapi.TransactionContextInterface, productID string) error {
+	if _, err := s.QueryProduct(ctx, productID); err != nil {
+		return err
+	}
+	stored, err := s.putTransientPrivateDetails(ctx, productID)
+	if err != nil {
+		return err
+	}
+	if !stored {
+		return newError(ErrInvalidArgument, "transient field %s is required", privateDetailsTransientKey)
+	}
+	return nil
+}
+
+// GetPrivateDetails returns the calling organization's private details for
+// a product. It must be evaluated on one of the organization's own peers.
+func (s *SupplyChainContract) GetPrivateDetails(ctx contractapi.TransactionContextInterface, productID string) (*PrivateProductDetails, error) {
+	mspID, err := s.getClientMSPID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	key, err := s.makeKey(ctx, privateDetailsObjectType, productID, mspID)
+	if err != nil {
+		return nil, err
+	}
+
+	collection := implicitCollection(mspID)
+	detailsJSON, err := ctx.GetStub().GetPrivateData(collection, key)
+	if err != nil {
+		return nil, wrapError(err, "failed to read private collection %s", collection)
+	}
+	if detailsJSON == nil {
+		return nil, newError(ErrNotFound, "%s has no private details for product %s", mspID, productID)
+	}
+	var details PrivateProductDetails
+	if err := json.Unmarshal(detailsJSON, &details); err != nil {
+		return nil, err
+	}
+	return &details, nil
+}
+
+// GetPrivateDetailsHash returns the public hash of an organization's
+// private details for a product
+func (s *SupplyChainContract) GetPrivateDetailsHash(ctx contractapi.TransactionContextInterface, productID, mspID string) (*PrivateDetailsHash, error) {
+	key, err := s.makeKey(ctx, privateDetailsObjectType, productID, mspID)
+	if err != nil {
+		return nil, err
+	}
+	var record PrivateDetailsHash
+	exists, err := s.getState(ctx, key, &record)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, newError(ErrNotFound, "%s has no private details for product %s", mspID, productID)
+	}
+	return &record, nil
+}
+
+// VerifyPrivateDetails reports whether detailsJSON, a copy of the details
+// an organization shared off-chain exactly as GetPrivateDetails returned
+// them, matches the hash on the public ledger
+func (s *SupplyChainContract) VerifyPrivateDetails(ctx contractapi.TransactionContextInterface, productID, mspID, detailsJSON string) (bool, error) {
+	record, err := s.GetPrivateDetailsHash(ctx, productID, mspID)
+	if err != nil {
+		return false, err
+	}
+	var details PrivateProductDetails
+	if err := json.Unmarshal([]byte(detailsJSON), &details); err != nil {
+		return false, newError(ErrInvalidArgument, "failed to parse details: %v", err)
+	}
+	canonical, err := json.Marshal(details)
+	if err != nil {
+		return false, err
+	}
+	return privateDetailsDigest(canonical) == record.Hash, nil
+}
+
+// putTransientPrivateDetails stores the private details passed in the
+// transient field "details", if any, for the calling organization and
+// reports whether there were any. CreateProduct and UpdateProduct accept
+// the field as well, so a product's price and internal notes can be set in
+// the same transaction without appearing in its arguments.
+func (s *SupplyChainContract) putTransientPrivateDetails(ctx contractapi.TransactionContextInterface, productID string) (bool, error) {
+	transient, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return false, wrapError(err, "failed to read transient data")
+	}
+	value, ok := transient[privateDetailsTransientKey]
+	if !ok {
+		return false, nil
+	}
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	var details PrivateProductDetails
+	if err := json.Unmarshal(value, &details); err != nil {
+		return false, newError(ErrInvalidArgument, "failed to parse transient field %s: %v", privateDetailsTransientKey, err)
+	}
+	if details.Price < 0 {
+		return false, newError(ErrInvalidArgument, "price must not be negative")
+	}
+	mspID, err := s.getClientMSPID(ctx)
+	if err != nil {
+		return false, err
+	}
+	clientID, err := s.getClientID(ctx)
+	if err != nil {
+		return false, err
+	}
+	details.ProductID = productID
+	details.UpdatedBy = clientID
+	details.UpdatedAt = curTime
+
+	detailsJSON, err := json.Marshal(details)
+	if err != nil {
+		return false, err
+	}
+	key, err := s.makeKey(ctx, privateDetailsObjectType, productID, mspID)
+	if err != nil {
+		return false, err
+	}
+	collection := implicitCollection(mspID)
+	if err := ctx.GetStub().PutPrivateData(collection, key, detailsJSON); err != nil {
+		return false, wrapError(err, "failed to put to private collection %s", collection)
+	}
+	return true, s.putState(ctx, key, &PrivateDetailsHash{
+		ProductID: productID,
+		MSPID:     mspID,
+		Hash:      privateDetailsDigest(detailsJSON),
+		UpdatedAt: curTime,
+	})
+}
+
+// implicitCollection is the name of an organization's implicit private
+// data collection, which every Fabric 2 channel provides without a
+// collection definition
+func implicitCollection(mspID string) string {
+	return "_implicit_org_" + mspID
+}
+
+// privateDetailsDigest is the hex SHA-256 of stored private details
+func privateDetailsDigest(detailsJSON []byte) string {
+	digest := sha256.Sum256(detailsJSON)
+	return hex.EncodeToString(digest[:])
+}
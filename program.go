@@ -0,0 +1,196 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	programObjectType     = "program"
+	programRoleObjectType = "programrole"
+)
+
+// Program is an independent supply-chain program sharing the deployment.
+// Its record and everything else it stores live under its own keys.
+type Program struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	CreatedBy string `json:"created_by"`
+	CreatedAt string `json:"created_at"`
+}
+
+// ProgramRoles are the roles an identity holds in one program
+type ProgramRoles struct {
+	ClientID   string   `json:"client_id"`
+	Roles      []string `json:"roles"`
+	AssignedBy string   `json:"assigned_by"`
+	AssignedAt string   `json:"assigned_at"`
+}
+
+// CreateProgram sets up the program selected in the transaction's transient
+// data. Only a channel admin, whose certificate carries the admin role, can
+// create programs; they go on to assign the program's roles.
+func (s *SupplyChainContract) CreateProgram(ctx contractapi.TransactionContextInterface, name string) (*Program, error) {
+	program, err := s.getProgram(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if program == "" {
+		return nil, newError(ErrInvalidArgument, "select the program to create in the transient field %s", programTransientKey)
+	}
+	if err := s.requireRole(ctx, RoleAdmin); err != nil {
+		return nil, err
+	}
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := s.makeKey(ctx, programObjectType)
+	if err != nil {
+		return nil, err
+	}
+	var existing Program
+	exists, err := s.getState(ctx, key, &existing)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return nil, newError(ErrAlreadyExists, "program %s already exists", program)
+	}
+	clientID, err := s.getClientID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	record := Program{ID: program, Name: name, CreatedBy: clientID, CreatedAt: curTime}
+	return &record, s.putState(ctx, key, &record)
+}
+
+// GetProgram returns the program the transaction runs in
+func (s *SupplyChainContract) GetProgram(ctx contractapi.TransactionContextInterface) (*Program, error) {
+	program, err := s.getProgram(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if program == "" {
+		return &Program{}, nil
+	}
+	record, err := s.queryProgram(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if record == nil {
+		return nil, newError(ErrNotFound, "program %s does not exist", program)
+	}
+	return record, nil
+}
+
+// AssignProgramRoles sets the roles (comma separated; empty removes them)
+// an identity holds in the selected program. Program admins assign roles.
+func (s *SupplyChainContract) AssignProgramRoles(ctx contractapi.TransactionContextInterface, clientID, roles string) error {
+	program, err := s.getProgram(ctx)
+	if err != nil {
+		return err
+	}
+	if program == "" {
+		return newError(ErrInvalidArgument, "roles in the default program come from certificate attributes")
+	}
+	if err := s.requireRole(ctx, RoleAdmin); err != nil {
+		return err
+	}
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	assignedBy, err := s.getClientID(ctx)
+	if err != nil {
+		return err
+	}
+
+	key, err := s.makeKey(ctx, programRoleObjectType, clientID)
+	if err != nil {
+		return err
+	}
+	var assigned []string
+	for _, role := range strings.Split(roles, ",") {
+		if role = strings.TrimSpace(role); role != "" {
+			assigned = append(assigned, role)
+		}
+	}
+	if len(assigned) == 0 {
+		if err := ctx.GetStub().DelState(key); err != nil {
+			return wrapError(err, "failed to delete from world state")
+		}
+		return nil
+	}
+	sort.Strings(assigned)
+	return s.putState(ctx, key, &ProgramRoles{ClientID: clientID, Roles: assigned, AssignedBy: assignedBy, AssignedAt: curTime})
+}
+
+// GetProgramRoles returns the roles an identity holds in the selected program
+func (s *SupplyChainContract) GetProgramRoles(ctx contractapi.TransactionContextInterface, clientID string) (*ProgramRoles, error) {
+	key, err := s.makeKey(ctx, programRoleObjectType, clientID)
+	if err != nil {
+		return nil, err
+	}
+	roles := ProgramRoles{ClientID: clientID, Roles: []string{}}
+	if _, err := s.getState(ctx, key, &roles); err != nil {
+		return nil, err
+	}
+	return &roles, nil
+}
+
+// queryProgram returns the record of the selected program, or nil if it has
+// not been created
+func (s *SupplyChainContract) queryProgram(ctx contractapi.TransactionContextInterface) (*Program, error) {
+	key, err := s.makeKey(ctx, programObjectType)
+	if err != nil {
+		return nil, err
+	}
+	var record Program
+	exists, err := s.getState(ctx, key, &record)
+	if err != nil || !exists {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// checkProgram refuses a transaction that selects an invalid or unknown
+// program, or a program the caller holds no role in. Channel admins may
+// act in every program, which is how a new program gets its first roles.
+func (s *SupplyChainContract) checkProgram(ctx contractapi.TransactionContextInterface) error {
+	program, err := s.getProgram(ctx)
+	if err != nil || program == "" {
+		return err
+	}
+	if transactionName(ctx) == "CreateProgram" {
+		return nil
+	}
+
+	record, err := s.queryProgram(ctx)
+	if err != nil {
+		return err
+	}
+	if record == nil {
+		return newError(ErrNotFound, "program %s does not exist", program)
+	}
+	isChannelAdmin, err := s.hasCertRole(ctx, RoleAdmin)
+	if err != nil || isChannelAdmin {
+		return err
+	}
+	clientID, err := s.getClientID(ctx)
+	if err != nil {
+		return err
+	}
+	roles, err := s.GetProgramRoles(ctx, clientID)
+	if err != nil {
+		return err
+	}
+	if len(roles.Roles) == 0 {
+		return newError(ErrForbidden, "caller is not a member of program %s", program)
+	}
+	return nil
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	promotionObjectType = "promotion"
+	rebateObjectType    = "rebate"
+)
+
+// Rebate entitlement statuses
+const (
+	RebateAccrued = "Accrued"
+	RebateClaimed = "Claimed"
+	RebateSettled = "Settled"
+)
+
+// Promotion pays RebatePerUnit to the retailer for every qualifying sale of
+// one of the SKUs (product models) sold in [Start, End). Manufacturer, when
+// set, limits the promotion to that manufacturer's products.
+type Promotion struct {
+	ID            string   `json:"id"`
+	Name          string   `json:"name"`
+	Manufacturer  string   `json:"manufacturer"`
+	SKUs          []string `json:"skus"`
+	Start         string   `json:"start"`
+	End           string   `json:"end"`
+	RebatePerUnit float64  `json:"rebate_per_unit"`
+	CreatedBy     string   `json:"created_by"`
+	CreatedAt     string   `json:"created_at"`
+}
+
+// RebateEntitlement is the rebate a retailer has earned on one sale
+type RebateEntitlement struct {
+	PromotionID string  `json:"promotion_id"`
+	ProductID   string  `json:"product_id"`
+	Retailer    string  `json:"retailer"`
+	Amount      float64 `json:"amount"`
+	Status      string  `json:"status"`
+	SoldAt      string  `json:"sold_at"`
+	ClaimedAt   string  `json:"claimed_at"`
+	SettledAt   string  `json:"settled_at"`
+	SettledBy   string  `json:"settled_by"`
+	CreatedAt   string  `json:"created_at"`
+}
+
+// RebateStatement totals a retailer's entitlements by status
+type RebateStatement struct {
+	Retailer     string               `json:"retailer"`
+	Accrued      float64              `json:"accrued"`
+	Claimed      float64              `json:"claimed"`
+	Settled      float64              `json:"settled"`
+	Entitlements []*RebateEntitlement `json:"entitlements"`
+}
+
+// CreatePromotion defines a rebate promotion. start and end are RFC3339.
+func (s *SupplyChainContract) CreatePromotion(ctx contractapi.TransactionContextInterface, id, name, manufacturer string, skus []string, start, end string, rebatePerUnit float64) error {
+	if err := s.requireRole(ctx, RoleAdmin); err != nil {
+		return err
+	}
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	if id == "" {
+		return newError(ErrInvalidArgument, "promotion ID must not be empty")
+	}
+	if len(skus) == 0 {
+		return newError(ErrInvalidArgument, "a promotion needs at least one eligible SKU")
+	}
+	if rebatePerUnit <= 0 {
+		return newError(ErrInvalidArgument, "rebate per unit must be positive")
+	}
+	startTime, err := time.Parse(time.RFC3339, start)
+	if err != nil {
+		return newError(ErrInvalidArgument, "start must be an RFC3339 timestamp: %v", err)
+	}
+	endTime, err := time.Parse(time.RFC3339, end)
+	if err != nil {
+		return newError(ErrInvalidArgument, "end must be an RFC3339 timestamp: %v", err)
+	}
+	if !endTime.After(startTime) {
+		return newError(ErrInvalidArgument, "promotion must end after it starts")
+	}
+
+	key, err := s.makeKey(ctx, promotionObjectType, id)
+	if err != nil {
+		return err
+	}
+	var existing Promotion
+	exists, err := s.getState(ctx, key, &existing)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return newError(ErrAlreadyExists, "promotion with ID %s already exists", id)
+	}
+	clientID, err := s.getClientID(ctx)
+	if err != nil {
+		return err
+	}
+
+	promotion := Promotion{
+		ID:            id,
+		Name:          name,
+		Manufacturer:  manufacturer,
+		SKUs:          mergeIDs(skus, nil),
+		Start:         startTime.UTC().Format(time.RFC3339),
+		End:           endTime.UTC().Format(time.RFC3339),
+		RebatePerUnit: rebatePerUnit,
+		CreatedBy:     clientID,
+		CreatedAt:     curTime,
+	}
+	return s.putState(ctx, key, &promotion)
+}
+
+// QueryPromotion retrieves a single promotion from the ledger by ID
+func (s *SupplyChainContract) QueryPromotion(ctx contractapi.TransactionContextInterface, id string) (*Promotion, error) {
+	key, err := s.makeKey(ctx, promotionObjectType, id)
+	if err != nil {
+		return nil, err
+	}
+
+	var promotion Promotion
+	exists, err := s.getState(ctx, key, &promotion)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, newError(ErrNotFound, "promotion with ID %s does not exist", id)
+	}
+
+	return &promotion, nil
+}
+
+// GetPromotions returns every promotion
+func (s *SupplyChainContract) GetPromotions(ctx contractapi.TransactionContextInterface) ([]*Promotion, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(promotionObjectType, []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	promotions := []*Promotion{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var promotion Promotion
+		if err := json.Unmarshal(queryResponse.Value, &promotion); err != nil {
+			return nil, err
+		}
+		promotions = append(promotions, &promotion)
+	}
+
+	return promotions, nil
+}
+
+// GetRebateStatement returns a retailer's rebate entitlements, optionally
+// narrowed to one promotion, with totals per status
+func (s *SupplyChainContract) GetRebateStatement(ctx contractapi.TransactionContextInterface, retailer, promotionID string) (*RebateStatement, error) {
+	entitlements, err := s.getRebateEntitlements(ctx, retailer, promotionID)
+	if err != nil {
+		return nil, err
+	}
+
+	statement := &RebateStatement{Retailer: retailer, Entitlements: entitlements}
+	for _, entitlement := range entitlements {
+		switch entitlement.Status {
+		case RebateAccrued:
+			statement.Accrued += entitlement.Amount
+		case RebateClaimed:
+			statement.Claimed += entitlement.Amount
+		case RebateSettled:
+			statement.Settled += entitlement.Amount
+		}
+	}
+	return statement, nil
+}
+
+// ClaimRebates claims every accrued entitlement of the calling organization
+// under a promotion and returns how many were claimed
+func (s *SupplyChainContract) ClaimRebates(ctx contractapi.TransactionContextInterface, promotionID string) (int, error) {
+	mspID, err := s.getClientMSPID(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return s.advanceRebates(ctx, mspID, promotionID, RebateAccrued, RebateClaimed)
+}
+
+// SettleRebates marks every claimed entitlement of a retailer under a
+// promotion as paid and returns how many were settled
+func (s *SupplyChainContract) SettleRebates(ctx contractapi.TransactionContextInterface, retailer, promotionID string) (int, error) {
+	if err := s.requireRole(ctx, RoleAdmin); err != nil {
+		return 0, err
+	}
+	return s.advanceRebates(ctx, retailer, promotionID, RebateClaimed, RebateSettled)
+}
+
+// advanceRebates moves a retailer's entitlements under a promotion from one
+// status to the next
+func (s *SupplyChainContract) advanceRebates(ctx contractapi.TransactionContextInterface, retailer, promotionID, from, to string) (int, error) {
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := s.QueryPromotion(ctx, promotionID); err != nil {
+		return 0, err
+	}
+	clientID, err := s.getClientID(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	entitlements, err := s.getRebateEntitlements(ctx, retailer, promotionID)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, entitlement := range entitlements {
+		if entitlement.Status != from {
+			continue
+		}
+		entitlement.Status = to
+		if to == RebateClaimed {
+			entitlement.ClaimedAt = curTime
+		} else {
+			entitlement.SettledAt = curTime
+			entitlement.SettledBy = clientID
+		}
+		if err := s.putRebateEntitlement(ctx, entitlement); err != nil {
+			return 0, err
+		}
+		count++
+	}
+	if count == 0 {
+		return 0, newError(ErrNotFound, "%s has no %s rebates under promotion %s", retailer, from, promotionID)
+	}
+	return count, nil
+}
+
+// accrueRebates creates an entitlement for every promotion the sale qualifies for
+func (s *SupplyChainContract) accrueRebates(ctx contractapi.TransactionContextInterface, sale *Sale) error {
+	promotions, err := s.GetPromotions(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, promotion := range promotions {
+		if sale.SoldAt < promotion.Start || sale.SoldAt >= promotion.End {
+			continue
+		}
+		if promotion.Manufacturer != "" && promotion.Manufacturer != sale.Manufacturer {
+			continue
+		}
+		if !containsString(promotion.SKUs, sale.Model) {
+			continue
+		}
+
+		entitlement := RebateEntitlement{
+			PromotionID: promotion.ID,
+			ProductID:   sale.ProductID,
+			Retailer:    sale.Retailer,
+			Amount:      promotion.RebatePerUnit,
+			Status:      RebateAccrued,
+			SoldAt:      sale.SoldAt,
+			CreatedAt:   sale.CreatedAt,
+		}
+		if err := s.putRebateEntitlement(ctx, &entitlement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// putRebateEntitlement writes an entitlement under its retailer and promotion
+func (s *SupplyChainContract) putRebateEntitlement(ctx contractapi.TransactionContextInterface, entitlement *RebateEntitlement) error {
+	key, err := s.makeKey(ctx, rebateObjectType, entitlement.Retailer, entitlement.PromotionID, entitlement.ProductID)
+	if err != nil {
+		return err
+	}
+	return s.putState(ctx, key, entitlement)
+}
+
+// getRebateEntitlements lists a retailer's entitlements by sale time,
+// optionally narrowed to one promotion
+func (s *SupplyChainContract) getRebateEntitlements(ctx contractapi.TransactionContextInterface, retailer, promotionID string) ([]*RebateEntitlement, error) {
+	prefix := []string{retailer}
+	if promotionID != "" {
+		prefix = append(prefix, promotionID)
+	}
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(rebateObjectType, prefix)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	entitlements := []*RebateEntitlement{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var entitlement RebateEntitlement
+		if err := json.Unmarshal(queryResponse.Value, &entitlement); err != nil {
+			return nil, err
+		}
+		entitlements = append(entitlements, &entitlement)
+	}
+
+	// Keys are ordered by promotion and product, not time
+	sort.SliceStable(entitlements, func(i, j int) bool {
+		return entitlements[i].SoldAt < entitlements[j].SoldAt
+	})
+	return entitlements, nil
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	savedQueryObjectType        = "savedquery"
+	savedQueryVersionObjectType = "savedqueryversion"
+	savedQueryVersionLayout     = "%06d"
+
+	// FilterOwnerSelf in a filter's owner field stands for the MSP of
+	// whoever runs the query
+	FilterOwnerSelf = "$me"
+)
+
+// Who can see and run a saved query
+const (
+	QueryVisibilityPrivate = "private"
+	QueryVisibilityOrg     = "org"
+)
+
+// ProductFilter selects products. Empty fields match everything and
+// UpdatedAfter and UpdatedBefore are RFC3339 bounds on the last update.
+// ShipmentID matches the products carried by a shipment.
+type ProductFilter struct {
+	Status        string `json:"status"`
+	Owner         string `json:"owner"`
+	Category      string `json:"category"`
+	Manufacturer  string `json:"manufacturer"`
+	LotID         string `json:"lot_id"`
+	ShipmentID    string `json:"shipment_id"`
+	UpdatedAfter  string `json:"updated_after"`
+	UpdatedBefore string `json:"updated_before"`
+}
+
+// SavedQuery is a named product filter kept in the creating organization's
+// namespace. Saving under an existing name creates a new version; earlier
+// versions stay readable through GetSavedQueryVersions.
+type SavedQuery struct {
+	Name       string        `json:"name"`
+	MSPID      string        `json:"msp_id"`
+	Visibility string        `json:"visibility"`
+	Filter     ProductFilter `json:"filter"`
+	Version    int           `json:"version"`
+	CreatedBy  string        `json:"created_by"`
+	CreatedAt  string        `json:"created_at"`
+	UpdatedAt  string        `json:"updated_at"`
+}
+
+// SavedQueryPage is one page of RunSavedQuery results. Bookmark is empty
+// once the last page has been read; a page can hold fewer matches than the
+// page size since the filter is applied after paging.
+type SavedQueryPage struct {
+	Name     string     `json:"name"`
+	Version  int        `json:"version"`
+	Products []*Product `json:"products"`
+	Bookmark string     `json:"bookmark"`
+}
+
+// SaveQuery stores a product filter (filterJSON, a ProductFilter) under a
+// name in the caller's organization. A private query can only be seen by the
+// identity that created it, an org query by every member of the
+// organization. Only the creator can save new versions.
+func (s *SupplyChainContract) SaveQuery(ctx contractapi.TransactionContextInterface, name, filterJSON, visibility string) (*SavedQuery, error) {
+	if name == "" {
+		return nil, newError(ErrInvalidArgument, "query name must not be empty")
+	}
+	if visibility != QueryVisibilityPrivate && visibility != QueryVisibilityOrg {
+		return nil, newError(ErrInvalidArgument, "visibility must be %s or %s", QueryVisibilityPrivate, QueryVisibilityOrg)
+	}
+	filter, err := parseProductFilter(filterJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+	clientID, err := s.getClientID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	mspID, err := s.getClientMSPID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := s.makeKey(ctx, savedQueryObjectType, mspID, name)
+	if err != nil {
+		return nil, err
+	}
+	query := SavedQuery{
+		Name:      name,
+		MSPID:     mspID,
+		Version:   1,
+		CreatedBy: clientID,
+		CreatedAt: curTime,
+	}
+	var previous SavedQuery
+	exists, err := s.getState(ctx, key, &previous)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		if previous.CreatedBy != clientID {
+			return nil, newError(ErrForbidden, "query %s belongs to another member of %s", name, mspID)
+		}
+		query.Version = previous.Version + 1
+		query.CreatedAt = previous.CreatedAt
+	}
+	query.Visibility = visibility
+	query.Filter = *filter
+	query.UpdatedAt = curTime
+
+	if err := s.putState(ctx, key, &query); err != nil {
+		return nil, err
+	}
+	versionKey, err := s.makeKey(ctx, savedQueryVersionObjectType, mspID, name, fmt.Sprintf(savedQueryVersionLayout, query.Version))
+	if err != nil {
+		return nil, err
+	}
+	if err := s.putState(ctx, versionKey, &query); err != nil {
+		return nil, err
+	}
+
+	return &query, nil
+}
+
+// GetSavedQuery returns the latest version of a query saved in the
+// caller's organization
+func (s *SupplyChainContract) GetSavedQuery(ctx contractapi.TransactionContextInterface, name string) (*SavedQuery, error) {
+	mspID, err := s.getClientMSPID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	key, err := s.makeKey(ctx, savedQueryObjectType, mspID, name)
+	if err != nil {
+		return nil, err
+	}
+
+	var query SavedQuery
+	exists, err := s.getState(ctx, key, &query)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		visible, err := s.canSeeQuery(ctx, &query)
+		if err != nil {
+			return nil, err
+		}
+		exists = visible
+	}
+	if !exists {
+		return nil, newError(ErrNotFound, "saved query %s does not exist", name)
+	}
+
+	return &query, nil
+}
+
+// GetSavedQueryVersions returns every version of a saved query, oldest first
+func (s *SupplyChainContract) GetSavedQueryVersions(ctx contractapi.TransactionContextInterface, name string) ([]*SavedQuery, error) {
+	latest, err := s.GetSavedQuery(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := []*SavedQuery{}
+	for version := 1; version <= latest.Version; version++ {
+		key, err := s.makeKey(ctx, savedQueryVersionObjectType, latest.MSPID, name, fmt.Sprintf(savedQueryVersionLayout, version))
+		if err != nil {
+			return nil, err
+		}
+		var query SavedQuery
+		exists, err := s.getState(ctx, key, &query)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			versions = append(versions, &query)
+		}
+	}
+	return versions, nil
+}
+
+// RunSavedQuery runs the latest version of a saved query over one page of
+// products. Pass the returned bookmark to read the next page.
+func (s *SupplyChainContract) RunSavedQuery(ctx contractapi.TransactionContextInterface, name string, pageSize int, bookmark string) (*SavedQueryPage, error) {
+	if err := s.checkPageSize(ctx, pageSize); err != nil {
+		return nil, err
+	}
+	query, err := s.GetSavedQuery(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	filter := query.Filter
+	if filter.Owner == FilterOwnerSelf {
+		if filter.Owner, err = s.getClientMSPID(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	resultsIterator, metadata, err := ctx.GetStub().GetStateByRangeWithPagination("", "", int32(pageSize), bookmark)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	page := &SavedQueryPage{Name: query.Name, Version: query.Version, Products: []*Product{}, Bookmark: metadata.GetBookmark()}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var product Product
+		if err := decodeProduct(queryResponse.Value, &product); err != nil {
+			return nil, err
+		}
+		match, err := s.filterMatches(ctx, &filter, &product)
+		if err != nil {
+			return nil, err
+		}
+		if match {
+			page.Products = append(page.Products, &product)
+		}
+	}
+
+	return page, nil
+}
+
+// canSeeQuery reports whether the caller may read a query of its own organization
+func (s *SupplyChainContract) canSeeQuery(ctx contractapi.TransactionContextInterface, query *SavedQuery) (bool, error) {
+	if query.Visibility == QueryVisibilityOrg {
+		return true, nil
+	}
+	clientID, err := s.getClientID(ctx)
+	if err != nil {
+		return false, err
+	}
+	return clientID == query.CreatedBy, nil
+}
+
+// parseProductFilter reads a ProductFilter from JSON. Product timestamps are
+// UTC, so the time bounds are normalized to UTC for string comparison.
+func parseProductFilter(filterJSON string) (*ProductFilter, error) {
+	var filter ProductFilter
+	if err := json.Unmarshal([]byte(filterJSON), &filter); err != nil {
+		return nil, newError(ErrInvalidArgument, "failed to parse filter: %v", err)
+	}
+	for _, bound := range []*string{&filter.UpdatedAfter, &filter.UpdatedBefore} {
+		if *bound == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, *bound)
+		if err != nil {
+			return nil, newError(ErrInvalidArgument, "filter times must be RFC3339 timestamps: %v", err)
+		}
+		*bound = t.UTC().Format(time.RFC3339)
+	}
+	return &filter, nil
+}
+
+// filterMatches applies a filter, including the shipment condition, which
+// needs the ledger
+func (s *SupplyChainContract) filterMatches(ctx contractapi.TransactionContextInterface, filter *ProductFilter, product *Product) (bool, error) {
+	if !filter.matches(product) {
+		return false, nil
+	}
+	if filter.ShipmentID == "" {
+		return true, nil
+	}
+	return s.indexKeyExists(ctx, productShipmentIndexName, product.ID, filter.ShipmentID)
+}
+
+// matches reports whether a product passes every set field of the filter
+// other than ShipmentID. Archived products never match.
+func (f *ProductFilter) matches(product *Product) bool {
+	switch {
+	case product.Archived:
+		return false
+	case f.Status != "" && product.Status != f.Status:
+		return false
+	case f.Owner != "" && product.Owner != f.Owner:
+		return false
+	case f.Category != "" && product.Category != f.Category:
+		return false
+	case f.Manufacturer != "" && product.Manufacturer != f.Manufacturer:
+		return false
+	case f.LotID != "" && product.LotID != f.LotID:
+		return false
+	case f.UpdatedAfter != "" && product.UpdatedAt <= f.UpdatedAfter:
+		return false
+	case f.UpdatedBefore != "" && product.UpdatedAt >= f.UpdatedBefore:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"sort"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	quotaObjectType = "quota"
+	usageObjectType = "usage"
+
+	// usageShards spreads each day's counter over several keys so that
+	// concurrent transactions of one organization rarely touch the same key
+	usageShards = 16
+)
+
+// EventQuotaExceeded is emitted when a transaction goes over a quota in
+// warn mode. The transaction's own event, if it sets one, takes precedence.
+const EventQuotaExceeded = "QuotaExceeded"
+
+// What happens when a quota is exceeded
+const (
+	QuotaModeReject = "reject"
+	QuotaModeWarn   = "warn"
+)
+
+// Quota limits the number of transactions of one method an organization
+// may submit per UTC day
+type Quota struct {
+	MSPID      string `json:"msp_id"`
+	Method     string `json:"method"`
+	DailyLimit int    `json:"daily_limit"`
+	Mode       string `json:"mode"`
+	SetBy      string `json:"set_by"`
+	UpdatedAt  string `json:"updated_at"`
+}
+
+// MethodUsage is the number of transactions of one method on one day
+type MethodUsage struct {
+	Method string `json:"method"`
+	Count  int    `json:"count"`
+}
+
+// QuotaExceededEvent is the payload of EventQuotaExceeded
+type QuotaExceededEvent struct {
+	MSPID      string `json:"msp_id"`
+	Method     string `json:"method"`
+	Date       string `json:"date"`
+	Count      int    `json:"count"`
+	DailyLimit int    `json:"daily_limit"`
+	TxID       string `json:"tx_id"`
+}
+
+// usageCounter is one shard of an organization's daily count for a method
+type usageCounter struct {
+	Count int `json:"count"`
+}
+
+// SetOrgQuota sets how many transactions of a method an organization may
+// submit per day, and whether going over rejects the transaction or only
+// emits EventQuotaExceeded. A zero limit removes the quota.
+func (s *SupplyChainContract) SetOrgQuota(ctx contractapi.TransactionContextInterface, mspID, method string, dailyLimit int, mode string) error {
+	if err := s.requireRole(ctx, RoleAdmin); err != nil {
+		return err
+	}
+	if mspID == "" || method == "" {
+		return newError(ErrInvalidArgument, "MSP ID and method must not be empty")
+	}
+	if dailyLimit < 0 {
+		return newError(ErrInvalidArgument, "daily limit must not be negative")
+	}
+	if mode != QuotaModeReject && mode != QuotaModeWarn {
+		return newError(ErrInvalidArgument, "quota mode must be %s or %s", QuotaModeReject, QuotaModeWarn)
+	}
+
+	key, err := s.makeKey(ctx, quotaObjectType, mspID, method)
+	if err != nil {
+		return err
+	}
+	if dailyLimit == 0 {
+		if err := ctx.GetStub().DelState(key); err != nil {
+			return wrapError(err, "failed to delete from world state")
+		}
+		return nil
+	}
+
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	clientID, err := s.getClientID(ctx)
+	if err != nil {
+		return err
+	}
+	quota := Quota{MSPID: mspID, Method: method, DailyLimit: dailyLimit, Mode: mode, SetBy: clientID, UpdatedAt: curTime}
+	return s.putState(ctx, key, &quota)
+}
+
+// GetOrgQuotas returns the quotas configured for an organization
+func (s *SupplyChainContract) GetOrgQuotas(ctx contractapi.TransactionContextInterface, mspID string) ([]*Quota, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(quotaObjectType, []string{mspID})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	quotas := []*Quota{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var quota Quota
+		if err := json.Unmarshal(queryResponse.Value, &quota); err != nil {
+			return nil, err
+		}
+		quotas = append(quotas, &quota)
+	}
+
+	return quotas, nil
+}
+
+// GetOrgUsage returns an organization's transaction counts per method on a
+// day (YYYY-MM-DD), ordered by method
+func (s *SupplyChainContract) GetOrgUsage(ctx contractapi.TransactionContextInterface, mspID, date string) ([]MethodUsage, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(usageObjectType, []string{mspID, date})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	counts := make(map[string]int)
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, keyParts, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, err
+		}
+		if len(keyParts) < 3 {
+			continue
+		}
+
+		var counter usageCounter
+		if err := json.Unmarshal(queryResponse.Value, &counter); err != nil {
+			return nil, err
+		}
+		counts[keyParts[2]] += counter.Count
+	}
+
+	usage := []MethodUsage{}
+	for method, count := range counts {
+		usage = append(usage, MethodUsage{Method: method, Count: count})
+	}
+	sort.Slice(usage, func(i, j int) bool {
+		return usage[i].Method < usage[j].Method
+	})
+	return usage, nil
+}
+
+// accountUsage counts the current transaction against the caller's
+// organization and enforces its quota for the method. Without a quota only
+// the shard the transaction hashes to is read and written. With one, every
+// shard is read to total the day, so concurrent transactions of a quota'd
+// method may fail validation and be resubmitted; the quota is soft in that
+// transactions endorsed at the same time can all pass it.
+func (s *SupplyChainContract) accountUsage(ctx contractapi.TransactionContextInterface) error {
+	function := transactionName(ctx)
+	mspID, err := s.getClientMSPID(ctx)
+	if err != nil {
+		return err
+	}
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	date := curTime[:receiptDateLayoutLength]
+
+	txID := ctx.GetStub().GetTxID()
+	shard := usageShard(txID)
+
+	key, err := s.makeKey(ctx, usageObjectType, mspID, date, function, shard)
+	if err != nil {
+		return err
+	}
+	var counter usageCounter
+	if _, err := s.getState(ctx, key, &counter); err != nil {
+		return err
+	}
+	counter.Count++
+	if err := s.putState(ctx, key, &counter); err != nil {
+		return err
+	}
+
+	quotaKey, err := s.makeKey(ctx, quotaObjectType, mspID, function)
+	if err != nil {
+		return err
+	}
+	var quota Quota
+	exists, err := s.getState(ctx, quotaKey, &quota)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return nil
+	}
+	total := counter.Count
+	for i := 0; i < usageShards; i++ {
+		other := fmt.Sprintf("%02d", i)
+		if other == shard {
+			continue
+		}
+		otherKey, err := s.makeKey(ctx, usageObjectType, mspID, date, function, other)
+		if err != nil {
+			return err
+		}
+		var otherCounter usageCounter
+		if _, err := s.getState(ctx, otherKey, &otherCounter); err != nil {
+			return err
+		}
+		total += otherCounter.Count
+	}
+	if total <= quota.DailyLimit {
+		return nil
+	}
+
+	if quota.Mode == QuotaModeReject {
+		return newError(WarningQuotaExceeded, "%s has used its daily quota of %d %s transactions", mspID, quota.DailyLimit, function)
+	}
+	addWarning(ctx, WarningQuotaExceeded, "", "%s is over its daily quota of %d %s transactions", mspID, quota.DailyLimit, function)
+	event := QuotaExceededEvent{MSPID: mspID, Method: function, Date: date, Count: total, DailyLimit: quota.DailyLimit, TxID: txID}
+	eventJSON, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if err := ctx.GetStub().SetEvent(EventQuotaExceeded, eventJSON); err != nil {
+		return wrapError(err, "failed to set event")
+	}
+	return nil
+}
+
+// usageShard picks the counter shard a transaction increments
+func usageShard(txID string) string {
+	h := fnv.New32a()
+	h.Write([]byte(txID))
+	return fmt.Sprintf("%02d", h.Sum32()%usageShards)
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	reasonCodeObjectType  = "reason"
+	auditRecordObjectType = "audit"
+)
+
+// Sensitive actions that must carry a reason code
+const (
+	AuditStatusDowngrade   = "StatusDowngrade"
+	AuditOwnershipReversal = "OwnershipReversal"
+	AuditDeletion          = "Deletion"
+)
+
+// productStatusRank orders the forward lifecycle of a product. Moving to a
+// status of lower rank is a downgrade; statuses outside the lifecycle (such
+// as Recalled) are never treated as one.
+var productStatusRank = map[string]int{
+	"Manufactured":   0,
+	"QualityChecked": 1,
+	"Shipped":        2,
+	"InTransit":      3,
+	"Delivered":      4,
+	"Sold":           5,
+}
+
+// isValidProductStatus reports whether status is part of the product
+// lifecycle or one of the statuses outside it
+func isValidProductStatus(status string) bool {
+	_, inLifecycle := productStatusRank[status]
+	return inLifecycle || status == "Recalled" || status == productStatusConsumed
+}
+
+// ReasonCode is an entry in the admin-managed list of reasons that may be
+// given for sensitive changes
+type ReasonCode struct {
+	Code        string `json:"code"`
+	Description string `json:"description"`
+	Active      bool   `json:"active"`
+	UpdatedAt   string `json:"updated_at"`
+}
+
+// AuditRecord stores the justification given for a sensitive change
+type AuditRecord struct {
+	ProductID  string `json:"product_id"`
+	TxID       string `json:"tx_id"`
+	Action     string `json:"action"`
+	ReasonCode string `json:"reason_code"`
+	ReasonNote string `json:"reason_note"`
+	Actor      string `json:"actor"`
+	Timestamp  string `json:"timestamp"`
+}
+
+// changeReason is the reason code and note supplied with a sensitive change
+type changeReason struct {
+	Code string
+	Note string
+}
+
+// AddReasonCode adds or reactivates a reason code in the managed list
+func (s *SupplyChainContract) AddReasonCode(ctx contractapi.TransactionContextInterface, code, description string) error {
+	if err := s.requireRole(ctx, RoleAdmin); err != nil {
+		return err
+	}
+	if code == "" {
+		return newError(ErrInvalidArgument, "reason code must not be empty")
+	}
+
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	reasonCode := ReasonCode{Code: code, Description: description, Active: true, UpdatedAt: curTime}
+	key, err := s.makeKey(ctx, reasonCodeObjectType, code)
+	if err != nil {
+		return err
+	}
+	return s.putState(ctx, key, &reasonCode)
+}
+
+// RetireReasonCode stops a reason code from being accepted for new changes.
+// Existing audit records that cite it are unaffected.
+func (s *SupplyChainContract) RetireReasonCode(ctx contractapi.TransactionContextInterface, code string) error {
+	if err := s.requireRole(ctx, RoleAdmin); err != nil {
+		return err
+	}
+
+	key, err := s.makeKey(ctx, reasonCodeObjectType, code)
+	if err != nil {
+		return err
+	}
+	var reasonCode ReasonCode
+	exists, err := s.getState(ctx, key, &reasonCode)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return newError(ErrNotFound, "reason code %s does not exist", code)
+	}
+
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	reasonCode.Active = false
+	reasonCode.UpdatedAt = curTime
+	return s.putState(ctx, key, &reasonCode)
+}
+
+// GetReasonCodes returns the managed list of reason codes, including retired ones
+func (s *SupplyChainContract) GetReasonCodes(ctx contractapi.TransactionContextInterface) ([]*ReasonCode, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(reasonCodeObjectType, []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var codes []*ReasonCode
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var code ReasonCode
+		if err := json.Unmarshal(queryResponse.Value, &code); err != nil {
+			return nil, err
+		}
+		codes = append(codes, &code)
+	}
+
+	return codes, nil
+}
+
+// GetProductAuditTrail returns the audit records of every sensitive change made to a product
+func (s *SupplyChainContract) GetProductAuditTrail(ctx contractapi.TransactionContextInterface, id string) ([]*AuditRecord, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(auditRecordObjectType, []string{id})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var records []*AuditRecord
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var record AuditRecord
+		if err := json.Unmarshal(queryResponse.Value, &record); err != nil {
+			return nil, err
+		}
+		records = append(records, &record)
+	}
+
+	// Keys are ordered by transaction ID, not time
+	sort.SliceStable(records, func(i, j int) bool { return records[i].Timestamp < records[j].Timestamp })
+
+	return records, nil
+}
+
+// requireReason checks that a sensitive action carries an active reason code and a note
+func (s *SupplyChainContract) requireReason(ctx contractapi.TransactionContextInterface, action string, reason *changeReason) error {
+	if reason == nil || reason.Code == "" {
+		return newError(ErrInvalidArgument, "%s requires a reason code", action)
+	}
+	if reason.Note == "" {
+		return newError(ErrInvalidArgument, "%s requires a note explaining the change", action)
+	}
+
+	key, err := s.makeKey(ctx, reasonCodeObjectType, reason.Code)
+	if err != nil {
+		return err
+	}
+	var reasonCode ReasonCode
+	exists, err := s.getState(ctx, key, &reasonCode)
+	if err != nil {
+		return err
+	}
+	if !exists || !reasonCode.Active {
+		return newError(ErrInvalidArgument, "reason code %s is not in the list of active reason codes", reason.Code)
+	}
+	return nil
+}
+
+// putAuditRecord stores the reason given for a sensitive action on a product
+func (s *SupplyChainContract) putAuditRecord(ctx contractapi.TransactionContextInterface, productID, action string, reason *changeReason) error {
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	clientID, err := s.getClientID(ctx)
+	if err != nil {
+		return err
+	}
+
+	record := AuditRecord{
+		ProductID:  productID,
+		TxID:       ctx.GetStub().GetTxID(),
+		Action:     action,
+		ReasonCode: reason.Code,
+		ReasonNote: reason.Note,
+		Actor:      clientID,
+		Timestamp:  curTime,
+	}
+	key, err := s.makeKey(ctx, auditRecordObjectType, productID, record.TxID, action)
+	if err != nil {
+		return err
+	}
+	return s.putState(ctx, key, &record)
+}
+
+// isStatusDowngrade reports whether moving from one lifecycle status to another goes backwards
+func isStatusDowngrade(from, to string) bool {
+	fromRank, fromKnown := productStatusRank[from]
+	toRank, toKnown := productStatusRank[to]
+	return fromKnown && toKnown && toRank < fromRank
+}
+
+// isOwnershipReversal reports whether a transfer hands the product back to its previous owner
+func isOwnershipReversal(product *Product, newOwner string) bool {
+	return product.PreviousOwner != "" && newOwner == product.PreviousOwner && newOwner != product.Owner
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	receiptObjectType       = "receipt"
+	receiptPartyIndexName   = "receipt~party~date"
+	receiptDateIndexName    = "receipt~date"
+	receiptDateLayoutLength = len("2006-01-02")
+)
+
+// TransferReceipt is written for every completed ownership transfer so
+// transfer reporting does not need to walk product histories
+type TransferReceipt struct {
+	ID        string `json:"id"`
+	ProductID string `json:"product_id"`
+	FromOwner string `json:"from_owner"`
+	ToOwner   string `json:"to_owner"`
+	PriceRef  string `json:"price_ref"`
+	// OrderID is the purchase order that authorized the transfer, if any
+	OrderID string `json:"order_id"`
+	// Amount is the transfer price, when one was given
+	Amount    float64 `json:"amount"`
+	TxID      string  `json:"tx_id"`
+	Timestamp string  `json:"timestamp"`
+}
+
+// QueryTransferReceipt retrieves a single transfer receipt from the ledger by ID
+func (s *SupplyChainContract) QueryTransferReceipt(ctx contractapi.TransactionContextInterface, id string) (*TransferReceipt, error) {
+	key, err := s.makeKey(ctx, receiptObjectType, id)
+	if err != nil {
+		return nil, err
+	}
+
+	var receipt TransferReceipt
+	exists, err := s.getState(ctx, key, &receipt)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, newError(ErrNotFound, "transfer receipt with ID %s does not exist", id)
+	}
+
+	return &receipt, nil
+}
+
+// GetTransferReceiptsByParty returns the receipts of transfers a party sent
+// or received. date (YYYY-MM-DD) is optional and narrows the result to one day.
+func (s *SupplyChainContract) GetTransferReceiptsByParty(ctx contractapi.TransactionContextInterface, party, date string) ([]*TransferReceipt, error) {
+	prefix := []string{party}
+	if date != "" {
+		prefix = append(prefix, date)
+	}
+	return s.getTransferReceipts(ctx, receiptPartyIndexName, prefix...)
+}
+
+// GetTransferReceiptsByDate returns the receipts of every transfer on a day (YYYY-MM-DD)
+func (s *SupplyChainContract) GetTransferReceiptsByDate(ctx contractapi.TransactionContextInterface, date string) ([]*TransferReceipt, error) {
+	return s.getTransferReceipts(ctx, receiptDateIndexName, date)
+}
+
+// getTransferReceipts resolves the receipt IDs found under an index prefix
+func (s *SupplyChainContract) getTransferReceipts(ctx contractapi.TransactionContextInterface, indexName string, prefix ...string) ([]*TransferReceipt, error) {
+	receiptIDs, err := s.getIndexedIDs(ctx, indexName, prefix...)
+	if err != nil {
+		return nil, err
+	}
+
+	var receipts []*TransferReceipt
+	for _, receiptID := range receiptIDs {
+		receipt, err := s.QueryTransferReceipt(ctx, receiptID)
+		if err != nil {
+			return nil, err
+		}
+		receipts = append(receipts, receipt)
+	}
+
+	return receipts, nil
+}
+
+// putTransferReceipt writes the receipt for a transfer of productID, indexes
+// it under both parties and the transfer date and charges any fees due on
+// its amount
+func (s *SupplyChainContract) putTransferReceipt(ctx contractapi.TransactionContextInterface, productID, fromOwner, toOwner, priceRef, orderID string, amount float64) error {
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	txID := ctx.GetStub().GetTxID()
+	receipt := TransferReceipt{
+		// A transaction may transfer several products, so the ID includes the product
+		ID:        txID + "-" + productID,
+		ProductID: productID,
+		FromOwner: fromOwner,
+		ToOwner:   toOwner,
+		PriceRef:  priceRef,
+		OrderID:   orderID,
+		Amount:    amount,
+		TxID:      txID,
+		Timestamp: curTime,
+	}
+
+	key, err := s.makeKey(ctx, receiptObjectType, receipt.ID)
+	if err != nil {
+		return err
+	}
+	if err := s.putState(ctx, key, &receipt); err != nil {
+		return err
+	}
+
+	date := curTime[:receiptDateLayoutLength]
+	if err := s.putIndexKey(ctx, receiptDateIndexName, date, receipt.ID); err != nil {
+		return err
+	}
+	for _, party := range []string{fromOwner, toOwner} {
+		if err := s.putIndexKey(ctx, receiptPartyIndexName, party, date, receipt.ID); err != nil {
+			return err
+		}
+	}
+
+	if amount > 0 {
+		return s.chargeTransferFees(ctx, &receipt)
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Relations between products. Each relation is stored as a forward edge from
+// the container to the contained product and a reverse edge back, so both
+// directions can be walked with a partial composite key scan.
+const (
+	relationIndexName        = "relation"
+	reverseRelationIndexName = "relation~reverse"
+
+	// RelationComponent links an assembled product to one of its components
+	RelationComponent = "component"
+	// RelationBundle links a bundle (e.g. a multipack) to one of its members
+	RelationBundle = "bundle"
+)
+
+// AddComponent records that componentID was assembled into parentID. A
+// product can be a component of at most one parent, and a product cannot
+// contain one of its own ancestors. The caller must own both products, and
+// neither may be frozen.
+func (s *SupplyChainContract) AddComponent(ctx contractapi.TransactionContextInterface, parentID, componentID string) error {
+	if parentID == componentID {
+		return newError(ErrInvalidArgument, "product %s cannot be a component of itself", parentID)
+	}
+	if err := s.requireRelatable(ctx, "assemble", parentID, componentID); err != nil {
+		return err
+	}
+
+	parents, err := s.getRelated(ctx, reverseRelationIndexName, componentID, RelationComponent)
+	if err != nil {
+		return err
+	}
+	if len(parents) > 0 {
+		return newError(ErrConflict, "product %s is already a component of %s", componentID, parents[0])
+	}
+
+	// Walk up from the parent; finding the component there would form a cycle
+	for ancestor := parentID; ancestor != ""; {
+		if ancestor == componentID {
+			return newError(ErrConflict, "product %s is an ancestor of %s", componentID, parentID)
+		}
+		ancestors, err := s.getRelated(ctx, reverseRelationIndexName, ancestor, RelationComponent)
+		if err != nil {
+			return err
+		}
+		ancestor = ""
+		if len(ancestors) > 0 {
+			ancestor = ancestors[0]
+		}
+	}
+
+	return s.putRelation(ctx, parentID, RelationComponent, componentID)
+}
+
+// RemoveComponent undoes AddComponent, e.g. when a part is swapped during
+// repair. The caller must own both products, and neither may be frozen.
+func (s *SupplyChainContract) RemoveComponent(ctx contractapi.TransactionContextInterface, parentID, componentID string) error {
+	if err := s.requireRelatable(ctx, "disassemble", parentID, componentID); err != nil {
+		return err
+	}
+	return s.delRelation(ctx, parentID, RelationComponent, componentID)
+}
+
+// AddToBundle records that productID is sold as part of bundleID. The caller
+// must own both products, and neither may be frozen.
+func (s *SupplyChainContract) AddToBundle(ctx contractapi.TransactionContextInterface, bundleID, productID string) error {
+	if bundleID == productID {
+		return newError(ErrInvalidArgument, "product %s cannot be bundled with itself", bundleID)
+	}
+	if err := s.requireRelatable(ctx, "bundle", bundleID, productID); err != nil {
+		return err
+	}
+
+	return s.putRelation(ctx, bundleID, RelationBundle, productID)
+}
+
+// RemoveFromBundle undoes AddToBundle, with the same checks
+func (s *SupplyChainContract) RemoveFromBundle(ctx contractapi.TransactionContextInterface, bundleID, productID string) error {
+	if err := s.requireRelatable(ctx, "unbundle", bundleID, productID); err != nil {
+		return err
+	}
+	return s.delRelation(ctx, bundleID, RelationBundle, productID)
+}
+
+// requireRelatable returns an error unless every product of ids exists, is
+// not frozen and is owned by the caller or the caller is an admin. action
+// describes the refused operation in the error.
+func (s *SupplyChainContract) requireRelatable(ctx contractapi.TransactionContextInterface, action string, ids ...string) error {
+	for _, id := range ids {
+		product, err := s.QueryProduct(ctx, id)
+		if err != nil {
+			return err
+		}
+		if err := checkNotFrozen(product); err != nil {
+			return err
+		}
+		if err := s.requireProductOwner(ctx, product, action); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// putRelation writes the forward and reverse edges of a relation
+func (s *SupplyChainContract) putRelation(ctx contractapi.TransactionContextInterface, fromID, relation, toID string) error {
+	if err := s.putIndexKey(ctx, relationIndexName, fromID, relation, toID); err != nil {
+		return err
+	}
+	return s.putIndexKey(ctx, reverseRelationIndexName, toID, relation, fromID)
+}
+
+// delRelation removes both edges of a relation, failing if it is not recorded
+func (s *SupplyChainContract) delRelation(ctx contractapi.TransactionContextInterface, fromID, relation, toID string) error {
+	key, err := s.makeKey(ctx, relationIndexName, fromID, relation, toID)
+	if err != nil {
+		return err
+	}
+	edge, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return wrapError(err, "failed to read from world state")
+	}
+	if edge == nil {
+		return newError(ErrNotFound, "product %s has no %s %s", fromID, relation, toID)
+	}
+
+	if err := s.delIndexKey(ctx, relationIndexName, fromID, relation, toID); err != nil {
+		return err
+	}
+	return s.delIndexKey(ctx, reverseRelationIndexName, toID, relation, fromID)
+}
+
+// getRelated returns the IDs at the other end of id's edges of one relation
+// in the given index (forward or reverse)
+func (s *SupplyChainContract) getRelated(ctx contractapi.TransactionContextInterface, indexName, id, relation string) ([]string, error) {
+	return s.getIndexedIDs(ctx, indexName, id, relation)
+}
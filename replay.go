@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	seenNonceObjectType = "seennonce"
+
+	// defaultReplayWindowMinutes is used until an admin sets a replay window
+	defaultReplayWindowMinutes = 10
+	// maxNonceLength bounds the nonce a signer may put in a payload
+	maxNonceLength = 128
+)
+
+// seenNonce records a nonce accepted from one signer. Nonces are only kept
+// while their signed_at is inside the replay window; a payload signed
+// earlier than that is rejected on its timestamp alone.
+type seenNonce struct {
+	SignedAt string `json:"signed_at"`
+	TxID     string `json:"tx_id"`
+}
+
+// SetReplayWindow sets how many minutes a signed payload's signed_at may be
+// from the transaction time. A longer window tolerates more clock drift but
+// keeps more nonces on the ledger.
+func (s *SupplyChainContract) SetReplayWindow(ctx contractapi.TransactionContextInterface, minutes int) error {
+	if err := s.requireRole(ctx, RoleAdmin); err != nil {
+		return err
+	}
+	if minutes <= 0 {
+		return newError(ErrInvalidArgument, "replay window must be at least one minute")
+	}
+
+	config, err := s.GetContractConfig(ctx)
+	if err != nil {
+		return err
+	}
+
+	config.ReplayWindowMinutes = minutes
+	return s.putContractConfig(ctx, config)
+}
+
+// PruneSeenNonces deletes the nonces of a signer that have left the replay
+// window and returns how many were deleted. Expired nonces can no longer be
+// replayed, so pruning them only saves space.
+func (s *SupplyChainContract) PruneSeenNonces(ctx contractapi.TransactionContextInterface, signer string) (int, error) {
+	if err := s.requireRole(ctx, RoleAdmin); err != nil {
+		return 0, err
+	}
+
+	window, err := s.replayWindow(ctx)
+	if err != nil {
+		return 0, err
+	}
+	txTime, err := rawTxTime(ctx)
+	if err != nil {
+		return 0, err
+	}
+	cutoff := txTime.Add(-window)
+
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(seenNonceObjectType, []string{signer})
+	if err != nil {
+		return 0, err
+	}
+	defer resultsIterator.Close()
+
+	var expired []string
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return 0, err
+		}
+
+		var nonce seenNonce
+		if err := json.Unmarshal(queryResponse.Value, &nonce); err != nil {
+			return 0, err
+		}
+		signedAt, err := time.Parse(time.RFC3339, nonce.SignedAt)
+		if err != nil {
+			return 0, err
+		}
+		if signedAt.Before(cutoff) {
+			expired = append(expired, queryResponse.Key)
+		}
+	}
+
+	for _, key := range expired {
+		if err := ctx.GetStub().DelState(key); err != nil {
+			return 0, wrapError(err, "failed to delete from world state")
+		}
+	}
+	return len(expired), nil
+}
+
+// checkReplay rejects a signed payload whose signed_at is outside the replay
+// window or whose nonce the signer has already used, and records the nonce
+// otherwise. It must only be called once the signature has been verified,
+// so that a forged payload cannot burn a genuine signer's nonce.
+func (s *SupplyChainContract) checkReplay(ctx contractapi.TransactionContextInterface, signer, nonce, signedAt string) error {
+	if nonce == "" || len(nonce) > maxNonceLength {
+		return newError(ErrInvalidArgument, "a signed payload must carry a nonce of 1 to %d characters", maxNonceLength)
+	}
+	signedTime, err := time.Parse(time.RFC3339, signedAt)
+	if err != nil {
+		return newError(ErrInvalidArgument, "signed_at must be an RFC3339 timestamp: %v", err)
+	}
+
+	window, err := s.replayWindow(ctx)
+	if err != nil {
+		return err
+	}
+	txTime, err := rawTxTime(ctx)
+	if err != nil {
+		return err
+	}
+	if signedTime.Before(txTime.Add(-window)) || signedTime.After(txTime.Add(window)) {
+		return newError(ErrInvalidArgument, "payload signed at %s is outside the %s replay window", signedAt, window)
+	}
+
+	key, err := s.makeKey(ctx, seenNonceObjectType, signer, nonce)
+	if err != nil {
+		return err
+	}
+	var seen seenNonce
+	exists, err := s.getState(ctx, key, &seen)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return newError(ErrConflict, "nonce %s of %s was already used in transaction %s", nonce, signer, seen.TxID)
+	}
+
+	seen = seenNonce{SignedAt: signedTime.UTC().Format(time.RFC3339), TxID: ctx.GetStub().GetTxID()}
+	return s.putState(ctx, key, &seen)
+}
+
+// replayWindow returns the configured replay window
+func (s *SupplyChainContract) replayWindow(ctx contractapi.TransactionContextInterface) (time.Duration, error) {
+	config, err := s.GetContractConfig(ctx)
+	if err != nil {
+		return 0, err
+	}
+	minutes := config.ReplayWindowMinutes
+	if minutes == 0 {
+		minutes = defaultReplayWindowMinutes
+	}
+	return time.Duration(minutes) * time.Minute, nil
+}
+
+// rawTxTime returns the transaction time without the sandbox offset. Signers
+// stamp payloads with their real clocks, so the replay window is measured
+// against the real time.
+func rawTxTime(ctx contractapi.TransactionContextInterface) (time.Time, error) {
+	txTimestamp, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return time.Time{}, wrapError(err, "failed to get transaction timestamp")
+	}
+	return txTimestamp.AsTime().UTC(), nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// CreateProductRequest holds the arguments of CreateProductFromRequest.
+// ID, Name and Owner are required. Manufacturer defaults to the owner; a
+// request with a serial number is checked for duplicates as with
+// CreateSerializedProduct.
+type CreateProductRequest struct {
+	ID             string `json:"id"`
+	Name           string `json:"name"`
+	Owner          string `json:"owner"`
+	Description    string `json:"description"`
+	Category       string `json:"category"`
+	Manufacturer   string `json:"manufacturer"`
+	SerialNumber   string `json:"serial_number"`
+	AllowDuplicate bool   `json:"allow_duplicate"`
+}
+
+// UpdateProductRequest holds the arguments of UpdateProductFromRequest. ID
+// and at least one field to change are required; fields left out keep their
+// current value. ReasonCode and ReasonNote are needed for status downgrades
+// and ownership reversals, as with UpdateProductWithReason.
+type UpdateProductRequest struct {
+	ID          string `json:"id"`
+	Status      string `json:"status"`
+	Owner       string `json:"owner"`
+	Description string `json:"description"`
+	Category    string `json:"category"`
+	ReasonCode  string `json:"reason_code"`
+	ReasonNote  string `json:"reason_note"`
+}
+
+// CreateProductFromRequest is CreateProduct taking a CreateProductRequest
+// JSON object instead of positional strings
+func (s *SupplyChainContract) CreateProductFromRequest(ctx contractapi.TransactionContextInterface, requestJSON string) (*TxResult, error) {
+	var request CreateProductRequest
+	if err := parseRequest(requestJSON, &request); err != nil {
+		return nil, err
+	}
+	if err := request.validate(); err != nil {
+		return nil, err
+	}
+	if err := s.createProduct(ctx, request.ID, request.Name, request.Owner, request.Description, request.Category, request.Manufacturer, request.SerialNumber, request.AllowDuplicate); err != nil {
+		return nil, err
+	}
+	return txResult(ctx), nil
+}
+
+// UpdateProductFromRequest is UpdateProductWithReason taking an
+// UpdateProductRequest JSON object instead of positional strings
+func (s *SupplyChainContract) UpdateProductFromRequest(ctx contractapi.TransactionContextInterface, requestJSON string) (*TxResult, error) {
+	var request UpdateProductRequest
+	if err := parseRequest(requestJSON, &request); err != nil {
+		return nil, err
+	}
+	if err := request.validate(); err != nil {
+		return nil, err
+	}
+	var reason *changeReason
+	if request.ReasonCode != "" || request.ReasonNote != "" {
+		reason = &changeReason{Code: request.ReasonCode, Note: request.ReasonNote}
+	}
+	if err := s.updateProduct(ctx, request.ID, request.Status, request.Owner, request.Description, request.Category, reason); err != nil {
+		return nil, err
+	}
+	return txResult(ctx), nil
+}
+
+// parseRequest decodes a request object into v. Unknown fields are refused
+// so a misspelt field name fails instead of being silently dropped.
+func parseRequest(requestJSON string, v interface{}) error {
+	decoder := json.NewDecoder(strings.NewReader(requestJSON))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return newError(ErrInvalidArgument, "failed to parse request: %v", err)
+	}
+	return nil
+}
+
+// validate trims and checks the fields and fills in the manufacturer
+func (r *CreateProductRequest) validate() error {
+	if err := validateFields(
+		field("id", &r.ID, productIDRule),
+		field("name", &r.Name, productNameRule),
+		field("owner", &r.Owner, ownerRule),
+		field("description", &r.Description, descriptionRule),
+		field("category", &r.Category, categoryRule),
+		field("manufacturer", &r.Manufacturer, manufacturerRule),
+		field("serial_number", &r.SerialNumber, serialNumberRule),
+	); err != nil {
+		return err
+	}
+	if r.Manufacturer == "" {
+		r.Manufacturer = r.Owner
+	}
+	return nil
+}
+
+// validate checks that the request names a product and changes something
+func (r *UpdateProductRequest) validate() error {
+	if r.ID == "" {
+		return newError(ErrInvalidArgument, "request is missing required field(s): id")
+	}
+	if r.Status == "" && r.Owner == "" && r.Description == "" && r.Category == "" {
+		return newError(ErrInvalidArgument, "request for product %s changes no fields; set at least one of status, owner, description or category", r.ID)
+	}
+	return nil
+}
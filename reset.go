@@ -0,0 +1,262 @@
+package main
+
+import (
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	ledgerResetObjectType = "ledgerreset"
+
+	// resetApprovalsRequired is how many different admins must call
+	// ResetLedgerData before it starts deleting
+	resetApprovalsRequired = 2
+	// resetProposalValidHours is how long approvals wait for the rest
+	resetProposalValidHours = 24
+	// resetPageSize is how many keys one ResetLedgerData call deletes, which
+	// keeps each transaction's write set bounded
+	resetPageSize = 500
+)
+
+// resetObjectTypes are the composite key types ResetLedgerData deletes:
+// every object type and index the contract writes except the contract
+// config, which holds the development flags the reset is gated on. An
+// object type missing here survives a reset.
+var resetObjectTypes = []string{
+	allergenObjectType,
+	amendmentObjectType,
+	archivedIndexName,
+	archivedProductObjectType,
+	auditRecordObjectType,
+	benchmarkObjectType,
+	categoryProductIndexName,
+	certifierObjectType,
+	changeEventObjectType,
+	chargeFacilityIndexName,
+	chargePartyIndexName,
+	chargeableTimeObjectType,
+	chargebackEvidenceIndexName,
+	chargebackObjectType,
+	chargebackSupplierIndexName,
+	checkpointLeavesObjectType,
+	checkpointObjectType,
+	credentialObjectType,
+	credentialSubjectIndexName,
+	creditLimitObjectType,
+	custodyAssignmentObjectType,
+	depositEntryObjectType,
+	deviceObjectType,
+	didObjectType,
+	disclosureObjectType,
+	disclosureProductIndexName,
+	discrepancyObjectType,
+	disputeObjectType,
+	disputeProductIndexName,
+	distributionPathIndexName,
+	dockSlotObjectType,
+	dunningObjectType,
+	duplicateFlagObjectType,
+	earlySaleFlagObjectType,
+	embargoObjectType,
+	excursionObjectType,
+	externalAssetProductIndex,
+	externalNetworkObjectType,
+	externalReferenceObjectType,
+	featureFlagObjectType,
+	feeRuleObjectType,
+	fingerprintIndexName,
+	inspectionObjectType,
+	invoiceIssuerIndexName,
+	invoiceObjectType,
+	invoicePayerIndexName,
+	letterOfCreditObjectType,
+	lotObjectType,
+	lotProductIndexName,
+	methodStatsObjectType,
+	oracleObjectType,
+	orderProductIndexName,
+	orderShipmentIndexName,
+	originObjectType,
+	ownerProductIndexName,
+	ownershipHopsObjectType,
+	participantObjectType,
+	pauseObjectType,
+	pauseProposalObjectType,
+	privateDetailsObjectType,
+	productScanObjectType,
+	productShipmentIndexName,
+	programObjectType,
+	programRoleObjectType,
+	promotionObjectType,
+	proofOfDeliveryObjectType,
+	pruneRecordObjectType,
+	purchaseOrderObjectType,
+	queryLimitObjectType,
+	quotaObjectType,
+	readingMarkObjectType,
+	reasonCodeObjectType,
+	rebateObjectType,
+	receiptDateIndexName,
+	receiptObjectType,
+	receiptPartyIndexName,
+	relationIndexName,
+	returnableHolderIndexName,
+	returnableObjectType,
+	reverseRelationIndexName,
+	routePlanObjectType,
+	saleManufacturerIndexName,
+	saleObjectType,
+	savedQueryObjectType,
+	savedQueryVersionObjectType,
+	seenNonceObjectType,
+	sensorReadingObjectType,
+	shelfLifeRuleObjectType,
+	shipmentLegObjectType,
+	shipmentObjectType,
+	shipmentOrderIndexName,
+	shipmentProductIndexName,
+	stateCheckpointObjectType,
+	statusProductIndexName,
+	telemetrySummaryObjectType,
+	tradeDocumentObjectType,
+	tradeFinanceAnchorObjectType,
+	transferFeeObjectType,
+	transformationFacilityIndex,
+	transformationInputIndexName,
+	transformationObjectType,
+	transformationOutputIndexName,
+	usageObjectType,
+	watchObjectType,
+	watchWatcherIndexName,
+}
+
+// LedgerReset tracks a reset of a development channel's ledger data. It
+// collects approvals until Approved; Deleted counts the keys removed so far.
+type LedgerReset struct {
+	Approvers   []string `json:"approvers"`
+	ProposedAt  string   `json:"proposed_at"`
+	Approved    bool     `json:"approved"`
+	Deleted     int      `json:"deleted"`
+	Done        bool     `json:"done"`
+	CompletedAt string   `json:"completed_at"`
+}
+
+// ResetLedgerData clears a development channel without redeploying it. It
+// needs sandbox mode on a development channel and resetApprovalsRequired
+// different admins calling it within resetProposalValidHours. Each call
+// after that deletes up to resetPageSize of the program's keys, products
+// and every object in resetObjectTypes, until a call reports Done; the
+// contract config is kept. Private data collections are not touched.
+func (s *SupplyChainContract) ResetLedgerData(ctx contractapi.TransactionContextInterface) (*LedgerReset, error) {
+	if _, err := s.requireSandbox(ctx); err != nil {
+		return nil, err
+	}
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+	txTime, err := s.getTxTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := s.makeKey(ctx, ledgerResetObjectType, "ledger")
+	if err != nil {
+		return nil, err
+	}
+	var reset LedgerReset
+	exists, err := s.getState(ctx, key, &reset)
+	if err != nil {
+		return nil, err
+	}
+	if exists && !reset.Approved {
+		proposedAt, err := time.Parse(time.RFC3339, reset.ProposedAt)
+		if err != nil {
+			return nil, err
+		}
+		if txTime.Sub(proposedAt) > resetProposalValidHours*time.Hour {
+			exists = false
+		}
+	}
+	if !exists {
+		reset = LedgerReset{Approvers: []string{}, ProposedAt: curTime}
+	}
+
+	if !reset.Approved {
+		clientID, err := s.getClientID(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, approver := range reset.Approvers {
+			if approver == clientID {
+				return nil, newError(ErrConflict, "you have already approved this reset")
+			}
+		}
+		reset.Approvers = append(reset.Approvers, clientID)
+		if len(reset.Approvers) < resetApprovalsRequired {
+			if err := s.putState(ctx, key, &reset); err != nil {
+				return nil, err
+			}
+			return &reset, nil
+		}
+		reset.Approved = true
+	}
+
+	deleted, err := s.deleteLedgerPage(ctx, resetPageSize)
+	if err != nil {
+		return nil, err
+	}
+	reset.Deleted += deleted
+	if deleted < resetPageSize {
+		reset.Done = true
+		reset.CompletedAt = curTime
+		if err := ctx.GetStub().DelState(key); err != nil {
+			return nil, wrapError(err, "failed to delete from world state")
+		}
+		return &reset, nil
+	}
+	if err := s.putState(ctx, key, &reset); err != nil {
+		return nil, err
+	}
+	return &reset, nil
+}
+
+// deleteLedgerPage deletes up to limit products and objects of
+// resetObjectTypes and returns how many it deleted. A transaction does not
+// see its own deletes, so every page scans from the start and finds only
+// the keys earlier pages left.
+func (s *SupplyChainContract) deleteLedgerPage(ctx contractapi.TransactionContextInterface, limit int) (int, error) {
+	deleted := 0
+	deleteAll := func(resultsIterator shim.StateQueryIteratorInterface, err error) error {
+		if err != nil {
+			return err
+		}
+		defer resultsIterator.Close()
+		for deleted < limit && resultsIterator.HasNext() {
+			queryResponse, err := resultsIterator.Next()
+			if err != nil {
+				return err
+			}
+			if err := ctx.GetStub().DelState(queryResponse.Key); err != nil {
+				return wrapError(err, "failed to delete from world state")
+			}
+			deleted++
+		}
+		return nil
+	}
+
+	if err := deleteAll(ctx.GetStub().GetStateByRange("", "")); err != nil {
+		return 0, err
+	}
+	for _, objectType := range resetObjectTypes {
+		if deleted == limit {
+			break
+		}
+		if err := deleteAll(ctx.GetStub().GetStateByPartialCompositeKey(objectType, []string{})); err != nil {
+			return 0, err
+		}
+	}
+	return deleted, nil
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	pruneRecordObjectType     = "prune"
+	archivedProductObjectType = "archived"
+
+	// maxArchiveBatch bounds the products one ArchiveProducts call may archive
+	maxArchiveBatch = 100
+)
+
+// What a prune record covers
+const (
+	PruneTelemetry = "telemetry"
+	PruneProducts  = "products"
+)
+
+// terminalProductStatuses are the statuses a product never leaves
+var terminalProductStatuses = map[string]bool{
+	productStatusSold:     true,
+	productStatusConsumed: true,
+	"Recalled":            true,
+}
+
+// PruneRecord records what one pruning transaction deleted. For telemetry
+// Scope is the shipment and Count the readings deleted; for products IDs
+// lists the archived products.
+type PruneRecord struct {
+	ID       string   `json:"id"`
+	Kind     string   `json:"kind"`
+	Scope    string   `json:"scope"`
+	Cutoff   string   `json:"cutoff"`
+	Count    int      `json:"count"`
+	IDs      []string `json:"ids"`
+	PrunedBy string   `json:"pruned_by"`
+	PrunedAt string   `json:"pruned_at"`
+}
+
+// ArchivedProduct is left in place of an archived product. StateHash is the
+// SHA-256 of the product's last JSON state, so an off-chain archive copy can
+// be checked against the ledger.
+type ArchivedProduct struct {
+	ID         string `json:"id"`
+	Status     string `json:"status"`
+	UpdatedAt  string `json:"updated_at"`
+	StateHash  string `json:"state_hash"`
+	ArchivedAt string `json:"archived_at"`
+}
+
+// SetRetentionPolicy sets how many months raw telemetry is kept and how many
+// years products in a terminal state (Sold, Consumed, Recalled) are kept
+// after their last update. Zero disables the corresponding pruning.
+func (s *SupplyChainContract) SetRetentionPolicy(ctx contractapi.TransactionContextInterface, telemetryMonths, productYears int) error {
+	if err := s.requireRole(ctx, RoleAdmin); err != nil {
+		return err
+	}
+	if telemetryMonths < 0 || productYears < 0 {
+		return newError(ErrInvalidArgument, "retention periods must not be negative")
+	}
+
+	config, err := s.GetContractConfig(ctx)
+	if err != nil {
+		return err
+	}
+
+	config.TelemetryRetentionMonths = telemetryMonths
+	config.ProductRetentionYears = productYears
+	return s.putContractConfig(ctx, config)
+}
+
+// PruneTelemetry deletes the raw readings of a shipment that are older than
+// the telemetry retention period, from the world state and from the
+// configured private collection. Hourly summaries are kept.
+func (s *SupplyChainContract) PruneTelemetry(ctx contractapi.TransactionContextInterface, shipmentID string) (*PruneRecord, error) {
+	if err := s.requireRole(ctx, RoleAdmin); err != nil {
+		return nil, err
+	}
+	config, err := s.GetContractConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if config.TelemetryRetentionMonths == 0 {
+		return nil, newError(ErrConflict, "no telemetry retention period is configured")
+	}
+	now, err := s.getTxTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cutoff := now.AddDate(0, -config.TelemetryRetentionMonths, 0).Format(time.RFC3339)
+
+	record, err := s.newPruneRecord(ctx, PruneTelemetry, shipmentID, cutoff)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := s.expiredReadingKeys(ctx, "", shipmentID, cutoff)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		if err := ctx.GetStub().DelState(key); err != nil {
+			return nil, wrapError(err, "failed to delete from world state")
+		}
+	}
+	record.Count = len(keys)
+
+	if config.TelemetryCollection != "" {
+		keys, err := s.expiredReadingKeys(ctx, config.TelemetryCollection, shipmentID, cutoff)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			if err := ctx.GetStub().DelPrivateData(config.TelemetryCollection, key); err != nil {
+				return nil, wrapError(err, "failed to delete from private collection %s", config.TelemetryCollection)
+			}
+		}
+		record.Count += len(keys)
+	}
+
+	return record, s.putPruneRecord(ctx, record)
+}
+
+// ArchiveProducts removes products that have been in a terminal state for
+// longer than the product retention period, leaving an ArchivedProduct with
+// the hash of each one's last state. Every listed product must qualify.
+func (s *SupplyChainContract) ArchiveProducts(ctx contractapi.TransactionContextInterface, productIDs []string) (*PruneRecord, error) {
+	if err := s.requireRole(ctx, RoleAdmin); err != nil {
+		return nil, err
+	}
+	config, err := s.GetContractConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if config.ProductRetentionYears == 0 {
+		return nil, newError(ErrConflict, "no product retention period is configured")
+	}
+	if len(productIDs) == 0 || len(productIDs) > maxArchiveBatch {
+		return nil, newError(ErrInvalidArgument, "between 1 and %d products can be archived at once", maxArchiveBatch)
+	}
+	now, err := s.getTxTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cutoff := now.AddDate(-config.ProductRetentionYears, 0, 0).Format(time.RFC3339)
+
+	record, err := s.newPruneRecord(ctx, PruneProducts, "", cutoff)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check every product before deleting any
+	archives := make([]ArchivedProduct, 0, len(productIDs))
+	products := make([]Product, 0, len(productIDs))
+	for _, productID := range mergeIDs(productIDs, nil) {
+		productJSON, err := ctx.GetStub().GetState(productID)
+		if err != nil {
+			return nil, wrapError(err, "failed to read from world state")
+		}
+		if productJSON == nil {
+			return nil, newError(ErrNotFound, "the product %s does not exist", productID)
+		}
+		var product Product
+		if err := decodeProduct(productJSON, &product); err != nil {
+			return nil, err
+		}
+		if !terminalProductStatuses[product.Status] {
+			return nil, newError(ErrConflict, "product %s is %s, not in a terminal state", productID, product.Status)
+		}
+		if product.UpdatedAt >= cutoff {
+			return nil, newError(ErrConflict, "product %s was last updated at %s, within the retention period", productID, product.UpdatedAt)
+		}
+
+		digest := sha256.Sum256(productJSON)
+		products = append(products, product)
+		archives = append(archives, ArchivedProduct{
+			ID:         productID,
+			Status:     product.Status,
+			UpdatedAt:  product.UpdatedAt,
+			StateHash:  hex.EncodeToString(digest[:]),
+			ArchivedAt: record.PrunedAt,
+		})
+	}
+
+	for i := range archives {
+		key, err := s.makeKey(ctx, archivedProductObjectType, archives[i].ID)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.putState(ctx, key, &archives[i]); err != nil {
+			return nil, err
+		}
+		if err := s.delIndexKey(ctx, archivedIndexName, archives[i].ID); err != nil {
+			return nil, err
+		}
+		if err := s.indexProduct(ctx, &products[i], nil); err != nil {
+			return nil, err
+		}
+		if err := s.recordChange(ctx, ChangeEntityProduct, archives[i].ID, archives[i].ID, nil); err != nil {
+			return nil, err
+		}
+		if err := ctx.GetStub().DelState(archives[i].ID); err != nil {
+			return nil, wrapError(err, "failed to delete from world state")
+		}
+		record.IDs = append(record.IDs, archives[i].ID)
+	}
+	record.Count = len(record.IDs)
+
+	return record, s.putPruneRecord(ctx, record)
+}
+
+// QueryArchivedProduct retrieves the archive entry of a product
+func (s *SupplyChainContract) QueryArchivedProduct(ctx contractapi.TransactionContextInterface, productID string) (*ArchivedProduct, error) {
+	key, err := s.makeKey(ctx, archivedProductObjectType, productID)
+	if err != nil {
+		return nil, err
+	}
+
+	var archived ArchivedProduct
+	exists, err := s.getState(ctx, key, &archived)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, newError(ErrNotFound, "product %s has not been archived", productID)
+	}
+
+	return &archived, nil
+}
+
+// GetPruneRecords returns every pruning transaction, oldest first
+func (s *SupplyChainContract) GetPruneRecords(ctx contractapi.TransactionContextInterface) ([]*PruneRecord, error) {
+	records := []*PruneRecord{}
+	err := s.forEachState(ctx, pruneRecordObjectType, func(value []byte) error {
+		var record PruneRecord
+		if err := json.Unmarshal(value, &record); err != nil {
+			return err
+		}
+		records = append(records, &record)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Keys are ordered by transaction ID, not time
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].PrunedAt < records[j].PrunedAt
+	})
+	return records, nil
+}
+
+// expiredReadingKeys lists the reading keys of a shipment recorded before
+// cutoff, in the world state or, when collection is set, in that private
+// collection. Readings are keyed by time, so the scan stops at the first
+// reading that is still retained.
+func (s *SupplyChainContract) expiredReadingKeys(ctx contractapi.TransactionContextInterface, collection, shipmentID, cutoff string) ([]string, error) {
+	var resultsIterator shim.StateQueryIteratorInterface
+	var err error
+	if collection != "" {
+		resultsIterator, err = ctx.GetStub().GetPrivateDataByPartialCompositeKey(collection, sensorReadingObjectType, []string{shipmentID})
+	} else {
+		resultsIterator, err = ctx.GetStub().GetStateByPartialCompositeKey(sensorReadingObjectType, []string{shipmentID})
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	keys := []string{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, keyParts, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, err
+		}
+		if len(keyParts) < 2 || keyParts[1] >= cutoff {
+			break
+		}
+		keys = append(keys, queryResponse.Key)
+	}
+	return keys, nil
+}
+
+// newPruneRecord starts the record of a pruning transaction
+func (s *SupplyChainContract) newPruneRecord(ctx contractapi.TransactionContextInterface, kind, scope, cutoff string) (*PruneRecord, error) {
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+	clientID, err := s.getClientID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &PruneRecord{
+		ID:       ctx.GetStub().GetTxID(),
+		Kind:     kind,
+		Scope:    scope,
+		Cutoff:   cutoff,
+		IDs:      []string{},
+		PrunedBy: clientID,
+		PrunedAt: curTime,
+	}, nil
+}
+
+// putPruneRecord writes a prune record under its transaction ID
+func (s *SupplyChainContract) putPruneRecord(ctx contractapi.TransactionContextInterface, record *PruneRecord) error {
+	key, err := s.makeKey(ctx, pruneRecordObjectType, record.ID)
+	if err != nil {
+		return err
+	}
+	return s.putState(ctx, key, record)
+}
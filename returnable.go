@@ -0,0 +1,306 @@
+package main
+
+import (
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	returnableObjectType      = "returnable"
+	returnableHolderIndexName = "returnable~holder"
+)
+
+// Returnable asset types
+const (
+	ReturnablePallet = "Pallet"
+	ReturnableCrate  = "Crate"
+	ReturnableKeg    = "Keg"
+)
+
+// Returnable asset statuses
+const (
+	ReturnableAvailable = "Available"
+	ReturnableIssued    = "Issued"
+	ReturnableLost      = "Lost"
+)
+
+var returnableTypes = map[string]bool{
+	ReturnablePallet: true,
+	ReturnableCrate:  true,
+	ReturnableKeg:    true,
+}
+
+// ReturnableAsset represents a reusable piece of packaging (pallet, crate,
+// keg) that circulates between the pool owner and its partners
+type ReturnableAsset struct {
+	ID            string  `json:"id"`
+	Type          string  `json:"type"`
+	Owner         string  `json:"owner"`
+	Holder        string  `json:"holder"`
+	DepositAmount float64 `json:"deposit_amount"`
+	CycleCount    int     `json:"cycle_count"`
+	Status        string  `json:"status"`
+	IssuedAt      string  `json:"issued_at"`
+	DueAt         string  `json:"due_at"`
+	CreatedAt     string  `json:"created_at"`
+	UpdatedAt     string  `json:"updated_at"`
+}
+
+// RegisterReturnableAsset adds a new returnable asset to its owner's pool.
+// Only the owner's organization can register it.
+func (s *SupplyChainContract) RegisterReturnableAsset(ctx contractapi.TransactionContextInterface, id, assetType, owner string, depositAmount float64) error {
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	mspID, err := s.getClientMSPID(ctx)
+	if err != nil {
+		return err
+	}
+	if mspID != owner {
+		return newError(ErrForbidden, "%s cannot register returnable assets for %s", mspID, owner)
+	}
+	if !returnableTypes[assetType] {
+		return newError(ErrInvalidArgument, "unknown returnable asset type %s", assetType)
+	}
+	if depositAmount < 0 {
+		return newError(ErrInvalidArgument, "deposit amount must not be negative")
+	}
+
+	key, err := s.makeKey(ctx, returnableObjectType, id)
+	if err != nil {
+		return err
+	}
+	var existing ReturnableAsset
+	exists, err := s.getState(ctx, key, &existing)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return newError(ErrAlreadyExists, "returnable asset with ID %s already exists", id)
+	}
+
+	asset := ReturnableAsset{
+		ID:            id,
+		Type:          assetType,
+		Owner:         owner,
+		Holder:        owner,
+		DepositAmount: depositAmount,
+		Status:        ReturnableAvailable,
+		CreatedAt:     curTime,
+		UpdatedAt:     curTime,
+	}
+
+	return s.putReturnable(ctx, &asset, "")
+}
+
+// IssueReturnableAsset hands an available asset to a partner, who is expected
+// to return it within returnDays. The asset's deposit is charged to the partner.
+// Only the owner's organization or an admin can issue it.
+func (s *SupplyChainContract) IssueReturnableAsset(ctx contractapi.TransactionContextInterface, id, partner string, returnDays int) error {
+	txTime, err := s.getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+	curTime := txTime.Format(time.RFC3339)
+
+	if returnDays <= 0 {
+		return newError(ErrInvalidArgument, "return window must be at least one day")
+	}
+
+	asset, err := s.QueryReturnableAsset(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := s.requireReturnableOwner(ctx, asset, "issue"); err != nil {
+		return err
+	}
+	if asset.Status != ReturnableAvailable {
+		return newError(ErrConflict, "returnable asset with ID %s is %s and cannot be issued", id, asset.Status)
+	}
+
+	previousHolder := asset.Holder
+	asset.Holder = partner
+	asset.Status = ReturnableIssued
+	asset.IssuedAt = curTime
+	asset.DueAt = txTime.AddDate(0, 0, returnDays).Format(time.RFC3339)
+	asset.UpdatedAt = curTime
+
+	if err := s.putDepositEntry(ctx, asset, partner, DepositCharge); err != nil {
+		return err
+	}
+	return s.putReturnable(ctx, asset, previousHolder)
+}
+
+// ReturnReturnableAsset records an issued asset coming back to its owner,
+// completing one circulation cycle and refunding the partner's deposit.
+// Only the owner's organization can confirm the return.
+func (s *SupplyChainContract) ReturnReturnableAsset(ctx contractapi.TransactionContextInterface, id string) error {
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	asset, err := s.QueryReturnableAsset(ctx, id)
+	if err != nil {
+		return err
+	}
+	if asset.Status != ReturnableIssued {
+		return newError(ErrConflict, "returnable asset with ID %s is not currently issued", id)
+	}
+	mspID, err := s.getClientMSPID(ctx)
+	if err != nil {
+		return err
+	}
+	if mspID != asset.Owner {
+		return newError(ErrForbidden, "only the owner %s can confirm the return of returnable asset %s", asset.Owner, id)
+	}
+
+	previousHolder := asset.Holder
+	asset.Holder = asset.Owner
+	asset.Status = ReturnableAvailable
+	asset.CycleCount++
+	asset.IssuedAt = ""
+	asset.DueAt = ""
+	asset.UpdatedAt = curTime
+
+	if err := s.putDepositEntry(ctx, asset, previousHolder, DepositRefund); err != nil {
+		return err
+	}
+	return s.putReturnable(ctx, asset, previousHolder)
+}
+
+// MarkReturnableLost writes off an asset that will not come back. The last
+// holder is kept so losses remain attributable to a partner. Only the
+// owner's organization or an admin can write it off.
+func (s *SupplyChainContract) MarkReturnableLost(ctx contractapi.TransactionContextInterface, id string) error {
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	asset, err := s.QueryReturnableAsset(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := s.requireReturnableOwner(ctx, asset, "write off"); err != nil {
+		return err
+	}
+	if asset.Status == ReturnableLost {
+		return newError(ErrConflict, "returnable asset with ID %s is already marked lost", id)
+	}
+
+	asset.Status = ReturnableLost
+	asset.UpdatedAt = curTime
+
+	return s.putReturnable(ctx, asset, asset.Holder)
+}
+
+// requireReturnableOwner checks that the caller belongs to the asset's owner
+// or is an admin. action describes the refused operation in the error.
+func (s *SupplyChainContract) requireReturnableOwner(ctx contractapi.TransactionContextInterface, asset *ReturnableAsset, action string) error {
+	mspID, err := s.getClientMSPID(ctx)
+	if err != nil {
+		return err
+	}
+	if mspID == asset.Owner {
+		return nil
+	}
+	isAdmin, err := s.hasRole(ctx, RoleAdmin)
+	if err != nil {
+		return err
+	}
+	if !isAdmin {
+		return newError(ErrForbidden, "only the owner %s can %s returnable asset %s", asset.Owner, action, asset.ID)
+	}
+	return nil
+}
+
+// QueryReturnableAsset retrieves a single returnable asset from the ledger by ID
+func (s *SupplyChainContract) QueryReturnableAsset(ctx contractapi.TransactionContextInterface, id string) (*ReturnableAsset, error) {
+	key, err := s.makeKey(ctx, returnableObjectType, id)
+	if err != nil {
+		return nil, err
+	}
+
+	var asset ReturnableAsset
+	exists, err := s.getState(ctx, key, &asset)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, newError(ErrNotFound, "returnable asset with ID %s does not exist", id)
+	}
+
+	return &asset, nil
+}
+
+// GetReturnablesByHolder returns every returnable asset currently held by a partner
+func (s *SupplyChainContract) GetReturnablesByHolder(ctx contractapi.TransactionContextInterface, holder string) ([]*ReturnableAsset, error) {
+	assetIDs, err := s.getIndexedIDs(ctx, returnableHolderIndexName, holder)
+	if err != nil {
+		return nil, err
+	}
+
+	var assets []*ReturnableAsset
+	for _, assetID := range assetIDs {
+		asset, err := s.QueryReturnableAsset(ctx, assetID)
+		if err != nil {
+			return nil, err
+		}
+		assets = append(assets, asset)
+	}
+
+	return assets, nil
+}
+
+// GetOverdueReturnables returns the assets issued to a partner whose return
+// window has passed
+func (s *SupplyChainContract) GetOverdueReturnables(ctx contractapi.TransactionContextInterface, partner string) ([]*ReturnableAsset, error) {
+	txTime, err := s.getTxTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	held, err := s.GetReturnablesByHolder(ctx, partner)
+	if err != nil {
+		return nil, err
+	}
+
+	var overdue []*ReturnableAsset
+	for _, asset := range held {
+		if asset.Status != ReturnableIssued {
+			continue
+		}
+		dueAt, err := time.Parse(time.RFC3339, asset.DueAt)
+		if err != nil {
+			return nil, wrapError(err, "returnable asset with ID %s has an invalid due date", asset.ID)
+		}
+		if txTime.After(dueAt) {
+			overdue = append(overdue, asset)
+		}
+	}
+
+	return overdue, nil
+}
+
+// putReturnable writes a returnable asset and moves its holder index entry
+// from previousHolder (if any) to the current holder
+func (s *SupplyChainContract) putReturnable(ctx contractapi.TransactionContextInterface, asset *ReturnableAsset, previousHolder string) error {
+	key, err := s.makeKey(ctx, returnableObjectType, asset.ID)
+	if err != nil {
+		return err
+	}
+	if err := s.putState(ctx, key, asset); err != nil {
+		return err
+	}
+
+	if previousHolder != "" && previousHolder != asset.Holder {
+		if err := s.delIndexKey(ctx, returnableHolderIndexName, previousHolder, asset.ID); err != nil {
+			return err
+		}
+	}
+	return s.putIndexKey(ctx, returnableHolderIndexName, asset.Holder, asset.ID)
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	routePlanObjectType  = "route"
+	checkpointObjectType = "checkpoint"
+)
+
+// Route deviations
+const (
+	DeviationOutOfOrder = "OutOfOrder"
+	DeviationUnplanned  = "Unplanned"
+)
+
+// EventRouteDeviation is emitted when a security-sensitive shipment deviates from its planned route
+const EventRouteDeviation = "RouteDeviation"
+
+// RoutePlan is the ordered list of checkpoint codes a shipment should pass
+type RoutePlan struct {
+	ShipmentID        string   `json:"shipment_id"`
+	Checkpoints       []string `json:"checkpoints"`
+	SecuritySensitive bool     `json:"security_sensitive"`
+	UpdatedAt         string   `json:"updated_at"`
+}
+
+// Checkpoint records a shipment passing a location. Deviation is empty when
+// the checkpoint matches the planned route.
+type Checkpoint struct {
+	ShipmentID string `json:"shipment_id"`
+	Code       string `json:"code"`
+	RecordedAt string `json:"recorded_at"`
+	Deviation  string `json:"deviation"`
+	RecordedBy string `json:"recorded_by"`
+	TxID       string `json:"tx_id"`
+}
+
+// SetRoutePlan stores the planned route of a shipment, replacing any earlier
+// plan. Only the shipper or an admin can plan the route.
+func (s *SupplyChainContract) SetRoutePlan(ctx contractapi.TransactionContextInterface, shipmentID string, checkpoints []string, securitySensitive bool) error {
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	if len(checkpoints) == 0 {
+		return newError(ErrInvalidArgument, "a route plan needs at least one checkpoint")
+	}
+	seen := make(map[string]bool)
+	for _, code := range checkpoints {
+		if code == "" || seen[code] {
+			return newError(ErrInvalidArgument, "checkpoint codes must be non-empty and unique; got %q", code)
+		}
+		seen[code] = true
+	}
+	shipment, err := s.QueryShipment(ctx, shipmentID)
+	if err != nil {
+		return err
+	}
+	if err := s.requireShipper(ctx, shipment, "plan the route of"); err != nil {
+		return err
+	}
+
+	plan := RoutePlan{
+		ShipmentID:        shipmentID,
+		Checkpoints:       checkpoints,
+		SecuritySensitive: securitySensitive,
+		UpdatedAt:         curTime,
+	}
+	key, err := s.makeKey(ctx, routePlanObjectType, shipmentID)
+	if err != nil {
+		return err
+	}
+	return s.putState(ctx, key, &plan)
+}
+
+// QueryRoutePlan retrieves the planned route of a shipment
+func (s *SupplyChainContract) QueryRoutePlan(ctx contractapi.TransactionContextInterface, shipmentID string) (*RoutePlan, error) {
+	key, err := s.makeKey(ctx, routePlanObjectType, shipmentID)
+	if err != nil {
+		return nil, err
+	}
+
+	var plan RoutePlan
+	exists, err := s.getState(ctx, key, &plan)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, newError(ErrNotFound, "shipment with ID %s has no route plan", shipmentID)
+	}
+
+	return &plan, nil
+}
+
+// RecordCheckpoint records a shipment passing a checkpoint at recordedAt
+// (RFC3339, defaults to the transaction time, and cannot lie in the future).
+// Only the shipment's carrier, the carrier of one of its legs or an admin can
+// record checkpoints. If the shipment has a route plan, a checkpoint that is
+// not on it or comes before one already passed is flagged as a deviation;
+// for security-sensitive cargo a RouteDeviation event is emitted as well.
+func (s *SupplyChainContract) RecordCheckpoint(ctx contractapi.TransactionContextInterface, shipmentID, code, recordedAt string) (*Checkpoint, error) {
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if code == "" {
+		return nil, newError(ErrInvalidArgument, "checkpoint code must not be empty")
+	}
+	if recordedAt == "" {
+		recordedAt = curTime
+	} else {
+		parsed, err := time.Parse(time.RFC3339, recordedAt)
+		if err != nil {
+			return nil, newError(ErrInvalidArgument, "recorded_at must be an RFC3339 timestamp: %v", err)
+		}
+		recordedAt = parsed.UTC().Format(time.RFC3339)
+	}
+	if recordedAt > curTime {
+		return nil, newError(ErrInvalidArgument, "recorded_at %s is in the future", recordedAt)
+	}
+	shipment, err := s.QueryShipment(ctx, shipmentID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.requireCarrier(ctx, shipment, "", "record checkpoints of"); err != nil {
+		return nil, err
+	}
+	clientID, err := s.getClientID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	checkpoint := Checkpoint{
+		ShipmentID: shipmentID,
+		Code:       code,
+		RecordedAt: recordedAt,
+		RecordedBy: clientID,
+		TxID:       ctx.GetStub().GetTxID(),
+	}
+
+	planKey, err := s.makeKey(ctx, routePlanObjectType, shipmentID)
+	if err != nil {
+		return nil, err
+	}
+	var plan RoutePlan
+	planned, err := s.getState(ctx, planKey, &plan)
+	if err != nil {
+		return nil, err
+	}
+	if planned {
+		position := make(map[string]int, len(plan.Checkpoints))
+		for i, planned := range plan.Checkpoints {
+			position[planned] = i
+		}
+
+		// The furthest planned checkpoint passed before this one
+		furthest := -1
+		previous, err := s.GetShipmentCheckpoints(ctx, shipmentID)
+		if err != nil {
+			return nil, err
+		}
+		for _, passed := range previous {
+			if passed.RecordedAt > recordedAt {
+				break
+			}
+			if i, ok := position[passed.Code]; ok && i > furthest {
+				furthest = i
+			}
+		}
+
+		if i, ok := position[code]; !ok {
+			checkpoint.Deviation = DeviationUnplanned
+		} else if i <= furthest {
+			checkpoint.Deviation = DeviationOutOfOrder
+		}
+	}
+
+	key, err := s.makeKey(ctx, checkpointObjectType, shipmentID, recordedAt, checkpoint.TxID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.putState(ctx, key, &checkpoint); err != nil {
+		return nil, err
+	}
+
+	if checkpoint.Deviation != "" && plan.SecuritySensitive {
+		eventJSON, err := json.Marshal(checkpoint)
+		if err != nil {
+			return nil, err
+		}
+		if err := ctx.GetStub().SetEvent(EventRouteDeviation, eventJSON); err != nil {
+			return nil, wrapError(err, "failed to set event")
+		}
+	}
+
+	return &checkpoint, nil
+}
+
+// GetShipmentCheckpoints returns every checkpoint recorded for a shipment, ordered by time
+func (s *SupplyChainContract) GetShipmentCheckpoints(ctx contractapi.TransactionContextInterface, shipmentID string) ([]*Checkpoint, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(checkpointObjectType, []string{shipmentID})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var checkpoints []*Checkpoint
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var checkpoint Checkpoint
+		if err := json.Unmarshal(queryResponse.Value, &checkpoint); err != nil {
+			return nil, err
+		}
+		checkpoints = append(checkpoints, &checkpoint)
+	}
+
+	return checkpoints, nil
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	saleObjectType            = "sale"
+	saleManufacturerIndexName = "sale~manufacturer"
+	productStatusSold         = "Sold"
+)
+
+// Sell-through report periods
+const (
+	PeriodDay   = "day"
+	PeriodMonth = "month"
+	PeriodYear  = "year"
+)
+
+// periodLength is how much of an RFC3339 timestamp identifies each period
+var periodLength = map[string]int{
+	PeriodDay:   len("2006-01-02"),
+	PeriodMonth: len("2006-01"),
+	PeriodYear:  len("2006"),
+}
+
+// SaleMeta describes a consumer sale. SoldAt (RFC3339) defaults to the
+// transaction time; Price is informational and in Currency.
+type SaleMeta struct {
+	Region   string  `json:"region"`
+	SoldAt   string  `json:"sold_at"`
+	Price    float64 `json:"price"`
+	Currency string  `json:"currency"`
+	Channel  string  `json:"channel"`
+}
+
+// Sale is the end-of-chain sale of a product to a consumer
+type Sale struct {
+	ProductID    string  `json:"product_id"`
+	Model        string  `json:"model"`
+	Manufacturer string  `json:"manufacturer"`
+	Retailer     string  `json:"retailer"`
+	Region       string  `json:"region"`
+	SoldAt       string  `json:"sold_at"`
+	Price        float64 `json:"price"`
+	Currency     string  `json:"currency"`
+	Channel      string  `json:"channel"`
+	EarlySale    bool    `json:"early_sale"`
+	RecordedBy   string  `json:"recorded_by"`
+	TxID         string  `json:"tx_id"`
+	CreatedAt    string  `json:"created_at"`
+}
+
+// SellThroughRow is the number of units sold in one period and region
+type SellThroughRow struct {
+	Period string `json:"period"`
+	Region string `json:"region"`
+	Units  int    `json:"units"`
+}
+
+// SellThroughReport is a manufacturer's consumer sales per period and region
+type SellThroughReport struct {
+	Manufacturer string           `json:"manufacturer"`
+	Period       string           `json:"period"`
+	From         string           `json:"from"`
+	To           string           `json:"to"`
+	TotalUnits   int              `json:"total_units"`
+	Rows         []SellThroughRow `json:"rows"`
+}
+
+// RecordSale records the sale of a product to a consumer and marks it Sold.
+// Only the organization owning the product may record it, and a registered
+// owner must be a retailer. saleMeta is a JSON SaleMeta. Sales before an
+// embargo on the product lifted in the region are flagged, and qualifying
+// sales accrue rebates under running promotions.
+func (s *SupplyChainContract) RecordSale(ctx contractapi.TransactionContextInterface, productID, saleMeta string) (*Sale, error) {
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var meta SaleMeta
+	if err := json.Unmarshal([]byte(saleMeta), &meta); err != nil {
+		return nil, newError(ErrInvalidArgument, "failed to parse sale: %v", err)
+	}
+
+	product, err := s.QueryProduct(ctx, productID)
+	if err != nil {
+		return nil, err
+	}
+	mspID, err := s.getClientMSPID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if mspID != product.Owner {
+		return nil, newError(ErrForbidden, "only the owner %s can record the sale of product %s", product.Owner, productID)
+	}
+	participant, err := s.findParticipant(ctx, product.Owner)
+	if err != nil {
+		return nil, err
+	}
+	if participant != nil && participant.Role != ParticipantRetailer {
+		return nil, newError(ErrConflict, "%s is registered as a %s, not a retailer", product.Owner, participant.Role)
+	}
+	switch product.Status {
+	case productStatusSold, productStatusConsumed, "Recalled":
+		return nil, newError(ErrConflict, "product %s is %s and cannot be sold", productID, product.Status)
+	}
+
+	if meta.Region == "" {
+		return nil, newError(ErrInvalidArgument, "sale region must not be empty")
+	}
+	if meta.Price < 0 {
+		return nil, newError(ErrInvalidArgument, "sale price must not be negative")
+	}
+	soldAt := curTime
+	if meta.SoldAt != "" {
+		parsed, err := time.Parse(time.RFC3339, meta.SoldAt)
+		if err != nil {
+			return nil, newError(ErrInvalidArgument, "sold_at must be an RFC3339 timestamp: %v", err)
+		}
+		soldAt = parsed.UTC().Format(time.RFC3339)
+		if soldAt > curTime {
+			return nil, newError(ErrInvalidArgument, "sold_at %s is in the future", soldAt)
+		}
+	}
+
+	earlySale, err := s.flagEarlySale(ctx, product, meta.Region, soldAt)
+	if err != nil {
+		return nil, err
+	}
+	clientID, err := s.getClientID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	sale := Sale{
+		ProductID:    productID,
+		Model:        product.Name,
+		Manufacturer: product.Manufacturer,
+		Retailer:     product.Owner,
+		Region:       meta.Region,
+		SoldAt:       soldAt,
+		Price:        meta.Price,
+		Currency:     meta.Currency,
+		Channel:      meta.Channel,
+		EarlySale:    earlySale,
+		RecordedBy:   clientID,
+		TxID:         ctx.GetStub().GetTxID(),
+		CreatedAt:    curTime,
+	}
+
+	key, err := s.makeKey(ctx, saleObjectType, productID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.putState(ctx, key, &sale); err != nil {
+		return nil, err
+	}
+	// Keyed by sale time so a manufacturer's sales come back in order
+	if err := s.putIndexKey(ctx, saleManufacturerIndexName, sale.Manufacturer, soldAt, productID); err != nil {
+		return nil, err
+	}
+	if err := s.accrueRebates(ctx, &sale); err != nil {
+		return nil, err
+	}
+
+	before := *product
+	if err := s.setProductStatus(ctx, product, productStatusSold, nil); err != nil {
+		return nil, err
+	}
+	product.UpdatedAt = curTime
+	if err := s.putIndexedProduct(ctx, before, product); err != nil {
+		return nil, err
+	}
+
+	return &sale, nil
+}
+
+// QuerySale retrieves the sale record of a product
+func (s *SupplyChainContract) QuerySale(ctx contractapi.TransactionContextInterface, productID string) (*Sale, error) {
+	key, err := s.makeKey(ctx, saleObjectType, productID)
+	if err != nil {
+		return nil, err
+	}
+
+	var sale Sale
+	exists, err := s.getState(ctx, key, &sale)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, newError(ErrNotFound, "no sale recorded for product %s", productID)
+	}
+
+	return &sale, nil
+}
+
+// GetSellThroughReport counts a manufacturer's consumer sales per period
+// (day, month or year) and region. from and to (RFC3339) are optional and
+// bound the sale time as [from, to).
+func (s *SupplyChainContract) GetSellThroughReport(ctx contractapi.TransactionContextInterface, manufacturer, period, from, to string) (*SellThroughReport, error) {
+	length, ok := periodLength[period]
+	if !ok {
+		return nil, newError(ErrInvalidArgument, "period must be %s, %s or %s", PeriodDay, PeriodMonth, PeriodYear)
+	}
+	for _, bound := range []*string{&from, &to} {
+		if *bound == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, *bound)
+		if err != nil {
+			return nil, newError(ErrInvalidArgument, "report bounds must be RFC3339 timestamps: %v", err)
+		}
+		*bound = parsed.UTC().Format(time.RFC3339)
+	}
+
+	sales, err := s.getManufacturerSales(ctx, manufacturer, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[SellThroughRow]int)
+	for _, sale := range sales {
+		counts[SellThroughRow{Period: sale.SoldAt[:length], Region: sale.Region}]++
+	}
+
+	report := &SellThroughReport{Manufacturer: manufacturer, Period: period, From: from, To: to, TotalUnits: len(sales), Rows: []SellThroughRow{}}
+	for row, units := range counts {
+		row.Units = units
+		report.Rows = append(report.Rows, row)
+	}
+	sort.Slice(report.Rows, func(i, j int) bool {
+		if report.Rows[i].Period != report.Rows[j].Period {
+			return report.Rows[i].Period < report.Rows[j].Period
+		}
+		return report.Rows[i].Region < report.Rows[j].Region
+	})
+
+	return report, nil
+}
+
+// getManufacturerSales returns a manufacturer's sales with a sale time in
+// [from, to), in sale time order. Empty bounds are open.
+func (s *SupplyChainContract) getManufacturerSales(ctx contractapi.TransactionContextInterface, manufacturer, from, to string) ([]*Sale, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(saleManufacturerIndexName, []string{manufacturer})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	sales := []*Sale{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		_, keyParts, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, err
+		}
+		if len(keyParts) != 3 {
+			continue
+		}
+		soldAt := keyParts[1]
+		if (from != "" && soldAt < from) || (to != "" && soldAt >= to) {
+			continue
+		}
+
+		sale, err := s.QuerySale(ctx, keyParts[2])
+		if err != nil {
+			return nil, err
+		}
+		sales = append(sales, sale)
+	}
+
+	return sales, nil
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"math"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// maxSyntheticReadings bounds the size of a single synthetic stream so a
+// sandbox call cannot produce an oversized transaction
+const maxSyntheticReadings = 500
+
+// EnableSandbox turns on sandbox mode. It is only available to admins on a
+// channel explicitly configured as a development environment.
+func (s *SupplyChainContract) EnableSandbox(ctx contractapi.TransactionContextInterface) error {
+	if err := s.requireRole(ctx, RoleAdmin); err != nil {
+		return err
+	}
+
+	config, err := s.GetContractConfig(ctx)
+	if err != nil {
+		return err
+	}
+	if config.Environment != EnvironmentDevelopment {
+		return newError(ErrConflict, "sandbox mode is only available in the %s environment", EnvironmentDevelopment)
+	}
+
+	config.SandboxEnabled = true
+	return s.putContractConfig(ctx, config)
+}
+
+// DisableSandbox turns off sandbox mode and clears any time offset
+func (s *SupplyChainContract) DisableSandbox(ctx contractapi.TransactionContextInterface) error {
+	if err := s.requireRole(ctx, RoleAdmin); err != nil {
+		return err
+	}
+
+	config, err := s.GetContractConfig(ctx)
+	if err != nil {
+		return err
+	}
+
+	config.SandboxEnabled = false
+	config.TimeOffsetSeconds = 0
+	return s.putContractConfig(ctx, config)
+}
+
+// SetTimeOffset shifts the contract clock seen by every transaction by
+// offsetSeconds, so expiry and SLA rules can be exercised without waiting
+func (s *SupplyChainContract) SetTimeOffset(ctx contractapi.TransactionContextInterface, offsetSeconds int64) error {
+	config, err := s.requireSandbox(ctx)
+	if err != nil {
+		return err
+	}
+
+	config.TimeOffsetSeconds = offsetSeconds
+	return s.putContractConfig(ctx, config)
+}
+
+// AdvanceClock moves the contract clock forward by seconds on top of the
+// current offset, so a test can step through expiry, SLA and approval
+// windows one transaction at a time
+func (s *SupplyChainContract) AdvanceClock(ctx contractapi.TransactionContextInterface, seconds int64) error {
+	config, err := s.requireSandbox(ctx)
+	if err != nil {
+		return err
+	}
+	if seconds <= 0 {
+		return newError(ErrInvalidArgument, "the clock can only be advanced by a positive number of seconds")
+	}
+
+	config.TimeOffsetSeconds += seconds
+	return s.putContractConfig(ctx, config)
+}
+
+// ContractClock is the result of GetContractClock. ContractTime is what
+// every time rule of this transaction sees; it differs from TxTime only
+// while sandbox mode is active on a development channel.
+type ContractClock struct {
+	TxTime        string `json:"tx_time"`
+	OffsetSeconds int64  `json:"offset_seconds"`
+	ContractTime  string `json:"contract_time"`
+	SandboxActive bool   `json:"sandbox_active"`
+}
+
+// GetContractClock returns the transaction time, the sandbox offset in
+// effect and the resulting contract time
+func (s *SupplyChainContract) GetContractClock(ctx contractapi.TransactionContextInterface) (*ContractClock, error) {
+	txTime, err := rawTxTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+	config, err := s.GetContractConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	contractTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	clock := &ContractClock{
+		TxTime:        txTime.Format(time.RFC3339),
+		ContractTime:  contractTime,
+		SandboxActive: config.sandboxActive(),
+	}
+	if clock.SandboxActive {
+		clock.OffsetSeconds = config.TimeOffsetSeconds
+	}
+	return clock, nil
+}
+
+// GenerateSyntheticReadings writes count synthetic readings for a shipment,
+// intervalSeconds apart starting at the current contract time. Temperatures
+// follow a sine wave of the given amplitude around baseTemperature, which is
+// deterministic so every endorser produces the same write set.
+func (s *SupplyChainContract) GenerateSyntheticReadings(ctx contractapi.TransactionContextInterface, shipmentID, deviceID string, count, intervalSeconds int, baseTemperature, amplitude float64) error {
+	if _, err := s.requireSandbox(ctx); err != nil {
+		return err
+	}
+	if count <= 0 || count > maxSyntheticReadings {
+		return newError(ErrInvalidArgument, "count must be between 1 and %d", maxSyntheticReadings)
+	}
+	if intervalSeconds <= 0 {
+		return newError(ErrInvalidArgument, "interval must be a positive number of seconds")
+	}
+
+	if _, err := s.QueryShipment(ctx, shipmentID); err != nil {
+		return err
+	}
+
+	start, err := s.getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	writer, err := s.newTelemetryWriter(ctx)
+	if err != nil {
+		return err
+	}
+	txID := ctx.GetStub().GetTxID()
+	for i := 0; i < count; i++ {
+		// One full wave every 24 readings
+		phase := 2 * math.Pi * float64(i) / 24
+		reading := SensorReading{
+			ShipmentID:  shipmentID,
+			DeviceID:    deviceID,
+			Temperature: math.Round((baseTemperature+amplitude*math.Sin(phase))*100) / 100,
+			RecordedAt:  start.Add(time.Duration(i*intervalSeconds) * time.Second).Format(time.RFC3339),
+			Synthetic:   true,
+			TxID:        txID,
+		}
+		if err := writer.add(&reading); err != nil {
+			return err
+		}
+	}
+
+	return writer.flush()
+}
+
+// requireSandbox returns the contract config if the caller is an admin and
+// sandbox mode is active on a development channel
+func (s *SupplyChainContract) requireSandbox(ctx contractapi.TransactionContextInterface) (*ContractConfig, error) {
+	if err := s.requireRole(ctx, RoleAdmin); err != nil {
+		return nil, err
+	}
+
+	config, err := s.GetContractConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !config.sandboxActive() {
+		return nil, newError(ErrConflict, "sandbox mode is not enabled")
+	}
+	return config, nil
+}
+
+// sandboxActive reports whether sandbox features may take effect. The
+// environment is re-checked here so a production config can never honour a
+// stale sandbox flag.
+func (c *ContractConfig) sandboxActive() bool {
+	return c.SandboxEnabled && c.Environment == EnvironmentDevelopment
+}
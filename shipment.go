@@ -0,0 +1,768 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	shipmentObjectType       = "shipment"
+	discrepancyObjectType    = "discrepancy"
+	productShipmentIndexName = "product~shipment"
+	shipmentProductIndexName = "shipment~product"
+)
+
+// Shipment statuses
+const (
+	ShipmentCreated           = "Created"
+	ShipmentInTransit         = "InTransit"
+	ShipmentDelayed           = "Delayed"
+	ShipmentCancelled         = "Cancelled"
+	ShipmentPartiallyReceived = "PartiallyReceived"
+	ShipmentReceived          = "Received"
+)
+
+// shipmentStatusTransitions are the moves UpdateShipmentStatus may make.
+// Receiving is recorded by ReceiveShipment and ConfirmDelivery instead, from
+// any status short of Cancelled.
+var shipmentStatusTransitions = map[string][]string{
+	ShipmentCreated:   {ShipmentInTransit, ShipmentCancelled},
+	ShipmentInTransit: {ShipmentDelayed},
+	ShipmentDelayed:   {ShipmentInTransit},
+}
+
+// Discrepancy types and statuses
+const (
+	DiscrepancyMissing = "Missing"
+	DiscrepancyExtra   = "Extra"
+
+	DiscrepancyOpen     = "Open"
+	DiscrepancyResolved = "Resolved"
+)
+
+// Shipment represents a movement of a set of products between two parties.
+// ShipperMSP is the organization that created it.
+type Shipment struct {
+	ID                 string   `json:"id"`
+	Carrier            string   `json:"carrier"`
+	Origin             string   `json:"origin"`
+	Destination        string   `json:"destination"`
+	ConsigneeMSP       string   `json:"consignee_msp"`
+	ShipperMSP         string   `json:"shipper_msp"`
+	ProductIDs         []string `json:"product_ids"`
+	ReceivedProductIDs []string `json:"received_product_ids"`
+	Status             string   `json:"status"`
+	// ETA is the expected arrival time (RFC3339), when known
+	ETA string `json:"eta"`
+	// ReceivedAt is when the shipment was fully received
+	ReceivedAt string `json:"received_at"`
+	// TemperatureRange is set for cold-chain shipments
+	TemperatureRange *TemperatureRange `json:"temperature_range,omitempty" metadata:",optional"`
+	CreatedAt        string            `json:"created_at"`
+	UpdatedAt        string            `json:"updated_at"`
+}
+
+// Discrepancy records a difference between what a shipment was expected to
+// carry and what the receiver actually confirmed
+type Discrepancy struct {
+	ID         string `json:"id"`
+	ShipmentID string `json:"shipment_id"`
+	ProductID  string `json:"product_id"`
+	Type       string `json:"type"`
+	Note       string `json:"note"`
+	Status     string `json:"status"`
+	Resolution string `json:"resolution"`
+	CreatedAt  string `json:"created_at"`
+	UpdatedAt  string `json:"updated_at"`
+}
+
+// CreateShipment registers a new shipment carrying the given products, none
+// of which may already be on a shipment that has not been received or
+// cancelled. Only their owner or an admin can ship them, and they become
+// Shipped.
+func (s *SupplyChainContract) CreateShipment(ctx contractapi.TransactionContextInterface, id, carrier, origin, destination string, productIDs []string) error {
+	return s.createShipment(ctx, id, carrier, origin, destination, "", productIDs)
+}
+
+// CreateConsignedShipment registers a new shipment whose delivery can be
+// confirmed by the consignee organization (MSP ID) with ConfirmDelivery
+func (s *SupplyChainContract) CreateConsignedShipment(ctx contractapi.TransactionContextInterface, id, carrier, origin, destination, consigneeMSP string, productIDs []string) error {
+	if consigneeMSP == "" {
+		return newError(ErrInvalidArgument, "consignee MSP ID must not be empty")
+	}
+	return s.createShipment(ctx, id, carrier, origin, destination, consigneeMSP, productIDs)
+}
+
+// createShipment is the shared implementation of CreateShipment and CreateConsignedShipment
+func (s *SupplyChainContract) createShipment(ctx contractapi.TransactionContextInterface, id, carrier, origin, destination, consigneeMSP string, productIDs []string) error {
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	key, err := s.makeKey(ctx, shipmentObjectType, id)
+	if err != nil {
+		return err
+	}
+	var existing Shipment
+	exists, err := s.getState(ctx, key, &existing)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return newError(ErrAlreadyExists, "shipment with ID %s already exists", id)
+	}
+
+	mspID, err := s.getClientMSPID(ctx)
+	if err != nil {
+		return err
+	}
+
+	listed := make(map[string]bool, len(productIDs))
+	products := make([]*Product, 0, len(productIDs))
+	for _, productID := range productIDs {
+		if listed[productID] {
+			return newError(ErrInvalidArgument, "product %s is listed more than once", productID)
+		}
+		listed[productID] = true
+
+		product, err := s.QueryProduct(ctx, productID)
+		if err != nil {
+			return err
+		}
+		if err := s.checkShippable(ctx, product); err != nil {
+			return err
+		}
+		products = append(products, product)
+	}
+
+	shipment := Shipment{
+		ID:                 id,
+		Carrier:            carrier,
+		Origin:             origin,
+		Destination:        destination,
+		ConsigneeMSP:       consigneeMSP,
+		ShipperMSP:         mspID,
+		ProductIDs:         productIDs,
+		ReceivedProductIDs: []string{},
+		Status:             ShipmentCreated,
+		CreatedAt:          curTime,
+		UpdatedAt:          curTime,
+	}
+
+	for _, product := range products {
+		if err := s.shipProduct(ctx, &shipment, product, curTime); err != nil {
+			return err
+		}
+	}
+
+	return s.putShipment(ctx, &shipment)
+}
+
+// QueryShipment retrieves a single shipment from the ledger by ID
+func (s *SupplyChainContract) QueryShipment(ctx contractapi.TransactionContextInterface, id string) (*Shipment, error) {
+	key, err := s.makeKey(ctx, shipmentObjectType, id)
+	if err != nil {
+		return nil, err
+	}
+
+	var shipment Shipment
+	exists, err := s.getState(ctx, key, &shipment)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, newError(ErrNotFound, "shipment with ID %s does not exist", id)
+	}
+
+	return &shipment, nil
+}
+
+// UpdateShipmentStatus moves a shipment along its journey: from Created to
+// InTransit or Cancelled, and between InTransit and Delayed. eta (RFC3339)
+// replaces the expected arrival time. An empty status or eta keeps the
+// current one, so the ETA can be revised on its own. Only the carrier, the
+// shipper or an admin can update a shipment.
+func (s *SupplyChainContract) UpdateShipmentStatus(ctx contractapi.TransactionContextInterface, id, status, eta string) error {
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	shipment, err := s.QueryShipment(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := s.requireShipmentHandler(ctx, shipment, "update"); err != nil {
+		return err
+	}
+	if status == "" {
+		status = shipment.Status
+	}
+	if status != shipment.Status {
+		if err := checkShipmentTransition(shipment.Status, status); err != nil {
+			return err
+		}
+		if status == ShipmentInTransit {
+			if err := s.requireABACRole(ctx, RoleCarrier, "mark shipments InTransit"); err != nil {
+				return err
+			}
+		}
+	}
+	if eta != "" {
+		parsed, err := time.Parse(time.RFC3339, eta)
+		if err != nil {
+			return newError(ErrInvalidArgument, "ETA must be an RFC3339 timestamp: %v", err)
+		}
+		shipment.ETA = parsed.UTC().Format(time.RFC3339)
+	}
+
+	// Products shipped with the shipment leave with it
+	if status == ShipmentInTransit && shipment.Status == ShipmentCreated {
+		for _, productID := range shipment.ProductIDs {
+			if err := s.setShippedProductStatus(ctx, productID, "Shipped", "InTransit", curTime); err != nil {
+				return err
+			}
+		}
+	}
+
+	shipment.Status = status
+	shipment.UpdatedAt = curTime
+	return s.putShipment(ctx, shipment)
+}
+
+// AddProductToShipment puts a product on a shipment that has not been
+// received or cancelled. The product must not be on another such shipment,
+// and only its owner or an admin can ship it. It becomes Shipped, or
+// InTransit if the shipment is already under way.
+func (s *SupplyChainContract) AddProductToShipment(ctx contractapi.TransactionContextInterface, shipmentID, productID string) error {
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	shipment, err := s.QueryShipment(ctx, shipmentID)
+	if err != nil {
+		return err
+	}
+	if !shipmentOpen(shipment) {
+		return newError(ErrConflict, "shipment with ID %s is %s", shipmentID, shipment.Status)
+	}
+	if containsString(shipment.ProductIDs, productID) {
+		return newError(ErrAlreadyExists, "product %s is already on shipment %s", productID, shipmentID)
+	}
+	product, err := s.QueryProduct(ctx, productID)
+	if err != nil {
+		return err
+	}
+	if err := s.checkShippable(ctx, product); err != nil {
+		return err
+	}
+	if err := s.shipProduct(ctx, shipment, product, curTime); err != nil {
+		return err
+	}
+
+	shipment.ProductIDs = append(shipment.ProductIDs, productID)
+	shipment.UpdatedAt = curTime
+	return s.putShipment(ctx, shipment)
+}
+
+// checkShippable returns an error unless the caller may put a product on a
+// shipment: it must not be archived, frozen, in a final status or on
+// another open shipment, and only its owner or an admin can ship it
+func (s *SupplyChainContract) checkShippable(ctx contractapi.TransactionContextInterface, product *Product) error {
+	if product.Archived {
+		return newError(ErrConflict, "product %s is archived and must be restored first", product.ID)
+	}
+	if err := checkNotFrozen(product); err != nil {
+		return err
+	}
+	if err := s.requireProductOwner(ctx, product, "ship"); err != nil {
+		return err
+	}
+	if next, known := productStatusTransitions[product.Status]; known && len(next) == 0 {
+		return newError(ErrConflict, "product %s is %s and cannot be shipped", product.ID, product.Status)
+	}
+	return s.checkNotOnOpenShipment(ctx, product.ID)
+}
+
+// shipProduct moves a product checked by checkShippable onto a shipment. It
+// becomes Shipped, or InTransit if the shipment is already under way.
+func (s *SupplyChainContract) shipProduct(ctx contractapi.TransactionContextInterface, shipment *Shipment, product *Product, curTime string) error {
+	before := *product
+	if err := s.setProductStatus(ctx, product, "Shipped", nil); err != nil {
+		return err
+	}
+	if shipment.Status == ShipmentInTransit || shipment.Status == ShipmentDelayed {
+		if err := s.setProductStatus(ctx, product, "InTransit", nil); err != nil {
+			return err
+		}
+	}
+	product.UpdatedAt = curTime
+	if err := s.putIndexedProduct(ctx, before, product); err != nil {
+		return err
+	}
+	return s.putShipmentProductIndex(ctx, shipment.ID, product.ID)
+}
+
+// RemoveProductFromShipment takes a product off a shipment that has not
+// left yet. The product keeps its status; correct it with UpdateProduct
+// where it did not stay ready to ship. Only the shipper, the product's
+// owner or an admin can remove it.
+func (s *SupplyChainContract) RemoveProductFromShipment(ctx contractapi.TransactionContextInterface, shipmentID, productID string) error {
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	shipment, err := s.QueryShipment(ctx, shipmentID)
+	if err != nil {
+		return err
+	}
+	mspID, err := s.getClientMSPID(ctx)
+	if err != nil {
+		return err
+	}
+	if shipment.ShipperMSP == "" || mspID != shipment.ShipperMSP {
+		product, err := s.QueryProduct(ctx, productID)
+		if err != nil {
+			return err
+		}
+		if err := s.requireProductOwner(ctx, product, "unship"); err != nil {
+			return err
+		}
+	}
+	if shipment.Status != ShipmentCreated {
+		return newError(ErrConflict, "shipment with ID %s is %s; products can only be removed before it leaves", shipmentID, shipment.Status)
+	}
+	remaining := []string{}
+	for _, id := range shipment.ProductIDs {
+		if id != productID {
+			remaining = append(remaining, id)
+		}
+	}
+	if len(remaining) == len(shipment.ProductIDs) {
+		return newError(ErrNotFound, "product %s is not on shipment %s", productID, shipmentID)
+	}
+
+	if err := s.delIndexKey(ctx, productShipmentIndexName, productID, shipmentID); err != nil {
+		return err
+	}
+	if err := s.delIndexKey(ctx, shipmentProductIndexName, shipmentID, productID); err != nil {
+		return err
+	}
+
+	shipment.ProductIDs = remaining
+	shipment.UpdatedAt = curTime
+	return s.putShipment(ctx, shipment)
+}
+
+// GetShipmentProducts returns the products on a shipment. It reads the
+// shipment~product index.
+func (s *SupplyChainContract) GetShipmentProducts(ctx contractapi.TransactionContextInterface, shipmentID string) ([]*Product, error) {
+	if _, err := s.QueryShipment(ctx, shipmentID); err != nil {
+		return nil, err
+	}
+	productIDs, err := s.getIndexedIDs(ctx, shipmentProductIndexName, shipmentID)
+	if err != nil {
+		return nil, err
+	}
+
+	products := []*Product{}
+	for _, productID := range productIDs {
+		product, err := s.QueryProduct(ctx, productID)
+		if err != nil {
+			return nil, err
+		}
+		products = append(products, product)
+	}
+	return products, nil
+}
+
+// ReceiveShipment confirms which products actually arrived. Expected products
+// that were not received and received products that were not expected each
+// open a discrepancy record, as does every entry in discrepanciesJSON (a JSON
+// array of {"product_id", "type", "note"} for e.g. damaged goods). The
+// shipment stays PartiallyReceived while any discrepancy remains open. Only
+// the receiving organization or an admin can receive a shipment.
+func (s *SupplyChainContract) ReceiveShipment(ctx contractapi.TransactionContextInterface, shipmentID string, receivedProductIDs []string, discrepanciesJSON string) error {
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	shipment, err := s.QueryShipment(ctx, shipmentID)
+	if err != nil {
+		return err
+	}
+	if err := s.requireShipmentReceiver(ctx, shipment, "receive"); err != nil {
+		return err
+	}
+	if shipment.Status == ShipmentReceived {
+		return newError(ErrConflict, "shipment with ID %s has already been received", shipmentID)
+	}
+	if shipment.Status == ShipmentCancelled {
+		return newError(ErrConflict, "shipment with ID %s was cancelled", shipmentID)
+	}
+
+	var declared []Discrepancy
+	if discrepanciesJSON != "" {
+		if err := json.Unmarshal([]byte(discrepanciesJSON), &declared); err != nil {
+			return newError(ErrInvalidArgument, "failed to parse discrepancies: %v", err)
+		}
+	}
+
+	expected := make(map[string]bool, len(shipment.ProductIDs))
+	for _, productID := range shipment.ProductIDs {
+		expected[productID] = true
+	}
+	received := make(map[string]bool, len(shipment.ReceivedProductIDs))
+	for _, productID := range shipment.ReceivedProductIDs {
+		received[productID] = true
+	}
+
+	var opened []Discrepancy
+	for _, productID := range receivedProductIDs {
+		if received[productID] {
+			continue
+		}
+		received[productID] = true
+		shipment.ReceivedProductIDs = append(shipment.ReceivedProductIDs, productID)
+
+		if !expected[productID] {
+			opened = append(opened, Discrepancy{ProductID: productID, Type: DiscrepancyExtra, Note: "received but not on shipment manifest"})
+			continue
+		}
+
+		product, err := s.QueryProduct(ctx, productID)
+		if err != nil {
+			return err
+		}
+		before := *product
+		if err := s.setProductStatus(ctx, product, "Delivered", nil); err != nil {
+			return err
+		}
+		product.UpdatedAt = curTime
+		if err := s.putIndexedProduct(ctx, before, product); err != nil {
+			return err
+		}
+	}
+
+	// Products received in this call close any earlier "missing" records
+	existing, err := s.GetShipmentDiscrepancies(ctx, shipmentID)
+	if err != nil {
+		return err
+	}
+	stillOpen := false
+	missingOpen := make(map[string]bool)
+	for _, discrepancy := range existing {
+		if discrepancy.Status != DiscrepancyOpen {
+			continue
+		}
+		if discrepancy.Type == DiscrepancyMissing && received[discrepancy.ProductID] {
+			discrepancy.Status = DiscrepancyResolved
+			discrepancy.Resolution = "received in a later delivery"
+			discrepancy.UpdatedAt = curTime
+			if err := s.putDiscrepancy(ctx, discrepancy); err != nil {
+				return err
+			}
+			continue
+		}
+		if discrepancy.Type == DiscrepancyMissing {
+			missingOpen[discrepancy.ProductID] = true
+		}
+		stillOpen = true
+	}
+
+	for _, productID := range shipment.ProductIDs {
+		if !received[productID] && !missingOpen[productID] {
+			opened = append(opened, Discrepancy{ProductID: productID, Type: DiscrepancyMissing, Note: "on shipment manifest but not received"})
+		}
+	}
+	for _, discrepancy := range declared {
+		if discrepancy.Type == "" {
+			return newError(ErrInvalidArgument, "discrepancy for product %s is missing a type", discrepancy.ProductID)
+		}
+		opened = append(opened, Discrepancy{ProductID: discrepancy.ProductID, Type: discrepancy.Type, Note: discrepancy.Note})
+	}
+
+	txID := ctx.GetStub().GetTxID()
+	for i := range opened {
+		opened[i].ID = fmt.Sprintf("%s-%d", txID, i)
+		opened[i].ShipmentID = shipmentID
+		opened[i].Status = DiscrepancyOpen
+		opened[i].CreatedAt = curTime
+		opened[i].UpdatedAt = curTime
+		if err := s.putDiscrepancy(ctx, &opened[i]); err != nil {
+			return err
+		}
+	}
+
+	if stillOpen || len(opened) > 0 {
+		shipment.Status = ShipmentPartiallyReceived
+	} else {
+		shipment.Status = ShipmentReceived
+		shipment.ReceivedAt = curTime
+	}
+	shipment.UpdatedAt = curTime
+
+	return s.putShipment(ctx, shipment)
+}
+
+// ResolveDiscrepancy closes an open discrepancy. Once the last open
+// discrepancy of a partially received shipment is resolved, the shipment
+// moves to Received. Only the receiving organization or an admin can
+// resolve a discrepancy.
+func (s *SupplyChainContract) ResolveDiscrepancy(ctx contractapi.TransactionContextInterface, shipmentID, discrepancyID, resolution string) error {
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	shipment, err := s.QueryShipment(ctx, shipmentID)
+	if err != nil {
+		return err
+	}
+	if err := s.requireShipmentReceiver(ctx, shipment, "resolve discrepancies of"); err != nil {
+		return err
+	}
+
+	key, err := s.makeKey(ctx, discrepancyObjectType, shipmentID, discrepancyID)
+	if err != nil {
+		return err
+	}
+	var discrepancy Discrepancy
+	exists, err := s.getState(ctx, key, &discrepancy)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return newError(ErrNotFound, "discrepancy with ID %s does not exist on shipment %s", discrepancyID, shipmentID)
+	}
+	if discrepancy.Status == DiscrepancyResolved {
+		return newError(ErrConflict, "discrepancy with ID %s is already resolved", discrepancyID)
+	}
+
+	discrepancy.Status = DiscrepancyResolved
+	discrepancy.Resolution = resolution
+	discrepancy.UpdatedAt = curTime
+	if err := s.putDiscrepancy(ctx, &discrepancy); err != nil {
+		return err
+	}
+
+	if shipment.Status != ShipmentPartiallyReceived {
+		return nil
+	}
+	open, err := s.hasOpenDiscrepancies(ctx, shipmentID, discrepancyID)
+	if err != nil {
+		return err
+	}
+	if open {
+		return nil
+	}
+	shipment.Status = ShipmentReceived
+	shipment.ReceivedAt = curTime
+	shipment.UpdatedAt = curTime
+	return s.putShipment(ctx, shipment)
+}
+
+// GetShipmentDiscrepancies returns every discrepancy recorded against a shipment
+func (s *SupplyChainContract) GetShipmentDiscrepancies(ctx contractapi.TransactionContextInterface, shipmentID string) ([]*Discrepancy, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(discrepancyObjectType, []string{shipmentID})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var discrepancies []*Discrepancy
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var discrepancy Discrepancy
+		if err := json.Unmarshal(queryResponse.Value, &discrepancy); err != nil {
+			return nil, err
+		}
+		discrepancies = append(discrepancies, &discrepancy)
+	}
+
+	return discrepancies, nil
+}
+
+// hasOpenDiscrepancies reports whether any discrepancy on the shipment other
+// than excludeID is still open. It reads committed state, so the caller
+// excludes the record it is resolving in the current transaction.
+func (s *SupplyChainContract) hasOpenDiscrepancies(ctx contractapi.TransactionContextInterface, shipmentID, excludeID string) (bool, error) {
+	discrepancies, err := s.GetShipmentDiscrepancies(ctx, shipmentID)
+	if err != nil {
+		return false, err
+	}
+	for _, discrepancy := range discrepancies {
+		if discrepancy.Status == DiscrepancyOpen && discrepancy.ID != excludeID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// checkShipmentTransition returns an error unless UpdateShipmentStatus may
+// move a shipment from one status to another
+func checkShipmentTransition(from, to string) error {
+	next := shipmentStatusTransitions[from]
+	for _, status := range next {
+		if status == to {
+			return nil
+		}
+	}
+	if len(next) == 0 {
+		return newError(ErrConflict, "illegal shipment status transition from %s to %s: %s cannot be changed", from, to, from)
+	}
+	return newError(ErrConflict, "illegal shipment status transition from %s to %s: %s can only move to %s", from, to, from, strings.Join(next, " or "))
+}
+
+// requireShipmentHandler returns an error unless the caller's organization
+// carries or shipped the shipment, or the caller is an admin
+func (s *SupplyChainContract) requireShipmentHandler(ctx contractapi.TransactionContextInterface, shipment *Shipment, action string) error {
+	mspID, err := s.getClientMSPID(ctx)
+	if err != nil {
+		return err
+	}
+	if mspID == shipment.Carrier || mspID == shipment.ShipperMSP {
+		return nil
+	}
+	isAdmin, err := s.hasRole(ctx, RoleAdmin)
+	if err != nil {
+		return err
+	}
+	if !isAdmin {
+		return newError(ErrForbidden, "only the carrier or shipper of shipment %s can %s it", shipment.ID, action)
+	}
+	return nil
+}
+
+// requireShipper returns an error unless the caller's organization created
+// the shipment or the caller is an admin. action names the refused
+// operation in the error.
+func (s *SupplyChainContract) requireShipper(ctx contractapi.TransactionContextInterface, shipment *Shipment, action string) error {
+	mspID, err := s.getClientMSPID(ctx)
+	if err != nil {
+		return err
+	}
+	if shipment.ShipperMSP != "" && mspID == shipment.ShipperMSP {
+		return nil
+	}
+	isAdmin, err := s.hasRole(ctx, RoleAdmin)
+	if err != nil {
+		return err
+	}
+	if !isAdmin {
+		return newError(ErrForbidden, "only the shipper %s can %s shipment %s", shipment.ShipperMSP, action, shipment.ID)
+	}
+	return nil
+}
+
+// requireShipmentReceiver returns an error unless the caller's organization
+// is the consignee of the shipment, or its destination when it has none, or
+// the caller is an admin. action names the refused operation in the error.
+func (s *SupplyChainContract) requireShipmentReceiver(ctx contractapi.TransactionContextInterface, shipment *Shipment, action string) error {
+	receiver := shipmentReceiver(shipment)
+	mspID, err := s.getClientMSPID(ctx)
+	if err != nil {
+		return err
+	}
+	if mspID == receiver {
+		return nil
+	}
+	isAdmin, err := s.hasRole(ctx, RoleAdmin)
+	if err != nil {
+		return err
+	}
+	if !isAdmin {
+		return newError(ErrForbidden, "only the receiver %s can %s shipment %s", receiver, action, shipment.ID)
+	}
+	return nil
+}
+
+// shipmentReceiver returns the organization receiving a shipment: its
+// consignee, or its destination when it has none
+func shipmentReceiver(shipment *Shipment) string {
+	if shipment.ConsigneeMSP != "" {
+		return shipment.ConsigneeMSP
+	}
+	return shipment.Destination
+}
+
+// shipmentOpen reports whether a shipment can still take products
+func shipmentOpen(shipment *Shipment) bool {
+	return shipment.Status != ShipmentReceived && shipment.Status != ShipmentCancelled
+}
+
+// checkNotOnOpenShipment returns an error if a product is already on a
+// shipment that has not been received or cancelled
+func (s *SupplyChainContract) checkNotOnOpenShipment(ctx contractapi.TransactionContextInterface, productID string) error {
+	shipmentIDs, err := s.getIndexedIDs(ctx, productShipmentIndexName, productID)
+	if err != nil {
+		return err
+	}
+	for _, shipmentID := range shipmentIDs {
+		shipment, err := s.QueryShipment(ctx, shipmentID)
+		if err != nil {
+			return err
+		}
+		if shipmentOpen(shipment) {
+			return newError(ErrConflict, "product %s is already on open shipment %s", productID, shipmentID)
+		}
+	}
+	return nil
+}
+
+// setShippedProductStatus moves a product on a shipment from one status to
+// another, leaving it alone if it is in any other status. A frozen product
+// fails the whole shipment update.
+func (s *SupplyChainContract) setShippedProductStatus(ctx contractapi.TransactionContextInterface, productID, from, to, curTime string) error {
+	product, err := s.QueryProduct(ctx, productID)
+	if err != nil {
+		return err
+	}
+	if product.Status != from {
+		return nil
+	}
+	before := *product
+	if err := s.setProductStatus(ctx, product, to, nil); err != nil {
+		return err
+	}
+	product.UpdatedAt = curTime
+	return s.putIndexedProduct(ctx, before, product)
+}
+
+// putShipmentProductIndex indexes a product on a shipment both ways
+func (s *SupplyChainContract) putShipmentProductIndex(ctx contractapi.TransactionContextInterface, shipmentID, productID string) error {
+	if err := s.putIndexKey(ctx, productShipmentIndexName, productID, shipmentID); err != nil {
+		return err
+	}
+	return s.putIndexKey(ctx, shipmentProductIndexName, shipmentID, productID)
+}
+
+// putShipment is a helper method for inserting or updating a shipment in the ledger
+func (s *SupplyChainContract) putShipment(ctx contractapi.TransactionContextInterface, shipment *Shipment) error {
+	key, err := s.makeKey(ctx, shipmentObjectType, shipment.ID)
+	if err != nil {
+		return err
+	}
+	return s.putState(ctx, key, shipment)
+}
+
+// putDiscrepancy is a helper method for inserting or updating a discrepancy in the ledger
+func (s *SupplyChainContract) putDiscrepancy(ctx contractapi.TransactionContextInterface, discrepancy *Discrepancy) error {
+	key, err := s.makeKey(ctx, discrepancyObjectType, discrepancy.ShipmentID, discrepancy.ID)
+	if err != nil {
+		return err
+	}
+	return s.putState(ctx, key, discrepancy)
+}
@@ -3,6 +3,7 @@ package main // Package main, Do not change this line.
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
@@ -18,6 +19,26 @@ type Product struct {
 	UpdatedAt   string `json:"updated_at"`
 	Description string `json:"description"`
 	Category    string `json:"category"`
+	CreatedBy   string `json:"created_by"`
+	// PreviousOwner is the owner before the most recent transfer, used to
+	// recognise ownership reversals
+	PreviousOwner string `json:"previous_owner"`
+	LotID         string `json:"lot_id"`
+	Manufacturer  string `json:"manufacturer"`
+	SerialNumber  string `json:"serial_number"`
+	// Archived products are out of circulation: listings leave them out and
+	// they cannot be updated or transferred until restored
+	Archived bool `json:"archived"`
+	// Frozen products are held by a regulator: they cannot be updated,
+	// transferred, archived or deleted until unfrozen
+	Frozen       bool   `json:"frozen"`
+	FreezeReason string `json:"freeze_reason"`
+	// DID is the product's decentralized identifier, registered with
+	// RegisterDID
+	DID string `json:"did"`
+	// SchemaVersion is the version of the record's layout; records written
+	// before versioning are version 0 and are upgraded as they are read
+	SchemaVersion int `json:"schema_version"`
 }
 
 // SupplyChainContract defines the smart contract structure
@@ -25,42 +46,155 @@ type SupplyChainContract struct {
 	contractapi.Contract
 }
 
+// GetBeforeTransaction makes beforeTransaction run ahead of every transaction function
+func (s *SupplyChainContract) GetBeforeTransaction() interface{} {
+	return s.beforeTransaction
+}
+
+// beforeTransaction refuses transactions outside the caller's programs and
+// writes while the contract is paused, flags calls to deprecated methods,
+// counts the transaction in the usage statistics and accounts it against
+// its organization's quotas
+func (s *SupplyChainContract) beforeTransaction(ctx contractapi.TransactionContextInterface) error {
+	if err := s.checkProgram(ctx); err != nil {
+		return err
+	}
+	pausedQuery, err := s.checkPaused(ctx)
+	if err != nil {
+		return err
+	}
+	if err := s.warnDeprecated(ctx); err != nil {
+		return err
+	}
+	if err := s.recordMethodStats(ctx); err != nil {
+		return err
+	}
+	if err := s.accountUsage(ctx); err != nil {
+		return err
+	}
+	if pausedQuery {
+		lockWrites(ctx)
+	}
+	return nil
+}
+
+// transactionName returns the name of the function being invoked, without
+// the contract name prefix
+func transactionName(ctx contractapi.TransactionContextInterface) string {
+	function, _ := ctx.GetStub().GetFunctionAndParameters()
+	if i := strings.LastIndex(function, ":"); i >= 0 {
+		function = function[i+1:]
+	}
+	return function
+}
+
 func (s *SupplyChainContract) getTimestamp(ctx contractapi.TransactionContextInterface) (string, error) {
+	txTime, err := s.getTxTime(ctx)
+	if err != nil {
+		return "", err
+	}
+	return txTime.Format(time.RFC3339), nil
+}
+
+// getTxTime returns the transaction timestamp, for callers that need to do
+// date arithmetic. In sandbox mode the configured time offset is applied.
+func (s *SupplyChainContract) getTxTime(ctx contractapi.TransactionContextInterface) (time.Time, error) {
 	txTimestamp, err := ctx.GetStub().GetTxTimestamp()
 	if err != nil {
-		return "", fmt.Errorf("failed to get transaction timestamp: %v", err)
+		return time.Time{}, wrapError(err, "failed to get transaction timestamp")
+	}
+	txTime := time.Unix(txTimestamp.Seconds, int64(txTimestamp.Nanos)).UTC()
+
+	config, err := s.GetContractConfig(ctx)
+	if err != nil {
+		return time.Time{}, err
 	}
-	return time.Unix(txTimestamp.Seconds, int64(txTimestamp.Nanos)).Format(time.RFC3339), nil
+	if config.sandboxActive() {
+		txTime = txTime.Add(time.Duration(config.TimeOffsetSeconds) * time.Second)
+	}
+	return txTime, nil
 }
 
+// InitLedger seeds the ledger with sample products. Only admins can call it,
+// and it refuses to overwrite products that already exist.
 func (s *SupplyChainContract) InitLedger(ctx contractapi.TransactionContextInterface) error {
+	if err := s.requireRole(ctx, RoleAdmin); err != nil {
+		return err
+	}
 	curTime, err := s.getTimestamp(ctx)
 	if err != nil {
 		return err
 	}
+	clientID, err := s.getClientID(ctx)
+	if err != nil {
+		return err
+	}
 
 	assets := []Product{
-		{ID: "p1", Name: "Laptop", Status: "Manufactured", Owner: "CompanyA", CreatedAt: curTime, UpdatedAt: curTime, Description: "High-end gaming laptop", Category: "Electronics"},
-		{ID: "p2", Name: "Smartphone", Status: "Manufactured", Owner: "CompanyB", CreatedAt: curTime, UpdatedAt: curTime, Description: "Latest model smartphone", Category: "Electronics"},
+		{ID: "p1", Name: "Laptop", Status: "Manufactured", Owner: "CompanyA", CreatedAt: curTime, UpdatedAt: curTime, Description: "High-end gaming laptop", Category: "Electronics", CreatedBy: clientID},
+		{ID: "p2", Name: "Smartphone", Status: "Manufactured", Owner: "CompanyB", CreatedAt: curTime, UpdatedAt: curTime, Description: "Latest model smartphone", Category: "Electronics", CreatedBy: clientID},
 	}
 
 	for _, asset := range assets {
-		assetJSON, err := json.Marshal(asset)
+		exists, err := s.ProductExists(ctx, asset.ID)
 		if err != nil {
 			return err
 		}
+		if exists {
+			return newError(ErrAlreadyExists, "product with ID %s already exists", asset.ID)
+		}
+	}
 
-		err = ctx.GetStub().PutState(asset.ID, assetJSON)
-		if err != nil {
-			return fmt.Errorf("failed to put to world state. %v", err)
+	for i := range assets {
+		if err := s.putProduct(ctx, &assets[i]); err != nil {
+			return err
+		}
+		if err := s.indexProduct(ctx, nil, &assets[i]); err != nil {
+			return err
 		}
 	}
 
 	return nil
 }
 
-// CreateProduct creates a new product in the ledger
-func (s *SupplyChainContract) CreateProduct(ctx contractapi.TransactionContextInterface, id, name, owner, description, category string) error {
+// CreateProduct creates a new product in the ledger. The creator's price
+// and internal notes can be passed as PrivateProductDetails JSON in the
+// transient field "details"; they are kept in its private collection.
+func (s *SupplyChainContract) CreateProduct(ctx contractapi.TransactionContextInterface, id, name, owner, description, category string) (*TxResult, error) {
+	// The first owner of a newly manufactured product is its manufacturer
+	if err := s.createProduct(ctx, id, name, owner, description, category, owner, "", false); err != nil {
+		return nil, err
+	}
+	return txResult(ctx), nil
+}
+
+// CreateSerializedProduct creates a new product that carries a manufacturer
+// and serial number (or SKU). A product whose normalized manufacturer, name
+// and serial match an existing one is rejected as a probable duplicate unless
+// allowDuplicate is set, in which case a duplicate flag is recorded for review
+// and the result carries a DUPLICATE_SUSPECTED warning.
+func (s *SupplyChainContract) CreateSerializedProduct(ctx contractapi.TransactionContextInterface, id, name, owner, description, category, manufacturer, serialNumber string, allowDuplicate bool) (*TxResult, error) {
+	if err := s.createProduct(ctx, id, name, owner, description, category, manufacturer, serialNumber, allowDuplicate); err != nil {
+		return nil, err
+	}
+	return txResult(ctx), nil
+}
+
+func (s *SupplyChainContract) createProduct(ctx contractapi.TransactionContextInterface, id, name, owner, description, category, manufacturer, serialNumber string, allowDuplicate bool) error {
+	if err := validateFields(
+		field("id", &id, productIDRule),
+		field("name", &name, productNameRule),
+		field("owner", &owner, ownerRule),
+		field("description", &description, descriptionRule),
+		field("category", &category, categoryRule),
+		field("manufacturer", &manufacturer, manufacturerRule),
+		field("serial number", &serialNumber, serialNumberRule),
+	); err != nil {
+		return err
+	}
+	if err := s.requireABACRole(ctx, RoleManufacturer, "create products"); err != nil {
+		return err
+	}
 	// Check if the product already exists
 	curTime, err := s.getTimestamp(ctx)
 	if err != nil {
@@ -72,32 +206,117 @@ func (s *SupplyChainContract) CreateProduct(ctx contractapi.TransactionContextIn
 		return err
 	}
 	if exists {
-		return fmt.Errorf("product with ID %s already exists", id)
+		return newError(ErrAlreadyExists, "product with ID %s already exists", id)
+	}
+	if err := s.checkProductID(ctx, id, category); err != nil {
+		return err
+	}
+	if err := s.checkOwnerParticipant(ctx, owner); err != nil {
+		return err
+	}
+	clientID, err := s.getClientID(ctx)
+	if err != nil {
+		return err
 	}
 
 	// Create a new product
 	product := Product{
-		ID:          id,
-		Name:        name,
-		Status:      "Manufactured",
-		Owner:       owner,
-		CreatedAt:   curTime,
-		UpdatedAt:   curTime,
-		Description: description,
-		Category:    category,
+		ID:           id,
+		Name:         name,
+		Status:       "Manufactured",
+		Owner:        owner,
+		CreatedAt:    curTime,
+		UpdatedAt:    curTime,
+		Description:  description,
+		Category:     category,
+		CreatedBy:    clientID,
+		Manufacturer: manufacturer,
+		SerialNumber: serialNumber,
+	}
+	if err := s.checkCategoryRules(ctx, &product); err != nil {
+		return err
+	}
+
+	if serialNumber != "" {
+		if err := s.checkDuplicate(ctx, &product, allowDuplicate); err != nil {
+			return err
+		}
 	}
 
 	// Add the product to the ledger
-	assetJSON, err := json.Marshal(product)
-	if err != nil {
+	if err := s.putProduct(ctx, &product); err != nil {
 		return err
 	}
+	if err := s.indexProduct(ctx, nil, &product); err != nil {
+		return err
+	}
+	if _, err := s.putTransientPrivateDetails(ctx, id); err != nil {
+		return err
+	}
+	return s.emitProductEvent(ctx, EventProductCreated, ChangeCreate, nil, &product)
+}
 
-	return ctx.GetStub().PutState(id, assetJSON)
+// ProductPatch holds the fields UpdateProduct changes. Fields left out or
+// empty keep their current value.
+type ProductPatch struct {
+	Status      string `json:"status"`
+	Owner       string `json:"owner"`
+	Description string `json:"description"`
+	Category    string `json:"category"`
+}
+
+// UpdateProduct applies patchJSON, a ProductPatch, to a product's status,
+// owner, description, and category. While the state_machine feature is on,
+// a new status must be allowed by the lifecycle state machine (see
+// GetStatusTransitions); while it is off, only Recalled and Consumed
+// products are held to it, as they are final. Only the owning
+// organization or an admin can update a product. Status downgrades and
+// ownership reversals must go through UpdateProductWithReason. Private
+// details in the transient field "details" replace the caller's, as with
+// PutPrivateDetails.
+func (s *SupplyChainContract) UpdateProduct(ctx contractapi.TransactionContextInterface, id, patchJSON string) (*TxResult, error) {
+	decoder := json.NewDecoder(strings.NewReader(patchJSON))
+	decoder.DisallowUnknownFields()
+	var patch ProductPatch
+	if err := decoder.Decode(&patch); err != nil {
+		return nil, newError(ErrInvalidArgument, "failed to parse product patch: %v", err)
+	}
+	if err := s.updateProduct(ctx, id, patch.Status, patch.Owner, patch.Description, patch.Category, nil); err != nil {
+		return nil, err
+	}
+	return txResult(ctx), nil
+}
+
+// UpdateProductV1 is the positional form UpdateProduct had before it took a
+// ProductPatch. Its result carries a WarningDeprecatedCall warning.
+// Deprecated: use UpdateProduct.
+func (s *SupplyChainContract) UpdateProductV1(ctx contractapi.TransactionContextInterface, id string, newStatus string, newOwner string, newDescription string, newCategory string) (*TxResult, error) {
+	if err := s.updateProduct(ctx, id, newStatus, newOwner, newDescription, newCategory, nil); err != nil {
+		return nil, err
+	}
+	return txResult(ctx), nil
 }
 
-// UpdateProduct allows updating a product's status, owner, description, and category
-func (s *SupplyChainContract) UpdateProduct(ctx contractapi.TransactionContextInterface, id string, newStatus string, newOwner string, newDescription string, newCategory string) error {
+// UpdateProductWithReason is UpdateProduct with a reason code and note, as required
+// for status downgrades and ownership reversals
+func (s *SupplyChainContract) UpdateProductWithReason(ctx contractapi.TransactionContextInterface, id, newStatus, newOwner, newDescription, newCategory, reasonCode, reasonNote string) (*TxResult, error) {
+	if err := s.updateProduct(ctx, id, newStatus, newOwner, newDescription, newCategory, &changeReason{Code: reasonCode, Note: reasonNote}); err != nil {
+		return nil, err
+	}
+	return txResult(ctx), nil
+}
+
+func (s *SupplyChainContract) updateProduct(ctx contractapi.TransactionContextInterface, id, newStatus, newOwner, newDescription, newCategory string, reason *changeReason) error {
+	// Empty fields keep their current value
+	if err := validateFields(
+		field("id", &id, productRefRule),
+		field("status", &newStatus, statusRule),
+		field("owner", &newOwner, ownerRule.optional()),
+		field("description", &newDescription, descriptionRule),
+		field("category", &newCategory, categoryRule),
+	); err != nil {
+		return err
+	}
 	// Retrieve the existing product from the ledger
 	curTime, err := s.getTimestamp(ctx)
 	if err != nil {
@@ -108,12 +327,57 @@ func (s *SupplyChainContract) UpdateProduct(ctx contractapi.TransactionContextIn
 	if err != nil {
 		return err
 	}
+	if asset.Archived {
+		return newError(ErrConflict, "product %s is archived and must be restored first", id)
+	}
+	if err := checkNotFrozen(asset); err != nil {
+		return err
+	}
+	if err := s.requireProductOwner(ctx, asset, "update"); err != nil {
+		return err
+	}
+	before := *asset
+
+	// Sensitive changes need a reason code before anything is modified
+	var actions []string
+	if newStatus != "" && isStatusDowngrade(asset.Status, newStatus) {
+		actions = append(actions, AuditStatusDowngrade)
+	}
+	if newOwner != "" && isOwnershipReversal(asset, newOwner) {
+		actions = append(actions, AuditOwnershipReversal)
+	}
+	for _, action := range actions {
+		if err := s.requireReason(ctx, action, reason); err != nil {
+			return err
+		}
+	}
 
 	// Check if new values are empty, if not, update the corresponding fields
 	if newStatus != "" {
-		asset.Status = newStatus
+		if err := s.setProductStatus(ctx, asset, newStatus, reason); err != nil {
+			return err
+		}
 	}
-	if newOwner != "" {
+	if newOwner != "" && newOwner != asset.Owner {
+		if err := s.checkOwnerParticipant(ctx, newOwner); err != nil {
+			return err
+		}
+		if err := s.checkEmbargo(ctx, asset, newOwner); err != nil {
+			return err
+		}
+		if err := s.checkOwnershipPolicy(ctx, asset, newOwner); err != nil {
+			return err
+		}
+		if err := s.putTransferReceipt(ctx, asset.ID, asset.Owner, newOwner, "", "", 0); err != nil {
+			return err
+		}
+		if err := s.checkTransferDiversion(ctx, asset, newOwner); err != nil {
+			return err
+		}
+		if err := s.setOwnerEndorsement(ctx, asset.ID, newOwner); err != nil {
+			return err
+		}
+		asset.PreviousOwner = asset.Owner
 		asset.Owner = newOwner
 	}
 	if newDescription != "" {
@@ -122,21 +386,84 @@ func (s *SupplyChainContract) UpdateProduct(ctx contractapi.TransactionContextIn
 	if newCategory != "" {
 		asset.Category = newCategory
 	}
+	if err := s.checkUpdatedCategoryRules(ctx, &before, asset); err != nil {
+		return err
+	}
 
 	// Update the UpdatedAt field
 	asset.UpdatedAt = curTime
+	if err := s.warnNearingExpiry(ctx, asset); err != nil {
+		return err
+	}
+
+	for _, action := range actions {
+		if err := s.putAuditRecord(ctx, id, action, reason); err != nil {
+			return err
+		}
+	}
 
 	// Add the updated product to the ledger
-	assetJSON, err := json.Marshal(asset)
-	if err != nil {
+	if err := s.putIndexedProduct(ctx, before, asset); err != nil {
+		return err
+	}
+	if _, err := s.putTransientPrivateDetails(ctx, id); err != nil {
 		return err
 	}
+	return s.emitProductEvent(ctx, EventProductUpdated, ChangeUpdate, &before, asset)
+}
 
-	return ctx.GetStub().PutState(id, assetJSON)
+// TransferOwnership changes the owner of a product. Only the owning
+// organization or an admin can transfer it. Handing a product back to its
+// previous owner must go through TransferOwnershipWithReason. With owner
+// endorsement enabled (SetOwnerEndorsement), the new owner's peers become
+// the only ones that can endorse the product's later changes.
+func (s *SupplyChainContract) TransferOwnership(ctx contractapi.TransactionContextInterface, id, newOwner string) (*TxResult, error) {
+	return s.transferOwnershipResult(ctx, id, newOwner, transferOptions{})
 }
 
-// TransferOwnership changes the owner of a product
-func (s *SupplyChainContract) TransferOwnership(ctx contractapi.TransactionContextInterface, id, newOwner string) error {
+// TransferOwnershipWithReason is TransferOwnership with a reason code and note,
+// as required for ownership reversals
+func (s *SupplyChainContract) TransferOwnershipWithReason(ctx contractapi.TransactionContextInterface, id, newOwner, reasonCode, reasonNote string) (*TxResult, error) {
+	return s.transferOwnershipResult(ctx, id, newOwner, transferOptions{reason: &changeReason{Code: reasonCode, Note: reasonNote}})
+}
+
+// TransferOwnershipWithPriceRef is TransferOwnership that also records a
+// reference to the commercial terms (invoice, contract or price list entry)
+// on the transfer receipt
+func (s *SupplyChainContract) TransferOwnershipWithPriceRef(ctx contractapi.TransactionContextInterface, id, newOwner, priceRef string) (*TxResult, error) {
+	return s.transferOwnershipResult(ctx, id, newOwner, transferOptions{priceRef: priceRef})
+}
+
+// TransferOwnershipWithPrice is TransferOwnershipWithPriceRef that also
+// records the transfer price. Priced transfers are charged the configured
+// transfer fees.
+func (s *SupplyChainContract) TransferOwnershipWithPrice(ctx contractapi.TransactionContextInterface, id, newOwner, priceRef string, amount float64) (*TxResult, error) {
+	if amount <= 0 {
+		return nil, newError(ErrInvalidArgument, "transfer price must be positive")
+	}
+	return s.transferOwnershipResult(ctx, id, newOwner, transferOptions{priceRef: priceRef, amount: amount})
+}
+
+// transferOptions carries the optional inputs of an ownership transfer
+type transferOptions struct {
+	reason   *changeReason
+	priceRef string
+	orderID  string
+	amount   float64
+}
+
+// transferOwnershipResult runs a single transfer and returns its TxResult
+func (s *SupplyChainContract) transferOwnershipResult(ctx contractapi.TransactionContextInterface, id, newOwner string, opts transferOptions) (*TxResult, error) {
+	if err := s.transferOwnership(ctx, id, newOwner, opts); err != nil {
+		return nil, err
+	}
+	return txResult(ctx), nil
+}
+
+func (s *SupplyChainContract) transferOwnership(ctx contractapi.TransactionContextInterface, id, newOwner string, opts transferOptions) error {
+	if err := validateFields(field("id", &id, productRefRule), field("new owner", &newOwner, ownerRule)); err != nil {
+		return err
+	}
 	// Retrieve the existing product from the ledger
 	curTime, err := s.getTimestamp(ctx)
 	if err != nil {
@@ -147,15 +474,136 @@ func (s *SupplyChainContract) TransferOwnership(ctx contractapi.TransactionConte
 	if err != nil {
 		return err
 	}
+	if asset.Archived {
+		return newError(ErrConflict, "product %s is archived and must be restored first", id)
+	}
+	if err := checkNotFrozen(asset); err != nil {
+		return err
+	}
+	if err := s.requireProductOwner(ctx, asset, "transfer"); err != nil {
+		return err
+	}
+	before := *asset
+
+	if isOwnershipReversal(asset, newOwner) {
+		if err := s.requireReason(ctx, AuditOwnershipReversal, opts.reason); err != nil {
+			return err
+		}
+		if err := s.putAuditRecord(ctx, id, AuditOwnershipReversal, opts.reason); err != nil {
+			return err
+		}
+	}
 
+	if newOwner != asset.Owner {
+		if err := s.checkOwnerParticipant(ctx, newOwner); err != nil {
+			return err
+		}
+		if err := s.checkEmbargo(ctx, asset, newOwner); err != nil {
+			return err
+		}
+		if err := s.checkOwnershipPolicy(ctx, asset, newOwner); err != nil {
+			return err
+		}
+		if err := s.putTransferReceipt(ctx, asset.ID, asset.Owner, newOwner, opts.priceRef, opts.orderID, opts.amount); err != nil {
+			return err
+		}
+		if err := s.checkTransferDiversion(ctx, asset, newOwner); err != nil {
+			return err
+		}
+		if err := s.setOwnerEndorsement(ctx, asset.ID, newOwner); err != nil {
+			return err
+		}
+		asset.PreviousOwner = asset.Owner
+	}
 	asset.Owner = newOwner
+	if err := s.checkUpdatedCategoryRules(ctx, &before, asset); err != nil {
+		return err
+	}
 	asset.UpdatedAt = curTime
-	assetJSON, err := json.Marshal(asset)
+	if err := s.warnNearingExpiry(ctx, asset); err != nil {
+		return err
+	}
+	if err := s.putIndexedProduct(ctx, before, asset); err != nil {
+		return err
+	}
+	return s.emitProductEvent(ctx, EventProductTransferred, ChangeTransfer, &before, asset)
+}
+
+// EventProductDeleted is emitted when a product is deleted, with a
+// ProductEvent payload
+const EventProductDeleted = "ProductDeleted"
+
+// DeleteProduct removes a product from the ledger. Only the owning
+// organization or an admin can delete it, giving a reason code and note that
+// stay in the audit records. A product that is on an open shipment or has
+// components or bundle members must be detached first so that no record is
+// left pointing at it.
+func (s *SupplyChainContract) DeleteProduct(ctx contractapi.TransactionContextInterface, id, reasonCode, reasonNote string) error {
+	product, err := s.QueryProduct(ctx, id)
 	if err != nil {
 		return err
 	}
+	if err := s.requireProductOwner(ctx, product, "delete"); err != nil {
+		return err
+	}
+	if err := checkNotFrozen(product); err != nil {
+		return err
+	}
 
-	return ctx.GetStub().PutState(id, assetJSON)
+	if err := s.checkNotOnOpenShipment(ctx, id); err != nil {
+		return err
+	}
+	for _, relation := range []string{RelationComponent, RelationBundle} {
+		for _, indexName := range []string{relationIndexName, reverseRelationIndexName} {
+			related, err := s.getRelated(ctx, indexName, id, relation)
+			if err != nil {
+				return err
+			}
+			if len(related) > 0 {
+				return newError(ErrConflict, "product %s has a %s relation with %s", id, relation, related[0])
+			}
+		}
+	}
+	reason := &changeReason{Code: reasonCode, Note: reasonNote}
+	if err := s.requireReason(ctx, AuditDeletion, reason); err != nil {
+		return err
+	}
+
+	if product.LotID != "" {
+		if err := s.delIndexKey(ctx, lotProductIndexName, product.LotID, id); err != nil {
+			return err
+		}
+	}
+	if product.Archived {
+		if err := s.delIndexKey(ctx, archivedIndexName, id); err != nil {
+			return err
+		}
+	}
+	shipmentIDs, err := s.getIndexedIDs(ctx, productShipmentIndexName, id)
+	if err != nil {
+		return err
+	}
+	for _, shipmentID := range shipmentIDs {
+		if err := s.delIndexKey(ctx, productShipmentIndexName, id, shipmentID); err != nil {
+			return err
+		}
+	}
+	if err := s.dropFingerprint(ctx, product); err != nil {
+		return err
+	}
+	if err := s.indexProduct(ctx, product, nil); err != nil {
+		return err
+	}
+	if err := s.recordChange(ctx, ChangeEntityProduct, id, id, nil); err != nil {
+		return err
+	}
+	if err := s.putAuditRecord(ctx, id, AuditDeletion, reason); err != nil {
+		return err
+	}
+	if err := ctx.GetStub().DelState(id); err != nil {
+		return wrapError(err, "failed to delete from world state")
+	}
+	return s.emitProductEvent(ctx, EventProductDeleted, ChangeDelete, product, nil)
 }
 
 // QueryProduct retrieves a single product from the ledger by ID
@@ -163,39 +611,59 @@ func (s *SupplyChainContract) QueryProduct(ctx contractapi.TransactionContextInt
 	// Retrieve the product from the ledger
 	productJSON, err := ctx.GetStub().GetState(id)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read from world state: %v", err)
+		return nil, wrapError(err, "failed to read from world state")
 	}
 	if productJSON == nil {
-		return nil, fmt.Errorf("product with ID %s does not exist", id)
+		return nil, newError(ErrNotFound, "product with ID %s does not exist", id)
 	}
 
 	var product Product
-	if err := json.Unmarshal(productJSON, &product); err != nil {
+	if err := decodeProduct(productJSON, &product); err != nil {
 		return nil, err
 	}
 
 	return &product, nil
 }
 
-// putProduct is a helper method for inserting or updating a product in the ledger
+// putProduct is a helper method for inserting or updating a product in the
+// ledger. Watchers of the product are notified and, with event sourcing on,
+// the change is logged.
 func (s *SupplyChainContract) putProduct(ctx contractapi.TransactionContextInterface, product *Product) error {
+	product.SchemaVersion = productSchemaVersion
 	productJSON, err := json.Marshal(product)
 	if err != nil {
 		return err
 	}
-	return ctx.GetStub().PutState(product.ID, productJSON)
+	if err := s.recordChange(ctx, ChangeEntityProduct, product.ID, product.ID, productJSON); err != nil {
+		return err
+	}
+	config, err := s.GetContractConfig(ctx)
+	if err != nil {
+		return err
+	}
+	value := productJSON
+	if config.stateEncoding() != StateEncodingJSON {
+		if value, err = encodeProduct(product, config.stateEncoding()); err != nil {
+			return err
+		}
+	}
+	if err := ctx.GetStub().PutState(product.ID, value); err != nil {
+		return err
+	}
+	return s.notifyWatchers(ctx, product)
 }
 
 // ProductExists is a helper method to check if a product exists in the ledger
 func (s *SupplyChainContract) ProductExists(ctx contractapi.TransactionContextInterface, id string) (bool, error) {
 	productJSON, err := ctx.GetStub().GetState(id)
 	if err != nil {
-		return false, fmt.Errorf("failed to read from world state: %v", err)
+		return false, wrapError(err, "failed to read from world state")
 	}
 	return productJSON != nil, nil
 }
 
-// GetAllProducts is a helper method to retrieve all products from the ledger
+// GetAllProducts is a helper method to retrieve all products from the ledger,
+// other than archived ones
 func (s *SupplyChainContract) GetAllProducts(ctx contractapi.TransactionContextInterface) ([]*Product, error) {
 	resultsIterator, err := ctx.GetStub().GetStateByRange("", "")
 	if err != nil {
@@ -211,15 +679,151 @@ func (s *SupplyChainContract) GetAllProducts(ctx contractapi.TransactionContextI
 		}
 
 		var product Product
-		if err := json.Unmarshal(queryResponse.Value, &product); err != nil {
+		if err := decodeProduct(queryResponse.Value, &product); err != nil {
 			return nil, err
 		}
+		if product.Archived {
+			continue
+		}
 		products = append(products, &product)
 	}
 
 	return products, nil
 }
 
+// GetProductsByOwner returns the unarchived products owned by owner. It
+// reads the owner~product index, so it works on LevelDB as well as CouchDB.
+func (s *SupplyChainContract) GetProductsByOwner(ctx contractapi.TransactionContextInterface, owner string) ([]*Product, error) {
+	productIDs, err := s.getIndexedIDs(ctx, ownerProductIndexName, owner)
+	if err != nil {
+		return nil, err
+	}
+
+	products := []*Product{}
+	for _, productID := range productIDs {
+		product, err := s.QueryProduct(ctx, productID)
+		if err != nil {
+			return nil, err
+		}
+		if !product.Archived {
+			products = append(products, product)
+		}
+	}
+	return products, nil
+}
+
+// GetProductsByCategory returns one page of the unarchived products in a
+// category. Pass the returned bookmark to read the next page. It reads the
+// category~product index, so it works on LevelDB as well as CouchDB.
+func (s *SupplyChainContract) GetProductsByCategory(ctx contractapi.TransactionContextInterface, category string, pageSize int, bookmark string) (*ProductPage, error) {
+	return s.indexedProductPage(ctx, categoryProductIndexName, category, pageSize, bookmark)
+}
+
+// GetProductsByStatus returns one page of the unarchived products in a
+// status, e.g. InTransit. Pass the returned bookmark to read the next page.
+// It reads the status~product index, so it works on LevelDB as well as
+// CouchDB.
+func (s *SupplyChainContract) GetProductsByStatus(ctx contractapi.TransactionContextInterface, status string, pageSize int, bookmark string) (*ProductPage, error) {
+	return s.indexedProductPage(ctx, statusProductIndexName, status, pageSize, bookmark)
+}
+
+// indexedProductPage reads one page of a product index under value and
+// returns the unarchived products it lists
+func (s *SupplyChainContract) indexedProductPage(ctx contractapi.TransactionContextInterface, indexName, value string, pageSize int, bookmark string) (*ProductPage, error) {
+	if err := s.checkPageSize(ctx, pageSize); err != nil {
+		return nil, err
+	}
+
+	resultsIterator, metadata, err := ctx.GetStub().GetStateByPartialCompositeKeyWithPagination(indexName, []string{value}, int32(pageSize), bookmark)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	page := &ProductPage{Products: []*Product{}, Bookmark: metadata.GetBookmark()}
+	var values [][]byte
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, keyParts, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, err
+		}
+		if len(keyParts) == 0 {
+			continue
+		}
+
+		productID := keyParts[len(keyParts)-1]
+		productJSON, err := ctx.GetStub().GetState(productID)
+		if err != nil {
+			return nil, wrapError(err, "failed to read from world state")
+		}
+		if productJSON == nil {
+			return nil, newError(ErrNotFound, "index %s lists product %s, which does not exist", indexName, productID)
+		}
+		var product Product
+		if err := decodeProduct(productJSON, &product); err != nil {
+			return nil, err
+		}
+		if product.Archived {
+			continue
+		}
+		page.Products = append(page.Products, &product)
+		values = append(values, productJSON)
+	}
+	page.ETag = listETag(values)
+
+	return page, nil
+}
+
+// ProductPage is one page of GetAllProductsPaginated results. Bookmark is
+// empty once the last page has been read; a page leaves out archived
+// products and so can hold fewer than the page size. ETag changes whenever
+// the page's products do.
+type ProductPage struct {
+	Products []*Product `json:"products"`
+	Bookmark string     `json:"bookmark"`
+	ETag     string     `json:"etag"`
+}
+
+// GetAllProductsPaginated returns one page of products. Pass the returned
+// bookmark to read the next page; an empty bookmark starts from the first.
+func (s *SupplyChainContract) GetAllProductsPaginated(ctx contractapi.TransactionContextInterface, pageSize int, bookmark string) (*ProductPage, error) {
+	if err := s.checkPageSize(ctx, pageSize); err != nil {
+		return nil, err
+	}
+
+	resultsIterator, metadata, err := ctx.GetStub().GetStateByRangeWithPagination("", "", int32(pageSize), bookmark)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	page := &ProductPage{Products: []*Product{}, Bookmark: metadata.GetBookmark()}
+	var values [][]byte
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var product Product
+		if err := decodeProduct(queryResponse.Value, &product); err != nil {
+			return nil, err
+		}
+		if product.Archived {
+			continue
+		}
+		page.Products = append(page.Products, &product)
+		values = append(values, queryResponse.Value)
+	}
+	page.ETag = listETag(values)
+
+	return page, nil
+}
+
 func main() {
 	chaincode, err := contractapi.NewChaincode(&SupplyChainContract{})
 	if err != nil {
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// compressionThreshold is the size in bytes above which putCompressedState
+// gzips a value
+const compressionThreshold = 4096
+
+// makeKey builds the composite key for an entity of the given object type.
+// Products keep their plain ID keys; every other entity lives in the
+// composite key namespace so it never shows up in GetAllProducts range scans.
+// The stub scopes both kinds of key to the transaction's program.
+func (s *SupplyChainContract) makeKey(ctx contractapi.TransactionContextInterface, objectType string, attributes ...string) (string, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(objectType, attributes)
+	if err != nil {
+		return "", newError(ErrInvalidArgument, "failed to create %s key: %v", objectType, err)
+	}
+	return key, nil
+}
+
+// putState marshals v and writes it to the ledger under key
+func (s *SupplyChainContract) putState(ctx contractapi.TransactionContextInterface, key string, v interface{}) error {
+	valueJSON, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if err := ctx.GetStub().PutState(key, valueJSON); err != nil {
+		return wrapError(err, "failed to put to world state")
+	}
+	return nil
+}
+
+// getState reads key into v and reports whether the key was present
+func (s *SupplyChainContract) getState(ctx contractapi.TransactionContextInterface, key string, v interface{}) (bool, error) {
+	valueJSON, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return false, wrapError(err, "failed to read from world state")
+	}
+	if valueJSON == nil {
+		return false, nil
+	}
+	valueJSON, err = decompressValue(valueJSON)
+	if err != nil {
+		return false, wrapError(err, "failed to decompress %s", key)
+	}
+	if err := json.Unmarshal(valueJSON, v); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// putCompressedState is putState for entities that can grow large. Values
+// over compressionThreshold are stored gzipped; getState reads both forms.
+// Entities written this way must only be read through getState. The
+// compressor writes no timestamp, so every endorsing peer produces the same
+// bytes.
+func (s *SupplyChainContract) putCompressedState(ctx contractapi.TransactionContextInterface, key string, v interface{}) error {
+	valueJSON, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if len(valueJSON) > compressionThreshold {
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(valueJSON); err != nil {
+			return err
+		}
+		if err := w.Close(); err != nil {
+			return err
+		}
+		valueJSON = buf.Bytes()
+	}
+	if err := ctx.GetStub().PutState(key, valueJSON); err != nil {
+		return wrapError(err, "failed to put to world state")
+	}
+	return nil
+}
+
+// decompressValue returns a stored value, gunzipping it if it carries the
+// gzip magic number, which no JSON document starts with
+func decompressValue(value []byte) ([]byte, error) {
+	if len(value) < 2 || value[0] != 0x1f || value[1] != 0x8b {
+		return value, nil
+	}
+	r, err := gzip.NewReader(bytes.NewReader(value))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// putIndexKey writes an index entry. Index entries carry no data of their
+// own; the composite key is the information.
+func (s *SupplyChainContract) putIndexKey(ctx contractapi.TransactionContextInterface, indexName string, attributes ...string) error {
+	key, err := s.makeKey(ctx, indexName, attributes...)
+	if err != nil {
+		return err
+	}
+	if err := ctx.GetStub().PutState(key, []byte{0x00}); err != nil {
+		return wrapError(err, "failed to put to world state")
+	}
+	return nil
+}
+
+// delIndexKey removes an index entry
+func (s *SupplyChainContract) delIndexKey(ctx contractapi.TransactionContextInterface, indexName string, attributes ...string) error {
+	key, err := s.makeKey(ctx, indexName, attributes...)
+	if err != nil {
+		return err
+	}
+	if err := ctx.GetStub().DelState(key); err != nil {
+		return wrapError(err, "failed to delete from world state")
+	}
+	return nil
+}
+
+// indexKeyExists reports whether an index entry is present
+func (s *SupplyChainContract) indexKeyExists(ctx contractapi.TransactionContextInterface, indexName string, attributes ...string) (bool, error) {
+	key, err := s.makeKey(ctx, indexName, attributes...)
+	if err != nil {
+		return false, err
+	}
+	value, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return false, wrapError(err, "failed to read from world state")
+	}
+	return value != nil, nil
+}
+
+// getIndexedIDs returns the last attribute of every index entry under the
+// given prefix, e.g. the product IDs of a lot~product index for one lot
+func (s *SupplyChainContract) getIndexedIDs(ctx contractapi.TransactionContextInterface, indexName string, prefix ...string) ([]string, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(indexName, prefix)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var ids []string
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		_, keyParts, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, err
+		}
+		if len(keyParts) == 0 {
+			continue
+		}
+		ids = append(ids, keyParts[len(keyParts)-1])
+	}
+
+	return ids, nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const methodStatsObjectType = "methodstats"
+
+// UsageStats is the number of committed transactions per method on one day
+// (YYYY-MM-DD), across every organization
+type UsageStats struct {
+	Date    string        `json:"date"`
+	Total   int           `json:"total"`
+	Methods []MethodUsage `json:"methods"`
+}
+
+// GetUsageStats returns the channel-wide transaction counts per method on a
+// day, ordered by method. Only transactions that were submitted and
+// committed are counted: a query evaluated on one peer writes nothing, and
+// Fabric discards the writes of a transaction that fails, so failures
+// cannot be counted on-chain. GetOrgUsage breaks the counts down for one
+// organization.
+func (s *SupplyChainContract) GetUsageStats(ctx contractapi.TransactionContextInterface, date string) (*UsageStats, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(methodStatsObjectType, []string{date})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	counts := make(map[string]int)
+	stats := &UsageStats{Date: date, Methods: []MethodUsage{}}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, keyParts, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, err
+		}
+		if len(keyParts) < 2 {
+			continue
+		}
+
+		var counter usageCounter
+		if err := json.Unmarshal(queryResponse.Value, &counter); err != nil {
+			return nil, err
+		}
+		counts[keyParts[1]] += counter.Count
+		stats.Total += counter.Count
+	}
+
+	for method, count := range counts {
+		stats.Methods = append(stats.Methods, MethodUsage{Method: method, Count: count})
+	}
+	sort.Slice(stats.Methods, func(i, j int) bool {
+		return stats.Methods[i].Method < stats.Methods[j].Method
+	})
+	return stats, nil
+}
+
+// recordMethodStats counts the current transaction in the channel-wide
+// statistics. Like the organization counters, each day's count for a method
+// is spread over usageShards keys.
+func (s *SupplyChainContract) recordMethodStats(ctx contractapi.TransactionContextInterface) error {
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	date := curTime[:receiptDateLayoutLength]
+
+	key, err := s.makeKey(ctx, methodStatsObjectType, date, transactionName(ctx), usageShard(ctx.GetStub().GetTxID()))
+	if err != nil {
+		return err
+	}
+	var counter usageCounter
+	if _, err := s.getState(ctx, key, &counter); err != nil {
+		return err
+	}
+	counter.Count++
+	return s.putState(ctx, key, &counter)
+}
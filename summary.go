@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	telemetrySummaryObjectType = "summary"
+	// readingMarkObjectType marks the readings taken while only summaries
+	// are kept, so that a resubmitted reading is still recognized
+	readingMarkObjectType = "readingmark"
+)
+
+// Where raw sensor readings are kept. Hourly summaries are always public.
+const (
+	TelemetryStoragePublic  = "public"
+	TelemetryStoragePrivate = "private"
+	TelemetryStorageSummary = "summary"
+)
+
+// TelemetrySummary aggregates one device's readings on a shipment over one
+// hour. Hour is the RFC3339 start of the hour in UTC.
+type TelemetrySummary struct {
+	ShipmentID     string  `json:"shipment_id"`
+	DeviceID       string  `json:"device_id"`
+	Hour           string  `json:"hour"`
+	Count          int     `json:"count"`
+	MinTemperature float64 `json:"min_temperature"`
+	MaxTemperature float64 `json:"max_temperature"`
+	AvgTemperature float64 `json:"avg_temperature"`
+	MinHumidity    float64 `json:"min_humidity"`
+	MaxHumidity    float64 `json:"max_humidity"`
+	AvgHumidity    float64 `json:"avg_humidity"`
+}
+
+// SetTelemetryStorage chooses where raw readings go: the public world state,
+// the private data collection named by collection, or nowhere, keeping only
+// the hourly summaries. Readings already written stay where they are.
+func (s *SupplyChainContract) SetTelemetryStorage(ctx contractapi.TransactionContextInterface, storage, collection string) error {
+	if err := s.requireRole(ctx, RoleAdmin); err != nil {
+		return err
+	}
+	switch storage {
+	case TelemetryStoragePublic, TelemetryStorageSummary:
+		collection = ""
+	case TelemetryStoragePrivate:
+		if collection == "" {
+			return newError(ErrInvalidArgument, "private telemetry storage needs a collection name")
+		}
+	default:
+		return newError(ErrInvalidArgument, "unknown telemetry storage %s", storage)
+	}
+
+	config, err := s.GetContractConfig(ctx)
+	if err != nil {
+		return err
+	}
+
+	config.TelemetryStorage = storage
+	config.TelemetryCollection = collection
+	return s.putContractConfig(ctx, config)
+}
+
+// GetTelemetrySummary returns the hourly summaries of a shipment, ordered by hour
+func (s *SupplyChainContract) GetTelemetrySummary(ctx contractapi.TransactionContextInterface, shipmentID string) ([]*TelemetrySummary, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(telemetrySummaryObjectType, []string{shipmentID})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	summaries := []*TelemetrySummary{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var summary TelemetrySummary
+		if err := json.Unmarshal(queryResponse.Value, &summary); err != nil {
+			return nil, err
+		}
+		summaries = append(summaries, &summary)
+	}
+
+	return summaries, nil
+}
+
+// telemetryWriter stores the readings of one transaction and keeps their
+// hourly summaries in memory, since a transaction cannot read its own
+// writes. flush must be called once every reading has been added.
+type telemetryWriter struct {
+	s         *SupplyChainContract
+	ctx       contractapi.TransactionContextInterface
+	config    *ContractConfig
+	summaries map[string]*TelemetrySummary
+}
+
+// newTelemetryWriter prepares a writer using the configured raw storage
+func (s *SupplyChainContract) newTelemetryWriter(ctx contractapi.TransactionContextInterface) (*telemetryWriter, error) {
+	config, err := s.GetContractConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &telemetryWriter{s: s, ctx: ctx, config: config, summaries: make(map[string]*TelemetrySummary)}, nil
+}
+
+// add stores a raw reading according to the storage setting and folds it
+// into its hourly summary. Readings are keyed by shipment, then time, then
+// device, so a partial key scan over a shipment returns them in time order.
+// A reading the device already recorded at that time is refused rather
+// than counted twice.
+func (w *telemetryWriter) add(reading *SensorReading) error {
+	key, err := w.s.makeKey(w.ctx, sensorReadingObjectType, reading.ShipmentID, reading.RecordedAt, reading.DeviceID)
+	if err != nil {
+		return err
+	}
+	exists, err := w.readingExists(reading, key)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return newError(ErrAlreadyExists, "device %s already has a reading on shipment %s at %s", reading.DeviceID, reading.ShipmentID, reading.RecordedAt)
+	}
+	switch w.config.TelemetryStorage {
+	case TelemetryStorageSummary:
+		markKey, err := w.s.makeKey(w.ctx, readingMarkObjectType, reading.ShipmentID, reading.RecordedAt, reading.DeviceID)
+		if err != nil {
+			return err
+		}
+		if err := w.s.putState(w.ctx, markKey, &readingMark{TxID: reading.TxID}); err != nil {
+			return err
+		}
+	case TelemetryStoragePrivate:
+		readingJSON, err := json.Marshal(reading)
+		if err != nil {
+			return err
+		}
+		if err := w.ctx.GetStub().PutPrivateData(w.config.TelemetryCollection, key, readingJSON); err != nil {
+			return wrapError(err, "failed to put to private collection %s", w.config.TelemetryCollection)
+		}
+	default:
+		if err := w.s.putState(w.ctx, key, reading); err != nil {
+			return err
+		}
+	}
+
+	// RecordedAt is normalized to UTC, so the hour is its first 13 characters
+	hour := reading.RecordedAt[:len("2006-01-02T15")] + ":00:00Z"
+	summaryKey := strings.Join([]string{reading.ShipmentID, hour, reading.DeviceID}, "|")
+	summary, ok := w.summaries[summaryKey]
+	if !ok {
+		key, err := w.s.makeKey(w.ctx, telemetrySummaryObjectType, reading.ShipmentID, hour, reading.DeviceID)
+		if err != nil {
+			return err
+		}
+		summary = &TelemetrySummary{}
+		exists, err := w.s.getState(w.ctx, key, summary)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			summary = &TelemetrySummary{
+				ShipmentID:     reading.ShipmentID,
+				DeviceID:       reading.DeviceID,
+				Hour:           hour,
+				MinTemperature: reading.Temperature,
+				MaxTemperature: reading.Temperature,
+				MinHumidity:    reading.Humidity,
+				MaxHumidity:    reading.Humidity,
+			}
+		}
+		w.summaries[summaryKey] = summary
+	}
+
+	n := float64(summary.Count)
+	summary.AvgTemperature = (summary.AvgTemperature*n + reading.Temperature) / (n + 1)
+	summary.AvgHumidity = (summary.AvgHumidity*n + reading.Humidity) / (n + 1)
+	if reading.Temperature < summary.MinTemperature {
+		summary.MinTemperature = reading.Temperature
+	}
+	if reading.Temperature > summary.MaxTemperature {
+		summary.MaxTemperature = reading.Temperature
+	}
+	if reading.Humidity < summary.MinHumidity {
+		summary.MinHumidity = reading.Humidity
+	}
+	if reading.Humidity > summary.MaxHumidity {
+		summary.MaxHumidity = reading.Humidity
+	}
+	summary.Count++
+	return nil
+}
+
+// readingMark is the value of a reading mark
+type readingMark struct {
+	TxID string `json:"tx_id"`
+}
+
+// readingExists reports whether a reading was already recorded, looking
+// wherever the readings of any storage setting went. Only the hash of a
+// private reading is read, which needs no collection membership.
+func (w *telemetryWriter) readingExists(reading *SensorReading, key string) (bool, error) {
+	readingJSON, err := w.ctx.GetStub().GetState(key)
+	if err != nil {
+		return false, wrapError(err, "failed to read from world state")
+	}
+	if readingJSON != nil {
+		return true, nil
+	}
+	markKey, err := w.s.makeKey(w.ctx, readingMarkObjectType, reading.ShipmentID, reading.RecordedAt, reading.DeviceID)
+	if err != nil {
+		return false, err
+	}
+	var mark readingMark
+	exists, err := w.s.getState(w.ctx, markKey, &mark)
+	if err != nil || exists || w.config.TelemetryCollection == "" {
+		return exists, err
+	}
+	hash, err := w.ctx.GetStub().GetPrivateDataHash(w.config.TelemetryCollection, key)
+	if err != nil {
+		return false, wrapError(err, "failed to read from private collection %s", w.config.TelemetryCollection)
+	}
+	return hash != nil, nil
+}
+
+// flush writes the updated summaries in key order
+func (w *telemetryWriter) flush() error {
+	summaryKeys := make([]string, 0, len(w.summaries))
+	for summaryKey := range w.summaries {
+		summaryKeys = append(summaryKeys, summaryKey)
+	}
+	sort.Strings(summaryKeys)
+
+	for _, summaryKey := range summaryKeys {
+		summary := w.summaries[summaryKey]
+		key, err := w.s.makeKey(w.ctx, telemetrySummaryObjectType, summary.ShipmentID, summary.Hour, summary.DeviceID)
+		if err != nil {
+			return err
+		}
+		if err := w.s.putState(w.ctx, key, summary); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const sensorReadingObjectType = "reading"
+
+// maxSensorBatchSize bounds the number of readings in one RecordSensorBatch call
+const maxSensorBatchSize = 500
+
+// Plausible ranges for readings; values outside them indicate a faulty device
+const (
+	minTemperature = -100.0
+	maxTemperature = 150.0
+	minHumidity    = 0.0
+	maxHumidity    = 100.0
+)
+
+// SensorReading is a single environmental measurement taken during a shipment
+type SensorReading struct {
+	ShipmentID  string  `json:"shipment_id"`
+	DeviceID    string  `json:"device_id"`
+	Temperature float64 `json:"temperature"`
+	Humidity    float64 `json:"humidity"`
+	RecordedAt  string  `json:"recorded_at"`
+	Synthetic   bool    `json:"synthetic"`
+	Signed      bool    `json:"signed"`
+	TxID        string  `json:"tx_id"`
+}
+
+// RecordSensorReading stores a reading taken by a device travelling with a
+// shipment. recordedAt is the device's own RFC3339 timestamp; when empty the
+// transaction time is used. The device must be registered and in
+// calibration when the reading was taken. Unsigned readings can only be
+// recorded by the device's registrant or an admin, and not at all once the
+// device's key has been revoked.
+func (s *SupplyChainContract) RecordSensorReading(ctx contractapi.TransactionContextInterface, shipmentID, deviceID string, temperature, humidity float64, recordedAt string) error {
+	return s.recordSensorReading(ctx, shipmentID, deviceID, temperature, humidity, recordedAt, false)
+}
+
+// SignedReadingPayload is the reading a device signs. The signature covers
+// the payload JSON exactly as submitted. Nonce must be unique per device and
+// SignedAt within the replay window, so an accepted payload cannot be
+// submitted again.
+type SignedReadingPayload struct {
+	ShipmentID  string  `json:"shipment_id"`
+	DeviceID    string  `json:"device_id"`
+	Temperature float64 `json:"temperature"`
+	Humidity    float64 `json:"humidity"`
+	RecordedAt  string  `json:"recorded_at"`
+	Nonce       string  `json:"nonce"`
+	SignedAt    string  `json:"signed_at"`
+}
+
+// RecordSignedSensorReading stores a reading signed by the device itself.
+// signature is the base64 encoded signature of payload made with the device
+// key registered in the device registry, so a compromised gateway identity
+// alone cannot forge readings.
+func (s *SupplyChainContract) RecordSignedSensorReading(ctx contractapi.TransactionContextInterface, payload, signature string) error {
+	var reading SignedReadingPayload
+	if err := json.Unmarshal([]byte(payload), &reading); err != nil {
+		return newError(ErrInvalidArgument, "failed to parse reading payload: %v", err)
+	}
+	if reading.RecordedAt == "" {
+		return newError(ErrInvalidArgument, "a signed reading must carry its own recorded_at")
+	}
+
+	if err := s.verifySignedReadings(ctx, reading.DeviceID, reading.Nonce, reading.SignedAt, payload, signature); err != nil {
+		return err
+	}
+
+	return s.recordSensorReading(ctx, reading.ShipmentID, reading.DeviceID, reading.Temperature, reading.Humidity, reading.RecordedAt, true)
+}
+
+// SensorBatchEntry is one reading in a RecordSensorBatch payload
+type SensorBatchEntry struct {
+	DeviceID    string  `json:"device_id"`
+	Temperature float64 `json:"temperature"`
+	Humidity    float64 `json:"humidity"`
+	RecordedAt  string  `json:"recorded_at"`
+}
+
+// RecordSensorBatch stores up to maxSensorBatchSize readings for a shipment
+// in one transaction. readingsJSON is a JSON array of SensorBatchEntry. The
+// batch is all or nothing: one invalid reading rejects every reading in it.
+// As with RecordSensorReading, only the registrant of each device or an
+// admin can record its readings unsigned.
+func (s *SupplyChainContract) RecordSensorBatch(ctx contractapi.TransactionContextInterface, shipmentID, readingsJSON string) (int, error) {
+	var entries []SensorBatchEntry
+	if err := json.Unmarshal([]byte(readingsJSON), &entries); err != nil {
+		return 0, newError(ErrInvalidArgument, "failed to parse readings: %v", err)
+	}
+	return s.recordSensorBatch(ctx, shipmentID, entries, false)
+}
+
+// SignedBatchPayload is a batch of readings a device signs, under the same
+// rules as SignedReadingPayload. The device ID of each reading may be left
+// empty; any other than DeviceID is refused.
+type SignedBatchPayload struct {
+	ShipmentID string             `json:"shipment_id"`
+	DeviceID   string             `json:"device_id"`
+	Readings   []SensorBatchEntry `json:"readings"`
+	Nonce      string             `json:"nonce"`
+	SignedAt   string             `json:"signed_at"`
+}
+
+// RecordSignedSensorBatch stores a batch of readings signed by the device
+// that took them, as RecordSignedSensorReading does for a single reading.
+// Every reading must carry its own recorded_at.
+func (s *SupplyChainContract) RecordSignedSensorBatch(ctx contractapi.TransactionContextInterface, payload, signature string) (int, error) {
+	var batch SignedBatchPayload
+	if err := json.Unmarshal([]byte(payload), &batch); err != nil {
+		return 0, newError(ErrInvalidArgument, "failed to parse batch payload: %v", err)
+	}
+	for i := range batch.Readings {
+		entry := &batch.Readings[i]
+		if entry.DeviceID == "" {
+			entry.DeviceID = batch.DeviceID
+		}
+		if entry.DeviceID != batch.DeviceID {
+			return 0, newError(ErrInvalidArgument, "reading %d: device %s did not sign this batch", i, entry.DeviceID)
+		}
+		if entry.RecordedAt == "" {
+			return 0, newError(ErrInvalidArgument, "reading %d: a signed reading must carry its own recorded_at", i)
+		}
+	}
+
+	if err := s.verifySignedReadings(ctx, batch.DeviceID, batch.Nonce, batch.SignedAt, payload, signature); err != nil {
+		return 0, err
+	}
+
+	return s.recordSensorBatch(ctx, batch.ShipmentID, batch.Readings, true)
+}
+
+// verifySignedReadings checks the device signature of a reading payload and
+// then its nonce, so that a forged payload cannot burn the device's nonce
+func (s *SupplyChainContract) verifySignedReadings(ctx contractapi.TransactionContextInterface, deviceID, nonce, signedAt, payload, signature string) error {
+	device, err := s.QueryDevice(ctx, deviceID)
+	if err != nil {
+		return err
+	}
+	txTime, err := rawTxTime(ctx)
+	if err != nil {
+		return err
+	}
+	if err := verifyDeviceSignature(device, []byte(payload), signature, txTime); err != nil {
+		return err
+	}
+	return s.checkReplay(ctx, deviceID, nonce, signedAt)
+}
+
+// recordSensorBatch is the shared implementation of RecordSensorBatch and RecordSignedSensorBatch
+func (s *SupplyChainContract) recordSensorBatch(ctx contractapi.TransactionContextInterface, shipmentID string, entries []SensorBatchEntry, signed bool) (int, error) {
+	if len(entries) == 0 || len(entries) > maxSensorBatchSize {
+		return 0, newError(ErrInvalidArgument, "a batch must hold between 1 and %d readings", maxSensorBatchSize)
+	}
+
+	if _, err := s.QueryShipment(ctx, shipmentID); err != nil {
+		return 0, err
+	}
+
+	writer, err := s.newTelemetryWriter(ctx)
+	if err != nil {
+		return 0, err
+	}
+	seen := make(map[string]bool)
+	allowed := make(map[string]bool)
+	for i, entry := range entries {
+		recordedAt, err := s.normalizeRecordedAt(ctx, entry.RecordedAt)
+		if err != nil {
+			return 0, wrapError(err, "reading %d", i)
+		}
+		if err := checkReadingRange(entry.Temperature, entry.Humidity); err != nil {
+			return 0, wrapError(err, "reading %d", i)
+		}
+
+		readingKey := entry.DeviceID + "|" + recordedAt
+		if seen[readingKey] {
+			return 0, newError(ErrInvalidArgument, "reading %d: device %s already has a reading at %s in this batch", i, entry.DeviceID, recordedAt)
+		}
+		seen[readingKey] = true
+
+		device, err := s.requireCalibratedDevice(ctx, entry.DeviceID, recordedAt)
+		if err != nil {
+			return 0, wrapError(err, "reading %d", i)
+		}
+		if !signed && !allowed[device.ID] {
+			if err := s.checkUnsignedReadings(ctx, device); err != nil {
+				return 0, wrapError(err, "reading %d", i)
+			}
+			allowed[device.ID] = true
+		}
+
+		reading := SensorReading{
+			ShipmentID:  shipmentID,
+			DeviceID:    entry.DeviceID,
+			Temperature: entry.Temperature,
+			Humidity:    entry.Humidity,
+			RecordedAt:  recordedAt,
+			Signed:      signed,
+			TxID:        ctx.GetStub().GetTxID(),
+		}
+		if err := writer.add(&reading); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(entries), writer.flush()
+}
+
+// recordSensorReading is the shared implementation of RecordSensorReading and RecordSignedSensorReading
+func (s *SupplyChainContract) recordSensorReading(ctx contractapi.TransactionContextInterface, shipmentID, deviceID string, temperature, humidity float64, recordedAt string, signed bool) error {
+	recordedAt, err := s.normalizeRecordedAt(ctx, recordedAt)
+	if err != nil {
+		return err
+	}
+	if err := checkReadingRange(temperature, humidity); err != nil {
+		return err
+	}
+
+	if _, err := s.QueryShipment(ctx, shipmentID); err != nil {
+		return err
+	}
+	device, err := s.requireCalibratedDevice(ctx, deviceID, recordedAt)
+	if err != nil {
+		return err
+	}
+	if !signed {
+		if err := s.checkUnsignedReadings(ctx, device); err != nil {
+			return err
+		}
+	}
+
+	reading := SensorReading{
+		ShipmentID:  shipmentID,
+		DeviceID:    deviceID,
+		Temperature: temperature,
+		Humidity:    humidity,
+		RecordedAt:  recordedAt,
+		Signed:      signed,
+		TxID:        ctx.GetStub().GetTxID(),
+	}
+
+	writer, err := s.newTelemetryWriter(ctx)
+	if err != nil {
+		return err
+	}
+	if err := writer.add(&reading); err != nil {
+		return err
+	}
+	return writer.flush()
+}
+
+// checkUnsignedReadings returns an error unless the caller may record
+// readings of a device without the device's signature: it must be the
+// registrant or an admin, and the device's key must not be revoked
+func (s *SupplyChainContract) checkUnsignedReadings(ctx contractapi.TransactionContextInterface, device *Device) error {
+	if device.KeyRevoked {
+		return newError(ErrConflict, "the key of device %s is revoked until a new one is rotated in", device.ID)
+	}
+	return s.requireDeviceManager(ctx, device, "record unsigned readings")
+}
+
+// GetSensorReadings returns every raw reading recorded for a shipment,
+// ordered by time. When raw readings go to a private collection, only
+// members of that collection can read them.
+func (s *SupplyChainContract) GetSensorReadings(ctx contractapi.TransactionContextInterface, shipmentID string) ([]*SensorReading, error) {
+	config, err := s.GetContractConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var resultsIterator shim.StateQueryIteratorInterface
+	if config.TelemetryStorage == TelemetryStoragePrivate {
+		resultsIterator, err = ctx.GetStub().GetPrivateDataByPartialCompositeKey(config.TelemetryCollection, sensorReadingObjectType, []string{shipmentID})
+	} else {
+		resultsIterator, err = ctx.GetStub().GetStateByPartialCompositeKey(sensorReadingObjectType, []string{shipmentID})
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var readings []*SensorReading
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var reading SensorReading
+		if err := json.Unmarshal(queryResponse.Value, &reading); err != nil {
+			return nil, err
+		}
+		readings = append(readings, &reading)
+	}
+
+	return readings, nil
+}
+
+// normalizeRecordedAt parses a device timestamp and returns it in UTC so the
+// lexical key order matches time order. An empty timestamp becomes the
+// transaction time.
+func (s *SupplyChainContract) normalizeRecordedAt(ctx contractapi.TransactionContextInterface, recordedAt string) (string, error) {
+	if recordedAt == "" {
+		return s.getTimestamp(ctx)
+	}
+	parsed, err := time.Parse(time.RFC3339, recordedAt)
+	if err != nil {
+		return "", newError(ErrInvalidArgument, "recorded_at must be an RFC3339 timestamp: %v", err)
+	}
+	return parsed.UTC().Format(time.RFC3339), nil
+}
+
+// checkReadingRange rejects physically implausible readings
+func checkReadingRange(temperature, humidity float64) error {
+	if err := checkTemperature(temperature); err != nil {
+		return err
+	}
+	if humidity < minHumidity || humidity > maxHumidity {
+		return newError(ErrInvalidArgument, "humidity %g is outside the range %g to %g", humidity, minHumidity, maxHumidity)
+	}
+	return nil
+}
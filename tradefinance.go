@@ -0,0 +1,193 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const tradeFinanceAnchorObjectType = "tfanchor"
+
+// TradeFinanceContents is everything the ledger holds about an order that a
+// financier needs. Invoices are included as stored, without their derived
+// payment state, so the contents hash does not change with time.
+type TradeFinanceContents struct {
+	Order            *PurchaseOrder     `json:"order"`
+	Shipments        []*Shipment        `json:"shipments"`
+	ProofsOfDelivery []*ProofOfDelivery `json:"proofs_of_delivery"`
+	Inspections      []*Inspection      `json:"inspections"`
+	Invoices         []*Invoice         `json:"invoices"`
+	Documents        []*TradeDocument   `json:"documents"`
+}
+
+// TradeFinancePackage bundles an order's contents with their SHA-256 hash.
+// A financier verifies a package by hashing the JSON encoding of Contents
+// and comparing it with the hash anchored on the ledger.
+type TradeFinancePackage struct {
+	OrderID      string                `json:"order_id"`
+	Contents     *TradeFinanceContents `json:"contents"`
+	PackageHash  string                `json:"package_hash"`
+	AnchoredHash string                `json:"anchored_hash"`
+	AnchoredAt   string                `json:"anchored_at"`
+}
+
+// TradeFinanceAnchor is the package hash of an order recorded on the ledger
+type TradeFinanceAnchor struct {
+	OrderID     string `json:"order_id"`
+	PackageHash string `json:"package_hash"`
+	AnchoredBy  string `json:"anchored_by"`
+	AnchoredAt  string `json:"anchored_at"`
+}
+
+// GetTradeFinancePackage assembles the order, its shipments, proofs of
+// delivery, inspections, invoices and document hashes into one hashed
+// bundle, along with the hash last anchored for the order, if any
+func (s *SupplyChainContract) GetTradeFinancePackage(ctx contractapi.TransactionContextInterface, orderID string) (*TradeFinancePackage, error) {
+	contents, packageHash, err := s.buildTradeFinanceContents(ctx, orderID)
+	if err != nil {
+		return nil, err
+	}
+
+	pkg := &TradeFinancePackage{OrderID: orderID, Contents: contents, PackageHash: packageHash}
+	anchor, err := s.findTradeFinanceAnchor(ctx, orderID)
+	if err != nil {
+		return nil, err
+	}
+	if anchor != nil {
+		pkg.AnchoredHash = anchor.PackageHash
+		pkg.AnchoredAt = anchor.AnchoredAt
+	}
+	return pkg, nil
+}
+
+// AnchorTradeFinancePackage records the current package hash of an order on
+// the ledger, replacing any earlier anchor. Only the buyer or seller may anchor.
+func (s *SupplyChainContract) AnchorTradeFinancePackage(ctx contractapi.TransactionContextInterface, orderID string) (*TradeFinanceAnchor, error) {
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.requireOrderParty(ctx, orderID); err != nil {
+		return nil, err
+	}
+
+	_, packageHash, err := s.buildTradeFinanceContents(ctx, orderID)
+	if err != nil {
+		return nil, err
+	}
+	mspID, err := s.getClientMSPID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	anchor := TradeFinanceAnchor{
+		OrderID:     orderID,
+		PackageHash: packageHash,
+		AnchoredBy:  mspID,
+		AnchoredAt:  curTime,
+	}
+	key, err := s.makeKey(ctx, tradeFinanceAnchorObjectType, orderID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.putState(ctx, key, &anchor); err != nil {
+		return nil, err
+	}
+	return &anchor, nil
+}
+
+// VerifyTradeFinancePackage reports whether a package hash matches the one
+// anchored for an order
+func (s *SupplyChainContract) VerifyTradeFinancePackage(ctx contractapi.TransactionContextInterface, orderID, packageHash string) (bool, error) {
+	anchor, err := s.findTradeFinanceAnchor(ctx, orderID)
+	if err != nil {
+		return false, err
+	}
+	if anchor == nil {
+		return false, newError(ErrNotFound, "no package has been anchored for order %s", orderID)
+	}
+	return anchor.PackageHash == packageHash, nil
+}
+
+// buildTradeFinanceContents collects an order's records and hashes them
+func (s *SupplyChainContract) buildTradeFinanceContents(ctx contractapi.TransactionContextInterface, orderID string) (*TradeFinanceContents, string, error) {
+	order, err := s.QueryPurchaseOrder(ctx, orderID)
+	if err != nil {
+		return nil, "", err
+	}
+	shipments, err := s.GetOrderShipments(ctx, orderID)
+	if err != nil {
+		return nil, "", err
+	}
+	documents, err := s.GetOrderDocuments(ctx, orderID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	contents := &TradeFinanceContents{
+		Order:            order,
+		Shipments:        shipments,
+		ProofsOfDelivery: []*ProofOfDelivery{},
+		Inspections:      []*Inspection{},
+		Invoices:         []*Invoice{},
+		Documents:        documents,
+	}
+
+	for _, shipment := range shipments {
+		key, err := s.makeKey(ctx, proofOfDeliveryObjectType, shipment.ID)
+		if err != nil {
+			return nil, "", err
+		}
+		var pod ProofOfDelivery
+		exists, err := s.getState(ctx, key, &pod)
+		if err != nil {
+			return nil, "", err
+		}
+		if exists {
+			contents.ProofsOfDelivery = append(contents.ProofsOfDelivery, &pod)
+		}
+
+		inspections, err := s.GetShipmentInspections(ctx, shipment.ID)
+		if err != nil {
+			return nil, "", err
+		}
+		contents.Inspections = append(contents.Inspections, inspections...)
+	}
+
+	invoiceIDs, err := s.getIndexedIDs(ctx, invoiceIssuerIndexName, order.Seller)
+	if err != nil {
+		return nil, "", err
+	}
+	for _, invoiceID := range invoiceIDs {
+		invoice, err := s.getInvoice(ctx, invoiceID)
+		if err != nil {
+			return nil, "", err
+		}
+		if invoice.Reference == orderID && invoice.Payer == order.Buyer {
+			contents.Invoices = append(contents.Invoices, invoice)
+		}
+	}
+
+	contentsJSON, err := json.Marshal(contents)
+	if err != nil {
+		return nil, "", err
+	}
+	digest := sha256.Sum256(contentsJSON)
+	return contents, hex.EncodeToString(digest[:]), nil
+}
+
+// findTradeFinanceAnchor returns the anchor of an order, or nil if none was recorded
+func (s *SupplyChainContract) findTradeFinanceAnchor(ctx contractapi.TransactionContextInterface, orderID string) (*TradeFinanceAnchor, error) {
+	key, err := s.makeKey(ctx, tradeFinanceAnchorObjectType, orderID)
+	if err != nil {
+		return nil, err
+	}
+	var anchor TradeFinanceAnchor
+	exists, err := s.getState(ctx, key, &anchor)
+	if err != nil || !exists {
+		return nil, err
+	}
+	return &anchor, nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Reasons a transfer is blocked
+const (
+	TransferBlockEmbargo        = "EMBARGO"
+	TransferBlockReasonRequired = "REASON_REQUIRED"
+	TransferBlockParticipant    = "PARTICIPANT"
+)
+
+// TransferBlock is one reason a transfer would fail
+type TransferBlock struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// TransferVerdict says whether TransferOwnership would succeed. A transfer
+// blocked only by REASON_REQUIRED can still go through
+// TransferOwnershipWithReason.
+type TransferVerdict struct {
+	ProductID string          `json:"product_id"`
+	NewOwner  string          `json:"new_owner"`
+	Eligible  bool            `json:"eligible"`
+	Blocks    []TransferBlock `json:"blocks"`
+}
+
+// CanTransfer runs the checks of an ownership transfer without performing
+// it and reports every one that fails
+func (s *SupplyChainContract) CanTransfer(ctx contractapi.TransactionContextInterface, id, newOwner string) (*TransferVerdict, error) {
+	product, err := s.QueryProduct(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	verdict := &TransferVerdict{ProductID: id, NewOwner: newOwner, Blocks: []TransferBlock{}}
+	block := func(code, format string, args ...interface{}) {
+		verdict.Blocks = append(verdict.Blocks, TransferBlock{Code: code, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if isOwnershipReversal(product, newOwner) {
+		block(TransferBlockReasonRequired, "handing product %s back to %s is an ownership reversal and needs a reason", id, newOwner)
+	}
+	if newOwner != product.Owner {
+		if err := s.checkOwnerParticipant(ctx, newOwner); err != nil {
+			block(TransferBlockParticipant, "%s", errorMessage(err))
+		}
+		embargo, err := s.transferEmbargo(ctx, product, newOwner)
+		if err != nil {
+			return nil, err
+		}
+		if embargo != nil {
+			block(TransferBlockEmbargo, "product %s is under embargo in %s until %s", id, embargo.Market, embargo.LiftsAt)
+		}
+	}
+
+	verdict.Eligible = len(verdict.Blocks) == 0
+	return verdict, nil
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"math"
+	"sort"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	transformationObjectType      = "transformation"
+	transformationInputIndexName  = "transformation~input"
+	transformationOutputIndexName = "transformation~output"
+	transformationFacilityIndex   = "transformation~facility"
+
+	// maxTransformationItems bounds the inputs and the outputs of one transformation
+	maxTransformationItems = 100
+
+	productStatusConsumed = "Consumed"
+)
+
+// OutputSpec describes a product produced by a transformation
+type OutputSpec struct {
+	ID          string  `json:"id"`
+	Name        string  `json:"name"`
+	Description string  `json:"description"`
+	Category    string  `json:"category"`
+	Quantity    float64 `json:"quantity"`
+}
+
+// Transformation records a process step (roasting, cutting, refining...)
+// that consumed a set of input products and produced new output products.
+// Quantities are in Unit; the yield is the share of the input quantity that
+// ended up in the outputs and the waste is the rest.
+type Transformation struct {
+	ID              string    `json:"id"`
+	ProcessType     string    `json:"process_type"`
+	Facility        string    `json:"facility"`
+	InputIDs        []string  `json:"input_ids"`
+	InputQuantities []float64 `json:"input_quantities"`
+	OutputIDs       []string  `json:"output_ids"`
+	OriginIDs       []string  `json:"origin_ids"`
+	Allergens       []string  `json:"allergens"`
+	Unit            string    `json:"unit"`
+	InputQuantity   float64   `json:"input_quantity"`
+	OutputQuantity  float64   `json:"output_quantity"`
+	YieldPercent    float64   `json:"yield_percent"`
+	WastePercent    float64   `json:"waste_percent"`
+	RecordedBy      string    `json:"recorded_by"`
+	CreatedAt       string    `json:"created_at"`
+}
+
+// YieldSummary aggregates the transformations of one process type and unit
+type YieldSummary struct {
+	ProcessType     string  `json:"process_type"`
+	Unit            string  `json:"unit"`
+	Transformations int     `json:"transformations"`
+	InputQuantity   float64 `json:"input_quantity"`
+	OutputQuantity  float64 `json:"output_quantity"`
+	YieldPercent    float64 `json:"yield_percent"`
+	WastePercent    float64 `json:"waste_percent"`
+}
+
+// FacilityYieldReport is the yield of every process run at a facility
+type FacilityYieldReport struct {
+	Facility  string         `json:"facility"`
+	Summaries []YieldSummary `json:"summaries"`
+}
+
+// RecordTransformation consumes the input products and creates the outputs
+// described by outputSpecs. inputQuantities gives the quantity of each input
+// in unit, in the same order as inputIDs. All inputs must belong to the same
+// owner, who also owns the outputs, and only that owner or an admin can
+// consume them. Frozen and archived inputs are refused. Inputs are marked
+// Consumed, both directions of the input/output link are indexed for
+// tracing and the origins and allergens of every input are carried over to
+// the outputs.
+func (s *SupplyChainContract) RecordTransformation(ctx contractapi.TransactionContextInterface, inputIDs []string, inputQuantities []float64, outputSpecs []OutputSpec, processType, facility, unit string) (*Transformation, error) {
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if processType == "" {
+		return nil, newError(ErrInvalidArgument, "process type must not be empty")
+	}
+	if unit == "" {
+		return nil, newError(ErrInvalidArgument, "unit must not be empty")
+	}
+	if len(inputIDs) == 0 || len(outputSpecs) == 0 {
+		return nil, newError(ErrInvalidArgument, "a transformation needs at least one input and one output")
+	}
+	if len(inputIDs) > maxTransformationItems || len(outputSpecs) > maxTransformationItems {
+		return nil, newError(ErrInvalidArgument, "a transformation takes at most %d inputs and %d outputs", maxTransformationItems, maxTransformationItems)
+	}
+	if len(inputQuantities) != len(inputIDs) {
+		return nil, newError(ErrInvalidArgument, "expected %d input quantities, got %d", len(inputIDs), len(inputQuantities))
+	}
+
+	var inputQuantity, outputQuantity float64
+	for i, quantity := range inputQuantities {
+		if quantity <= 0 {
+			return nil, newError(ErrInvalidArgument, "quantity of input %s must be positive", inputIDs[i])
+		}
+		inputQuantity += quantity
+	}
+	for _, spec := range outputSpecs {
+		if spec.Quantity < 0 {
+			return nil, newError(ErrInvalidArgument, "quantity of output %s must not be negative", spec.ID)
+		}
+		outputQuantity += spec.Quantity
+	}
+	if outputQuantity > inputQuantity {
+		return nil, newError(ErrInvalidArgument, "outputs (%g %s) cannot exceed inputs (%g %s)", outputQuantity, unit, inputQuantity, unit)
+	}
+
+	seen := make(map[string]bool)
+	inputs := make([]*Product, 0, len(inputIDs))
+	for _, inputID := range inputIDs {
+		if seen[inputID] {
+			return nil, newError(ErrInvalidArgument, "product %s is listed more than once", inputID)
+		}
+		seen[inputID] = true
+
+		input, err := s.QueryProduct(ctx, inputID)
+		if err != nil {
+			return nil, err
+		}
+		if input.Status == productStatusConsumed {
+			return nil, newError(ErrConflict, "product %s has already been consumed", inputID)
+		}
+		if len(inputs) > 0 && input.Owner != inputs[0].Owner {
+			return nil, newError(ErrInvalidArgument, "all inputs must belong to the same owner; %s is owned by %s", inputID, input.Owner)
+		}
+		if input.Archived {
+			return nil, newError(ErrConflict, "product %s is archived and must be restored first", inputID)
+		}
+		if err := checkNotFrozen(input); err != nil {
+			return nil, err
+		}
+		if err := s.requireProductOwner(ctx, input, "consume"); err != nil {
+			return nil, err
+		}
+		if err := s.checkProductStatusChange(ctx, input.Status, productStatusConsumed, nil); err != nil {
+			return nil, err
+		}
+		inputs = append(inputs, input)
+	}
+	owner := inputs[0].Owner
+
+	originIDs, allergens := []string{}, []string{}
+	for _, input := range inputs {
+		inputOrigins, err := s.productOriginIDs(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		originIDs = mergeIDs(originIDs, inputOrigins)
+
+		inputAllergens, err := s.productAllergens(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		allergens = mergeIDs(allergens, inputAllergens)
+	}
+
+	outputIDs := make([]string, 0, len(outputSpecs))
+	for _, spec := range outputSpecs {
+		if spec.ID == "" {
+			return nil, newError(ErrInvalidArgument, "every output needs an ID")
+		}
+		if seen[spec.ID] {
+			return nil, newError(ErrInvalidArgument, "product %s is listed more than once", spec.ID)
+		}
+		seen[spec.ID] = true
+		outputIDs = append(outputIDs, spec.ID)
+	}
+
+	clientID, err := s.getClientID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	yield, waste := yieldPercentages(inputQuantity, outputQuantity)
+	transformation := Transformation{
+		ID:              ctx.GetStub().GetTxID(),
+		ProcessType:     processType,
+		Facility:        facility,
+		InputIDs:        inputIDs,
+		InputQuantities: inputQuantities,
+		OutputIDs:       outputIDs,
+		OriginIDs:       originIDs,
+		Allergens:       allergens,
+		Unit:            unit,
+		InputQuantity:   inputQuantity,
+		OutputQuantity:  outputQuantity,
+		YieldPercent:    yield,
+		WastePercent:    waste,
+		RecordedBy:      clientID,
+		CreatedAt:       curTime,
+	}
+
+	for _, input := range inputs {
+		before := *input
+		if err := s.setProductStatus(ctx, input, productStatusConsumed, nil); err != nil {
+			return nil, err
+		}
+		input.UpdatedAt = curTime
+		if err := s.putIndexedProduct(ctx, before, input); err != nil {
+			return nil, err
+		}
+		if err := s.putIndexKey(ctx, transformationInputIndexName, input.ID, transformation.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, spec := range outputSpecs {
+		if err := s.createProduct(ctx, spec.ID, spec.Name, owner, spec.Description, spec.Category, owner, "", false); err != nil {
+			return nil, err
+		}
+		if err := s.putIndexKey(ctx, transformationOutputIndexName, spec.ID, transformation.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	key, err := s.makeKey(ctx, transformationObjectType, transformation.ID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.putState(ctx, key, &transformation); err != nil {
+		return nil, err
+	}
+	if facility != "" {
+		if err := s.putIndexKey(ctx, transformationFacilityIndex, facility, transformation.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	return &transformation, nil
+}
+
+// QueryTransformation retrieves a single transformation from the ledger by ID
+func (s *SupplyChainContract) QueryTransformation(ctx contractapi.TransactionContextInterface, id string) (*Transformation, error) {
+	key, err := s.makeKey(ctx, transformationObjectType, id)
+	if err != nil {
+		return nil, err
+	}
+
+	var transformation Transformation
+	exists, err := s.getState(ctx, key, &transformation)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, newError(ErrNotFound, "transformation with ID %s does not exist", id)
+	}
+
+	return &transformation, nil
+}
+
+// GetProductSources traces one step backwards: the transformation that
+// produced a product, or nil if the product was not produced by one
+func (s *SupplyChainContract) GetProductSources(ctx contractapi.TransactionContextInterface, productID string) (*Transformation, error) {
+	transformationIDs, err := s.getIndexedIDs(ctx, transformationOutputIndexName, productID)
+	if err != nil {
+		return nil, err
+	}
+	if len(transformationIDs) == 0 {
+		return nil, nil
+	}
+	return s.QueryTransformation(ctx, transformationIDs[0])
+}
+
+// GetProductUses traces one step forwards: the transformation that consumed
+// a product, or nil if it has not been consumed
+func (s *SupplyChainContract) GetProductUses(ctx contractapi.TransactionContextInterface, productID string) (*Transformation, error) {
+	transformationIDs, err := s.getIndexedIDs(ctx, transformationInputIndexName, productID)
+	if err != nil {
+		return nil, err
+	}
+	if len(transformationIDs) == 0 {
+		return nil, nil
+	}
+	return s.QueryTransformation(ctx, transformationIDs[0])
+}
+
+// GetFacilityYieldReport aggregates the yield and waste of every
+// transformation recorded at a facility, per process type and unit
+func (s *SupplyChainContract) GetFacilityYieldReport(ctx contractapi.TransactionContextInterface, facility string) (*FacilityYieldReport, error) {
+	if facility == "" {
+		return nil, newError(ErrInvalidArgument, "facility must not be empty")
+	}
+
+	transformationIDs, err := s.getIndexedIDs(ctx, transformationFacilityIndex, facility)
+	if err != nil {
+		return nil, err
+	}
+
+	summaries := make(map[string]*YieldSummary)
+	for _, transformationID := range transformationIDs {
+		transformation, err := s.QueryTransformation(ctx, transformationID)
+		if err != nil {
+			return nil, err
+		}
+
+		summaryKey := transformation.ProcessType + "|" + transformation.Unit
+		summary, ok := summaries[summaryKey]
+		if !ok {
+			summary = &YieldSummary{ProcessType: transformation.ProcessType, Unit: transformation.Unit}
+			summaries[summaryKey] = summary
+		}
+		summary.Transformations++
+		summary.InputQuantity += transformation.InputQuantity
+		summary.OutputQuantity += transformation.OutputQuantity
+	}
+
+	report := &FacilityYieldReport{Facility: facility, Summaries: []YieldSummary{}}
+	for _, summary := range summaries {
+		summary.YieldPercent, summary.WastePercent = yieldPercentages(summary.InputQuantity, summary.OutputQuantity)
+		report.Summaries = append(report.Summaries, *summary)
+	}
+	sort.Slice(report.Summaries, func(i, j int) bool {
+		if report.Summaries[i].ProcessType != report.Summaries[j].ProcessType {
+			return report.Summaries[i].ProcessType < report.Summaries[j].ProcessType
+		}
+		return report.Summaries[i].Unit < report.Summaries[j].Unit
+	})
+
+	return report, nil
+}
+
+// yieldPercentages returns the yield and waste of a process as percentages
+// of the input quantity, rounded to two decimals
+func yieldPercentages(inputQuantity, outputQuantity float64) (float64, float64) {
+	if inputQuantity <= 0 {
+		return 0, 0
+	}
+	yield := math.Round(outputQuantity/inputQuantity*10000) / 100
+	return yield, math.Round((100-yield)*100) / 100
+}
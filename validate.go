@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// ValidationResult is the outcome of a dry run. Error is the message of the
+// error a real submission would fail with, Code its error code if it has
+// one, and Writes the number of keys it would write or delete.
+type ValidationResult struct {
+	Function string `json:"function"`
+	Valid    bool   `json:"valid"`
+	Error    string `json:"error"`
+	Code     string `json:"code"`
+	Writes   int    `json:"writes"`
+}
+
+// Validate runs a transaction function with every validation and
+// access-control check but without writing anything. argsJSON is a JSON
+// array holding the function's arguments after the context, in order.
+// Writes, deletes and events are discarded even if Validate is submitted
+// for ordering, so it is safe to call either way.
+func (s *SupplyChainContract) Validate(ctx contractapi.TransactionContextInterface, function, argsJSON string) (*ValidationResult, error) {
+	method := reflect.ValueOf(s).MethodByName(function)
+	if !method.IsValid() || function == "Validate" {
+		return nil, newError(ErrInvalidArgument, "unknown transaction function %s", function)
+	}
+	methodType := method.Type()
+	contextType := reflect.TypeOf((*contractapi.TransactionContextInterface)(nil)).Elem()
+	if methodType.NumIn() == 0 || methodType.In(0) != contextType || methodType.NumOut() == 0 {
+		return nil, newError(ErrInvalidArgument, "unknown transaction function %s", function)
+	}
+
+	var rawArgs []json.RawMessage
+	if err := json.Unmarshal([]byte(argsJSON), &rawArgs); err != nil {
+		return nil, newError(ErrInvalidArgument, "failed to parse args: %v", err)
+	}
+	if len(rawArgs) != methodType.NumIn()-1 {
+		return nil, newError(ErrInvalidArgument, "%s takes %d arguments, got %d", function, methodType.NumIn()-1, len(rawArgs))
+	}
+
+	stub := &dryRunStub{ChaincodeStubInterface: ctx.GetStub()}
+	args := []reflect.Value{reflect.ValueOf(&dryRunContext{TransactionContextInterface: ctx, stub: stub})}
+	for i, rawArg := range rawArgs {
+		arg := reflect.New(methodType.In(i + 1))
+		if err := json.Unmarshal(rawArg, arg.Interface()); err != nil {
+			return nil, newError(ErrInvalidArgument, "failed to parse argument %d of %s: %v", i+1, function, err)
+		}
+		args = append(args, arg.Elem())
+	}
+
+	result := &ValidationResult{Function: function, Valid: true}
+	results := method.Call(args)
+	if err, ok := results[len(results)-1].Interface().(error); ok && err != nil {
+		result.Valid = false
+		var chaincodeErr *ChaincodeError
+		if errors.As(err, &chaincodeErr) {
+			result.Error, result.Code = chaincodeErr.Message, chaincodeErr.Code
+		} else {
+			result.Error = err.Error()
+		}
+	}
+	result.Writes = stub.writes
+	return result, nil
+}
+
+// dryRunContext hands the wrapped stub to the function being validated
+type dryRunContext struct {
+	contractapi.TransactionContextInterface
+	stub *dryRunStub
+}
+
+// GetStub returns the stub that discards writes
+func (c *dryRunContext) GetStub() shim.ChaincodeStubInterface {
+	return c.stub
+}
+
+// dryRunStub reads through to the real stub and counts, but drops, every
+// write. A transaction cannot read its own writes anyway, so dropping them
+// does not change what the validated function sees.
+type dryRunStub struct {
+	shim.ChaincodeStubInterface
+	writes int
+}
+
+func (d *dryRunStub) PutState(key string, value []byte) error {
+	d.writes++
+	return nil
+}
+
+func (d *dryRunStub) DelState(key string) error {
+	d.writes++
+	return nil
+}
+
+func (d *dryRunStub) SetStateValidationParameter(key string, ep []byte) error {
+	return nil
+}
+
+func (d *dryRunStub) PutPrivateData(collection, key string, value []byte) error {
+	d.writes++
+	return nil
+}
+
+func (d *dryRunStub) DelPrivateData(collection, key string) error {
+	d.writes++
+	return nil
+}
+
+func (d *dryRunStub) PurgePrivateData(collection, key string) error {
+	d.writes++
+	return nil
+}
+
+func (d *dryRunStub) SetPrivateDataValidationParameter(collection, key string, ep []byte) error {
+	return nil
+}
+
+func (d *dryRunStub) SetEvent(name string, payload []byte) error {
+	return nil
+}
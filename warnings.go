@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Warning codes. WarningDuplicateSuspected is declared with the duplicate
+// check.
+const (
+	WarningNearingExpiry = "NEARING_EXPIRY"
+	WarningQuotaExceeded = "QUOTA_EXCEEDED"
+)
+
+// expiryWarningDays is how close to its lot's expiry date a product has to
+// be for a change to it to carry WarningNearingExpiry
+const expiryWarningDays = 7
+
+// Warning is an advisory about a transaction that succeeded anyway.
+// ProductID is set when the warning concerns one product.
+type Warning struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	ProductID string `json:"product_id"`
+}
+
+// TxResult is returned by the product transactions that can succeed with
+// warnings, so clients can show them without the transaction failing
+type TxResult struct {
+	TxID     string    `json:"tx_id"`
+	Warnings []Warning `json:"warnings"`
+}
+
+// addWarning records a warning for the transaction's result. Warnings are
+// kept in the transaction context, so checks deep inside a transaction can
+// raise them; a context that does not collect them drops them.
+func addWarning(ctx contractapi.TransactionContextInterface, code, productID, format string, args ...interface{}) {
+	if c, ok := ctx.(*programContext); ok {
+		c.warnings = append(c.warnings, Warning{Code: code, Message: fmt.Sprintf(format, args...), ProductID: productID})
+	}
+}
+
+// txResult returns the result of a transaction with the warnings it raised
+func txResult(ctx contractapi.TransactionContextInterface) *TxResult {
+	return &TxResult{TxID: ctx.GetStub().GetTxID(), Warnings: txWarnings(ctx)}
+}
+
+// txWarnings returns the warnings the transaction has raised so far
+func txWarnings(ctx contractapi.TransactionContextInterface) []Warning {
+	warnings := []Warning{}
+	if c, ok := ctx.(*programContext); ok {
+		warnings = append(warnings, c.warnings...)
+	}
+	return warnings
+}
+
+// warnNearingExpiry warns when the product's lot expires within
+// expiryWarningDays or has already expired
+func (s *SupplyChainContract) warnNearingExpiry(ctx contractapi.TransactionContextInterface, product *Product) error {
+	if product.LotID == "" {
+		return nil
+	}
+	lot, err := s.QueryLot(ctx, product.LotID)
+	if err != nil {
+		return err
+	}
+	if lot.ExpiryDate == "" {
+		return nil
+	}
+	expiry, err := time.Parse(time.RFC3339, lot.ExpiryDate)
+	if err != nil {
+		return wrapError(err, "lot %s has an invalid expiry date", lot.ID)
+	}
+	txTime, err := s.getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case !txTime.Before(expiry):
+		addWarning(ctx, WarningNearingExpiry, product.ID, "product %s expired at %s with lot %s", product.ID, lot.ExpiryDate, lot.ID)
+	case expiry.Sub(txTime) <= expiryWarningDays*24*time.Hour:
+		addWarning(ctx, WarningNearingExpiry, product.ID, "product %s expires at %s with lot %s", product.ID, lot.ExpiryDate, lot.ID)
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	watchObjectType       = "watch"
+	watchWatcherIndexName = "watch~watcher"
+)
+
+// EventWatchedProductChanged is emitted when a product with watchers is
+// written. Only the last event of a transaction is delivered, so a
+// transaction that changes several watched products reports the last one.
+const EventWatchedProductChanged = "WatchedProductChanged"
+
+// Watch subscribes one identity to changes of one product
+type Watch struct {
+	ProductID string `json:"product_id"`
+	WatcherID string `json:"watcher_id"`
+	MSPID     string `json:"msp_id"`
+	CreatedAt string `json:"created_at"`
+}
+
+// WatchedProductEvent is the payload of EventWatchedProductChanged. Watchers
+// lets the listener notify exactly the identities following the product.
+type WatchedProductEvent struct {
+	ProductID string  `json:"product_id"`
+	Status    string  `json:"status"`
+	Owner     string  `json:"owner"`
+	UpdatedAt string  `json:"updated_at"`
+	TxID      string  `json:"tx_id"`
+	Watchers  []Watch `json:"watchers"`
+}
+
+// WatchProduct subscribes the calling identity to changes of a product
+func (s *SupplyChainContract) WatchProduct(ctx contractapi.TransactionContextInterface, id string) error {
+	if _, err := s.QueryProduct(ctx, id); err != nil {
+		return err
+	}
+	curTime, err := s.getTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	clientID, err := s.getClientID(ctx)
+	if err != nil {
+		return err
+	}
+	mspID, err := s.getClientMSPID(ctx)
+	if err != nil {
+		return err
+	}
+
+	key, err := s.makeKey(ctx, watchObjectType, id, clientID)
+	if err != nil {
+		return err
+	}
+	exists, err := s.getState(ctx, key, &Watch{})
+	if err != nil {
+		return err
+	}
+	if exists {
+		return newError(ErrAlreadyExists, "already watching product %s", id)
+	}
+
+	watch := Watch{ProductID: id, WatcherID: clientID, MSPID: mspID, CreatedAt: curTime}
+	if err := s.putState(ctx, key, &watch); err != nil {
+		return err
+	}
+	return s.putIndexKey(ctx, watchWatcherIndexName, clientID, id)
+}
+
+// UnwatchProduct removes the calling identity's subscription to a product
+func (s *SupplyChainContract) UnwatchProduct(ctx contractapi.TransactionContextInterface, id string) error {
+	clientID, err := s.getClientID(ctx)
+	if err != nil {
+		return err
+	}
+
+	key, err := s.makeKey(ctx, watchObjectType, id, clientID)
+	if err != nil {
+		return err
+	}
+	exists, err := s.getState(ctx, key, &Watch{})
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return newError(ErrNotFound, "not watching product %s", id)
+	}
+
+	if err := ctx.GetStub().DelState(key); err != nil {
+		return wrapError(err, "failed to delete from world state")
+	}
+	return s.delIndexKey(ctx, watchWatcherIndexName, clientID, id)
+}
+
+// GetWatchlist returns the IDs of the products the calling identity watches
+func (s *SupplyChainContract) GetWatchlist(ctx contractapi.TransactionContextInterface) ([]string, error) {
+	clientID, err := s.getClientID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	productIDs, err := s.getIndexedIDs(ctx, watchWatcherIndexName, clientID)
+	if err != nil {
+		return nil, err
+	}
+	if productIDs == nil {
+		productIDs = []string{}
+	}
+	return productIDs, nil
+}
+
+// GetProductWatchers returns the subscriptions to a product
+func (s *SupplyChainContract) GetProductWatchers(ctx contractapi.TransactionContextInterface, id string) ([]Watch, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(watchObjectType, []string{id})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	watches := []Watch{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var watch Watch
+		if err := json.Unmarshal(queryResponse.Value, &watch); err != nil {
+			return nil, err
+		}
+		watches = append(watches, watch)
+	}
+
+	return watches, nil
+}
+
+// notifyWatchers emits EventWatchedProductChanged for a product that has
+// just been written, if anyone is watching it. Transactions that go on to
+// emit a product lifecycle event replace it, and list the watchers there.
+func (s *SupplyChainContract) notifyWatchers(ctx contractapi.TransactionContextInterface, product *Product) error {
+	watches, err := s.GetProductWatchers(ctx, product.ID)
+	if err != nil {
+		return err
+	}
+	if len(watches) == 0 {
+		return nil
+	}
+
+	event := WatchedProductEvent{
+		ProductID: product.ID,
+		Status:    product.Status,
+		Owner:     product.Owner,
+		UpdatedAt: product.UpdatedAt,
+		TxID:      ctx.GetStub().GetTxID(),
+		Watchers:  watches,
+	}
+	eventJSON, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if err := ctx.GetStub().SetEvent(EventWatchedProductChanged, eventJSON); err != nil {
+		return wrapError(err, "failed to set event")
+	}
+	return nil
+}